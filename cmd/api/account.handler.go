@@ -0,0 +1,294 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/mailer"
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/security"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+)
+
+// reauthWindow is how recent a login must be to stand in for the current password or an mfa code
+// Users without a password, who log in with an identity provider, confirm it is them this way
+const reauthWindow = 5 * time.Minute
+
+// deleteAccount handles requests for deleting the current user's account, once the deletion grace period is over
+// The user confirms it is them with their currentPassword or an mfa code, unless they logged in moments ago
+// They are then logged out everywhere, and their API keys stop working. Logging in before the account is deleted calls the deletion off
+// Accessible @ DELETE /users/me
+func (a *application) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var params models.ReauthParams
+
+	// the body is optional, for users who just logged in
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && err != io.EOF {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	userID, sessionID, ok := a.currentSessionHelper(w, r, "err deleting account")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	ok = a.reauthHelper(w, r, userID, sessionID, params, "err deleting account")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	user, err := a.users.ScheduleDeletion(userID, a.deletionGrace)
+	if err != nil {
+		userErrHelper(w, err, "err deleting account")
+		return
+	}
+
+	// logging in again is how the deletion is called off, so no device stays logged in
+	err = a.sessions.RevokeSessions(userID)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "scheduled account deletion, but could not log out every device",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	security.ClearSessionCookies(w)
+	go a.mailDeletionHelper(user)
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusAccepted, &utils.GenericJSONRes{
+		Message: "successfully scheduled account deletion. Log in before deleteAfter to call it off",
+		Data:    user,
+	})
+}
+
+// reauthHelper checks that whoever holds a session is the user it belongs to:
+// they give their current password or an mfa code, or else logged in within the reauthWindow
+// Passwords and codes are throttled, and count towards locking the account, as logins are
+func (a *application) reauthHelper(w http.ResponseWriter, r *http.Request, userID string, sessionID string, params models.ReauthParams, message string) bool {
+	if params.CurrentPassword != "" || params.Code != "" {
+		user, err := a.users.GetByUUID(userID)
+		if err != nil {
+			userErrHelper(w, err, message)
+			return false
+		}
+
+		login, ok := a.throttleLoginHelper(w, r, user.Username, message)
+		if !ok {
+			// err is already handled (sent back as jsonRes to user)
+			return false
+		}
+
+		if params.CurrentPassword != "" {
+			_, err = a.users.GetByCredentials(&models.UserCredentials{Username: user.Username, Password: params.CurrentPassword})
+			if err != nil && errors.Is(err, utils.ErrWrongPassword) {
+				a.recordFailedLoginHelper(login, true)
+				utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+					Message: message,
+					Data:    &models.PasswordParams{CurrentPassword: "wrong currentPassword"},
+				})
+				return false
+			}
+		} else {
+			err = a.mfa.VerifyMFA(userID, params.Code)
+			if err != nil && errors.Is(err, utils.ErrMFACodeInvalid) {
+				a.recordFailedLoginHelper(login, true)
+				mfaErrHelper(w, err, message)
+				return false
+			}
+		}
+
+		// the check was counted as failed as it was let through, and it did not
+		a.releaseLoginHelper(login)
+
+		if err != nil && params.CurrentPassword != "" {
+			userErrHelper(w, err, message)
+			return false
+		}
+
+		if err != nil {
+			mfaErrHelper(w, err, message)
+			return false
+		}
+
+		return true
+	}
+
+	sessions, err := a.sessions.GetSessions(userID)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: message,
+			Data:    AuthError{err.Error()},
+		})
+		return false
+	}
+
+	// sessions keep the time of the login that started them, however often they are rotated
+	for _, session := range sessions {
+		if session.SessionID == sessionID && time.Since(session.CreatedAt) < reauthWindow {
+			return true
+		}
+	}
+
+	utils.SendJSONResponse(w, http.StatusUnauthorized, &utils.GenericJSONRes{
+		Message: message,
+		Data:    AuthError{utils.REAUTH_REQUIRED_ERR},
+	})
+	return false
+}
+
+// exportAccount handles requests for everything the api holds on the current user:
+// their profile, the actions they created with their outputs and shares, their workspaces, and their audit history
+// It comes as a zip of JSON files, one per part, unless ?format=json asks for a single JSON document
+// Accessible @ GET /users/me/export[?format=json]
+func (a *application) exportAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.currentUserHelper(w, r, "err exporting account")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	export, err := a.exportHelper(userID)
+	if err != nil {
+		userErrHelper(w, err, "err exporting account")
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+			Message: "successfully exported account",
+			Data:    export,
+		})
+		return
+	}
+
+	// the archive is built in full before anything is sent, so that errs can still be sent back as jsonRes
+	archive, err := zipExportHelper(export)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err exporting account",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"timelineapi-export-%v.zip\"", export.ExportedAt.Format("2006-01-02")))
+	w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(archive)
+	if err != nil {
+		log.Println("send account export: ", err)
+	}
+}
+
+// exportHelper gathers everything the api holds on a user, from every store that holds some of it
+func (a *application) exportHelper(userID string) (*models.UserExport, error) {
+	user, err := a.users.GetByUUID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	actions, err := a.actions.ExportActions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	workspaces, err := a.workspaces.GetWorkspaces(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := a.sessions.GetSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys, err := a.apiKeys.GetAPIKeys(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := a.identities.GetIdentities(userID)
+	if err != nil {
+		return nil, err
+	}
+	a.nameIdentitiesHelper(identities)
+
+	return &models.UserExport{
+		ExportedAt:    time.Now().UTC(),
+		Profile:       user,
+		ActionsExport: *actions,
+		Workspaces:    workspaces,
+		Audit:         models.NewAuditHistory(user, sessions, apiKeys, identities),
+	}, nil
+}
+
+// zipExportHelper packs an export into a zip, with each of its parts in a JSON file of its own
+func zipExportHelper(export *models.UserExport) ([]byte, error) {
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"actions.json", export.Actions},
+		{"outputs.json", export.Outputs},
+		{"shares.json", export.Shares},
+		{"workspaces.json", export.Workspaces},
+		{"audit.json", export.Audit},
+	}
+
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+	for _, file := range files {
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(file.data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// mailDeletionHelper emails a user that their account is to be deleted, and how to call it off
+func (a *application) mailDeletionHelper(user *models.User) {
+	err := a.mailer.Send(mailer.Message{
+		To:      user.Username,
+		Subject: "Your account is to be deleted",
+		Body: fmt.Sprintf("Your timelineapi account, and everything in it, is to be deleted for good after %v. Changed your mind? Log in before then @\r\n\r\n%v\r\n\r\nIf you did not ask for this, log in, which calls the deletion off, and change your password.",
+			user.DeleteAfter.UTC().Format("2006-01-02 15:04 MST"), a.appURL),
+	})
+	if err != nil {
+		log.Println("send account deletion: ", err)
+	}
+}
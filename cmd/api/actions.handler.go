@@ -3,11 +3,12 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
+	"github.com/dmithamo/timelineapi/pkg/dbservice"
 	"github.com/dmithamo/timelineapi/pkg/models"
-	"github.com/dmithamo/timelineapi/pkg/security"
 	"github.com/dmithamo/timelineapi/pkg/utils"
 	"github.com/gorilla/mux"
 )
@@ -18,7 +19,7 @@ type ActionErr struct {
 }
 
 // createAction handles requests for creating a new action
-// Accessible @ POST /actions
+// Accessible @ POST /actions[?workspaceID=]
 func (a *application) createAction(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	var actionParams models.ActionParams
@@ -41,18 +42,22 @@ func (a *application) createAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var actionModel models.Action
-	userID, err := security.DecodeToken(r)
-	if err != nil {
-		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
-			Message: "err creating action",
-			Data:    ActionErr{err.Error()},
-		})
+	actor, ok := a.currentActorHelper(w, r, "err creating action")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
 		return
 	}
-	createActionErr := actionModel.CreateAction(a.db, actionParams, userID.(string))
+	createActionErr := a.actions.CreateAction(actionParams, actor)
 	if createActionErr != nil {
-		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(createActionErr, utils.ErrWorkspaceNotFound):
+			status = http.StatusNotFound
+		case errors.Is(createActionErr, utils.ErrForbidden):
+			status = http.StatusForbidden
+		}
+
+		utils.SendJSONResponse(w, status, &utils.GenericJSONRes{
 			Message: "err creating action",
 			Data:    ActionErr{createActionErr.Error()},
 		})
@@ -66,70 +71,222 @@ func (a *application) createAction(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// getActions handles requests for retrieving all Actions
-// Accessible @ GET /actions
+// getActions handles requests for retrieving a page of the Actions visible to the user
+// Accessible @ GET /actions?limit=&sort=&order=&cursor=&createdAfter=&createdBefore=&updatedAfter=&updatedBefore=&userID=&archived=&workspaceID=
 func (a *application) getActions(w http.ResponseWriter, r *http.Request) {
-	var actionModel models.Action
-
-	allActions, err := actionModel.GetActions(a.db)
-	if err != nil || allActions == nil {
-		if err == sql.ErrNoRows || allActions == nil {
-			utils.SendJSONResponse(w, http.StatusNotFound, &utils.GenericJSONRes{
-				Message: "no actions found",
-				Data:    nil,
-			})
-			return
+	query, validationErrs := models.NewActionQueryParams(r.URL.Query()).Parse()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in query params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	actor, ok := a.currentActorHelper(w, r, "err retrieving actions")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	page, err := a.actions.GetActions(actor, query)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrWorkspaceNotFound) {
+			status = http.StatusNotFound
 		}
 
-		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+		utils.SendJSONResponse(w, status, &utils.GenericJSONRes{
 			Message: "err retrieving actions",
 			Data:    ActionErr{err.Error()},
 		})
 		return
 	}
 
+	if page.Actions == nil {
+		utils.SendJSONResponse(w, http.StatusNotFound, &utils.GenericJSONRes{
+			Message: "no actions found",
+			Data:    nil,
+		})
+		return
+	}
+
 	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
 		Message: "successfully retrieved actions",
-		Data:    allActions,
+		Data:    page.Actions,
+		Next:    page.Next,
+		Prev:    page.Prev,
 	})
 }
 
 // getAction handles requests for retrieving a single Action by ActionID
-// Accessible @ GET /actions/{actionID}
+// Accessible @ GET /actions/{actionID}[?workspaceID=]
 func (a *application) getAction(w http.ResponseWriter, r *http.Request) {
-	var actionModel models.Action
+	actor, ok := a.currentActorHelper(w, r, "err retrieving action")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
 
 	actionID := mux.Vars(r)["actionID"]
-	action, err := actionModel.GetActionByID(a.db, actionID)
+	action, err := a.actions.GetActionByID(actionID, actor)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			utils.SendJSONResponse(w, http.StatusNotFound,
-				&utils.GenericJSONRes{
-					Message: fmt.Sprintf("no actions found with actionID: %v", actionID),
-					Data:    nil,
-				})
-
-			return
-		}
+		actionErrHelper(w, err, actionID, "err retrieving action")
+		return
+	}
 
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved action",
+		Data:    action,
+	})
+}
+
+// updateAction handles requests for editing Action
+// Accesible @ PATCH /actions/{actionID}[?workspaceID=]
+func (a *application) updateAction(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var actionParams models.ActionParams
+
+	decodeErr := json.NewDecoder(r.Body).Decode(&actionParams)
+	if decodeErr != nil {
 		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
-			Message: "err retrieving action",
-			Data:    ActionErr{err.Error()},
+			Message: fmt.Sprintf("err decoding request body: %v", decodeErr.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := actionParams.ValidatePartial()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
 		})
+		return
+	}
 
+	actor, ok := a.currentActorHelper(w, r, "err updating action")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
 		return
 	}
 
+	actionID := mux.Vars(r)["actionID"]
+	action, err := a.actions.UpdateAction(actionID, actor, actionParams)
+	if err != nil {
+		actionErrHelper(w, err, actionID, "err updating action")
+		return
+	}
+
+	// success!
 	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
-		Message: "successfully retrieved action",
+		Message: "successfully updated action",
 		Data:    action,
 	})
 }
 
-// updateAction handles requests for editing Action
-// Accesible @ PATCH /actions/{actionID}
-func (a *application) updateAction(w http.ResponseWriter, r *http.Request) {}
+// deleteAction handles requests for deleting (archiving) an Action
+// Accessible @ DELETE /actions/{actionID}[?workspaceID=]
+func (a *application) deleteAction(w http.ResponseWriter, r *http.Request) {
+	actor, ok := a.currentActorHelper(w, r, "err deleting action")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	actionID := mux.Vars(r)["actionID"]
+	err := a.actions.ArchiveAction(actionID, actor)
+	if err != nil {
+		actionErrHelper(w, err, actionID, "err deleting action")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully deleted action",
+		Data:    nil,
+	})
+}
+
+// restoreAction handles requests for bringing back an archived Action
+// Accessible @ POST /actions/{actionID}/restore[?workspaceID=]
+func (a *application) restoreAction(w http.ResponseWriter, r *http.Request) {
+	actor, ok := a.currentActorHelper(w, r, "err restoring action")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	actionID := mux.Vars(r)["actionID"]
+	action, err := a.actions.RestoreAction(actionID, actor)
+	if err != nil {
+		actionErrHelper(w, err, actionID, "err restoring action")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully restored action",
+		Data:    action,
+	})
+}
+
+// purgeAction handles requests for permanently deleting an archived Action
+// Accessible @ DELETE /actions/{actionID}/purge[?workspaceID=]
+func (a *application) purgeAction(w http.ResponseWriter, r *http.Request) {
+	actor, ok := a.currentActorHelper(w, r, "err purging action")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	actionID := mux.Vars(r)["actionID"]
+	err := a.actions.PurgeAction(actionID, actor)
+	if err != nil {
+		actionErrHelper(w, err, actionID, "err purging action")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully purged action",
+		Data:    nil,
+	})
+}
+
+// actionErrHelper maps errs from the action model to json responses
+func actionErrHelper(w http.ResponseWriter, err error, actionID string, message string) {
+	var duplicateErr *dbservice.DuplicateEntryErr
+
+	switch {
+	case err == sql.ErrNoRows:
+		utils.SendJSONResponse(w, http.StatusNotFound, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("no actions found with actionID: %v", actionID),
+			Data:    nil,
+		})
+
+	case errors.Is(err, utils.ErrWorkspaceNotFound):
+		utils.SendJSONResponse(w, http.StatusNotFound, &utils.GenericJSONRes{
+			Message: message,
+			Data:    ActionErr{err.Error()},
+		})
+
+	case errors.Is(err, utils.ErrForbidden):
+		utils.SendJSONResponse(w, http.StatusForbidden, &utils.GenericJSONRes{
+			Message: "you are not allowed to modify this action",
+			Data:    nil,
+		})
 
-// deleteAction handles requests for deleting a Action
-// Accessible @ DELETE /actions/{actionID}
-func (a *application) deleteAction(w http.ResponseWriter, r *http.Request) {}
+	case errors.As(err, &duplicateErr):
+		utils.SendJSONResponse(w, http.StatusConflict, &utils.GenericJSONRes{
+			Message: message,
+			Data:    ActionErr{duplicateErr.Error()},
+		})
+
+	default:
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: message,
+			Data:    ActionErr{err.Error()},
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+// getUsers handles requests for listing every user, along with their role
+// Accessible @ GET /admin/users
+func (a *application) getUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := a.users.GetUsers()
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err retrieving users",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved users",
+		Data:    users,
+	})
+}
+
+// updateUserRole handles requests for giving a user a new role
+// The user is logged out of every device, so that their new role takes effect at once
+// Accessible @ PATCH /admin/users/{userID}
+func (a *application) updateUserRole(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var roleParams models.RoleParams
+
+	decodeErr := json.NewDecoder(r.Body).Decode(&roleParams)
+	if decodeErr != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", decodeErr.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := roleParams.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	userID, ok := a.otherUserHelper(w, r, "err updating user")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	user, err := a.users.SetRole(userID, roleParams.Role)
+	if err == nil {
+		err = a.sessions.RevokeSessions(userID)
+	}
+
+	if err != nil {
+		userErrHelper(w, err, "err updating user")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully updated user",
+		Data:    user,
+	})
+}
+
+// disableUser handles requests for disabling a user, logging them out of every device
+// Disabled users cannot log in, and their API keys stop working
+// Accessible @ POST /admin/users/{userID}/disable
+func (a *application) disableUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.otherUserHelper(w, r, "err disabling user")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	user, err := a.users.SetDisabled(userID, true)
+	if err == nil {
+		err = a.sessions.RevokeSessions(userID)
+	}
+
+	if err != nil {
+		userErrHelper(w, err, "err disabling user")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully disabled user",
+		Data:    user,
+	})
+}
+
+// enableUser handles requests for letting a disabled user log in again
+// Accessible @ POST /admin/users/{userID}/enable
+func (a *application) enableUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.otherUserHelper(w, r, "err enabling user")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	user, err := a.users.SetDisabled(userID, false)
+	if err != nil {
+		userErrHelper(w, err, "err enabling user")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully enabled user",
+		Data:    user,
+	})
+}
+
+// unlockUser handles requests for unlocking a user's account, locked after too many failed logins
+// Accessible @ POST /admin/users/{userID}/unlock
+func (a *application) unlockUser(w http.ResponseWriter, r *http.Request) {
+	user, err := a.users.GetByUUID(mux.Vars(r)["userID"])
+	if err == nil {
+		err = a.clearFailedLoginsHelper(user.Username)
+	}
+
+	if err != nil {
+		userErrHelper(w, err, "err unlocking user")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully unlocked user",
+		Data:    user,
+	})
+}
+
+// getAnyAction handles requests for retrieving any single Action, archived or not, whoever owns it
+// Accessible @ GET /admin/actions/{actionID}
+func (a *application) getAnyAction(w http.ResponseWriter, r *http.Request) {
+	actionID := mux.Vars(r)["actionID"]
+	action, err := a.actions.GetAnyAction(actionID)
+	if err != nil {
+		actionErrHelper(w, err, actionID, "err retrieving action")
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved action",
+		Data:    action,
+	})
+}
+
+// purgeAnyAction handles requests for permanently deleting any archived Action, whoever owns it
+// Accessible @ DELETE /admin/actions/{actionID}/purge
+func (a *application) purgeAnyAction(w http.ResponseWriter, r *http.Request) {
+	actionID := mux.Vars(r)["actionID"]
+	err := a.actions.PurgeAnyAction(actionID)
+	if err != nil {
+		actionErrHelper(w, err, actionID, "err purging action")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully purged action",
+		Data:    nil,
+	})
+}
+
+// otherUserHelper reads the id of the user an admin route acts on,
+// refusing to let admins act on themselves, lest they lock themselves out
+func (a *application) otherUserHelper(w http.ResponseWriter, r *http.Request, message string) (string, bool) {
+	adminID, ok := a.currentUserHelper(w, r, message)
+	if !ok {
+		return "", false
+	}
+
+	userID := mux.Vars(r)["userID"]
+	if userID == adminID {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: message,
+			Data:    AuthError{"admins cannot change their own role, or disable themselves"},
+		})
+		return "", false
+	}
+
+	return userID, true
+}
+
+// userErrHelper sends back the right response for errs acting on a user
+func userErrHelper(w http.ResponseWriter, err error, message string) {
+	if err == sql.ErrNoRows {
+		utils.SendJSONResponse(w, http.StatusNotFound, &utils.GenericJSONRes{
+			Message: "no user found with that userID",
+			Data:    nil,
+		})
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+		Message: message,
+		Data:    AuthError{err.Error()},
+	})
+}
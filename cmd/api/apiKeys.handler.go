@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+// createAPIKey handles requests for creating an API key, for scripts and CI jobs to use in place of logging in
+// The key is in the response, and never again: only its hash is kept
+// Accessible @ POST /auth/keys
+func (a *application) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var params models.APIKeyParams
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := params.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	userID, ok := a.currentUserHelper(w, r, "err creating API key")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	key, err := a.apiKeys.CreateAPIKey(userID, params)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err creating API key",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusCreated, &utils.GenericJSONRes{
+		Message: "successfully created API key. Copy it now, it will not be shown again",
+		Data:    key,
+	})
+}
+
+// getAPIKeys handles requests for listing the user's API keys, without the keys themselves
+// Accessible @ GET /auth/keys
+func (a *application) getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.currentUserHelper(w, r, "err retrieving API keys")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	keys, err := a.apiKeys.GetAPIKeys(userID)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err retrieving API keys",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved API keys",
+		Data:    keys,
+	})
+}
+
+// revokeAPIKey handles requests for revoking an API key, which stops working at once
+// Accessible @ DELETE /auth/keys/{keyID}
+func (a *application) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.currentUserHelper(w, r, "err revoking API key")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	err := a.apiKeys.RevokeAPIKey(mux.Vars(r)["keyID"], userID)
+	if err != nil {
+		if errors.Is(err, utils.ErrAPIKeyNotFound) {
+			utils.SendJSONResponse(w, http.StatusNotFound, &utils.GenericJSONRes{
+				Message: "no API key found with that keyID",
+				Data:    nil,
+			})
+			return
+		}
+
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err revoking API key",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully revoked API key",
+		Data:    nil,
+	})
+}
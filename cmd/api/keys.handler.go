@@ -0,0 +1,18 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// getJWKS handles requests for the public keys tokens are signed with, as a JSON Web Key Set,
+// so that other services can verify tokens without sharing a secret
+// Accessible @ GET /.well-known/jwks.json
+func (a *application) getJWKS(w http.ResponseWriter, r *http.Request) {
+	// verifiers are expected to refetch the set when they meet a token with an unknown kid
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+
+	// the set is served bare, in the shape every JWT library expects
+	_ = json.NewEncoder(w).Encode(a.keys.JWKS())
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/mailer"
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+)
+
+// unlockAccount handles requests for unlocking an account with the token emailed to it as it was locked
+// Accessible @ POST /auth/unlock
+func (a *application) unlockAccount(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var params models.UnlockParams
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := params.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	_, err = a.loginAttempts.RedeemUnlockToken(params.Token)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrUnlockTokenInvalid) {
+			status = http.StatusBadRequest
+		}
+
+		utils.SendJSONResponse(w, status, &utils.GenericJSONRes{
+			Message: "err unlocking account",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully unlocked account. Log in again",
+		Data:    nil,
+	})
+}
+
+// throttleLoginHelper refuses logins to the account of the user named username,
+// or from the client's ip, made too soon after others failed, and logins to locked accounts
+// It is called before any pwd is checked, so that refused logins cannot guess one either
+// Logins let through are counted as failed already, and must be released with releaseLoginHelper unless they fail
+// Errs are sent back with message
+func (a *application) throttleLoginHelper(w http.ResponseWriter, r *http.Request, username string, message string) (*loginReservation, bool) {
+	accountKey, ipKey := models.AccountAttemptsKey(username), models.IPAttemptsKey(clientIPHelper(r))
+
+	// logins refused outright are not counted, so that retrying too soon does not put the next try off further
+	account, err := a.loginAttempts.GetLoginAttempts(accountKey)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: message,
+			Data:    AuthError{err.Error()},
+		})
+		return nil, false
+	}
+
+	ip, err := a.loginAttempts.GetLoginAttempts(ipKey)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: message,
+			Data:    AuthError{err.Error()},
+		})
+		return nil, false
+	}
+
+	if a.refuseLoginHelper(w, account, ip, message) {
+		return nil, false
+	}
+
+	// the others are counted before they are let through, and judged again on the logins counted ahead of them,
+	// so that racing logins cannot all slip through on the same count
+	account, err = a.loginAttempts.ReserveLoginAttempt(accountKey, a.loginPolicy.LockoutDuration)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: message,
+			Data:    AuthError{err.Error()},
+		})
+		return nil, false
+	}
+
+	ip, err = a.loginAttempts.ReserveLoginAttempt(ipKey, a.loginPolicy.LockoutDuration)
+	if err != nil {
+		a.releaseAttemptHelper(accountKey, account)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: message,
+			Data:    AuthError{err.Error()},
+		})
+		return nil, false
+	}
+
+	login := &loginReservation{username: username, ip: clientIPHelper(r), account: account, ipAttempts: ip}
+	if a.refuseLoginHelper(w, account, ip, message) {
+		a.releaseLoginHelper(login)
+		return nil, false
+	}
+
+	return login, true
+}
+
+// loginReservation is a login let through by throttleLoginHelper, to the account of the user named username, from ip,
+// with the failures counted against each before it
+type loginReservation struct {
+	username   string
+	ip         string
+	account    *models.LoginAttempts
+	ipAttempts *models.LoginAttempts
+}
+
+// refuseLoginHelper refuses a login to an account with account's failures, from an ip with ip's,
+// if it is locked or the login comes too soon after them, with message, and reports whether it did
+func (a *application) refuseLoginHelper(w http.ResponseWriter, account *models.LoginAttempts, ip *models.LoginAttempts, message string) bool {
+	at := time.Now()
+	if a.loginPolicy.Locked(account) {
+		setRetryAfterHelper(w, account.LastFailedAt.Add(a.loginPolicy.LockoutDuration).Sub(at))
+		utils.SendJSONResponse(w, http.StatusLocked, &utils.GenericJSONRes{
+			Message: message,
+			Data:    AuthError{utils.ACCOUNT_LOCKED_ERR},
+		})
+		return true
+	}
+
+	retryAfter := a.loginPolicy.RetryAfter(account, a.loginPolicy.FreeAttempts, at)
+	if ipRetryAfter := a.loginPolicy.RetryAfter(ip, a.loginPolicy.IPFreeAttempts, at); ipRetryAfter > retryAfter {
+		retryAfter = ipRetryAfter
+	}
+
+	if retryAfter > 0 {
+		setRetryAfterHelper(w, retryAfter)
+		utils.SendJSONResponse(w, http.StatusTooManyRequests, &utils.GenericJSONRes{
+			Message: message,
+			Data:    AuthError{utils.LOGIN_THROTTLED_ERR},
+		})
+		return true
+	}
+
+	return false
+}
+
+// recordFailedLoginHelper settles a login let through by throttleLoginHelper as failed
+// It was counted as it was let through, so all that is left is to email users who exist a link to unlock their account as it is locked
+// Only the login counted last before the threshold saw the account's failures one short of it, so the email is sent once
+func (a *application) recordFailedLoginHelper(login *loginReservation, exists bool) {
+	if exists && a.loginPolicy.LockoutThreshold > 0 && login.account.Failures+1 == a.loginPolicy.LockoutThreshold {
+		go a.sendUnlockHelper(login.username)
+	}
+}
+
+// releaseLoginHelper uncounts a login let through by throttleLoginHelper, once it turns out not to have failed,
+// taking back the failure time it was counted at, so that it does not put off the next try either
+// Failing to uncount it should not change what the client is told, so errs are logged
+func (a *application) releaseLoginHelper(login *loginReservation) {
+	a.releaseAttemptHelper(models.AccountAttemptsKey(login.username), login.account)
+	a.releaseAttemptHelper(models.IPAttemptsKey(login.ip), login.ipAttempts)
+}
+
+// releaseAttemptHelper uncounts a login reserved under key, given the failures reserved returned, logging errs
+func (a *application) releaseAttemptHelper(key string, reserved *models.LoginAttempts) {
+	err := a.loginAttempts.ReleaseLoginAttempt(key, reserved)
+	if err != nil {
+		log.Println("release login attempt: ", err)
+	}
+}
+
+// clearFailedLoginsHelper forgets the failed logins to the account of the user named username,
+// once they log in, or prove who they are some other way
+func (a *application) clearFailedLoginsHelper(username string) error {
+	return a.loginAttempts.ClearLoginAttempts(models.AccountAttemptsKey(username))
+}
+
+// sendUnlockHelper emails a user whose account was just locked a link to unlock it
+// There is no one to report errs to, so they are logged
+func (a *application) sendUnlockHelper(username string) {
+	token, err := a.loginAttempts.CreateUnlockToken(username, a.loginPolicy.LockoutDuration)
+	if err != nil {
+		log.Println("create unlock token: ", err)
+		return
+	}
+
+	err = a.mailer.Send(mailer.Message{
+		To:      username,
+		Subject: "Your account has been locked",
+		Body: fmt.Sprintf("Your timelineapi account was locked after %v failed logins. If it was you, unlock it @\r\n\r\n%v/unlock?token=%v\r\n\r\nThe link works once, for %v. If it was not you, someone may be guessing your password: reset it to something they cannot guess.",
+			a.loginPolicy.LockoutThreshold, a.appURL, token, a.loginPolicy.LockoutDuration),
+	})
+	if err != nil {
+		log.Println("send unlock: ", err)
+	}
+}
+
+// setRetryAfterHelper tells the client how long to wait before trying again, in whole seconds
+func setRetryAfterHelper(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprintf("%.0f", math.Ceil(wait.Seconds())))
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/middleware"
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/security"
+	"github.com/dmithamo/timelineapi/pkg/store"
+)
+
+// throttledApp is an api where ada@example.com, with the pwd Pa55word!, may guess 3 times, and then waits a minute between guesses
+func throttledApp(t *testing.T) (*application, *store.Stores, *store.Caches, string) {
+	t.Helper()
+
+	keys, err := security.NewKeyManager("s3cret", "", 0)
+	if err != nil {
+		t.Fatalf("NewKeyManager: %v", err)
+	}
+
+	stores := store.NewMemoryStores()
+	caches := store.NewMemoryCaches(store.SessionPolicy{IdleTimeout: time.Hour, Lifetime: 24 * time.Hour})
+
+	a := &application{
+		keys:          keys,
+		users:         stores.Users,
+		mfa:           stores.MFA,
+		sessions:      caches.Sessions,
+		loginAttempts: caches.LoginAttempts,
+		loginPolicy: models.LoginPolicy{
+			FreeAttempts:    3,
+			IPFreeAttempts:  20,
+			BaseDelay:       time.Minute,
+			MaxDelay:        time.Minute,
+			LockoutDuration: time.Hour,
+		},
+	}
+
+	credentials := &models.UserCredentials{Username: "ada@example.com", Password: "Pa55word!"}
+	err = stores.Users.CreateUser(credentials)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	user, err := stores.Users.GetByCredentials(credentials)
+	if err != nil {
+		t.Fatalf("GetByCredentials: %v", err)
+	}
+
+	return a, stores, caches, user.UserID
+}
+
+// loggedIn logs the user with userID in, returning a func that sends body to a handler as them, through CheckAuth,
+// and returns the status it answers with
+func loggedIn(t *testing.T, a *application, stores *store.Stores, userID string) func(handler http.HandlerFunc, body string) int {
+	t.Helper()
+
+	session, err := a.sessions.CreateSession(userID, "lockout_test", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	token, err := a.keys.GenerateToken(userID, session.SessionID, models.RoleUser)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	auth := middleware.CheckAuth(a.keys, a.sessions, stores.APIKeys)
+	return func(handler http.HandlerFunc, body string) int {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+*token)
+		w := httptest.NewRecorder()
+		auth(handler).ServeHTTP(w, r)
+		return w.Code
+	}
+}
+
+// enrollMFA enrolls the user with userID in mfa, without confirming it, returning their totp secret
+func enrollMFA(t *testing.T, stores *store.Stores, userID string) string {
+	t.Helper()
+
+	err := security.SetTOTPKey("lockout_test")
+	if err != nil {
+		t.Fatalf("SetTOTPKey: %v", err)
+	}
+
+	enrollment, err := stores.MFA.EnrollMFA(userID)
+	if err != nil {
+		t.Fatalf("EnrollMFA: %v", err)
+	}
+
+	return enrollment.Secret
+}
+
+// enableMFA turns mfa on for the user with userID, returning their totp secret
+func enableMFA(t *testing.T, stores *store.Stores, userID string) string {
+	t.Helper()
+
+	secret := enrollMFA(t, stores, userID)
+	code, err := security.GenerateTOTPCode(secret, time.Now())
+	if err == nil {
+		_, err = stores.MFA.ConfirmMFA(userID, code)
+	}
+	if err != nil {
+		t.Fatalf("ConfirmMFA: %v", err)
+	}
+
+	return secret
+}
+
+// expectThrottled guesses wrong until the free guesses are spent, and checks that the next guess, even a right one, is refused
+func expectThrottled(t *testing.T, a *application, wrong func() int, right func() int) {
+	t.Helper()
+
+	for i := 0; i <= a.loginPolicy.FreeAttempts; i++ {
+		if code := wrong(); code == http.StatusTooManyRequests || code < 400 || code >= 500 {
+			t.Fatalf("wrong guess %v: got %v, expected it to be checked, and refused", i+1, code)
+		}
+	}
+
+	if code := right(); code != http.StatusTooManyRequests {
+		t.Errorf("right guess once the free ones are spent: got %v, expected %v", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestRacingLoginsAreThrottled(t *testing.T) {
+	a, _, caches, _ := throttledApp(t)
+
+	login := func(password string) int {
+		r := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"username": "ada@example.com", "password": "`+password+`"}`))
+		w := httptest.NewRecorder()
+		a.loginUser(w, r)
+		return w.Code
+	}
+
+	// every wrong guess made at once is judged on those made ahead of it, so only the free ones get to check a pwd
+	const racing = 20
+	codes := make(chan int, racing)
+	var wg sync.WaitGroup
+	for i := 0; i < racing; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- login("Wr0ng!pass")
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counted := map[int]int{}
+	for code := range codes {
+		counted[code]++
+	}
+
+	if counted[http.StatusBadRequest] != a.loginPolicy.FreeAttempts+1 || counted[http.StatusTooManyRequests] != racing-a.loginPolicy.FreeAttempts-1 {
+		t.Errorf("racing wrong logins: got %v, expected %v checked and the rest throttled", counted, a.loginPolicy.FreeAttempts+1)
+	}
+
+	// refused logins were not counted, and neither is the right pwd once it is let through
+	attempts, err := caches.LoginAttempts.GetLoginAttempts(models.AccountAttemptsKey("ada@example.com"))
+	if err != nil || attempts.Failures != a.loginPolicy.FreeAttempts+1 {
+		t.Errorf("GetLoginAttempts after racing logins: got %+v, %v, expected %v failures", attempts, err, a.loginPolicy.FreeAttempts+1)
+	}
+
+	if code := login("Pa55word!"); code != http.StatusTooManyRequests {
+		t.Errorf("login with the right pwd while throttled: got %v, expected %v", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestReauthIsThrottled(t *testing.T) {
+	tests := []struct {
+		name   string
+		params func(secret string, right bool) models.ReauthParams
+	}{
+		{name: "currentPassword", params: func(secret string, right bool) models.ReauthParams {
+			if right {
+				return models.ReauthParams{CurrentPassword: "Pa55word!"}
+			}
+			return models.ReauthParams{CurrentPassword: "Wr0ng!pass"}
+		}},
+		{name: "mfa code", params: func(secret string, right bool) models.ReauthParams {
+			if right {
+				code, _ := security.GenerateTOTPCode(secret, time.Now())
+				return models.ReauthParams{Code: code}
+			}
+			return models.ReauthParams{Code: "000000"}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, stores, _, userID := throttledApp(t)
+			secret := enableMFA(t, stores, userID)
+
+			reauth := func(right bool) func() int {
+				return func() int {
+					w := httptest.NewRecorder()
+					a.reauthHelper(w, httptest.NewRequest(http.MethodDelete, "/users/me", nil), userID, "", tt.params(secret, right), "err deleting account")
+					return w.Code
+				}
+			}
+
+			expectThrottled(t, a, reauth(false), reauth(true))
+		})
+	}
+}
+
+func TestMFACodesAreThrottled(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(a *application) http.HandlerFunc
+		setUp   func(t *testing.T, stores *store.Stores, userID string) string
+	}{
+		{name: "confirmMFA", handler: func(a *application) http.HandlerFunc { return a.confirmMFA }, setUp: enrollMFA},
+		{name: "disableMFA", handler: func(a *application) http.HandlerFunc { return a.disableMFA }, setUp: enableMFA},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, stores, _, userID := throttledApp(t)
+			secret := tt.setUp(t, stores, userID)
+			send := loggedIn(t, a, stores, userID)
+
+			expectThrottled(t, a, func() int {
+				return send(tt.handler(a), `{"code": "000000"}`)
+			}, func() int {
+				code, _ := security.GenerateTOTPCode(secret, time.Now())
+				return send(tt.handler(a), `{"code": "`+code+`"}`)
+			})
+		})
+	}
+}
+
+func TestPasswordChangesAreThrottled(t *testing.T) {
+	a, stores, _, userID := throttledApp(t)
+	send := loggedIn(t, a, stores, userID)
+
+	expectThrottled(t, a, func() int {
+		return send(a.changePassword, `{"currentPassword": "Wr0ng!pass", "newPassword": "c0rrect-H0rse-battery"}`)
+	}, func() int {
+		return send(a.changePassword, `{"currentPassword": "Pa55word!", "newPassword": "c0rrect-H0rse-battery"}`)
+	})
+}
+
+func TestReleasedChecksKeepTheFailureTime(t *testing.T) {
+	a, _, caches, userID := throttledApp(t)
+
+	reauth := func(password string) int {
+		w := httptest.NewRecorder()
+		a.reauthHelper(w, httptest.NewRequest(http.MethodDelete, "/users/me", nil), userID, "", models.ReauthParams{CurrentPassword: password}, "err deleting account")
+		return w.Code
+	}
+
+	if code := reauth("Wr0ng!pass"); code != http.StatusBadRequest {
+		t.Fatalf("reauth with a wrong pwd: got %v, expected %v", code, http.StatusBadRequest)
+	}
+
+	failed, err := caches.LoginAttempts.GetLoginAttempts(models.AccountAttemptsKey("ada@example.com"))
+	if err != nil {
+		t.Fatalf("GetLoginAttempts: %v", err)
+	}
+
+	// a check that succeeds was counted as failed as it was let through, and takes the failure time it was counted at back
+	time.Sleep(10 * time.Millisecond)
+	if code := reauth("Pa55word!"); code != http.StatusOK {
+		t.Fatalf("reauth with the right pwd: got %v, expected %v", code, http.StatusOK)
+	}
+
+	attempts, err := caches.LoginAttempts.GetLoginAttempts(models.AccountAttemptsKey("ada@example.com"))
+	if err != nil || attempts.Failures != 1 || !attempts.LastFailedAt.Equal(failed.LastFailedAt) {
+		t.Errorf("GetLoginAttempts after a released check: got %+v, %v, expected %+v", attempts, err, failed)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+)
+
+// enrollMFA handles requests for a new totp secret, to add to an authenticator app
+// mfa is only enabled once the user confirms it @ POST /auth/mfa/confirm
+// Accessible @ POST /auth/mfa/enroll
+func (a *application) enrollMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.currentUserHelper(w, r, "err enrolling in mfa")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	enrollment, err := a.mfa.EnrollMFA(userID)
+	if err != nil {
+		mfaErrHelper(w, err, "err enrolling in mfa")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully enrolled in mfa. Add the secret to an authenticator app, and confirm it with a code",
+		Data:    enrollment,
+	})
+}
+
+// confirmMFA handles requests for enabling mfa, with a code of the secret the user enrolled
+// The user's recovery codes are in the response, and never again
+// Wrong codes count as failed logins, and are throttled as loginUser throttles wrong pwds
+// Accessible @ POST /auth/mfa/confirm
+func (a *application) confirmMFA(w http.ResponseWriter, r *http.Request) {
+	params, ok := decodeMFACodeHelper(w, r)
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	userID, ok := a.currentUserHelper(w, r, "err confirming mfa")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	user, err := a.users.GetByUUID(userID)
+	if err != nil {
+		userErrHelper(w, err, "err confirming mfa")
+		return
+	}
+
+	login, ok := a.throttleLoginHelper(w, r, user.Username, "err confirming mfa")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	codes, err := a.mfa.ConfirmMFA(userID, params.Code)
+	if err != nil && errors.Is(err, utils.ErrMFACodeInvalid) {
+		a.recordFailedLoginHelper(login, true)
+		mfaErrHelper(w, err, "err confirming mfa")
+		return
+	}
+
+	// the code was counted as failed as it was let through, and it did not
+	a.releaseLoginHelper(login)
+
+	if err != nil {
+		mfaErrHelper(w, err, "err confirming mfa")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully enabled mfa. Keep the recovery codes somewhere safe: they are not shown again",
+		Data:    &models.MFARecovery{RecoveryCodes: codes},
+	})
+}
+
+// disableMFA handles requests for turning mfa off, given a totp or recovery code
+// Wrong codes count as failed logins, and are throttled as loginUser throttles wrong pwds
+// Accessible @ POST /auth/mfa/disable
+func (a *application) disableMFA(w http.ResponseWriter, r *http.Request) {
+	params, ok := decodeMFACodeHelper(w, r)
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	userID, ok := a.currentUserHelper(w, r, "err disabling mfa")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	user, err := a.users.GetByUUID(userID)
+	if err != nil {
+		userErrHelper(w, err, "err disabling mfa")
+		return
+	}
+
+	login, ok := a.throttleLoginHelper(w, r, user.Username, "err disabling mfa")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	err = a.mfa.VerifyMFA(userID, params.Code)
+	if err != nil && errors.Is(err, utils.ErrMFACodeInvalid) {
+		a.recordFailedLoginHelper(login, true)
+		mfaErrHelper(w, err, "err disabling mfa")
+		return
+	}
+
+	// the code was counted as failed as it was let through, and it did not
+	a.releaseLoginHelper(login)
+
+	if err == nil {
+		err = a.mfa.DisableMFA(userID)
+	}
+	if err != nil {
+		mfaErrHelper(w, err, "err disabling mfa")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully disabled mfa",
+		Data:    nil,
+	})
+}
+
+// loginMFA handles requests for finishing logging in, trading an mfa token and a totp or recovery code for a session
+// Wrong codes count as failed logins, and are throttled as loginUser throttles wrong pwds
+// Accessible @ POST /auth/login/mfa[?tokens=true]
+func (a *application) loginMFA(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var params models.MFALoginParams
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := params.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	userID, err := a.keys.ValidateMFAToken(params.MFAToken)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusUnauthorized, &utils.GenericJSONRes{
+			Message: "err logging in",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	user, err := a.users.GetByUUID(userID)
+	if err != nil {
+		userErrHelper(w, err, "err logging in")
+		return
+	}
+
+	login, ok := a.throttleLoginHelper(w, r, user.Username, "err logging in")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	err = a.mfa.VerifyMFA(userID, params.Code)
+	if err != nil && errors.Is(err, utils.ErrMFACodeInvalid) {
+		a.recordFailedLoginHelper(login, true)
+		mfaErrHelper(w, err, "err logging in")
+		return
+	}
+
+	// the login was counted as failed as it was let through, and it did not
+	a.releaseLoginHelper(login)
+
+	if err != nil {
+		mfaErrHelper(w, err, "err logging in")
+		return
+	}
+
+	// the user may have been disabled since their pwd checked out
+	if user.DisabledAt != nil {
+		utils.SendJSONResponse(w, http.StatusForbidden, &utils.GenericJSONRes{
+			Message: utils.USER_DISABLED_ERR,
+			Data:    nil,
+		})
+		return
+	}
+
+	a.loginUserHelper(w, r, user)
+}
+
+// challengeMFAHelper hands a user whose pwd checked out the mfa token they trade for a session @ POST /auth/login/mfa
+func (a *application) challengeMFAHelper(w http.ResponseWriter, user *models.User) {
+	token, expiresIn, err := a.keys.GenerateMFAToken(user.UserID)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err logging in",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "mfa required. Finish logging in @ POST /auth/login/mfa, with a code from your authenticator app",
+		Data:    &models.MFAChallenge{MFARequired: true, MFAToken: token, ExpiresIn: expiresIn},
+	})
+}
+
+// decodeMFACodeHelper decodes request body into MFACodeParams, validating them
+func decodeMFACodeHelper(w http.ResponseWriter, r *http.Request) (*models.MFACodeParams, bool) {
+	defer r.Body.Close()
+	var params models.MFACodeParams
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return nil, false
+	}
+
+	validationErrs := params.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return nil, false
+	}
+
+	return &params, true
+}
+
+// mfaErrHelper sends back an err from the mfa store, with the status it calls for
+func mfaErrHelper(w http.ResponseWriter, err error, message string) {
+	status := http.StatusInternalServerError
+	switch {
+	case err == sql.ErrNoRows:
+		status = http.StatusNotFound
+	case errors.Is(err, utils.ErrMFACodeInvalid):
+		status = http.StatusUnauthorized
+	case errors.Is(err, utils.ErrMFAAlreadyEnabled), errors.Is(err, utils.ErrMFANotEnrolled), errors.Is(err, utils.ErrMFANotEnabled):
+		status = http.StatusBadRequest
+	}
+
+	utils.SendJSONResponse(w, status, &utils.GenericJSONRes{
+		Message: message,
+		Data:    AuthError{err.Error()},
+	})
+}
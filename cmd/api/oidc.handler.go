@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/oidc"
+	"github.com/dmithamo/timelineapi/pkg/security"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+// OIDCProvider is an identity provider users may log in with, as listed to clients
+type OIDCProvider struct {
+	Name     string `json:"name"`
+	Issuer   string `json:"issuer"`
+	LoginURL string `json:"loginURL"`
+}
+
+// getOIDCProviders handles requests for the identity providers users may log in with
+// Accessible @ GET /auth/oidc/providers
+func (a *application) getOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	providers := []OIDCProvider{}
+	for _, p := range a.oidcProviders {
+		providers = append(providers, OIDCProvider{Name: p.Name, Issuer: p.Issuer, LoginURL: "/auth/oidc/" + p.Name + "/login"})
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved identity providers",
+		Data:    providers,
+	})
+}
+
+// loginOIDC handles requests for logging in with an identity provider, sending the user to it
+// The login's state, nonce and PKCE verifier are kept in a short-lived oidc_state cookie, for the callback to check
+// Accessible @ GET /auth/oidc/{provider}/login
+func (a *application) loginOIDC(w http.ResponseWriter, r *http.Request) {
+	provider, ok := a.oidcProviderHelper(w, r)
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	state := security.OIDCState{Provider: provider.Name}
+	var err error
+	for _, value := range []*string{&state.State, &state.Nonce, &state.Verifier} {
+		if err == nil {
+			*value, err = oidc.GenerateVerifier()
+		}
+	}
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err logging in",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusBadGateway, &utils.GenericJSONRes{
+			Message: "err reaching identity provider",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	err = a.keys.SetOIDCStateCookie(w, state)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err logging in",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallback handles the identity provider sending the user back, trading its code for who they are, and logging them in
+// Identities are linked to users as they first log in: to the user whose username is their verified email,
+// or else to a user created for them. Users who enabled mfa still give their second factor @ POST /auth/login/mfa
+// Accessible @ GET /auth/oidc/{provider}/callback?code=...&state=...[&tokens=true]
+func (a *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := a.oidcProviderHelper(w, r)
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	state, err := a.keys.OIDCStateFromRequest(w, r, provider.Name)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "err logging in",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	// users who cancel, or whom the provider turns away, come back with an error rather than a code
+	query := r.URL.Query()
+	if query.Get("error") != "" || query.Get("code") == "" {
+		detail := query.Get("error")
+		if description := query.Get("error_description"); description != "" {
+			detail += ": " + description
+		}
+
+		utils.SendJSONResponse(w, http.StatusUnauthorized, &utils.GenericJSONRes{
+			Message: "err logging in with identity provider",
+			Data:    AuthError{detail},
+		})
+		return
+	}
+
+	claims, err := provider.Exchange(query.Get("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusUnauthorized, &utils.GenericJSONRes{
+			Message: "err logging in with identity provider",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	login, err := a.identities.LoginWithIdentity(&models.ExternalIdentity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrIdentityEmailNotVerified) {
+			status = http.StatusForbidden
+		}
+
+		utils.SendJSONResponse(w, status, &utils.GenericJSONRes{
+			Message: "err logging in",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	// whoever signed up with the email before it was verified may have logged in since, and is logged out
+	if login.Claimed {
+		err = a.sessions.RevokeSessions(login.UserID)
+		if err != nil {
+			log.Println("revoke sessions of claimed account: ", err)
+		}
+	}
+
+	user, err := a.users.GetByUUID(login.UserID)
+	if err != nil {
+		userErrHelper(w, err, "err logging in")
+		return
+	}
+
+	if user.DisabledAt != nil {
+		utils.SendJSONResponse(w, http.StatusForbidden, &utils.GenericJSONRes{
+			Message: utils.USER_DISABLED_ERR,
+			Data:    nil,
+		})
+
+		return
+	}
+
+	if user.MFAEnabledAt != nil {
+		a.challengeMFAHelper(w, user)
+		return
+	}
+
+	a.loginUserHelper(w, r, user)
+}
+
+// getIdentities handles requests for the identity providers the current user logs in with
+// Accessible @ GET /users/me/identities
+func (a *application) getIdentities(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.currentUserHelper(w, r, "err retrieving identities")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	identities, err := a.identities.GetIdentities(userID)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err retrieving identities",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	a.nameIdentitiesHelper(identities)
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved identities",
+		Data:    identities,
+	})
+}
+
+// nameIdentitiesHelper names identities by the provider they are at, if it is still configured
+func (a *application) nameIdentitiesHelper(identities []models.ExternalIdentity) {
+	for i := range identities {
+		for _, p := range a.oidcProviders {
+			if p.Issuer == identities[i].Issuer {
+				identities[i].Provider = p.Name
+			}
+		}
+	}
+}
+
+// oidcProviderHelper finds the identity provider a request names, sending back a 404 if there is none by that name
+func (a *application) oidcProviderHelper(w http.ResponseWriter, r *http.Request) (*oidc.Provider, bool) {
+	provider, ok := a.oidcProviders.Get(mux.Vars(r)["provider"])
+	if !ok {
+		utils.SendJSONResponse(w, http.StatusNotFound, &utils.GenericJSONRes{
+			Message: utils.OIDC_PROVIDER_NOT_FOUND_ERR,
+			Data:    nil,
+		})
+		return nil, false
+	}
+
+	return provider, true
+}
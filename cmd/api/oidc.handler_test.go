@@ -0,0 +1,273 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/oidc"
+	"github.com/dmithamo/timelineapi/pkg/oidc/oidctest"
+	"github.com/dmithamo/timelineapi/pkg/security"
+	"github.com/dmithamo/timelineapi/pkg/store"
+	"github.com/gorilla/mux"
+)
+
+// noRedirects is a client that stops at redirects, so that where they go can be read
+var noRedirects = &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}}
+
+// oidcApp is an api that users log in to with a mock provider, named mock, served until the server returned is closed
+func oidcApp(t *testing.T) (*application, *store.Stores, *store.Caches, *httptest.Server) {
+	t.Helper()
+
+	mock, err := oidctest.NewProvider("", "timelineapi", "")
+	if err != nil {
+		t.Fatalf("oidctest.NewProvider: %v", err)
+	}
+
+	server := httptest.NewServer(mock)
+	mock.Issuer = server.URL
+
+	provider, err := oidc.NewProvider(oidc.Config{Name: "mock", Issuer: server.URL, ClientID: "timelineapi", RedirectURL: "http://api.test/auth/oidc/mock/callback"})
+	if err != nil {
+		server.Close()
+		t.Fatalf("NewProvider: %v", err)
+	}
+
+	keys, err := security.NewKeyManager("s3cret", "", 0)
+	if err != nil {
+		server.Close()
+		t.Fatalf("NewKeyManager: %v", err)
+	}
+
+	stores := store.NewMemoryStores()
+	caches := store.NewMemoryCaches(store.SessionPolicy{IdleTimeout: time.Hour, Lifetime: 24 * time.Hour})
+	a := &application{
+		keys:          keys,
+		users:         stores.Users,
+		identities:    stores.Identities,
+		sessions:      caches.Sessions,
+		loginAttempts: caches.LoginAttempts,
+		oidcProviders: oidc.Providers{provider},
+	}
+
+	return a, stores, caches, server
+}
+
+// oidcLogin logs in to the api with the mock provider as email, returning the callback's response
+// tamper changes the authorization request on its way to the provider, as an attacker might
+func oidcLogin(t *testing.T, a *application, email string, verified bool, tamper func(request url.Values)) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/login", nil), map[string]string{"provider": "mock"})
+	w := httptest.NewRecorder()
+	a.loginOIDC(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("loginOIDC: got %v, %v", w.Code, w.Body)
+	}
+
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("loginOIDC: %v", err)
+	}
+
+	request := authURL.Query()
+	request.Set("email", email)
+	request.Set("decision", "allow")
+	if verified {
+		request.Set("email_verified", "true")
+	}
+	if tamper != nil {
+		tamper(request)
+	}
+	authURL.RawQuery = ""
+
+	res, err := noRedirects.PostForm(authURL.String(), request)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	res.Body.Close()
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if res.StatusCode != http.StatusFound || err != nil {
+		t.Fatalf("authorize: got %v to %q", res.Status, res.Header.Get("Location"))
+	}
+
+	r = mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/callback?"+callback.RawQuery, nil), map[string]string{"provider": "mock"})
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+
+	w = httptest.NewRecorder()
+	a.oidcCallback(w, r)
+	return w
+}
+
+// loggedInUser is the user a callback logged in, read off its session_token cookie
+func loggedInUser(t *testing.T, a *application, w *httptest.ResponseRecorder) *models.User {
+	t.Helper()
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name != "session_token" {
+			continue
+		}
+
+		claims, err := a.keys.ValidateToken(cookie.Value)
+		if err != nil {
+			t.Fatalf("ValidateToken: %v", err)
+		}
+
+		userID, _ := claims.UID.(string)
+		user, err := a.users.GetByUUID(userID)
+		if err != nil {
+			t.Fatalf("GetByUUID: %v", err)
+		}
+
+		return user
+	}
+
+	t.Fatalf("no session_token was set: %v, %v", w.Code, w.Body)
+	return nil
+}
+
+func TestOIDCLoginChecks(t *testing.T) {
+	a, _, _, server := oidcApp(t)
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		email    string
+		verified bool
+		tamper   func(request url.Values)
+		expected int
+	}{
+		{name: "verified email", email: "ada@example.com", verified: true, expected: http.StatusOK},
+		{name: "unverified email", email: "bob@example.com", verified: false, expected: http.StatusForbidden},
+		{name: "another code_challenge", email: "cy@example.com", verified: true, tamper: func(request url.Values) {
+			request.Set("code_challenge", oidc.CodeChallenge("another verifier"))
+		}, expected: http.StatusUnauthorized},
+		{name: "another nonce", email: "dan@example.com", verified: true, tamper: func(request url.Values) {
+			request.Set("nonce", "another nonce")
+		}, expected: http.StatusUnauthorized},
+		{name: "another state", email: "eve@example.com", verified: true, tamper: func(request url.Values) {
+			request.Set("state", "another state")
+		}, expected: http.StatusBadRequest},
+	}
+
+	// identities are linked as they first log in, so each logs in as someone new
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := oidcLogin(t, a, tt.email, tt.verified, tt.tamper)
+			if w.Code != tt.expected {
+				t.Errorf("oidcCallback: got %v, %v, expected %v", w.Code, w.Body, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginLinksAndProvisions(t *testing.T) {
+	a, stores, _, server := oidcApp(t)
+	defer server.Close()
+
+	credentials := &models.UserCredentials{Username: "ada@example.com", Password: "Pa55word!"}
+	err := stores.Users.CreateUser(credentials)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	verification, err := stores.EmailVerifications.CreateEmailVerification("ada@example.com", time.Hour, 0)
+	if err == nil {
+		_, err = stores.EmailVerifications.VerifyEmail(verification.Token)
+	}
+	if err != nil {
+		t.Fatalf("verifying ada's email: %v", err)
+	}
+
+	ada, err := stores.Users.GetByCredentials(credentials)
+	if err != nil {
+		t.Fatalf("GetByCredentials: %v", err)
+	}
+
+	// an identity is linked to the user whose username is its email, however the provider writes it, and keeps their pwd
+	w := oidcLogin(t, a, "Ada@Example.com", true, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("oidcCallback: got %v, %v", w.Code, w.Body)
+	}
+
+	if user := loggedInUser(t, a, w); user.UserID != ada.UserID {
+		t.Errorf("oidcCallback logged in %+v, expected ada", user)
+	}
+
+	identities, err := stores.Identities.GetIdentities(ada.UserID)
+	if err != nil || len(identities) != 1 || identities[0].Email != "Ada@Example.com" {
+		t.Errorf("GetIdentities: %+v, %v", identities, err)
+	}
+
+	_, err = stores.Users.GetByCredentials(credentials)
+	if err != nil {
+		t.Errorf("GetByCredentials once linked: %v", err)
+	}
+
+	// anyone else gets a user of their own, with their email verified
+	w = oidcLogin(t, a, "bob@example.com", true, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("oidcCallback: got %v, %v", w.Code, w.Body)
+	}
+
+	bob := loggedInUser(t, a, w)
+	if bob.UserID == ada.UserID || bob.Username != "bob@example.com" || bob.EmailVerifiedAt == nil {
+		t.Errorf("oidcCallback provisioned %+v", bob)
+	}
+
+	// logging in again is logging in as the same user
+	w = oidcLogin(t, a, "bob@example.com", true, nil)
+	if w.Code != http.StatusOK || loggedInUser(t, a, w).UserID != bob.UserID {
+		t.Errorf("oidcCallback for a linked identity: got %v, %v", w.Code, w.Body)
+	}
+}
+
+func TestOIDCLoginClaimsUnverifiedAccounts(t *testing.T) {
+	a, stores, caches, server := oidcApp(t)
+	defer server.Close()
+
+	// whoever signed up with ada's email, without verifying it, is logged in somewhere
+	credentials := &models.UserCredentials{Username: "ada@example.com", Password: "Pa55word!"}
+	err := stores.Users.CreateUser(credentials)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	squatter, err := stores.Users.GetByCredentials(credentials)
+	if err != nil {
+		t.Fatalf("GetByCredentials: %v", err)
+	}
+
+	_, err = caches.Sessions.CreateSession(squatter.UserID, "squatter", "203.0.113.7")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	w := oidcLogin(t, a, "ada@example.com", true, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("oidcCallback: got %v, %v", w.Code, w.Body)
+	}
+
+	// the account is ada's now, and only she is logged in to it, with the pwd she never set dropped
+	sessions, err := caches.Sessions.GetSessions(squatter.UserID)
+	if err != nil || len(sessions) != 1 || sessions[0].UserAgent == "squatter" {
+		t.Errorf("GetSessions of a claimed account: %+v, %v", sessions, err)
+	}
+
+	if user := loggedInUser(t, a, w); user.UserID != squatter.UserID || user.EmailVerifiedAt == nil {
+		t.Errorf("oidcCallback logged in %+v, expected the claimed account", user)
+	}
+
+	_, err = stores.Users.GetByCredentials(credentials)
+	if err == nil {
+		t.Errorf("GetByCredentials with the pwd of a claimed account: expected an err")
+	}
+}
@@ -1,27 +1,257 @@
 package main
 
-import "net/http"
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dmithamo/timelineapi/pkg/dbservice"
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+// OutputErr structures an err that arises during output
+type OutputErr struct {
+	Message string `json:"detail,omitempty"`
+}
 
 // createOutput handles requests for creating a new output
-// Accessible @ POST /outputs
-func (a *application) createOutput(w http.ResponseWriter, r *http.Request) {}
+// Accessible @ POST /outputs[?workspaceID=]
+func (a *application) createOutput(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var outputParams models.OutputParams
+
+	decodeErr := json.NewDecoder(r.Body).Decode(&outputParams)
+	if decodeErr != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", decodeErr.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := outputParams.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	actor, ok := a.currentActorHelper(w, r, "err creating output")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	err := a.outputs.CreateOutput(outputParams, actor)
+	if err != nil {
+		// a missing or foreign parent is reported against the action
+		actionErrHelper(w, err, outputParams.ActionID, "err creating output")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusCreated, &utils.GenericJSONRes{
+		Message: "successfully created output",
+		Data:    nil,
+	})
+}
+
+// getOutputs handles requests for retrieving all outputs visible to the user
+// Accessible @ GET /outputs[?workspaceID=], or GET /outputs?archived=true[&workspaceID=] for archived ones
+func (a *application) getOutputs(w http.ResponseWriter, r *http.Request) {
+	actor, ok := a.currentActorHelper(w, r, "err retrieving outputs")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
 
-// getOutputs handles requests for retrieving all outputs
-// Accessible @ GET /outputs
-func (a *application) getOutputs(w http.ResponseWriter, r *http.Request) {}
+	archived := r.URL.Query().Get("archived") == "true"
+	allOutputs, err := a.outputs.GetOutputs(actor, archived)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrWorkspaceNotFound) {
+			status = http.StatusNotFound
+		}
+
+		utils.SendJSONResponse(w, status, &utils.GenericJSONRes{
+			Message: "err retrieving outputs",
+			Data:    OutputErr{err.Error()},
+		})
+		return
+	}
+
+	if allOutputs == nil {
+		utils.SendJSONResponse(w, http.StatusNotFound, &utils.GenericJSONRes{
+			Message: "no outputs found",
+			Data:    nil,
+		})
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved outputs",
+		Data:    allOutputs,
+	})
+}
 
 // getOutput handles requests for retrieving a single output by outputID
-// Accessible @ GET /outputs/{outputID}
-func (a *application) getOutput(w http.ResponseWriter, r *http.Request) {}
+// Accessible @ GET /outputs/{outputID}[?workspaceID=]
+func (a *application) getOutput(w http.ResponseWriter, r *http.Request) {
+	actor, ok := a.currentActorHelper(w, r, "err retrieving output")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	outputID := mux.Vars(r)["outputID"]
+	output, err := a.outputs.GetOutputByID(outputID, actor)
+	if err != nil {
+		outputErrHelper(w, err, outputID, "err retrieving output")
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved output",
+		Data:    output,
+	})
+}
+
+// getOutputsByAction handles requests for retrieving an action's outputs by actionID
+// Accessible @ GET /actions/{actionID}/outputs[?workspaceID=]
+func (a *application) getOutputsByAction(w http.ResponseWriter, r *http.Request) {
+	actor, ok := a.currentActorHelper(w, r, "err retrieving outputs")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	actionID := mux.Vars(r)["actionID"]
+	outputs, err := a.outputs.GetOutputsByAction(actionID, actor)
+	if err != nil {
+		actionErrHelper(w, err, actionID, "err retrieving outputs")
+		return
+	}
 
-// getOutputsByAction handles requests for retrieving a project's outputs by projectID
-// Accessible @ GET /outputs/{projectID}
-func (a *application) getOutputsByAction(w http.ResponseWriter, r *http.Request) {}
+	if outputs == nil {
+		utils.SendJSONResponse(w, http.StatusNotFound, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("no outputs found for actionID: %v", actionID),
+			Data:    nil,
+		})
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved outputs",
+		Data:    outputs,
+	})
+}
 
 // updateOutput handles requests for editing output
-// Accesible @ PATCH /outputs/{outputID}
-func (a *application) updateOutput(w http.ResponseWriter, r *http.Request) {}
+// Accesible @ PATCH /outputs/{outputID}[?workspaceID=]
+func (a *application) updateOutput(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var outputParams models.OutputParams
+
+	decodeErr := json.NewDecoder(r.Body).Decode(&outputParams)
+	if decodeErr != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", decodeErr.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := outputParams.ValidatePartial()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	actor, ok := a.currentActorHelper(w, r, "err updating output")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	outputID := mux.Vars(r)["outputID"]
+	output, err := a.outputs.UpdateOutput(outputID, actor, outputParams)
+	if err != nil {
+		outputErrHelper(w, err, outputID, "err updating output")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully updated output",
+		Data:    output,
+	})
+}
+
+// deleteOutput handles requests for deleting (archiving) an output
+// Accessible @ DELETE /outputs/{outputID}[?workspaceID=]
+func (a *application) deleteOutput(w http.ResponseWriter, r *http.Request) {
+	actor, ok := a.currentActorHelper(w, r, "err deleting output")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	outputID := mux.Vars(r)["outputID"]
+	err := a.outputs.ArchiveOutput(outputID, actor)
+	if err != nil {
+		outputErrHelper(w, err, outputID, "err deleting output")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully deleted output",
+		Data:    nil,
+	})
+}
+
+// outputErrHelper maps errs from the output model to json responses
+func outputErrHelper(w http.ResponseWriter, err error, outputID string, message string) {
+	var duplicateErr *dbservice.DuplicateEntryErr
+
+	switch {
+	case err == sql.ErrNoRows:
+		utils.SendJSONResponse(w, http.StatusNotFound, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("no outputs found with outputID: %v", outputID),
+			Data:    nil,
+		})
+
+	case errors.Is(err, utils.ErrWorkspaceNotFound):
+		utils.SendJSONResponse(w, http.StatusNotFound, &utils.GenericJSONRes{
+			Message: message,
+			Data:    OutputErr{err.Error()},
+		})
+
+	case errors.Is(err, utils.ErrForbidden):
+		utils.SendJSONResponse(w, http.StatusForbidden, &utils.GenericJSONRes{
+			Message: "you are not allowed to modify this output",
+			Data:    nil,
+		})
+
+	case errors.As(err, &duplicateErr):
+		utils.SendJSONResponse(w, http.StatusConflict, &utils.GenericJSONRes{
+			Message: message,
+			Data:    OutputErr{duplicateErr.Error()},
+		})
 
-// deleteOutput handles requests for deleting a output
-// Accessible @ DELETE /outputs/{outputID}
-func (a *application) deleteOutput(w http.ResponseWriter, r *http.Request) {}
+	default:
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: message,
+			Data:    OutputErr{err.Error()},
+		})
+	}
+}
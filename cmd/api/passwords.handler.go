@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/dmithamo/timelineapi/pkg/mailer"
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+)
+
+// forgotPassword handles requests for a pwd reset email
+// It succeeds whether or not the user exists, so that it cannot be used to find out who has an account
+// Accessible @ POST /auth/password/forgot
+func (a *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var params models.ForgotPasswordParams
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := params.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	// the reset is started, and emailed, in the background, so that how long it takes gives nothing away either
+	go a.sendPasswordResetHelper(params.Username)
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "if that user exists, a link to reset their password has been emailed to them",
+		Data:    nil,
+	})
+}
+
+// sendPasswordResetHelper starts a pwd reset for a user, and emails them its link
+// There is no one to report errs to, so they are logged
+func (a *application) sendPasswordResetHelper(username string) {
+	reset, err := a.passwordResets.CreatePasswordReset(username, a.resetTTL)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("create password reset: ", err)
+		}
+		return
+	}
+
+	err = a.mailer.Send(mailer.Message{
+		To:      reset.Username,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your timelineapi account. If it was you, reset it @\r\n\r\n%v/password/reset?token=%v\r\n\r\nThe link works once, until %v. If it was not you, ignore this email: your password has not changed.",
+			a.appURL, reset.Token, reset.ExpiresAt.UTC().Format("2006-01-02 15:04 MST")),
+	})
+	if err != nil {
+		log.Println("send password reset: ", err)
+	}
+}
+
+// resetPassword handles requests for resetting a pwd with the token emailed to the user
+// Every device the user is logged in on is logged out, and their account is unlocked
+// Accessible @ POST /auth/password/reset
+func (a *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var params models.ResetPasswordParams
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := params.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	userID, err := a.passwordResets.ResetPassword(params)
+	if err != nil {
+		if errors.Is(err, utils.ErrPasswordContainsEmail) {
+			utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+				Message: "validation errors in params",
+				Data:    &models.ResetPasswordParams{NewPassword: err.Error()},
+			})
+			return
+		}
+
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrResetTokenInvalid) {
+			status = http.StatusBadRequest
+		}
+
+		utils.SendJSONResponse(w, status, &utils.GenericJSONRes{
+			Message: "err resetting password",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	// whoever else knew the old password is logged out
+	err = a.sessions.RevokeSessions(userID)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "reset password, but could not log out other devices",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	// proving who they are by email unlocks their account, as an unlock link would
+	user, err := a.users.GetByUUID(userID)
+	if err == nil {
+		err = a.clearFailedLoginsHelper(user.Username)
+	}
+
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "reset password, but could not unlock account",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully reset password. Log in with the new one",
+		Data:    nil,
+	})
+}
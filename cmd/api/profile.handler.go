@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+)
+
+// getProfile handles requests for retrieving the current user, profile included
+// Accessible @ GET /users/me
+func (a *application) getProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.currentUserHelper(w, r, "err retrieving profile")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	user, err := a.users.GetByUUID(userID)
+	if err != nil {
+		userErrHelper(w, err, "err retrieving profile")
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved profile",
+		Data:    user,
+	})
+}
+
+// updateProfile handles requests for changing the current user's display name, timezone or avatar url
+// Accessible @ PATCH /users/me
+func (a *application) updateProfile(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var params models.ProfileParams
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := params.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	userID, ok := a.currentUserHelper(w, r, "err updating profile")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	user, err := a.users.UpdateProfile(userID, params)
+	if err != nil {
+		userErrHelper(w, err, "err updating profile")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully updated profile",
+		Data:    user,
+	})
+}
+
+// changePassword handles requests for changing the current user's password, given their current one
+// Every other device is logged out, while this one stays logged in
+// Wrong currentPasswords count as failed logins, and are throttled as loginUser throttles them
+// Accessible @ POST /users/me/password
+func (a *application) changePassword(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var params models.PasswordParams
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := params.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	userID, sessionID, ok := a.currentSessionHelper(w, r, "err changing password")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	user, err := a.users.GetByUUID(userID)
+	if err != nil {
+		userErrHelper(w, err, "err changing password")
+		return
+	}
+
+	login, ok := a.throttleLoginHelper(w, r, user.Username, "err changing password")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	err = a.users.ChangePassword(userID, params)
+	if err != nil && errors.Is(err, utils.ErrWrongPassword) {
+		a.recordFailedLoginHelper(login, true)
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "err changing password",
+			Data:    &models.PasswordParams{CurrentPassword: "wrong currentPassword"},
+		})
+		return
+	}
+
+	// the currentPassword was counted as wrong as it was let through, and it was not
+	a.releaseLoginHelper(login)
+
+	if err != nil {
+		if errors.Is(err, utils.ErrPasswordContainsEmail) {
+			utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+				Message: "validation errors in params",
+				Data:    &models.PasswordParams{NewPassword: err.Error()},
+			})
+			return
+		}
+
+		userErrHelper(w, err, "err changing password")
+		return
+	}
+
+	// whoever else knew the old password is logged out
+	err = a.revokeOtherSessionsHelper(userID, sessionID)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "changed password, but could not log out other devices",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully changed password. Other devices have been logged out",
+		Data:    nil,
+	})
+}
+
+// revokeOtherSessionsHelper revokes every session of a user, but the one they are using
+func (a *application) revokeOtherSessionsHelper(userID string, sessionID string) error {
+	sessions, err := a.sessions.GetSessions(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.SessionID == sessionID {
+			continue
+		}
+
+		// sessions ending as they are revoked are already gone
+		err := a.sessions.RevokeSession(session.SessionID, userID)
+		if err != nil && !errors.Is(err, utils.ErrSessionNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+)
+
+// SearchErr structures an err that arises during search
+type SearchErr struct {
+	Message string `json:"detail,omitempty"`
+}
+
+// search handles requests for searching the actions and outputs visible to the user
+// Accessible @ GET /search?q=&limit=&workspaceID=
+func (a *application) search(w http.ResponseWriter, r *http.Request) {
+	searchParams := models.NewSearchParams(r.URL.Query())
+
+	validationErrs := searchParams.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in query params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	actor, ok := a.currentActorHelper(w, r, "err searching")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	results, err := a.searcher.Search(actor, *searchParams)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrWorkspaceNotFound) {
+			status = http.StatusNotFound
+		}
+
+		utils.SendJSONResponse(w, status, &utils.GenericJSONRes{
+			Message: "err searching",
+			Data:    SearchErr{err.Error()},
+		})
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully searched",
+		Data:    results,
+	})
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/security"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+// refreshParams is the body of a refresh request, for clients that do not keep cookies
+type refreshParams struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// refreshSession handles requests for exchanging a refresh token for a new access token,
+// and a new refresh token. Each refresh token is good for a single exchange
+// The refresh token is read from the body, or else the refresh_token cookie
+// Accessible @ POST /auth/refresh[?tokens=true]
+func (a *application) refreshSession(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	refreshToken, ok := refreshTokenHelper(w, r)
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	session, err := a.sessions.RotateSession(refreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrSessionNotFound):
+			security.ClearSessionCookies(w)
+			utils.SendJSONResponse(w, http.StatusUnauthorized, &utils.GenericJSONRes{
+				Message: "session expired or revoked. Please log in again",
+				Data:    nil,
+			})
+
+		case errors.Is(err, utils.ErrRefreshTokenReused):
+			security.ClearSessionCookies(w)
+			utils.SendJSONResponse(w, http.StatusUnauthorized, &utils.GenericJSONRes{
+				Message: "refresh token already used. The session has been revoked, please log in again",
+				Data:    nil,
+			})
+
+		default:
+			utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+				Message: "err refreshing session",
+				Data:    AuthError{err.Error()},
+			})
+		}
+		return
+	}
+
+	// roles are read afresh on every refresh, so that a changed role reaches the token
+	user, err := a.users.GetByUUID(session.UserID)
+	if err == nil && user.DisabledAt != nil {
+		err = utils.ErrUserDisabled
+	}
+
+	if err != nil {
+		if err == sql.ErrNoRows || errors.Is(err, utils.ErrUserDisabled) {
+			// sessions of disabled users are revoked when they are disabled, this is belt and braces
+			_ = a.sessions.RevokeSession(session.SessionID, session.UserID)
+			security.ClearSessionCookies(w)
+			utils.SendJSONResponse(w, http.StatusForbidden, &utils.GenericJSONRes{
+				Message: utils.USER_DISABLED_ERR,
+				Data:    nil,
+			})
+			return
+		}
+
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err refreshing session",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	tokens, ok := a.issueTokensHelper(w, r, session, user.Role, "err refreshing session")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully refreshed session",
+		Data:    tokens,
+	})
+}
+
+// refreshTokenHelper reads the refresh token a request carries
+// A token in the body takes precedence over the refresh_token cookie
+func refreshTokenHelper(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var params refreshParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+
+	// an empty body is fine, the token is then expected in a cookie
+	if err != nil && err != io.EOF {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return "", false
+	}
+
+	if params.RefreshToken != "" {
+		return params.RefreshToken, true
+	}
+
+	cookie, err := r.Cookie("refresh_token")
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusUnauthorized, &utils.GenericJSONRes{
+			Message: "no valid refresh token",
+			Data:    nil,
+		})
+		return "", false
+	}
+
+	return cookie.Value, true
+}
+
+// getSessions handles requests for listing the user's active sessions, one per logged in device
+// Accessible @ GET /auth/sessions
+func (a *application) getSessions(w http.ResponseWriter, r *http.Request) {
+	userID, sessionID, ok := a.currentSessionHelper(w, r, "err retrieving sessions")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	sessions, err := a.sessions.GetSessions(userID)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err retrieving sessions",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionID == sessionID
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved sessions",
+		Data:    sessions,
+	})
+}
+
+// revokeSession handles requests for logging a single device out
+// Accessible @ DELETE /auth/sessions/{sessionID}
+func (a *application) revokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, sessionID, ok := a.currentSessionHelper(w, r, "err revoking session")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	revokedID := mux.Vars(r)["sessionID"]
+	err := a.sessions.RevokeSession(revokedID, userID)
+	if err != nil {
+		if errors.Is(err, utils.ErrSessionNotFound) {
+			utils.SendJSONResponse(w, http.StatusNotFound, &utils.GenericJSONRes{
+				Message: "no active session found with that sessionID",
+				Data:    nil,
+			})
+			return
+		}
+
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err revoking session",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	// revoking the current session is just logging out
+	if revokedID == sessionID {
+		security.ClearSessionCookies(w)
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully revoked session",
+		Data:    nil,
+	})
+}
+
+// logoutAllSessions handles requests for logging out of every device, this one included
+// Accessible @ POST /auth/logout/all
+func (a *application) logoutAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.currentUserHelper(w, r, "err logging out")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	err := a.sessions.RevokeSessions(userID)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err logging out",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	security.ClearSessionCookies(w)
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully logged out of all devices",
+		Data:    nil,
+	})
+}
+
+// issueTokensHelper signs an access token for a session, carrying the user's role,
+// handing it to the client in cookies alongside the session's fresh refresh token
+// Clients asking with `?tokens=true` get the tokens back as the response's data too, others get no data
+func (a *application) issueTokensHelper(w http.ResponseWriter, r *http.Request, session *models.Session, role string, message string) (interface{}, bool) {
+	token, err := a.keys.GenerateToken(session.UserID, session.SessionID, role)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: message,
+			Data:    AuthError{err.Error()},
+		})
+		return nil, false
+	}
+
+	security.SetSessionCookies(w, *token, session.RefreshToken)
+
+	if r.URL.Query().Get("tokens") != "true" {
+		return nil, true
+	}
+
+	return security.NewTokens(*token, session.RefreshToken), true
+}
+
+// clientIPHelper reads the client's address off a request, as recorded against its session
+func clientIPHelper(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
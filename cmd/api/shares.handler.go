@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+// shareAction handles requests for sharing an action with another user
+// Accessible @ POST /actions/{actionID}/shares
+func (a *application) shareAction(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var shareParams models.ShareParams
+
+	decodeErr := json.NewDecoder(r.Body).Decode(&shareParams)
+	if decodeErr != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", decodeErr.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := shareParams.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	actor, ok := a.currentActorHelper(w, r, "err sharing action")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	actionID := mux.Vars(r)["actionID"]
+	err := a.actions.ShareAction(actionID, actor, shareParams)
+	if err != nil {
+		shareErrHelper(w, err, actionID, "err sharing action")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: fmt.Sprintf("successfully shared action with %v", shareParams.Username),
+		Data:    nil,
+	})
+}
+
+// getActionShares handles requests for listing who an action is shared with
+// Accessible @ GET /actions/{actionID}/shares
+func (a *application) getActionShares(w http.ResponseWriter, r *http.Request) {
+	actor, ok := a.currentActorHelper(w, r, "err retrieving shares")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	actionID := mux.Vars(r)["actionID"]
+	shares, err := a.actions.GetActionShares(actionID, actor)
+	if err != nil {
+		shareErrHelper(w, err, actionID, "err retrieving shares")
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved shares",
+		Data:    shares,
+	})
+}
+
+// unshareAction handles requests for revoking another user's access to an action
+// Accessible @ DELETE /actions/{actionID}/shares/{userID}
+func (a *application) unshareAction(w http.ResponseWriter, r *http.Request) {
+	actor, ok := a.currentActorHelper(w, r, "err unsharing action")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	actionID := mux.Vars(r)["actionID"]
+	err := a.actions.UnshareAction(actionID, actor, mux.Vars(r)["userID"])
+	if err != nil {
+		shareErrHelper(w, err, actionID, "err unsharing action")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully unshared action",
+		Data:    nil,
+	})
+}
+
+// shareErrHelper maps errs from the share model to json responses
+func shareErrHelper(w http.ResponseWriter, err error, actionID string, message string) {
+	switch {
+	case errors.Is(err, utils.ErrShareWithOwner), errors.Is(err, utils.ErrShareWorkspaceAction):
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: message,
+			Data:    ActionErr{err.Error()},
+		})
+
+	default:
+		actionErrHelper(w, err, actionID, message)
+	}
+}
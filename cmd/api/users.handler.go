@@ -3,10 +3,11 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"time"
 
+	"github.com/dmithamo/timelineapi/pkg/middleware"
 	"github.com/dmithamo/timelineapi/pkg/models"
 	"github.com/dmithamo/timelineapi/pkg/security"
 	"github.com/dmithamo/timelineapi/pkg/utils"
@@ -21,15 +22,14 @@ type AuthError struct {
 // Accessible @ POST /auth/register
 func (a *application) registerUser(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	credentials, ok := a.decodeParamsHelper(w, r)
+	credentials, ok := a.decodeParamsHelper(w, r, false)
 
 	if !ok {
 		// err is already handled (sent back as jsonRes to user)
 		return
 	}
 
-	var u models.User
-	err := u.CreateUser(a.db, credentials)
+	err := a.users.CreateUser(credentials)
 
 	if err != nil {
 		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
@@ -40,38 +40,52 @@ func (a *application) registerUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the user proves they own their email with the link emailed to them
+	go a.sendVerificationHelper(credentials.Username, 0)
+
 	// success!
 	// TODO: login user after successful registration?
 	utils.SendJSONResponse(w, http.StatusCreated, &utils.GenericJSONRes{
-		Message: "successfully created user",
+		Message: "successfully created user. Verify your email with the link sent to it",
 		Data:    nil,
 	})
 }
 
 // login handles requests for login
-// Accessible @ POST /auth/login
+// Logins made too soon after others failed are refused with a 429, and those to locked accounts with a 423
+// Accessible @ POST /auth/login[?tokens=true]
 func (a *application) loginUser(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	credentials, ok := a.decodeParamsHelper(w, r)
+	credentials, ok := a.decodeParamsHelper(w, r, true)
 
 	if !ok {
 		// err is already handled (sent back as jsonRes to user)
 		return
 	}
 
-	var u models.User
-	user, err := u.GetByCredentials(a.db, credentials)
+	login, ok := a.throttleLoginHelper(w, r, credentials.Username, "err logging in")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
 
-	if err != nil {
-		if err == sql.ErrNoRows || err.Error() == utils.WRONG_PASSWORD_ERR {
-			utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
-				Message: "wrong username or password",
-				Data:    nil,
-			})
+	user, err := a.users.GetByCredentials(credentials)
 
-			return
-		}
+	if err != nil && (err == sql.ErrNoRows || errors.Is(err, utils.ErrWrongPassword)) {
+		// unknown users are counted too, so that lockouts do not tell who has an account
+		a.recordFailedLoginHelper(login, err != sql.ErrNoRows)
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "wrong username or password",
+			Data:    nil,
+		})
+
+		return
+	}
+
+	// the login was counted as failed as it was let through, and it did not
+	a.releaseLoginHelper(login)
 
+	if err != nil {
 		// any other errs
 		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
 			Message: fmt.Sprintf("err loggin in: %v", err.Error()),
@@ -81,12 +95,37 @@ func (a *application) loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user.DisabledAt != nil {
+		utils.SendJSONResponse(w, http.StatusForbidden, &utils.GenericJSONRes{
+			Message: utils.USER_DISABLED_ERR,
+			Data:    nil,
+		})
+
+		return
+	}
+
+	if a.verification == models.VerificationLogin && user.EmailVerifiedAt == nil {
+		utils.SendJSONResponse(w, http.StatusForbidden, &utils.GenericJSONRes{
+			Message: utils.EMAIL_NOT_VERIFIED_ERR,
+			Data:    nil,
+		})
+
+		return
+	}
+
+	// users who enabled mfa get an mfa token instead, to trade for a session along with their second factor
+	if user.MFAEnabledAt != nil {
+		a.challengeMFAHelper(w, user)
+		return
+	}
+
 	// if no err, sign user in
 	a.loginUserHelper(w, r, user)
 }
 
 // decodeParamsHelper decodes request body into a credentials struct
-func (a *application) decodeParamsHelper(w http.ResponseWriter, r *http.Request) (*models.UserCredentials, bool) {
+// Passwords are checked against the password policy, unless they are given to log in
+func (a *application) decodeParamsHelper(w http.ResponseWriter, r *http.Request, login bool) (*models.UserCredentials, bool) {
 	var credentials = &models.UserCredentials{}
 	err := json.NewDecoder(r.Body).Decode(credentials)
 
@@ -99,7 +138,12 @@ func (a *application) decodeParamsHelper(w http.ResponseWriter, r *http.Request)
 		return nil, false
 	}
 
-	validationErrs := credentials.Validate()
+	validate := credentials.Validate
+	if login {
+		validate = credentials.ValidateLogin
+	}
+
+	validationErrs := validate()
 	if validationErrs != nil {
 		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
 			Message: "invalid user credentials",
@@ -112,11 +156,86 @@ func (a *application) decodeParamsHelper(w http.ResponseWriter, r *http.Request)
 	return credentials, true
 }
 
-// loginUserHelper logs a user in
+// currentUserHelper reads the authenticated user's id, as CheckAuth found it
+func (a *application) currentUserHelper(w http.ResponseWriter, r *http.Request, message string) (string, bool) {
+	userID, _, ok := a.currentSessionHelper(w, r, message)
+	return userID, ok
+}
+
+// currentActorHelper reads the authenticated user's id, along with the workspace they act in
+// Requests act in the user's personal space, unless they select a workspace with ?workspaceID=
+func (a *application) currentActorHelper(w http.ResponseWriter, r *http.Request, message string) (models.Actor, bool) {
+	userID, ok := a.currentUserHelper(w, r, message)
+	if !ok {
+		return models.Actor{}, false
+	}
+
+	actor := models.NewActor(userID, r.URL.Query())
+	validationErrs := actor.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in query params",
+			Data:    validationErrs,
+		})
+		return models.Actor{}, false
+	}
+
+	return *actor, true
+}
+
+// currentSessionHelper reads the authenticated user's id, and their session's, as CheckAuth found them
+// Requests carrying an API key have no session, and are refused unless the route requires scopes of them
+func (a *application) currentSessionHelper(w http.ResponseWriter, r *http.Request, message string) (string, string, bool) {
+	identity, err := middleware.IdentityFrom(r)
+	if err != nil {
+		status := http.StatusUnauthorized
+		if errors.Is(err, utils.ErrAPIKeyNotAllowed) {
+			status = http.StatusForbidden
+		}
+
+		utils.SendJSONResponse(w, status, &utils.GenericJSONRes{
+			Message: message,
+			Data:    AuthError{err.Error()},
+		})
+
+		return "", "", false
+	}
+
+	return identity.UserID, identity.SessionID, true
+}
+
+// loginUserHelper logs a user in, calling off the deletion of their account if one is scheduled
 func (a *application) loginUserHelper(w http.ResponseWriter, r *http.Request, user *models.User) {
-	// generate session token, create a cookie for the client
-	token, err := security.GenerateToken(user.UserID)
+	// bootstrap admins are made admins as they log in, until there is someone to promote others, so that demoting them later sticks
+	if a.admins[user.Username] && user.Role != models.RoleAdmin {
+		var err error
+		user, err = a.users.BootstrapAdmin(user.UserID)
+		if err != nil {
+			utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+				Message: fmt.Sprintf("err loggin in: %v", err.Error()),
+				Data:    nil,
+			})
+
+			return
+		}
+	}
+
+	// users who asked for their account to be deleted call it off by logging in before it is
+	if user.DeleteAfter != nil {
+		var err error
+		user, err = a.users.CancelDeletion(user.UserID)
+		if err != nil {
+			utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+				Message: "err logging in",
+				Data:    AuthError{err.Error()},
+			})
+
+			return
+		}
+	}
 
+	// failures are forgotten once a login succeeds, second factor and all
+	err := a.clearFailedLoginsHelper(user.Username)
 	if err != nil {
 		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
 			Message: "err logging in",
@@ -125,31 +244,48 @@ func (a *application) loginUserHelper(w http.ResponseWriter, r *http.Request, us
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
-		Value:    *token,
-		HttpOnly: true,
-		Path:     "/",
-		SameSite: 1,
-	})
+	// start a server-side session, so that it can be revoked
+	session, err := a.sessions.CreateSession(user.UserID, r.UserAgent(), clientIPHelper(r))
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err logging in",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	tokens, ok := a.issueTokensHelper(w, r, session, user.Role, "err logging in")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
 
 	// success!
 	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
 		Message: "successfully logged in",
-		Data:    nil,
+		Data:    tokens,
 	})
 }
 
-// loginUser logs a user out
+// logoutUser logs a user out, revoking their current session
+// Accessible @ POST /auth/logout
 func (a *application) logoutUser(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
-		Value:    "",
-		HttpOnly: true,
-		Expires:  time.Unix(0, 0),
-		Path:     "/",
-		SameSite: 1,
-	})
+	// without a readable token there is no session to revoke, but an expired one will do
+	claims, err := a.keys.DecodeToken(r)
+	if err == nil || errors.Is(err, utils.ErrTokenExpired) {
+		userID, _ := claims.UID.(string)
+
+		err = a.sessions.RevokeSession(claims.SessionID, userID)
+		if err != nil && !errors.Is(err, utils.ErrSessionNotFound) {
+			utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+				Message: "err logging out",
+				Data:    AuthError{err.Error()},
+			})
+			return
+		}
+	}
+
+	security.ClearSessionCookies(w)
 
 	// success!
 	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
@@ -157,7 +293,3 @@ func (a *application) logoutUser(w http.ResponseWriter, r *http.Request) {
 		Data:    nil,
 	})
 }
-
-// updateUser handles request for editing user
-// Accessible @ PATCH /auth/register
-func (a *application) updateUser(w http.ResponseWriter, r *http.Request) {}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/mailer"
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+)
+
+// resendsPerIP is how many verification links may be asked for from a single ip, whatever the addresses,
+// before it must go -resendinterval without asking for another
+const resendsPerIP = 5
+
+// resendVerification handles requests for another email verification link
+// Like forgotPassword, it succeeds whether or not the user exists, or is already verified,
+// and so it does when asked too soon after the last link was sent to the address, or too often from the ip, sending nothing
+// Accessible @ POST /auth/verify/resend
+func (a *application) resendVerification(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var params models.ResendVerificationParams
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := params.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	err = a.resendVerificationHelper(params.Username, clientIPHelper(r))
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: "err resending verification email",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "if that user exists, and has not verified their email, a link to verify it has been emailed to them",
+		Data:    nil,
+	})
+}
+
+// resendVerificationHelper emails the user named username another verification link, as asked for from ip
+// Nothing is sent to unknown or verified users, nor when the ip asked too often, or the address was sent a link too recently,
+// and none of that is an err, so that the reply tells nothing of who has an account
+func (a *application) resendVerificationHelper(username string, ip string) error {
+	// asking from the same ip too often is ignored, whether or not the addresses asked for have accounts
+	asked, err := a.loginAttempts.ReserveLoginAttempt(models.ResendAttemptsKey(ip), a.resendInterval)
+	if err != nil {
+		return err
+	}
+
+	if asked.Failures >= resendsPerIP {
+		return nil
+	}
+
+	verification, err := a.verifications.CreateEmailVerification(username, a.verifyTTL, a.resendInterval)
+	if err != nil {
+		if err == sql.ErrNoRows || errors.Is(err, utils.ErrEmailAlreadyVerified) || errors.Is(err, utils.ErrVerificationThrottled) {
+			return nil
+		}
+
+		return err
+	}
+
+	go a.mailVerificationHelper(verification)
+	return nil
+}
+
+// verifyEmail handles requests for verifying a user's email with the token emailed to them
+// Accessible @ POST /auth/verify
+func (a *application) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var params models.VerifyEmailParams
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := params.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	_, err = a.verifications.VerifyEmail(params.Token)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrVerificationTokenInvalid) {
+			status = http.StatusBadRequest
+		}
+
+		utils.SendJSONResponse(w, status, &utils.GenericJSONRes{
+			Message: "err verifying email",
+			Data:    AuthError{err.Error()},
+		})
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully verified email",
+		Data:    nil,
+	})
+}
+
+// sendVerificationHelper starts verifying a user's email, and emails them its link,
+// unless they were sent one less than interval ago
+// There is no one to report errs to, so they are logged
+func (a *application) sendVerificationHelper(username string, interval time.Duration) {
+	verification, err := a.verifications.CreateEmailVerification(username, a.verifyTTL, interval)
+	if err != nil {
+		log.Println("create email verification: ", err)
+		return
+	}
+
+	a.mailVerificationHelper(verification)
+}
+
+// mailVerificationHelper emails a user the link to verify their email
+func (a *application) mailVerificationHelper(verification *models.EmailVerification) {
+	err := a.mailer.Send(mailer.Message{
+		To:      verification.Username,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Welcome to timelineapi! Verify your email @\r\n\r\n%v/verify-email?token=%v\r\n\r\nThe link works once, until %v. If you did not sign up, ignore this email.",
+			a.appURL, verification.Token, verification.ExpiresAt.UTC().Format("2006-01-02 15:04 MST")),
+	})
+	if err != nil {
+		log.Println("send email verification: ", err)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dmithamo/timelineapi/pkg/dbservice"
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+// WorkspaceErr structures an err that arises during workspace management
+type WorkspaceErr struct {
+	Message string `json:"detail,omitempty"`
+}
+
+// createWorkspace handles requests for creating a workspace, owned by the user creating it
+// Accessible @ POST /workspaces
+func (a *application) createWorkspace(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var params models.WorkspaceParams
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := params.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	userID, ok := a.currentUserHelper(w, r, "err creating workspace")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	workspace, err := a.workspaces.CreateWorkspace(userID, params)
+	if err != nil {
+		workspaceErrHelper(w, err, "err creating workspace")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusCreated, &utils.GenericJSONRes{
+		Message: "successfully created workspace",
+		Data:    workspace,
+	})
+}
+
+// getWorkspaces handles requests for listing the workspaces the user is a member of, with their role in each
+// Accessible @ GET /workspaces
+func (a *application) getWorkspaces(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.currentUserHelper(w, r, "err retrieving workspaces")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	workspaces, err := a.workspaces.GetWorkspaces(userID)
+	if err != nil {
+		workspaceErrHelper(w, err, "err retrieving workspaces")
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved workspaces",
+		Data:    workspaces,
+	})
+}
+
+// getWorkspace handles requests for retrieving a single workspace the user is a member of
+// Accessible @ GET /workspaces/{workspaceID}
+func (a *application) getWorkspace(w http.ResponseWriter, r *http.Request) {
+	actor, ok := a.workspaceActorHelper(w, r, "err retrieving workspace")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	workspace, err := a.workspaces.GetWorkspace(actor)
+	if err != nil {
+		workspaceErrHelper(w, err, "err retrieving workspace")
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved workspace",
+		Data:    workspace,
+	})
+}
+
+// getMembers handles requests for listing a workspace's members
+// Accessible @ GET /workspaces/{workspaceID}/members
+func (a *application) getMembers(w http.ResponseWriter, r *http.Request) {
+	actor, ok := a.workspaceActorHelper(w, r, "err retrieving members")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	members, err := a.workspaces.GetMembers(actor)
+	if err != nil {
+		workspaceErrHelper(w, err, "err retrieving members")
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved members",
+		Data:    members,
+	})
+}
+
+// updateMember handles requests for changing a member's role. Only owners may
+// Accessible @ PATCH /workspaces/{workspaceID}/members/{userID}
+func (a *application) updateMember(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var params models.MemberParams
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := params.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	actor, ok := a.workspaceActorHelper(w, r, "err updating member")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	member, err := a.workspaces.UpdateMember(actor, mux.Vars(r)["userID"], params)
+	if err != nil {
+		workspaceErrHelper(w, err, "err updating member")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully updated member",
+		Data:    member,
+	})
+}
+
+// removeMember handles requests for removing a member from a workspace
+// Owners may remove anyone, and every member may remove themselves to leave
+// Accessible @ DELETE /workspaces/{workspaceID}/members/{userID}
+func (a *application) removeMember(w http.ResponseWriter, r *http.Request) {
+	actor, ok := a.workspaceActorHelper(w, r, "err removing member")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	err := a.workspaces.RemoveMember(actor, mux.Vars(r)["userID"])
+	if err != nil {
+		workspaceErrHelper(w, err, "err removing member")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully removed member",
+		Data:    nil,
+	})
+}
+
+// inviteMember handles requests for inviting a user to a workspace, with the role they get once they accept
+// Only owners may
+// Accessible @ POST /workspaces/{workspaceID}/invitations
+func (a *application) inviteMember(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var params models.InvitationParams
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: fmt.Sprintf("err decoding request body: %v", err.Error()),
+			Data:    nil,
+		})
+		return
+	}
+
+	validationErrs := params.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: "validation errors in params",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	actor, ok := a.workspaceActorHelper(w, r, "err inviting member")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	invitation, err := a.workspaces.InviteMember(actor, params)
+	if err != nil {
+		workspaceErrHelper(w, err, "err inviting member")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusCreated, &utils.GenericJSONRes{
+		Message: fmt.Sprintf("successfully invited %v", params.Username),
+		Data:    invitation,
+	})
+}
+
+// getWorkspaceInvitations handles requests for listing a workspace's pending invitations. Only owners may
+// Accessible @ GET /workspaces/{workspaceID}/invitations
+func (a *application) getWorkspaceInvitations(w http.ResponseWriter, r *http.Request) {
+	actor, ok := a.workspaceActorHelper(w, r, "err retrieving invitations")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	invitations, err := a.workspaces.GetWorkspaceInvitations(actor)
+	if err != nil {
+		workspaceErrHelper(w, err, "err retrieving invitations")
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved invitations",
+		Data:    invitations,
+	})
+}
+
+// revokeInvitation handles requests for withdrawing a pending invitation. Only owners may
+// Accessible @ DELETE /workspaces/{workspaceID}/invitations/{invitationID}
+func (a *application) revokeInvitation(w http.ResponseWriter, r *http.Request) {
+	actor, ok := a.workspaceActorHelper(w, r, "err revoking invitation")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	err := a.workspaces.RevokeInvitation(actor, mux.Vars(r)["invitationID"])
+	if err != nil {
+		workspaceErrHelper(w, err, "err revoking invitation")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully revoked invitation",
+		Data:    nil,
+	})
+}
+
+// getInvitations handles requests for listing the invitations the user has yet to accept or decline
+// Accessible @ GET /invitations
+func (a *application) getInvitations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.currentUserHelper(w, r, "err retrieving invitations")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	invitations, err := a.workspaces.GetInvitations(userID)
+	if err != nil {
+		workspaceErrHelper(w, err, "err retrieving invitations")
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully retrieved invitations",
+		Data:    invitations,
+	})
+}
+
+// acceptInvitation handles requests for accepting an invitation, joining its workspace
+// Accessible @ POST /invitations/{invitationID}/accept
+func (a *application) acceptInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.currentUserHelper(w, r, "err accepting invitation")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	workspace, err := a.workspaces.AcceptInvitation(mux.Vars(r)["invitationID"], userID)
+	if err != nil {
+		workspaceErrHelper(w, err, "err accepting invitation")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: fmt.Sprintf("successfully joined %v", workspace.Name),
+		Data:    workspace,
+	})
+}
+
+// declineInvitation handles requests for declining an invitation
+// Accessible @ DELETE /invitations/{invitationID}
+func (a *application) declineInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.currentUserHelper(w, r, "err declining invitation")
+	if !ok {
+		// err is already handled (sent back as jsonRes to user)
+		return
+	}
+
+	err := a.workspaces.DeclineInvitation(mux.Vars(r)["invitationID"], userID)
+	if err != nil {
+		workspaceErrHelper(w, err, "err declining invitation")
+		return
+	}
+
+	// success!
+	utils.SendJSONResponse(w, http.StatusOK, &utils.GenericJSONRes{
+		Message: "successfully declined invitation",
+		Data:    nil,
+	})
+}
+
+// workspaceActorHelper reads the authenticated user's id, acting in the workspace named in the path
+func (a *application) workspaceActorHelper(w http.ResponseWriter, r *http.Request, message string) (models.Actor, bool) {
+	userID, ok := a.currentUserHelper(w, r, message)
+	if !ok {
+		return models.Actor{}, false
+	}
+
+	actor := models.Actor{UserID: userID, WorkspaceID: mux.Vars(r)["workspaceID"]}
+	validationErrs := actor.Validate()
+	if validationErrs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &utils.GenericJSONRes{
+			Message: message,
+			Data:    validationErrs,
+		})
+		return models.Actor{}, false
+	}
+
+	return actor, true
+}
+
+// workspaceErrHelper maps errs from the workspace model to json responses
+func workspaceErrHelper(w http.ResponseWriter, err error, message string) {
+	var duplicateErr *dbservice.DuplicateEntryErr
+
+	switch {
+	case errors.As(err, &duplicateErr):
+		utils.SendJSONResponse(w, http.StatusConflict, &utils.GenericJSONRes{
+			Message: message,
+			Data:    WorkspaceErr{"the user already has a pending invitation to the workspace"},
+		})
+
+	case errors.Is(err, utils.ErrWorkspaceNotFound), errors.Is(err, utils.ErrInvitationNotFound), errors.Is(err, utils.ErrUserNotFound):
+		utils.SendJSONResponse(w, http.StatusNotFound, &utils.GenericJSONRes{
+			Message: message,
+			Data:    WorkspaceErr{err.Error()},
+		})
+
+	case errors.Is(err, utils.ErrForbidden):
+		utils.SendJSONResponse(w, http.StatusForbidden, &utils.GenericJSONRes{
+			Message: "only owners of the workspace may do that",
+			Data:    nil,
+		})
+
+	case errors.Is(err, utils.ErrEmailNotVerified):
+		utils.SendJSONResponse(w, http.StatusForbidden, &utils.GenericJSONRes{
+			Message: utils.EMAIL_NOT_VERIFIED_ERR,
+			Data:    nil,
+		})
+
+	case errors.Is(err, utils.ErrAlreadyMember), errors.Is(err, utils.ErrLastOwner):
+		utils.SendJSONResponse(w, http.StatusConflict, &utils.GenericJSONRes{
+			Message: message,
+			Data:    WorkspaceErr{err.Error()},
+		})
+
+	default:
+		utils.SendJSONResponse(w, http.StatusInternalServerError, &utils.GenericJSONRes{
+			Message: message,
+			Data:    WorkspaceErr{err.Error()},
+		})
+	}
+}
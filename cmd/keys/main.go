@@ -0,0 +1,87 @@
+// package main generates and lists the keys the api signs tokens with
+//
+// Usage:
+//
+//	go run cmd/keys/main.go -dir=keys [-alg=RS256|EdDSA] [-activation=10m] generate | list
+//
+// A generated key is published by the api as it reloads its keys, within a minute, and signs new tokens
+// once it has been for the api's -keyactivation. Older keys keep verifying the tokens they signed, until their files are deleted
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/security"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	dir := flag.String("dir", "", "directory of the <kid>.pem keys, as passed to the api's -keys flag")
+	alg := flag.String("alg", security.EdDSA, "signing alg of generated keys: RS256 or EdDSA")
+	activation := flag.Duration("activation", 10*time.Minute, "the api's -keyactivation, to tell when keys sign from")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %v -dir=<dir> [-alg=RS256|EdDSA] [-activation=10m] generate | list\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// .env is optional here - it only adds SECRET to the list
+	_ = godotenv.Load()
+
+	args := flag.Args()
+	if len(args) == 0 || *dir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "generate":
+		key, err := security.GenerateSigningKey(*dir, *alg)
+		if err != nil {
+			log.Fatal("generate [keys]: ", err)
+		}
+		fmt.Printf("generated %v key %v\n\n", key.Method.Alg(), key.ID)
+
+	case "list":
+		// printed below, whatever the command
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := printKeys(*dir, *activation)
+	if err != nil {
+		log.Fatal("list [keys]: ", err)
+	}
+}
+
+// printKeys prints a table of the keys the api would load, which of them signs, and when those yet to become active will
+func printKeys(dir string, activation time.Duration) error {
+	km, err := security.NewKeyManager(os.Getenv("SECRET"), dir, activation)
+	if err != nil {
+		return err
+	}
+
+	keys, signer := km.Keys()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KID\tALG\tSIGNS\t")
+	for _, key := range keys {
+		signs := ""
+		if key == signer {
+			signs = "yes"
+		} else if time.Now().Before(key.ActiveFrom) {
+			signs = fmt.Sprintf("from %v", key.ActiveFrom.UTC().Format(time.RFC3339))
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t\n", key.ID, key.Method.Alg(), signs)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,110 @@
+// package main applies and reverts db schema migrations
+//
+// Usage:
+//
+//	go run cmd/migrate/main.go -dsn='dbuser:dbpassword@[host]/dbname' up|down [N]|status|to N
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/dmithamo/timelineapi/pkg/dbservice"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	dsn := flag.String("dsn", "", "data source name for the db: a MySQL dsn or postgres://...")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %v -dsn=<dsn> up | down [N] | status | to N\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// .env is optional here - the dsn is all that's needed
+	_ = godotenv.Load()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := dbservice.ConnectDB(dsn)
+	if err != nil {
+		log.Fatal("connectdb [migrate]: ", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		err = dbservice.MigrateUp(db)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatal("down [migrate]: N should be a positive number")
+			}
+		}
+		err = dbservice.MigrateDown(db, steps)
+
+	case "to":
+		if len(args) < 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			log.Fatal("to [migrate]: N should be a migration version")
+		}
+		err = dbservice.MigrateTo(db, version)
+
+	case "status":
+		// printed below, whatever the command
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("%v [migrate]: %v", args[0], err)
+	}
+
+	err = printStatus(db)
+	if err != nil {
+		log.Fatal("status [migrate]: ", err)
+	}
+}
+
+// printStatus prints a table of known migrations and whether they have been applied
+func printStatus(db *sql.DB) error {
+	statuses, err := dbservice.GetMigrationStatus(db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT\t")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format("2006/01/02 03:04:05PM MST")
+		}
+		if status.Modified {
+			appliedAt += " (modified since applied!)"
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t\n", status.Version, status.Name, appliedAt)
+	}
+
+	return w.Flush()
+}
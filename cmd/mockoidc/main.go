@@ -0,0 +1,38 @@
+// package main is a mock OpenID Connect provider, to log in to a local api with, and to test its OIDC login against
+//
+// Usage:
+//
+//	go run cmd/mockoidc/main.go [-addr=:3050] [-issuer=http://127.0.0.1:3050] [-clientid=timelineapi] [-clientsecret=...]
+//
+// and the api, with a -oidc file of
+//
+//	[{"name": "mock", "issuer": "http://127.0.0.1:3050", "clientID": "timelineapi"}]
+//
+// Users log in with whatever email and name they type in, without a pwd.
+// Adding `&login_hint=<email>` to the authorize url skips the form, logging in as that email, verified,
+// for scripts and tests. Never use it for anything else: anyone may log in as anyone
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/dmithamo/timelineapi/pkg/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", ":3050", "address where to serve the provider")
+	issuer := flag.String("issuer", "http://127.0.0.1:3050", "issuer identifier, the base url the api reaches the provider at")
+	clientID := flag.String("clientid", "timelineapi", "client_id the api is registered with")
+	clientSecret := flag.String("clientsecret", "", "client secret the api authenticates with. Public clients, relying on PKCE alone, are let in if unset")
+	flag.Parse()
+
+	p, err := oidctest.NewProvider(*issuer, *clientID, *clientSecret)
+	if err != nil {
+		log.Fatal("generatekey [mockoidc]: ", err)
+	}
+
+	log.Printf("mock oidc provider %v listening at %v", p.Issuer, *addr)
+	log.Fatal("serve [mockoidc]: ", http.ListenAndServe(*addr, p))
+}
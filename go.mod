@@ -9,5 +9,7 @@ require (
 	github.com/gomodule/redigo v1.8.2
 	github.com/gorilla/mux v1.7.4
 	github.com/joho/godotenv v1.3.0
+	github.com/lib/pq v1.10.0
+	github.com/mattn/go-sqlite3 v1.14.0
 	golang.org/x/crypto v0.0.0-20200707235045-ab33eee955e0
 )
@@ -1,14 +1,46 @@
 // package authservice manages authentication/authorization in the application
 package dbservice
 
-import "github.com/gomodule/redigo/redis"
+import (
+	"strings"
+	"time"
 
-// ConnectCache establishes a new conn to the redis db powering auth
-func ConnectCache(dsn *string) (redis.Conn, error) {
-	conn, err := redis.Dial("tcp", *dsn)
+	"github.com/gomodule/redigo/redis"
+)
+
+// ConnectCache creates a pool of conns to the redis db powering auth
+// The dsn is either `[host]:[port]` or a `redis://` url
+func ConnectCache(dsn *string) (*redis.Pool, error) {
+	pool := &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 4 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			if strings.HasPrefix(*dsn, "redis://") || strings.HasPrefix(*dsn, "rediss://") {
+				return redis.DialURL(*dsn)
+			}
+
+			return redis.Dial("tcp", *dsn)
+		},
+		TestOnBorrow: func(conn redis.Conn, lastUsed time.Time) error {
+			// only check conns that have been idle a while
+			if time.Since(lastUsed) < time.Minute {
+				return nil
+			}
+
+			_, err := conn.Do("PING")
+			return err
+		},
+	}
+
+	// fail early if redis is unreachable
+	conn := pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PING")
 	if err != nil {
+		pool.Close()
 		return nil, err
 	}
 
-	return conn, nil
+	return pool, nil
 }
@@ -6,29 +6,57 @@ import (
 
 	"github.com/VividCortex/mysqlerr"
 	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 )
 
+// DuplicateEntryErr is returned when a write violates a unique constraint
+type DuplicateEntryErr struct {
+	Columns []string
+}
+
+// Error formats the offending columns into a user friendly message
+func (e *DuplicateEntryErr) Error() string {
+	return fmt.Sprintf("%v is already in use", strings.Join(e.Columns, ", "))
+}
+
+// MissingReferenceErr is returned when a write violates a foreign key constraint
+type MissingReferenceErr struct{}
+
+// Error describes the violation without leaking the schema
+func (e *MissingReferenceErr) Error() string {
+	return "a record this one refers to does not exist, or is still referred to"
+}
+
 // CheckDatabaseErr returns `better` err messages for db errors
 func CheckDatabaseErr(err error, uniqueColum ...string) error {
 	if err == nil {
 		return nil
 	}
 
-	// db driver errs that we are currently checking
-	driverErrs := map[uint16]error{
-		mysqlerr.ER_DUP_ENTRY: fmt.Errorf("%v is already in use", strings.Join(uniqueColum, ", ")),
-	}
+	switch driverErr := err.(type) {
+	case *mysql.MySQLError:
+		// db driver errs that we are currently checking
+		driverErrs := map[uint16]error{
+			mysqlerr.ER_DUP_ENTRY:           &DuplicateEntryErr{uniqueColum},
+			mysqlerr.ER_NO_REFERENCED_ROW_2: &MissingReferenceErr{},
+			mysqlerr.ER_ROW_IS_REFERENCED_2: &MissingReferenceErr{},
+		}
 
-	// verify that err is driver specific err
-	driverErr, isDriverErr := err.(*mysql.MySQLError)
-	if !isDriverErr {
-		return err
-	}
+		if formattedErr, exists := driverErrs[driverErr.Number]; exists {
+			return formattedErr
+		}
+
+	case *pq.Error:
+		// postgres reports violations by SQLSTATE code
+		driverErrs := map[pq.ErrorCode]error{
+			"23505": &DuplicateEntryErr{uniqueColum},
+			"23503": &MissingReferenceErr{},
+		}
 
-	formattedErr, exists := driverErrs[driverErr.Number]
-	if !exists {
-		return err
+		if formattedErr, exists := driverErrs[driverErr.Code]; exists {
+			return formattedErr
+		}
 	}
 
-	return formattedErr
+	return err
 }
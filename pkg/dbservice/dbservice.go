@@ -1,4 +1,4 @@
-// package dbservice avails mySQL DB functionality
+// package dbservice avails MySQL and PostgreSQL DB functionality
 package dbservice
 
 import (
@@ -7,80 +7,81 @@ import (
 	"strings"
 
 	_ "github.com/go-sql-driver/mysql" // will run pkg's init() func
+	"github.com/lib/pq"
 )
 
-func init() {
-	// initialize schemas
-	initializeSchemas()
-}
+// Dialect names the flavour of SQL a db speaks
+type Dialect string
 
-// ConnectDB establishes a connection to the db
-func ConnectDB(dsn *string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", fmt.Sprintf("%v?parseTime=true", *dsn))
-	if err != nil {
-		return nil, err
-	}
+const (
+	// MySQL is the default dialect, used for plain `user:password@tcp(host)/dbname` dsns
+	MySQL Dialect = "mysql"
 
-	err = db.Ping()
-	if err != nil {
-		return nil, err
+	// Postgres is used for `postgres://` and `postgresql://` dsns
+	Postgres Dialect = "postgres"
+)
+
+// DialectOf tells which dialect a dsn speaks, by its scheme
+func DialectOf(dsn string) Dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return Postgres
 	}
 
-	return db, nil
+	return MySQL
 }
 
-// CreateTables creates all tables in the db
-func CreateTables(db *sql.DB) error {
-	createTableHelper := func(tableName string) error {
-		stmt, err := db.Prepare(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v %v", tableName, schemas[tableName]))
-		if err != nil {
-			return err
-		}
-		defer stmt.Close()
-
-		_, err = stmt.Exec()
-		if err != nil {
-			return err
-		}
-		return nil
+// dialectOf tells which dialect an open db speaks, by its driver
+func dialectOf(db *sql.DB) Dialect {
+	if _, ok := db.Driver().(*pq.Driver); ok {
+		return Postgres
 	}
 
-	// createTables in order - because references
-	err := createTableHelper("users")
-	if err != nil {
-		return err
-	}
+	return MySQL
+}
 
-	err = createTableHelper("actions")
-	if err != nil {
-		return err
+// Rebind rewrites a query's `?` placeholders into the ones the dialect expects
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres {
+		return query
 	}
 
-	err = createTableHelper("outputs")
-	if err != nil {
-		return err
+	var rebound strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			rebound.WriteRune(r)
+			continue
+		}
+
+		n++
+		fmt.Fprintf(&rebound, "$%v", n)
 	}
 
-	return nil
+	return rebound.String()
 }
 
-// DropTables drops the tables in the db
-func DropTables(db *sql.DB) error {
-	tables := []string{}
-	for tableName := range schemas {
-		tables = append(tables, tableName)
-	}
+// ConnectDB establishes a connection to the db, using the driver for the dsn's dialect
+func ConnectDB(dsn *string) (*sql.DB, error) {
+	var db *sql.DB
+	var err error
+
+	switch DialectOf(*dsn) {
+	case Postgres:
+		db, err = sql.Open("postgres", *dsn)
 
-	stmt, err := db.Prepare(fmt.Sprintf("DROP TABLE IF EXISTS %v", strings.Join(tables, ", ")))
+	default:
+		// updates report the rows they match, as in the other dialects, rather than those they change,
+		// and timestamps are read and written in UTC, as they are set from Go
+		db, err = sql.Open("mysql", fmt.Sprintf("%v?parseTime=true&clientFoundRows=true&time_zone=%%27%%2B00%%3A00%%27", *dsn))
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec()
+	err = db.Ping()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return db, nil
 }
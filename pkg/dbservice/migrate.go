@@ -0,0 +1,344 @@
+package dbservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// migrationsLockName names the advisory lock held while migrating,
+// so that two instances never migrate the same db concurrently
+const migrationsLockName = "timelineapi.schema_migrations"
+
+// migrationsLockTimeout is how long (in seconds) to wait for another instance to finish migrating
+const migrationsLockTimeout = 60
+
+// execer is anything statements can be run on: a conn, or a transaction on it
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Migration is a single, versioned change to the db schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// Checksum fingerprints a migration, so that edits to applied migrations are caught
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(strings.Join(m.Up, ";\n") + "\n--down--\n" + strings.Join(m.Down, ";\n")))
+	return fmt.Sprintf("%x", sum)
+}
+
+// MigrationStatus describes whether a migration has been applied to the db
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+	Modified  bool
+}
+
+// appliedMigration is a row in the schema_migrations table
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// LatestVersion is the version of the newest migration known to this build
+// Every dialect has the same versions, so it is the same whichever db is migrated
+func LatestVersion() int {
+	return mysqlMigrations[len(mysqlMigrations)-1].Version
+}
+
+// MigrateUp applies all pending migrations
+func MigrateUp(db *sql.DB) error {
+	return MigrateTo(db, LatestVersion())
+}
+
+// MigrateDown reverts the last `steps` applied migrations
+func MigrateDown(db *sql.DB, steps int) error {
+	return withMigrationsLock(db, func(conn *sql.Conn, dialect Dialect, applied map[int]appliedMigration) error {
+		versions := []int{}
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		target := 0
+		if steps < len(versions) {
+			target = versions[steps]
+		}
+
+		return migrateTo(conn, dialect, applied, target)
+	})
+}
+
+// MigrateTo applies or reverts migrations until the db is at version target
+func MigrateTo(db *sql.DB, target int) error {
+	if target < 0 || target > LatestVersion() {
+		return fmt.Errorf("unknown migration version %v. Use a version between 0 and %v", target, LatestVersion())
+	}
+
+	return withMigrationsLock(db, func(conn *sql.Conn, dialect Dialect, applied map[int]appliedMigration) error {
+		return migrateTo(conn, dialect, applied, target)
+	})
+}
+
+// GetMigrationStatus lists every known migration alongside whether it has been applied
+func GetMigrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	dialect := dialectOf(db)
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	err = createMigrationsTable(conn, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := getAppliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []MigrationStatus{}
+	for _, m := range migrationsFor(dialect) {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if row, ok := applied[m.Version]; ok {
+			appliedAt := row.appliedAt
+			status.AppliedAt = &appliedAt
+			status.Modified = row.checksum != m.Checksum()
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// withMigrationsLock runs fn on a single conn holding the migrations lock,
+// after checking the applied migrations against the ones known to this build
+func withMigrationsLock(db *sql.DB, fn func(conn *sql.Conn, dialect Dialect, applied map[int]appliedMigration) error) error {
+	ctx := context.Background()
+	dialect := dialectOf(db)
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = lockMigrations(conn, dialect)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// the lock is also released when conn closes, so errs here are harmless
+		_, _ = conn.ExecContext(ctx, unlockMigrationsQueries[dialect], migrationsLockName)
+	}()
+
+	err = createMigrationsTable(conn, dialect)
+	if err != nil {
+		return err
+	}
+
+	applied, err := getAppliedMigrations(conn)
+	if err != nil {
+		return err
+	}
+
+	err = verifyMigrations(dialect, applied)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn, dialect, applied)
+}
+
+// unlockMigrationsQueries release the migrations lock, in each dialect
+var unlockMigrationsQueries = map[Dialect]string{
+	MySQL:    "SELECT RELEASE_LOCK(?)",
+	Postgres: "SELECT pg_advisory_unlock(hashtext($1))",
+}
+
+// lockMigrations takes the migrations lock on conn, waiting up to migrationsLockTimeout for it
+func lockMigrations(conn *sql.Conn, dialect Dialect) error {
+	ctx := context.Background()
+
+	if dialect == Postgres {
+		// postgres advisory locks have no timeout of their own, so poll for one
+		for i := 0; i < migrationsLockTimeout; i++ {
+			var locked bool
+			err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", migrationsLockName).Scan(&locked)
+			if err != nil {
+				return err
+			}
+
+			if locked {
+				return nil
+			}
+
+			time.Sleep(1 * time.Second)
+		}
+
+		return fmt.Errorf("timed out waiting for another instance to finish migrating")
+	}
+
+	var locked sql.NullInt64
+	err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationsLockName, migrationsLockTimeout).Scan(&locked)
+	if err != nil {
+		return err
+	}
+
+	if locked.Int64 != 1 {
+		return fmt.Errorf("timed out waiting for another instance to finish migrating")
+	}
+
+	return nil
+}
+
+// migrateTo applies pending migrations up to target, then reverts applied ones above it
+func migrateTo(conn *sql.Conn, dialect Dialect, applied map[int]appliedMigration, target int) error {
+	known := migrationsFor(dialect)
+
+	for _, m := range known {
+		if _, ok := applied[m.Version]; ok || m.Version > target {
+			continue
+		}
+
+		err := runMigrationHelper(conn, dialect, m.Version, m.Up,
+			"INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)", m.Version, m.Name, m.Checksum())
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := len(known) - 1; i >= 0; i-- {
+		m := known[i]
+		if _, ok := applied[m.Version]; !ok || m.Version <= target {
+			continue
+		}
+
+		err := runMigrationHelper(conn, dialect, m.Version, m.Down,
+			"DELETE FROM schema_migrations WHERE version = ?", m.Version)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runMigrationHelper executes the statements of one direction of a migration,
+// then records it in schema_migrations with the bookkeeping statement
+// MySQL commits DDL implicitly, so a failed migration must be repaired by hand.
+// Postgres runs the whole of it in a transaction instead
+func runMigrationHelper(conn *sql.Conn, dialect Dialect, version int, statements []string, bookkeeping string, args ...interface{}) error {
+	ctx := context.Background()
+
+	var ex execer = conn
+	var tx *sql.Tx
+	if dialect == Postgres {
+		var err error
+		tx, err = conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		// a no-op once committed
+		defer tx.Rollback()
+
+		ex = tx
+	}
+
+	for _, statement := range statements {
+		_, err := ex.ExecContext(ctx, statement)
+		if err != nil {
+			return fmt.Errorf("migration %v: %v", version, err)
+		}
+	}
+
+	_, err := ex.ExecContext(ctx, dialect.Rebind(bookkeeping), args...)
+	if err != nil {
+		return err
+	}
+
+	if tx != nil {
+		return tx.Commit()
+	}
+
+	return nil
+}
+
+// migrationsTableSchemas describe the table that tracks applied migrations, in each dialect
+var migrationsTableSchemas = map[Dialect]string{
+	MySQL: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		appliedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+	Postgres: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		appliedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	)`,
+}
+
+// createMigrationsTable creates the table that tracks applied migrations
+func createMigrationsTable(conn *sql.Conn, dialect Dialect) error {
+	_, err := conn.ExecContext(context.Background(), migrationsTableSchemas[dialect])
+	return err
+}
+
+// getAppliedMigrations reads the schema_migrations table
+func getAppliedMigrations(conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(context.Background(), "SELECT version, checksum, appliedAt FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var version int
+		var row appliedMigration
+
+		err := rows.Scan(&version, &row.checksum, &row.appliedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		applied[version] = row
+	}
+
+	return applied, rows.Err()
+}
+
+// verifyMigrations checks that every applied migration is known to this build, unmodified
+func verifyMigrations(dialect Dialect, applied map[int]appliedMigration) error {
+	known := map[int]Migration{}
+	for _, m := range migrationsFor(dialect) {
+		known[m.Version] = m
+	}
+
+	for version, row := range applied {
+		m, ok := known[version]
+		if !ok {
+			return fmt.Errorf("migration %v is applied but unknown to this build", version)
+		}
+
+		if row.checksum != m.Checksum() {
+			return fmt.Errorf("migration %v (%v) has been modified since it was applied", version, m.Name)
+		}
+	}
+
+	return nil
+}
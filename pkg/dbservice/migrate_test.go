@@ -0,0 +1,201 @@
+package dbservice
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMigrationsAreConsistent(t *testing.T) {
+	for _, dialect := range []Dialect{MySQL, Postgres} {
+		migrations := migrationsFor(dialect)
+		if len(migrations) == 0 {
+			t.Fatalf("%v has no migrations", dialect)
+		}
+
+		// versions run from 1 without gaps. Some have nothing to do in a dialect, or to revert, so their statements may be empty
+		for i, m := range migrations {
+			if m.Version != i+1 || m.Name == "" {
+				t.Errorf("%v migration #%v: got version %v, %q", dialect, i, m.Version, m.Name)
+			}
+		}
+	}
+
+	// every dialect has the same migrations, written in its own SQL
+	if len(mysqlMigrations) != len(postgresMigrations) || LatestVersion() != len(mysqlMigrations) {
+		t.Fatalf("got %v mysql and %v postgres migrations, and latest version %v", len(mysqlMigrations), len(postgresMigrations), LatestVersion())
+	}
+
+	for i := range mysqlMigrations {
+		if mysqlMigrations[i].Name != postgresMigrations[i].Name {
+			t.Errorf("migration %v: got %q in mysql and %q in postgres", i+1, mysqlMigrations[i].Name, postgresMigrations[i].Name)
+		}
+	}
+}
+
+func TestMigrationChecksum(t *testing.T) {
+	m := Migration{Version: 1, Name: "create_users", Up: []string{"CREATE TABLE users (id INT)"}, Down: []string{"DROP TABLE users"}}
+
+	tests := []struct {
+		name     string
+		edit     func(m Migration) Migration
+		modified bool
+	}{
+		{name: "unchanged", edit: func(m Migration) Migration { return m }, modified: false},
+		{name: "renamed", edit: func(m Migration) Migration { m.Name = "create_people"; return m }, modified: false},
+		{name: "an up statement edited", edit: func(m Migration) Migration {
+			m.Up = []string{"CREATE TABLE users (id BIGINT)"}
+			return m
+		}, modified: true},
+		{name: "a down statement added", edit: func(m Migration) Migration {
+			m.Down = []string{"DROP TABLE users", "DROP TABLE roles"}
+			return m
+		}, modified: true},
+		{name: "a statement moved from down to up", edit: func(m Migration) Migration {
+			m.Up = append(m.Up, m.Down...)
+			m.Down = nil
+			return m
+		}, modified: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if modified := tt.edit(m).Checksum() != m.Checksum(); modified != tt.modified {
+				t.Errorf("Checksum: got modified %v, expected %v", modified, tt.modified)
+			}
+		})
+	}
+}
+
+func TestDialects(t *testing.T) {
+	tests := []struct {
+		dsn      string
+		expected Dialect
+		rebound  string
+	}{
+		{dsn: "root:password@tcp(localhost:3306)/timelineapi", expected: MySQL, rebound: "SELECT ? FROM t WHERE a = ? AND b = ?"},
+		{dsn: "postgres://localhost/timelineapi", expected: Postgres, rebound: "SELECT $1 FROM t WHERE a = $2 AND b = $3"},
+		{dsn: "postgresql://localhost/timelineapi", expected: Postgres, rebound: "SELECT $1 FROM t WHERE a = $2 AND b = $3"},
+	}
+
+	for _, tt := range tests {
+		dialect := DialectOf(tt.dsn)
+		if dialect != tt.expected {
+			t.Errorf("DialectOf(%q): got %v, expected %v", tt.dsn, dialect, tt.expected)
+		}
+
+		if rebound := dialect.Rebind("SELECT ? FROM t WHERE a = ? AND b = ?"); rebound != tt.rebound {
+			t.Errorf("%v Rebind: got %q, expected %q", dialect, rebound, tt.rebound)
+		}
+	}
+}
+
+// TestMigrate migrates the dbs at $TIMELINEAPI_TEST_MYSQL_DSN and $TIMELINEAPI_TEST_POSTGRES_DSN up and down,
+// leaving them at version 0. Each is skipped if its dsn is unset
+func TestMigrate(t *testing.T) {
+	for _, env := range []string{"TIMELINEAPI_TEST_MYSQL_DSN", "TIMELINEAPI_TEST_POSTGRES_DSN"} {
+		t.Run(env, func(t *testing.T) {
+			dsn := os.Getenv(env)
+			if dsn == "" {
+				t.Skip(env + " is unset")
+			}
+
+			testMigrate(t, dsn)
+		})
+	}
+}
+
+func testMigrate(t *testing.T, dsn string) {
+	db, err := ConnectDB(&dsn)
+	if err != nil {
+		t.Fatalf("ConnectDB: %v", err)
+	}
+	defer db.Close()
+
+	dialect := DialectOf(dsn)
+	latest := LatestVersion()
+
+	// applied is how many migrations status should report applied after each step
+	steps := []struct {
+		name    string
+		migrate func() error
+		applied int
+	}{
+		{name: "to 0", migrate: func() error { return MigrateTo(db, 0) }, applied: 0},
+		{name: "up", migrate: func() error { return MigrateUp(db) }, applied: latest},
+		{name: "up again", migrate: func() error { return MigrateUp(db) }, applied: latest},
+		{name: "down 1", migrate: func() error { return MigrateDown(db, 1) }, applied: latest - 1},
+		{name: "to 1", migrate: func() error { return MigrateTo(db, 1) }, applied: 1},
+		{name: "down more than are applied", migrate: func() error { return MigrateDown(db, latest) }, applied: 0},
+		{name: "up from 0", migrate: func() error { return MigrateUp(db) }, applied: latest},
+	}
+
+	for _, step := range steps {
+		err := step.migrate()
+		if err != nil {
+			t.Fatalf("migrating %v: %v", step.name, err)
+		}
+
+		statuses, err := GetMigrationStatus(db)
+		if err != nil || len(statuses) != latest {
+			t.Fatalf("GetMigrationStatus after migrating %v: got %v statuses, %v", step.name, len(statuses), err)
+		}
+
+		for _, status := range statuses {
+			if applied := status.AppliedAt != nil; applied != (status.Version <= step.applied) || status.Modified {
+				t.Errorf("after migrating %v, migration %v: got applied %v, modified %v, expected the first %v applied",
+					step.name, status.Version, applied, status.Modified, step.applied)
+			}
+		}
+	}
+
+	// versions out of range are refused before anything is touched
+	for _, target := range []int{-1, latest + 1} {
+		err = MigrateTo(db, target)
+		if err == nil || !strings.Contains(err.Error(), "unknown migration version") {
+			t.Errorf("MigrateTo(%v): got %v, expected an unknown migration version", target, err)
+		}
+	}
+
+	// migrations edited since they were applied, or unknown to this build, stop any migrating
+	tampering := []struct {
+		name     string
+		tamper   string
+		undo     string
+		expected string
+	}{
+		{name: "an edited migration", tamper: "UPDATE schema_migrations SET checksum = 'edited' WHERE version = 1",
+			undo:     "UPDATE schema_migrations SET checksum = ? WHERE version = 1",
+			expected: "has been modified since it was applied"},
+		{name: "an unknown migration", tamper: "INSERT INTO schema_migrations (version, name, checksum) VALUES (100000, 'from_the_future', 'unknown')",
+			undo:     "DELETE FROM schema_migrations WHERE version = 100000",
+			expected: "is applied but unknown to this build"},
+	}
+
+	for _, tt := range tampering {
+		_, err = db.Exec(tt.tamper)
+		if err != nil {
+			t.Fatalf("tampering with %v: %v", tt.name, err)
+		}
+
+		err = MigrateTo(db, 0)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("MigrateTo with %v: got %v, expected an err saying %q", tt.name, err, tt.expected)
+		}
+
+		args := []interface{}{}
+		if strings.Contains(tt.undo, "?") {
+			args = append(args, migrationsFor(dialect)[0].Checksum())
+		}
+
+		_, err = db.Exec(dialect.Rebind(tt.undo), args...)
+		if err != nil {
+			t.Fatalf("undoing %v: %v", tt.name, err)
+		}
+	}
+
+	err = MigrateTo(db, 0)
+	if err != nil {
+		t.Errorf("MigrateTo(0) once untampered: %v", err)
+	}
+}
@@ -1,23 +1,21 @@
 package dbservice
 
-// schemas is the structure of the tables
-var schemas map[string]string
-
-// initializeSchemas assigns value to the schemas variable above
-func initializeSchemas() {
-	schemas = map[string]string{
-		"users": `
-			(
+// mysqlMigrations is the structure of the tables, as the ordered list of changes that produce it
+// Applied migrations must never be edited: add a new one instead,
+// to postgresMigrations as well, under the same version
+var mysqlMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create users, actions and outputs",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS users (
 				userID BINARY(16) PRIMARY KEY,
 				username VARCHAR(100) UNIQUE NOT NULL,
 				password  VARCHAR(100) NOT NULL,
 				createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 				updatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
-			)
-		`,
-
-		"actions": `
-			(
+			)`,
+			`CREATE TABLE IF NOT EXISTS actions (
 				actionID BINARY(16) PRIMARY KEY,
 				title VARCHAR(50) UNIQUE NOT NULL,
 				description TEXT NOT NULL,
@@ -28,11 +26,8 @@ func initializeSchemas() {
 				FOREIGN KEY (userID)
 					REFERENCES users(userID)
 					ON DELETE CASCADE
-			)
-		`,
-
-		"outputs": `
-			(
+			)`,
+			`CREATE TABLE IF NOT EXISTS outputs (
 				outputID BINARY(16) PRIMARY KEY,
 				title VARCHAR(50) UNIQUE NOT NULL,
 				description TEXT NOT NULL,
@@ -43,7 +38,833 @@ func initializeSchemas() {
 				FOREIGN KEY (actionID)
 					REFERENCES actions(actionID)
 					ON DELETE CASCADE
-			)
-		`,
+			)`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS outputs",
+			"DROP TABLE IF EXISTS actions",
+			"DROP TABLE IF EXISTS users",
+		},
+	},
+	{
+		Version: 2,
+		Name:    "record when actions and outputs are archived",
+		Up: []string{
+			"ALTER TABLE actions ADD COLUMN archivedAt TIMESTAMP NULL DEFAULT NULL AFTER isArchived",
+			"ALTER TABLE outputs ADD COLUMN archivedAt TIMESTAMP NULL DEFAULT NULL AFTER isArchived",
+			"ALTER TABLE outputs MODIFY actionID BINARY(16) NOT NULL",
+		},
+		Down: []string{
+			"ALTER TABLE outputs MODIFY actionID BINARY(16)",
+			"ALTER TABLE outputs DROP COLUMN archivedAt",
+			"ALTER TABLE actions DROP COLUMN archivedAt",
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create action_shares",
+		Up: []string{
+			`CREATE TABLE action_shares (
+				actionID BINARY(16) NOT NULL,
+				userID BINARY(16) NOT NULL,
+				createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (actionID, userID),
+				FOREIGN KEY (actionID)
+					REFERENCES actions(actionID)
+					ON DELETE CASCADE,
+				FOREIGN KEY (userID)
+					REFERENCES users(userID)
+					ON DELETE CASCADE
+			)`,
+		},
+		Down: []string{
+			"DROP TABLE action_shares",
+		},
+	},
+	{
+		Version: 4,
+		Name:    "index actions for keyset pagination",
+		Up: []string{
+			"CREATE INDEX idx_actions_createdAt ON actions (createdAt, actionID)",
+			"CREATE INDEX idx_actions_updatedAt ON actions (updatedAt, actionID)",
+		},
+		Down: []string{
+			"DROP INDEX idx_actions_updatedAt ON actions",
+			"DROP INDEX idx_actions_createdAt ON actions",
+		},
+	},
+	{
+		Version: 5,
+		Name:    "index actions and outputs for full-text search",
+		Up: []string{
+			"CREATE FULLTEXT INDEX idx_actions_search ON actions (title, description)",
+			"CREATE FULLTEXT INDEX idx_outputs_search ON outputs (title, description)",
+		},
+		Down: []string{
+			"DROP INDEX idx_outputs_search ON outputs",
+			"DROP INDEX idx_actions_search ON actions",
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create api_keys",
+		Up: []string{
+			`CREATE TABLE api_keys (
+				keyID BINARY(16) PRIMARY KEY,
+				userID BINARY(16) NOT NULL,
+				name VARCHAR(50) NOT NULL,
+				prefix VARCHAR(16) NOT NULL,
+				keyHash CHAR(64) UNIQUE NOT NULL,
+				scopes VARCHAR(255) NOT NULL,
+				createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				lastUsedAt TIMESTAMP NULL DEFAULT NULL,
+				expiresAt TIMESTAMP NULL DEFAULT NULL,
+				FOREIGN KEY (userID)
+					REFERENCES users(userID)
+					ON DELETE CASCADE
+			)`,
+		},
+		Down: []string{
+			"DROP TABLE api_keys",
+		},
+	},
+	{
+		Version: 7,
+		Name:    "give users roles, and let them be disabled",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user' AFTER password",
+			"ALTER TABLE users ADD COLUMN disabledAt TIMESTAMP NULL DEFAULT NULL AFTER role",
+		},
+		Down: []string{
+			"ALTER TABLE users DROP COLUMN disabledAt",
+			"ALTER TABLE users DROP COLUMN role",
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create workspaces, their members and invitations, and scope actions to them",
+		Up: []string{
+			`CREATE TABLE workspaces (
+				workspaceID BINARY(16) PRIMARY KEY,
+				name VARCHAR(50) NOT NULL,
+				createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				updatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE workspace_members (
+				workspaceID BINARY(16) NOT NULL,
+				userID BINARY(16) NOT NULL,
+				role VARCHAR(16) NOT NULL,
+				createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (workspaceID, userID),
+				FOREIGN KEY (workspaceID)
+					REFERENCES workspaces(workspaceID)
+					ON DELETE CASCADE,
+				FOREIGN KEY (userID)
+					REFERENCES users(userID)
+					ON DELETE CASCADE
+			)`,
+			`CREATE TABLE workspace_invitations (
+				invitationID BINARY(16) PRIMARY KEY,
+				workspaceID BINARY(16) NOT NULL,
+				userID BINARY(16) NOT NULL,
+				role VARCHAR(16) NOT NULL,
+				invitedBy BINARY(16) NOT NULL,
+				createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (workspaceID, userID),
+				FOREIGN KEY (workspaceID)
+					REFERENCES workspaces(workspaceID)
+					ON DELETE CASCADE,
+				FOREIGN KEY (userID)
+					REFERENCES users(userID)
+					ON DELETE CASCADE,
+				FOREIGN KEY (invitedBy)
+					REFERENCES users(userID)
+					ON DELETE CASCADE
+			)`,
+			"ALTER TABLE actions ADD COLUMN workspaceID BINARY(16) NULL DEFAULT NULL AFTER userID",
+			"ALTER TABLE actions ADD CONSTRAINT fk_actions_workspaceID FOREIGN KEY (workspaceID) REFERENCES workspaces(workspaceID) ON DELETE CASCADE",
+		},
+		Down: []string{
+			"ALTER TABLE actions DROP FOREIGN KEY fk_actions_workspaceID",
+			"ALTER TABLE actions DROP COLUMN workspaceID",
+			"DROP TABLE workspace_invitations",
+			"DROP TABLE workspace_members",
+			"DROP TABLE workspaces",
+		},
+	},
+	{
+		Version: 9,
+		Name:    "give users profiles",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN displayName VARCHAR(50) NOT NULL DEFAULT '' AFTER disabledAt",
+			"ALTER TABLE users ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC' AFTER displayName",
+			"ALTER TABLE users ADD COLUMN avatarURL VARCHAR(255) NOT NULL DEFAULT '' AFTER timezone",
+		},
+		Down: []string{
+			"ALTER TABLE users DROP COLUMN avatarURL",
+			"ALTER TABLE users DROP COLUMN timezone",
+			"ALTER TABLE users DROP COLUMN displayName",
+		},
+	},
+	{
+		Version: 10,
+		Name:    "create password_resets",
+		Up: []string{
+			`CREATE TABLE password_resets (
+				tokenHash CHAR(64) PRIMARY KEY,
+				userID BINARY(16) NOT NULL,
+				createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				expiresAt TIMESTAMP NOT NULL,
+				FOREIGN KEY (userID)
+					REFERENCES users(userID)
+					ON DELETE CASCADE
+			)`,
+		},
+		Down: []string{
+			"DROP TABLE password_resets",
+		},
+	},
+	{
+		Version: 11,
+		Name:    "verify users' emails",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN emailVerifiedAt TIMESTAMP NULL DEFAULT NULL AFTER disabledAt",
+			`CREATE TABLE email_verifications (
+				tokenHash CHAR(64) PRIMARY KEY,
+				userID BINARY(16) NOT NULL,
+				createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				expiresAt TIMESTAMP NOT NULL,
+				FOREIGN KEY (userID)
+					REFERENCES users(userID)
+					ON DELETE CASCADE
+			)`,
+		},
+		Down: []string{
+			"DROP TABLE email_verifications",
+			"ALTER TABLE users DROP COLUMN emailVerifiedAt",
+		},
+	},
+	{
+		Version: 12,
+		Name:    "give users mfa",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN mfaSecret VARCHAR(64) NOT NULL DEFAULT '' AFTER emailVerifiedAt",
+			"ALTER TABLE users ADD COLUMN mfaEnabledAt TIMESTAMP NULL DEFAULT NULL AFTER mfaSecret",
+			"ALTER TABLE users ADD COLUMN mfaLastStep BIGINT NOT NULL DEFAULT 0 AFTER mfaEnabledAt",
+			`CREATE TABLE mfa_recovery_codes (
+				codeHash CHAR(64) PRIMARY KEY,
+				userID BINARY(16) NOT NULL,
+				createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (userID)
+					REFERENCES users(userID)
+					ON DELETE CASCADE
+			)`,
+		},
+		Down: []string{
+			"DROP TABLE mfa_recovery_codes",
+			"ALTER TABLE users DROP COLUMN mfaLastStep",
+			"ALTER TABLE users DROP COLUMN mfaEnabledAt",
+			"ALTER TABLE users DROP COLUMN mfaSecret",
+		},
+	},
+	{
+		Version: 13,
+		Name:    "widen password hashes",
+		Up: []string{
+			"ALTER TABLE users MODIFY COLUMN password VARCHAR(255) NOT NULL",
+		},
+		Down: []string{
+			"ALTER TABLE users MODIFY COLUMN password VARCHAR(100) NOT NULL",
+		},
+	},
+	{
+		Version: 14,
+		Name:    "link users to external identities",
+		Up: []string{
+			`CREATE TABLE user_identities (
+				issuer VARCHAR(255) NOT NULL,
+				subject VARCHAR(255) NOT NULL,
+				userID BINARY(16) NOT NULL,
+				email VARCHAR(100) NOT NULL DEFAULT '',
+				createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (issuer, subject),
+				FOREIGN KEY (userID)
+					REFERENCES users(userID)
+					ON DELETE CASCADE
+			)`,
+		},
+		Down: []string{
+			"DROP TABLE user_identities",
+		},
+	},
+	{
+		Version: 15,
+		Name:    "schedule account deletions",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN deleteAfter TIMESTAMP NULL DEFAULT NULL AFTER mfaLastStep",
+		},
+		Down: []string{
+			"ALTER TABLE users DROP COLUMN deleteAfter",
+		},
+	},
+	{
+		Version: 16,
+		Name:    "scope titles to their space",
+		Up: []string{
+			// titles are unique within scopeID: the action's workspace, or else its owner. NULL workspaceIDs never clash in a unique key
+			"ALTER TABLE actions ADD COLUMN scopeID BINARY(16) NULL DEFAULT NULL AFTER workspaceID",
+			"UPDATE actions SET scopeID = COALESCE(workspaceID, userID)",
+			"ALTER TABLE actions MODIFY scopeID BINARY(16) NOT NULL",
+			"ALTER TABLE actions DROP INDEX title, ADD UNIQUE INDEX idx_actions_scopeID_title (scopeID, title)",
+			"ALTER TABLE outputs DROP INDEX title, ADD UNIQUE INDEX idx_outputs_actionID_title (actionID, title)",
+		},
+		Down: []string{
+			"ALTER TABLE outputs ADD UNIQUE INDEX title (title), ADD INDEX idx_outputs_actionID (actionID), DROP INDEX idx_outputs_actionID_title",
+			"ALTER TABLE actions ADD UNIQUE INDEX title (title), DROP INDEX idx_actions_scopeID_title",
+			"ALTER TABLE actions DROP COLUMN scopeID",
+		},
+	},
+	{
+		Version: 17,
+		Name:    "address invitations to usernames",
+		Up: []string{
+			"ALTER TABLE workspace_invitations ADD COLUMN username VARCHAR(100) NOT NULL DEFAULT '' AFTER workspaceID",
+			"UPDATE workspace_invitations i JOIN users u ON u.userID = i.userID SET i.username = u.username",
+			// the unique key on (workspaceID, userID) is named after its first column, and also serves the workspaceID foreign key
+			"ALTER TABLE workspace_invitations ADD UNIQUE INDEX idx_workspace_invitations_workspaceID_username (workspaceID, username), DROP INDEX workspaceID",
+			"ALTER TABLE workspace_invitations DROP FOREIGN KEY workspace_invitations_ibfk_2",
+			"ALTER TABLE workspace_invitations DROP COLUMN userID, ADD INDEX idx_workspace_invitations_username (username)",
+		},
+		Down: []string{
+			"ALTER TABLE workspace_invitations ADD COLUMN userID BINARY(16) NULL DEFAULT NULL AFTER workspaceID, DROP INDEX idx_workspace_invitations_username",
+			"UPDATE workspace_invitations i JOIN users u ON u.username = i.username SET i.userID = u.userID",
+			"DELETE FROM workspace_invitations WHERE userID IS NULL",
+			"ALTER TABLE workspace_invitations MODIFY userID BINARY(16) NOT NULL",
+			"ALTER TABLE workspace_invitations ADD CONSTRAINT workspace_invitations_ibfk_2 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE workspace_invitations ADD UNIQUE INDEX workspaceID (workspaceID, userID), DROP INDEX idx_workspace_invitations_workspaceID_username",
+			"ALTER TABLE workspace_invitations DROP COLUMN username",
+		},
+	},
+	{
+		Version: 18,
+		Name:    "backfill when actions and outputs were archived",
+		Up: []string{
+			// rows archived before archivedAt was recorded were last updated as they were archived, and are otherwise never purged
+			// updatedAt is set to itself, lest ON UPDATE CURRENT_TIMESTAMP moves it
+			"UPDATE actions SET archivedAt = updatedAt, updatedAt = updatedAt WHERE isArchived = TRUE AND archivedAt IS NULL",
+			"UPDATE outputs SET archivedAt = updatedAt, updatedAt = updatedAt WHERE isArchived = TRUE AND archivedAt IS NULL",
+		},
+		// there is no telling backfilled archivedAts from recorded ones, so they are kept
+		Down: []string{},
+	},
+	{
+		Version: 19,
+		Name:    "make room for sealed totp secrets",
+		Up: []string{
+			"ALTER TABLE users MODIFY mfaSecret VARCHAR(128) NOT NULL DEFAULT ''",
+		},
+		// sealed secrets do not fit the narrower column, so it is kept
+		Down: []string{},
+	},
+	{
+		Version: 20,
+		Name:    "keep uuids as text, and timestamps to the microsecond",
+		// the store queries are shared with SQLite and Postgres, which write uuids as text and every timestamp from Go,
+		// so MySQL stops converting uuids, and stops moving updatedAt on its own
+		Up: []string{
+			// the foreign keys are dropped while the columns they join change type, and added back once they match again
+			"ALTER TABLE actions DROP FOREIGN KEY actions_ibfk_1, DROP FOREIGN KEY fk_actions_workspaceID",
+			"ALTER TABLE outputs DROP FOREIGN KEY outputs_ibfk_1",
+			"ALTER TABLE action_shares DROP FOREIGN KEY action_shares_ibfk_1, DROP FOREIGN KEY action_shares_ibfk_2",
+			"ALTER TABLE api_keys DROP FOREIGN KEY api_keys_ibfk_1",
+			"ALTER TABLE workspace_members DROP FOREIGN KEY workspace_members_ibfk_1, DROP FOREIGN KEY workspace_members_ibfk_2",
+			"ALTER TABLE workspace_invitations DROP FOREIGN KEY workspace_invitations_ibfk_1, DROP FOREIGN KEY workspace_invitations_ibfk_3",
+			"ALTER TABLE password_resets DROP FOREIGN KEY password_resets_ibfk_1",
+			"ALTER TABLE email_verifications DROP FOREIGN KEY email_verifications_ibfk_1",
+			"ALTER TABLE mfa_recovery_codes DROP FOREIGN KEY mfa_recovery_codes_ibfk_1",
+			"ALTER TABLE user_identities DROP FOREIGN KEY user_identities_ibfk_1",
+			// uuids go through VARBINARY, which holds both their 16 raw bytes and their 36 chars of text
+			"ALTER TABLE users MODIFY userID VARBINARY(36) NOT NULL, MODIFY disabledAt TIMESTAMP(6) NULL DEFAULT NULL, MODIFY emailVerifiedAt TIMESTAMP(6) NULL DEFAULT NULL, MODIFY mfaEnabledAt TIMESTAMP(6) NULL DEFAULT NULL, MODIFY deleteAfter TIMESTAMP(6) NULL DEFAULT NULL, MODIFY createdAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6), MODIFY updatedAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6)",
+			"ALTER TABLE workspaces MODIFY workspaceID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6), MODIFY updatedAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6)",
+			"ALTER TABLE workspace_members MODIFY workspaceID VARBINARY(36) NOT NULL, MODIFY userID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6)",
+			"ALTER TABLE workspace_invitations MODIFY invitationID VARBINARY(36) NOT NULL, MODIFY workspaceID VARBINARY(36) NOT NULL, MODIFY invitedBy VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6)",
+			"ALTER TABLE actions MODIFY actionID VARBINARY(36) NOT NULL, MODIFY userID VARBINARY(36) NOT NULL, MODIFY workspaceID VARBINARY(36) NULL DEFAULT NULL, MODIFY scopeID VARBINARY(36) NOT NULL, MODIFY archivedAt TIMESTAMP(6) NULL DEFAULT NULL, MODIFY createdAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6), MODIFY updatedAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6)",
+			"ALTER TABLE outputs MODIFY outputID VARBINARY(36) NOT NULL, MODIFY actionID VARBINARY(36) NOT NULL, MODIFY archivedAt TIMESTAMP(6) NULL DEFAULT NULL, MODIFY createdAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6), MODIFY updatedAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6)",
+			"ALTER TABLE action_shares MODIFY actionID VARBINARY(36) NOT NULL, MODIFY userID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6)",
+			"ALTER TABLE api_keys MODIFY keyID VARBINARY(36) NOT NULL, MODIFY userID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6), MODIFY lastUsedAt TIMESTAMP(6) NULL DEFAULT NULL, MODIFY expiresAt TIMESTAMP(6) NULL DEFAULT NULL",
+			"ALTER TABLE password_resets MODIFY userID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6), MODIFY expiresAt TIMESTAMP(6) NOT NULL",
+			"ALTER TABLE email_verifications MODIFY userID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6), MODIFY expiresAt TIMESTAMP(6) NOT NULL",
+			"ALTER TABLE mfa_recovery_codes MODIFY userID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6)",
+			"ALTER TABLE user_identities MODIFY userID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6)",
+			"UPDATE users SET userID = BIN_TO_UUID(userID)",
+			"UPDATE workspaces SET workspaceID = BIN_TO_UUID(workspaceID)",
+			"UPDATE workspace_members SET workspaceID = BIN_TO_UUID(workspaceID), userID = BIN_TO_UUID(userID)",
+			"UPDATE workspace_invitations SET invitationID = BIN_TO_UUID(invitationID), workspaceID = BIN_TO_UUID(workspaceID), invitedBy = BIN_TO_UUID(invitedBy)",
+			"UPDATE actions SET actionID = BIN_TO_UUID(actionID), userID = BIN_TO_UUID(userID), workspaceID = BIN_TO_UUID(workspaceID), scopeID = BIN_TO_UUID(scopeID)",
+			"UPDATE outputs SET outputID = BIN_TO_UUID(outputID), actionID = BIN_TO_UUID(actionID)",
+			"UPDATE action_shares SET actionID = BIN_TO_UUID(actionID), userID = BIN_TO_UUID(userID)",
+			"UPDATE api_keys SET keyID = BIN_TO_UUID(keyID), userID = BIN_TO_UUID(userID)",
+			"UPDATE password_resets SET userID = BIN_TO_UUID(userID)",
+			"UPDATE email_verifications SET userID = BIN_TO_UUID(userID)",
+			"UPDATE mfa_recovery_codes SET userID = BIN_TO_UUID(userID)",
+			"UPDATE user_identities SET userID = BIN_TO_UUID(userID)",
+			"ALTER TABLE users MODIFY userID CHAR(36) NOT NULL",
+			"ALTER TABLE workspaces MODIFY workspaceID CHAR(36) NOT NULL",
+			"ALTER TABLE workspace_members MODIFY workspaceID CHAR(36) NOT NULL, MODIFY userID CHAR(36) NOT NULL",
+			"ALTER TABLE workspace_invitations MODIFY invitationID CHAR(36) NOT NULL, MODIFY workspaceID CHAR(36) NOT NULL, MODIFY invitedBy CHAR(36) NOT NULL",
+			"ALTER TABLE actions MODIFY actionID CHAR(36) NOT NULL, MODIFY userID CHAR(36) NOT NULL, MODIFY workspaceID CHAR(36) NULL DEFAULT NULL, MODIFY scopeID CHAR(36) NOT NULL",
+			"ALTER TABLE outputs MODIFY outputID CHAR(36) NOT NULL, MODIFY actionID CHAR(36) NOT NULL",
+			"ALTER TABLE action_shares MODIFY actionID CHAR(36) NOT NULL, MODIFY userID CHAR(36) NOT NULL",
+			"ALTER TABLE api_keys MODIFY keyID CHAR(36) NOT NULL, MODIFY userID CHAR(36) NOT NULL",
+			"ALTER TABLE password_resets MODIFY userID CHAR(36) NOT NULL",
+			"ALTER TABLE email_verifications MODIFY userID CHAR(36) NOT NULL",
+			"ALTER TABLE mfa_recovery_codes MODIFY userID CHAR(36) NOT NULL",
+			"ALTER TABLE user_identities MODIFY userID CHAR(36) NOT NULL",
+			"ALTER TABLE actions ADD CONSTRAINT actions_ibfk_1 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE, ADD CONSTRAINT fk_actions_workspaceID FOREIGN KEY (workspaceID) REFERENCES workspaces(workspaceID) ON DELETE CASCADE",
+			"ALTER TABLE outputs ADD CONSTRAINT outputs_ibfk_1 FOREIGN KEY (actionID) REFERENCES actions(actionID) ON DELETE CASCADE",
+			"ALTER TABLE action_shares ADD CONSTRAINT action_shares_ibfk_1 FOREIGN KEY (actionID) REFERENCES actions(actionID) ON DELETE CASCADE, ADD CONSTRAINT action_shares_ibfk_2 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE api_keys ADD CONSTRAINT api_keys_ibfk_1 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE workspace_members ADD CONSTRAINT workspace_members_ibfk_1 FOREIGN KEY (workspaceID) REFERENCES workspaces(workspaceID) ON DELETE CASCADE, ADD CONSTRAINT workspace_members_ibfk_2 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE workspace_invitations ADD CONSTRAINT workspace_invitations_ibfk_1 FOREIGN KEY (workspaceID) REFERENCES workspaces(workspaceID) ON DELETE CASCADE, ADD CONSTRAINT workspace_invitations_ibfk_3 FOREIGN KEY (invitedBy) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE password_resets ADD CONSTRAINT password_resets_ibfk_1 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE email_verifications ADD CONSTRAINT email_verifications_ibfk_1 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE mfa_recovery_codes ADD CONSTRAINT mfa_recovery_codes_ibfk_1 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE user_identities ADD CONSTRAINT user_identities_ibfk_1 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+		},
+		Down: []string{
+			"ALTER TABLE actions DROP FOREIGN KEY actions_ibfk_1, DROP FOREIGN KEY fk_actions_workspaceID",
+			"ALTER TABLE outputs DROP FOREIGN KEY outputs_ibfk_1",
+			"ALTER TABLE action_shares DROP FOREIGN KEY action_shares_ibfk_1, DROP FOREIGN KEY action_shares_ibfk_2",
+			"ALTER TABLE api_keys DROP FOREIGN KEY api_keys_ibfk_1",
+			"ALTER TABLE workspace_members DROP FOREIGN KEY workspace_members_ibfk_1, DROP FOREIGN KEY workspace_members_ibfk_2",
+			"ALTER TABLE workspace_invitations DROP FOREIGN KEY workspace_invitations_ibfk_1, DROP FOREIGN KEY workspace_invitations_ibfk_3",
+			"ALTER TABLE password_resets DROP FOREIGN KEY password_resets_ibfk_1",
+			"ALTER TABLE email_verifications DROP FOREIGN KEY email_verifications_ibfk_1",
+			"ALTER TABLE mfa_recovery_codes DROP FOREIGN KEY mfa_recovery_codes_ibfk_1",
+			"ALTER TABLE user_identities DROP FOREIGN KEY user_identities_ibfk_1",
+			"ALTER TABLE users MODIFY userID VARBINARY(36) NOT NULL, MODIFY disabledAt TIMESTAMP NULL DEFAULT NULL, MODIFY emailVerifiedAt TIMESTAMP NULL DEFAULT NULL, MODIFY mfaEnabledAt TIMESTAMP NULL DEFAULT NULL, MODIFY deleteAfter TIMESTAMP NULL DEFAULT NULL, MODIFY createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP, MODIFY updatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP",
+			"ALTER TABLE workspaces MODIFY workspaceID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP, MODIFY updatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP",
+			"ALTER TABLE workspace_members MODIFY workspaceID VARBINARY(36) NOT NULL, MODIFY userID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+			"ALTER TABLE workspace_invitations MODIFY invitationID VARBINARY(36) NOT NULL, MODIFY workspaceID VARBINARY(36) NOT NULL, MODIFY invitedBy VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+			"ALTER TABLE actions MODIFY actionID VARBINARY(36) NOT NULL, MODIFY userID VARBINARY(36) NOT NULL, MODIFY workspaceID VARBINARY(36) NULL DEFAULT NULL, MODIFY scopeID VARBINARY(36) NOT NULL, MODIFY archivedAt TIMESTAMP NULL DEFAULT NULL, MODIFY createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP, MODIFY updatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP",
+			"ALTER TABLE outputs MODIFY outputID VARBINARY(36) NOT NULL, MODIFY actionID VARBINARY(36) NOT NULL, MODIFY archivedAt TIMESTAMP NULL DEFAULT NULL, MODIFY createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP, MODIFY updatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP",
+			"ALTER TABLE action_shares MODIFY actionID VARBINARY(36) NOT NULL, MODIFY userID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+			"ALTER TABLE api_keys MODIFY keyID VARBINARY(36) NOT NULL, MODIFY userID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP, MODIFY lastUsedAt TIMESTAMP NULL DEFAULT NULL, MODIFY expiresAt TIMESTAMP NULL DEFAULT NULL",
+			"ALTER TABLE password_resets MODIFY userID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP, MODIFY expiresAt TIMESTAMP NOT NULL",
+			"ALTER TABLE email_verifications MODIFY userID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP, MODIFY expiresAt TIMESTAMP NOT NULL",
+			"ALTER TABLE mfa_recovery_codes MODIFY userID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+			"ALTER TABLE user_identities MODIFY userID VARBINARY(36) NOT NULL, MODIFY createdAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+			// updatedAt is set to itself, lest ON UPDATE CURRENT_TIMESTAMP moves it
+			"UPDATE users SET userID = UUID_TO_BIN(userID), updatedAt = updatedAt",
+			"UPDATE workspaces SET workspaceID = UUID_TO_BIN(workspaceID), updatedAt = updatedAt",
+			"UPDATE workspace_members SET workspaceID = UUID_TO_BIN(workspaceID), userID = UUID_TO_BIN(userID)",
+			"UPDATE workspace_invitations SET invitationID = UUID_TO_BIN(invitationID), workspaceID = UUID_TO_BIN(workspaceID), invitedBy = UUID_TO_BIN(invitedBy)",
+			"UPDATE actions SET actionID = UUID_TO_BIN(actionID), userID = UUID_TO_BIN(userID), workspaceID = UUID_TO_BIN(workspaceID), scopeID = UUID_TO_BIN(scopeID), updatedAt = updatedAt",
+			"UPDATE outputs SET outputID = UUID_TO_BIN(outputID), actionID = UUID_TO_BIN(actionID), updatedAt = updatedAt",
+			"UPDATE action_shares SET actionID = UUID_TO_BIN(actionID), userID = UUID_TO_BIN(userID)",
+			"UPDATE api_keys SET keyID = UUID_TO_BIN(keyID), userID = UUID_TO_BIN(userID)",
+			"UPDATE password_resets SET userID = UUID_TO_BIN(userID)",
+			"UPDATE email_verifications SET userID = UUID_TO_BIN(userID)",
+			"UPDATE mfa_recovery_codes SET userID = UUID_TO_BIN(userID)",
+			"UPDATE user_identities SET userID = UUID_TO_BIN(userID)",
+			"ALTER TABLE users MODIFY userID BINARY(16) NOT NULL",
+			"ALTER TABLE workspaces MODIFY workspaceID BINARY(16) NOT NULL",
+			"ALTER TABLE workspace_members MODIFY workspaceID BINARY(16) NOT NULL, MODIFY userID BINARY(16) NOT NULL",
+			"ALTER TABLE workspace_invitations MODIFY invitationID BINARY(16) NOT NULL, MODIFY workspaceID BINARY(16) NOT NULL, MODIFY invitedBy BINARY(16) NOT NULL",
+			"ALTER TABLE actions MODIFY actionID BINARY(16) NOT NULL, MODIFY userID BINARY(16) NOT NULL, MODIFY workspaceID BINARY(16) NULL DEFAULT NULL, MODIFY scopeID BINARY(16) NOT NULL",
+			"ALTER TABLE outputs MODIFY outputID BINARY(16) NOT NULL, MODIFY actionID BINARY(16) NOT NULL",
+			"ALTER TABLE action_shares MODIFY actionID BINARY(16) NOT NULL, MODIFY userID BINARY(16) NOT NULL",
+			"ALTER TABLE api_keys MODIFY keyID BINARY(16) NOT NULL, MODIFY userID BINARY(16) NOT NULL",
+			"ALTER TABLE password_resets MODIFY userID BINARY(16) NOT NULL",
+			"ALTER TABLE email_verifications MODIFY userID BINARY(16) NOT NULL",
+			"ALTER TABLE mfa_recovery_codes MODIFY userID BINARY(16) NOT NULL",
+			"ALTER TABLE user_identities MODIFY userID BINARY(16) NOT NULL",
+			"ALTER TABLE actions ADD CONSTRAINT actions_ibfk_1 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE, ADD CONSTRAINT fk_actions_workspaceID FOREIGN KEY (workspaceID) REFERENCES workspaces(workspaceID) ON DELETE CASCADE",
+			"ALTER TABLE outputs ADD CONSTRAINT outputs_ibfk_1 FOREIGN KEY (actionID) REFERENCES actions(actionID) ON DELETE CASCADE",
+			"ALTER TABLE action_shares ADD CONSTRAINT action_shares_ibfk_1 FOREIGN KEY (actionID) REFERENCES actions(actionID) ON DELETE CASCADE, ADD CONSTRAINT action_shares_ibfk_2 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE api_keys ADD CONSTRAINT api_keys_ibfk_1 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE workspace_members ADD CONSTRAINT workspace_members_ibfk_1 FOREIGN KEY (workspaceID) REFERENCES workspaces(workspaceID) ON DELETE CASCADE, ADD CONSTRAINT workspace_members_ibfk_2 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE workspace_invitations ADD CONSTRAINT workspace_invitations_ibfk_1 FOREIGN KEY (workspaceID) REFERENCES workspaces(workspaceID) ON DELETE CASCADE, ADD CONSTRAINT workspace_invitations_ibfk_3 FOREIGN KEY (invitedBy) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE password_resets ADD CONSTRAINT password_resets_ibfk_1 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE email_verifications ADD CONSTRAINT email_verifications_ibfk_1 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE mfa_recovery_codes ADD CONSTRAINT mfa_recovery_codes_ibfk_1 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+			"ALTER TABLE user_identities ADD CONSTRAINT user_identities_ibfk_1 FOREIGN KEY (userID) REFERENCES users(userID) ON DELETE CASCADE",
+		},
+	},
+}
+
+// postgresMigrations are mysqlMigrations, in the Postgres dialect
+// uuids are kept in native UUID columns, and updatedAt is always written by the app,
+// as Postgres has no ON UPDATE CURRENT_TIMESTAMP
+var postgresMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create users, actions and outputs",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS users (
+				userID UUID PRIMARY KEY,
+				username VARCHAR(100) UNIQUE NOT NULL,
+				password  VARCHAR(100) NOT NULL,
+				createdAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS actions (
+				actionID UUID PRIMARY KEY,
+				title VARCHAR(50) UNIQUE NOT NULL,
+				description TEXT NOT NULL,
+				isArchived BOOLEAN DEFAULT FALSE,
+				createdAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				userID UUID NOT NULL
+					REFERENCES users(userID)
+					ON DELETE CASCADE
+			)`,
+			`CREATE TABLE IF NOT EXISTS outputs (
+				outputID UUID PRIMARY KEY,
+				title VARCHAR(50) UNIQUE NOT NULL,
+				description TEXT NOT NULL,
+				isArchived BOOLEAN DEFAULT FALSE,
+				createdAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				actionID UUID
+					REFERENCES actions(actionID)
+					ON DELETE CASCADE
+			)`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS outputs",
+			"DROP TABLE IF EXISTS actions",
+			"DROP TABLE IF EXISTS users",
+		},
+	},
+	{
+		Version: 2,
+		Name:    "record when actions and outputs are archived",
+		Up: []string{
+			"ALTER TABLE actions ADD COLUMN archivedAt TIMESTAMPTZ NULL DEFAULT NULL",
+			"ALTER TABLE outputs ADD COLUMN archivedAt TIMESTAMPTZ NULL DEFAULT NULL",
+			"ALTER TABLE outputs ALTER COLUMN actionID SET NOT NULL",
+		},
+		Down: []string{
+			"ALTER TABLE outputs ALTER COLUMN actionID DROP NOT NULL",
+			"ALTER TABLE outputs DROP COLUMN archivedAt",
+			"ALTER TABLE actions DROP COLUMN archivedAt",
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create action_shares",
+		Up: []string{
+			`CREATE TABLE action_shares (
+				actionID UUID NOT NULL
+					REFERENCES actions(actionID)
+					ON DELETE CASCADE,
+				userID UUID NOT NULL
+					REFERENCES users(userID)
+					ON DELETE CASCADE,
+				createdAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (actionID, userID)
+			)`,
+		},
+		Down: []string{
+			"DROP TABLE action_shares",
+		},
+	},
+	{
+		Version: 4,
+		Name:    "index actions for keyset pagination",
+		Up: []string{
+			"CREATE INDEX idx_actions_createdAt ON actions (createdAt, actionID)",
+			"CREATE INDEX idx_actions_updatedAt ON actions (updatedAt, actionID)",
+		},
+		Down: []string{
+			"DROP INDEX idx_actions_updatedAt",
+			"DROP INDEX idx_actions_createdAt",
+		},
+	},
+	{
+		Version: 5,
+		Name:    "index actions and outputs for full-text search",
+		Up: []string{
+			"CREATE INDEX idx_actions_search ON actions USING GIN (to_tsvector('simple', title || ' ' || description))",
+			"CREATE INDEX idx_outputs_search ON outputs USING GIN (to_tsvector('simple', title || ' ' || description))",
+		},
+		Down: []string{
+			"DROP INDEX idx_outputs_search",
+			"DROP INDEX idx_actions_search",
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create api_keys",
+		Up: []string{
+			`CREATE TABLE api_keys (
+				keyID UUID PRIMARY KEY,
+				userID UUID NOT NULL
+					REFERENCES users(userID)
+					ON DELETE CASCADE,
+				name VARCHAR(50) NOT NULL,
+				prefix VARCHAR(16) NOT NULL,
+				keyHash CHAR(64) UNIQUE NOT NULL,
+				scopes VARCHAR(255) NOT NULL,
+				createdAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				lastUsedAt TIMESTAMPTZ NULL DEFAULT NULL,
+				expiresAt TIMESTAMPTZ NULL DEFAULT NULL
+			)`,
+			"CREATE INDEX idx_api_keys_userID ON api_keys (userID)",
+		},
+		Down: []string{
+			"DROP TABLE api_keys",
+		},
+	},
+	{
+		Version: 7,
+		Name:    "give users roles, and let them be disabled",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user'",
+			"ALTER TABLE users ADD COLUMN disabledAt TIMESTAMPTZ NULL DEFAULT NULL",
+		},
+		Down: []string{
+			"ALTER TABLE users DROP COLUMN disabledAt",
+			"ALTER TABLE users DROP COLUMN role",
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create workspaces, their members and invitations, and scope actions to them",
+		Up: []string{
+			`CREATE TABLE workspaces (
+				workspaceID UUID PRIMARY KEY,
+				name VARCHAR(50) NOT NULL,
+				createdAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updatedAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE workspace_members (
+				workspaceID UUID NOT NULL
+					REFERENCES workspaces(workspaceID)
+					ON DELETE CASCADE,
+				userID UUID NOT NULL
+					REFERENCES users(userID)
+					ON DELETE CASCADE,
+				role VARCHAR(16) NOT NULL,
+				createdAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (workspaceID, userID)
+			)`,
+			"CREATE INDEX idx_workspace_members_userID ON workspace_members (userID)",
+			`CREATE TABLE workspace_invitations (
+				invitationID UUID PRIMARY KEY,
+				workspaceID UUID NOT NULL
+					REFERENCES workspaces(workspaceID)
+					ON DELETE CASCADE,
+				userID UUID NOT NULL
+					REFERENCES users(userID)
+					ON DELETE CASCADE,
+				role VARCHAR(16) NOT NULL,
+				invitedBy UUID NOT NULL
+					REFERENCES users(userID)
+					ON DELETE CASCADE,
+				createdAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (workspaceID, userID)
+			)`,
+			"CREATE INDEX idx_workspace_invitations_userID ON workspace_invitations (userID)",
+			"ALTER TABLE actions ADD COLUMN workspaceID UUID NULL DEFAULT NULL REFERENCES workspaces(workspaceID) ON DELETE CASCADE",
+			"CREATE INDEX idx_actions_workspaceID ON actions (workspaceID)",
+		},
+		Down: []string{
+			"ALTER TABLE actions DROP COLUMN workspaceID",
+			"DROP TABLE workspace_invitations",
+			"DROP TABLE workspace_members",
+			"DROP TABLE workspaces",
+		},
+	},
+	{
+		Version: 9,
+		Name:    "give users profiles",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN displayName VARCHAR(50) NOT NULL DEFAULT ''",
+			"ALTER TABLE users ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC'",
+			"ALTER TABLE users ADD COLUMN avatarURL VARCHAR(255) NOT NULL DEFAULT ''",
+		},
+		Down: []string{
+			"ALTER TABLE users DROP COLUMN avatarURL",
+			"ALTER TABLE users DROP COLUMN timezone",
+			"ALTER TABLE users DROP COLUMN displayName",
+		},
+	},
+	{
+		Version: 10,
+		Name:    "create password_resets",
+		Up: []string{
+			`CREATE TABLE password_resets (
+				tokenHash CHAR(64) PRIMARY KEY,
+				userID UUID NOT NULL
+					REFERENCES users(userID)
+					ON DELETE CASCADE,
+				createdAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				expiresAt TIMESTAMPTZ NOT NULL
+			)`,
+			"CREATE INDEX idx_password_resets_userID ON password_resets (userID)",
+		},
+		Down: []string{
+			"DROP TABLE password_resets",
+		},
+	},
+	{
+		Version: 11,
+		Name:    "verify users' emails",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN emailVerifiedAt TIMESTAMPTZ NULL DEFAULT NULL",
+			`CREATE TABLE email_verifications (
+				tokenHash CHAR(64) PRIMARY KEY,
+				userID UUID NOT NULL
+					REFERENCES users(userID)
+					ON DELETE CASCADE,
+				createdAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				expiresAt TIMESTAMPTZ NOT NULL
+			)`,
+			"CREATE INDEX idx_email_verifications_userID ON email_verifications (userID)",
+		},
+		Down: []string{
+			"DROP TABLE email_verifications",
+			"ALTER TABLE users DROP COLUMN emailVerifiedAt",
+		},
+	},
+	{
+		Version: 12,
+		Name:    "give users mfa",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN mfaSecret VARCHAR(64) NOT NULL DEFAULT ''",
+			"ALTER TABLE users ADD COLUMN mfaEnabledAt TIMESTAMPTZ NULL DEFAULT NULL",
+			"ALTER TABLE users ADD COLUMN mfaLastStep BIGINT NOT NULL DEFAULT 0",
+			`CREATE TABLE mfa_recovery_codes (
+				codeHash CHAR(64) PRIMARY KEY,
+				userID UUID NOT NULL
+					REFERENCES users(userID)
+					ON DELETE CASCADE,
+				createdAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+			"CREATE INDEX idx_mfa_recovery_codes_userID ON mfa_recovery_codes (userID)",
+		},
+		Down: []string{
+			"DROP TABLE mfa_recovery_codes",
+			"ALTER TABLE users DROP COLUMN mfaLastStep",
+			"ALTER TABLE users DROP COLUMN mfaEnabledAt",
+			"ALTER TABLE users DROP COLUMN mfaSecret",
+		},
+	},
+	{
+		Version: 13,
+		Name:    "widen password hashes",
+		Up: []string{
+			"ALTER TABLE users ALTER COLUMN password TYPE VARCHAR(255)",
+		},
+		Down: []string{
+			"ALTER TABLE users ALTER COLUMN password TYPE VARCHAR(100)",
+		},
+	},
+	{
+		Version: 14,
+		Name:    "link users to external identities",
+		Up: []string{
+			`CREATE TABLE user_identities (
+				issuer VARCHAR(255) NOT NULL,
+				subject VARCHAR(255) NOT NULL,
+				userID UUID NOT NULL
+					REFERENCES users(userID)
+					ON DELETE CASCADE,
+				email VARCHAR(100) NOT NULL DEFAULT '',
+				createdAt TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (issuer, subject)
+			)`,
+			"CREATE INDEX idx_user_identities_userID ON user_identities (userID)",
+		},
+		Down: []string{
+			"DROP TABLE user_identities",
+		},
+	},
+	{
+		Version: 15,
+		Name:    "schedule account deletions",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN deleteAfter TIMESTAMPTZ NULL DEFAULT NULL",
+		},
+		Down: []string{
+			"ALTER TABLE users DROP COLUMN deleteAfter",
+		},
+	},
+	{
+		Version: 16,
+		Name:    "scope titles to their space",
+		Up: []string{
+			"ALTER TABLE actions ADD COLUMN scopeID UUID NULL DEFAULT NULL",
+			"UPDATE actions SET scopeID = COALESCE(workspaceID, userID)",
+			"ALTER TABLE actions ALTER COLUMN scopeID SET NOT NULL",
+			"ALTER TABLE actions DROP CONSTRAINT actions_title_key",
+			"ALTER TABLE actions ADD CONSTRAINT actions_scopeID_title_key UNIQUE (scopeID, title)",
+			"ALTER TABLE outputs DROP CONSTRAINT outputs_title_key",
+			"ALTER TABLE outputs ADD CONSTRAINT outputs_actionID_title_key UNIQUE (actionID, title)",
+		},
+		Down: []string{
+			"ALTER TABLE outputs DROP CONSTRAINT outputs_actionID_title_key",
+			"ALTER TABLE outputs ADD CONSTRAINT outputs_title_key UNIQUE (title)",
+			"ALTER TABLE actions DROP CONSTRAINT actions_scopeID_title_key",
+			"ALTER TABLE actions ADD CONSTRAINT actions_title_key UNIQUE (title)",
+			"ALTER TABLE actions DROP COLUMN scopeID",
+		},
+	},
+	{
+		Version: 17,
+		Name:    "address invitations to usernames",
+		Up: []string{
+			"ALTER TABLE workspace_invitations ADD COLUMN username VARCHAR(100) NOT NULL DEFAULT ''",
+			"UPDATE workspace_invitations i SET username = u.username FROM users u WHERE u.userID = i.userID",
+			"ALTER TABLE workspace_invitations DROP COLUMN userID",
+			"ALTER TABLE workspace_invitations ADD CONSTRAINT workspace_invitations_workspaceid_username_key UNIQUE (workspaceID, username)",
+			"CREATE INDEX idx_workspace_invitations_username ON workspace_invitations (username)",
+		},
+		Down: []string{
+			"DROP INDEX idx_workspace_invitations_username",
+			"ALTER TABLE workspace_invitations DROP CONSTRAINT workspace_invitations_workspaceid_username_key",
+			"ALTER TABLE workspace_invitations ADD COLUMN userID UUID NULL DEFAULT NULL REFERENCES users(userID) ON DELETE CASCADE",
+			"UPDATE workspace_invitations i SET userID = u.userID FROM users u WHERE u.username = i.username",
+			"DELETE FROM workspace_invitations WHERE userID IS NULL",
+			"ALTER TABLE workspace_invitations ALTER COLUMN userID SET NOT NULL",
+			"ALTER TABLE workspace_invitations ADD CONSTRAINT workspace_invitations_workspaceid_userid_key UNIQUE (workspaceID, userID)",
+			"CREATE INDEX idx_workspace_invitations_userID ON workspace_invitations (userID)",
+			"ALTER TABLE workspace_invitations DROP COLUMN username",
+		},
+	},
+	{
+		Version: 18,
+		Name:    "backfill when actions and outputs were archived",
+		Up: []string{
+			"UPDATE actions SET archivedAt = updatedAt WHERE isArchived = TRUE AND archivedAt IS NULL",
+			"UPDATE outputs SET archivedAt = updatedAt WHERE isArchived = TRUE AND archivedAt IS NULL",
+		},
+		Down: []string{},
+	},
+	{
+		Version: 19,
+		Name:    "make room for sealed totp secrets",
+		Up: []string{
+			"ALTER TABLE users ALTER COLUMN mfaSecret TYPE VARCHAR(128)",
+		},
+		Down: []string{},
+	},
+	{
+		Version: 20,
+		Name:    "keep uuids as text, and timestamps to the microsecond",
+		// Postgres reads and writes UUID columns as text, and its TIMESTAMPTZs already keep microseconds
+		Up:   []string{},
+		Down: []string{},
+	},
+}
+
+// migrationsFor lists the migrations written in a dialect
+func migrationsFor(dialect Dialect) []Migration {
+	if dialect == Postgres {
+		return postgresMigrations
 	}
+
+	return mysqlMigrations
 }
@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"log"
+	"os"
+	"sync"
+)
+
+// fileMailer writes emails down in a file instead of sending them, one after the other
+type fileMailer struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileMailer sets up a mailer appending every email to the file at path, creating it if need be
+func NewFileMailer(path string) (Mailer, error) {
+	// fail now, rather than with the first email, if the file cannot be written
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileMailer{path: path}, f.Close()
+}
+
+func (m *fileMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(format(msg) + "\r\n")
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// logMailer logs emails instead of sending them
+type logMailer struct{}
+
+// NewLogMailer sets up a mailer logging every email, so that they show up beside the app's logs
+func NewLogMailer() Mailer {
+	return logMailer{}
+}
+
+func (logMailer) Send(msg Message) error {
+	log.Printf("email:\n%v", format(msg))
+	return nil
+}
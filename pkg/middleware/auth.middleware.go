@@ -2,70 +2,252 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
+	"github.com/dmithamo/timelineapi/pkg/models"
 	"github.com/dmithamo/timelineapi/pkg/security"
+	"github.com/dmithamo/timelineapi/pkg/store"
 	"github.com/dmithamo/timelineapi/pkg/utils"
 )
 
-// CheckAuth checks that authorization header is present and valid
-func CheckAuth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("session_token")
+// APIKeyHeader is the header API keys are sent in
+const APIKeyHeader = "X-API-Key"
+
+// Identity is who a request was authenticated as, by CheckAuth
+type Identity struct {
+	UserID string
+
+	// SessionID is set for requests carrying an access token
+	SessionID string
+
+	// Role is the role the access token was issued for
+	Role string
+
+	// APIKey is set for requests carrying an API key, whose scopes bound what they may do
+	APIKey *models.APIKey
+
+	// scoped records that the route checked the API key's scopes, with RequireScope
+	scoped bool
+}
+
+// identityKey is the request context key an Identity is kept under
+type identityKey struct{}
+
+// IdentityFrom reads who a request was authenticated as
+// Requests carrying an API key are refused, unless the route requires scopes of them
+func IdentityFrom(r *http.Request) (*Identity, error) {
+	identity, ok := r.Context().Value(identityKey{}).(*Identity)
+	if !ok {
+		return nil, utils.ErrNoToken
+	}
+
+	if identity.APIKey != nil && !identity.scoped {
+		return nil, utils.ErrAPIKeyNotAllowed
+	}
+
+	return identity, nil
+}
+
+// RequireScope lets requests carrying an API key through to next, if the key was granted every one of scopes
+// Logged in users may do anything, so their requests always go through
+func RequireScope(next http.HandlerFunc, scopes ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := r.Context().Value(identityKey{}).(*Identity)
+		if ok && identity.APIKey != nil {
+			for _, scope := range scopes {
+				if !identity.APIKey.HasScope(scope) {
+					utils.SendJSONResponse(w, http.StatusForbidden,
+						&utils.GenericJSONRes{
+							Message: fmt.Sprintf("this API key lacks the `%v` scope", scope),
+							Data:    nil,
+						})
+					return
+				}
+			}
+
+			identity.scoped = true
+		}
+
+		next(w, r)
+	}
+}
+
+// RequirePermission lets requests through to next, if the user's role grants every one of permissions
+// API keys are never let through: admin work is done logged in
+func RequirePermission(next http.HandlerFunc, permissions ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity, err := IdentityFrom(r)
 		if err != nil {
-			if err == http.ErrNoCookie {
-				utils.SendJSONResponse(w, http.StatusUnauthorized,
-					&utils.GenericJSONRes{
-						Message: "no valid authorization token",
-						Data:    nil,
-					})
-				return
+			status := http.StatusUnauthorized
+			if errors.Is(err, utils.ErrAPIKeyNotAllowed) {
+				status = http.StatusForbidden
 			}
 
-			// other errs
-			utils.SendJSONResponse(w, http.StatusInternalServerError,
+			utils.SendJSONResponse(w, status,
 				&utils.GenericJSONRes{
-					Message: fmt.Sprintf("err reading authorization token: %v", err.Error()),
+					Message: err.Error(),
 					Data:    nil,
 				})
 			return
 		}
-		// validate token
-		claims, err := security.ValidateToken(cookie.Value)
 
-		if err != nil {
-			if err.Error() == utils.TOKEN_EXPIRED_ERR {
-				//means a refreshToken is needed
-				refreshToken, refreshErr := security.GenerateToken(claims)
-				if refreshErr != nil {
+		for _, permission := range permissions {
+			if !models.RoleHasPermission(identity.Role, permission) {
+				utils.SendJSONResponse(w, http.StatusForbidden,
+					&utils.GenericJSONRes{
+						Message: fmt.Sprintf("this route requires the `%v` permission", permission),
+						Data:    nil,
+					})
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// withIdentity hands a request on to next, along with who it was authenticated as
+func withIdentity(next http.Handler, w http.ResponseWriter, r *http.Request, identity *Identity) {
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+}
+
+// CheckAuth checks that a request carries a live API key in the X-API-Key header,
+// or else a valid access token, in the Authorization header or else the session_token cookie,
+// signed by one of keys, and that the session it belongs to has not expired or been revoked
+func CheckAuth(keys *security.KeyManager, sessions store.SessionStore, apiKeys store.APIKeyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := r.Header.Get(APIKeyHeader); key != "" {
+				apiKey, err := apiKeys.AuthenticateAPIKey(key)
+				if err != nil {
+					if errors.Is(err, utils.ErrAPIKeyNotFound) {
+						utils.SendJSONResponse(w, http.StatusUnauthorized,
+							&utils.GenericJSONRes{
+								Message: "invalid, expired or revoked API key",
+								Data:    nil,
+							})
+						return
+					}
+
 					utils.SendJSONResponse(w, http.StatusInternalServerError,
 						&utils.GenericJSONRes{
-							Message: fmt.Sprintf("err refreshing auth token: %v", refreshErr.Error()),
+							Message: fmt.Sprintf("err checking API key: %v", err.Error()),
+							Data:    nil,
+						})
+					return
+				}
+
+				withIdentity(next, w, r, &Identity{UserID: apiKey.UserID, APIKey: apiKey})
+				return
+			}
+
+			token, err := security.TokenFromRequest(r)
+			if err != nil {
+				message := "no valid authorization token"
+				if errors.Is(err, utils.ErrMalformedAuthHeader) {
+					message = fmt.Sprintf("invalid authorization header: %v", err.Error())
+				}
+
+				utils.SendJSONResponse(w, http.StatusUnauthorized,
+					&utils.GenericJSONRes{
+						Message: message,
+						Data:    nil,
+					})
+				return
+			}
+			// validate token
+			claims, err := keys.ValidateToken(token)
+			if err != nil {
+				if errors.Is(err, utils.ErrTokenExpired) {
+					// expired tokens are never renewed here, only in exchange for a refresh token
+					utils.SendJSONResponse(w, http.StatusUnauthorized,
+						&utils.GenericJSONRes{
+							Message: "authorization token expired. Refresh it @ POST /auth/refresh",
+							Data:    nil,
+						})
+					return
+				}
+
+				// forged, malformed or otherwise unusable tokens
+				utils.SendJSONResponse(w, http.StatusUnauthorized,
+					&utils.GenericJSONRes{
+						Message: fmt.Sprintf("invalid authorization token: %v", err.Error()),
+						Data:    nil,
+					})
+				return
+			}
+
+			// check the session server-side, so that revoked tokens stop working at once
+			session, err := sessions.TouchSession(claims.SessionID)
+			if err == nil && session.UserID != claims.UID {
+				err = utils.ErrSessionNotFound
+			}
+
+			if err != nil {
+				if errors.Is(err, utils.ErrSessionNotFound) {
+					security.ClearSessionCookies(w)
+					utils.SendJSONResponse(w, http.StatusUnauthorized,
+						&utils.GenericJSONRes{
+							Message: "session expired or revoked. Please log in again",
 							Data:    nil,
 						})
 					return
 				}
 
-				http.SetCookie(w, &http.Cookie{
-					Name:     "session_token",
-					Value:    *refreshToken,
-					HttpOnly: true,
-					Path:     "/",
-					SameSite: 1,
-				})
-			} else {
-				// other errs
 				utils.SendJSONResponse(w, http.StatusInternalServerError,
 					&utils.GenericJSONRes{
-						Message: fmt.Sprintf("err validating authorization token: %v", err.Error()),
+						Message: fmt.Sprintf("err checking session: %v", err.Error()),
+						Data:    nil,
+					})
+				return
+			}
+
+			// tokens issued before users had roles were all issued to plain users
+			role := claims.Role
+			if role == "" {
+				role = models.RoleUser
+			}
+
+			// if is authorized
+			withIdentity(next, w, r, &Identity{UserID: session.UserID, SessionID: session.SessionID, Role: role})
+		})
+	}
+}
+
+// RequireVerifiedEmail refuses requests that change anything, made by users who have not verified their email
+// It goes after CheckAuth, and lets requests that only read through, whoever makes them
+func RequireVerifiedEmail(users store.UserStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := r.Context().Value(identityKey{}).(*Identity)
+			if !ok || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			user, err := users.GetByUUID(identity.UserID)
+			if err != nil {
+				utils.SendJSONResponse(w, http.StatusInternalServerError,
+					&utils.GenericJSONRes{
+						Message: fmt.Sprintf("err checking email verification: %v", err.Error()),
+						Data:    nil,
+					})
+				return
+			}
+
+			if user.EmailVerifiedAt == nil {
+				utils.SendJSONResponse(w, http.StatusForbidden,
+					&utils.GenericJSONRes{
+						Message: utils.EMAIL_NOT_VERIFIED_ERR,
 						Data:    nil,
 					})
 				return
 			}
-		}
 
-		// if is authorized
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
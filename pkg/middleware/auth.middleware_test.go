@@ -0,0 +1,301 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/security"
+	"github.com/dmithamo/timelineapi/pkg/store"
+)
+
+// authFixture is a logged in user, ada, and what CheckAuth checks her requests against
+type authFixture struct {
+	keys      *security.KeyManager
+	stores    *store.Stores
+	caches    *store.Caches
+	userID    string
+	session   *models.Session
+	token     string
+	checkAuth func(http.Handler) http.Handler
+}
+
+// newAuthFixture logs ada in, with role
+func newAuthFixture(t *testing.T, role string) *authFixture {
+	t.Helper()
+
+	keys, err := security.NewKeyManager("s3cret", "", 0)
+	if err != nil {
+		t.Fatalf("NewKeyManager: %v", err)
+	}
+
+	stores := store.NewMemoryStores()
+	caches := store.NewMemoryCaches(store.SessionPolicy{IdleTimeout: time.Hour, Lifetime: 24 * time.Hour})
+
+	credentials := &models.UserCredentials{Username: "ada@example.com", Password: "Pa55word!"}
+	err = stores.Users.CreateUser(credentials)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	user, err := stores.Users.GetByCredentials(credentials)
+	if err != nil {
+		t.Fatalf("GetByCredentials: %v", err)
+	}
+
+	session, err := caches.Sessions.CreateSession(user.UserID, "auth_test", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	token, err := keys.GenerateToken(user.UserID, session.SessionID, role)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	return &authFixture{
+		keys:      keys,
+		stores:    stores,
+		caches:    caches,
+		userID:    user.UserID,
+		session:   session,
+		token:     *token,
+		checkAuth: CheckAuth(keys, caches.Sessions, stores.APIKeys),
+	}
+}
+
+// bearer sets token as the request's access token
+func bearer(token string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// serve sends a request, set up by setUp, through handler, returning the status it was answered with,
+// and who it was authenticated as by the time it got through, if it did
+func serve(handler func(next http.HandlerFunc) http.Handler, setUp func(r *http.Request)) (int, *Identity) {
+	var identity *Identity
+	next := func(w http.ResponseWriter, r *http.Request) {
+		identity, _ = r.Context().Value(identityKey{}).(*Identity)
+		w.WriteHeader(http.StatusOK)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/actions", nil)
+	if setUp != nil {
+		setUp(r)
+	}
+
+	w := httptest.NewRecorder()
+	handler(next).ServeHTTP(w, r)
+	return w.Code, identity
+}
+
+// signed signs claims with secret, as tokens were before keys were named
+func signed(t *testing.T, secret string, claims jwt.Claims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+
+	return token
+}
+
+func TestCheckAuth(t *testing.T) {
+	f := newAuthFixture(t, "")
+
+	other, err := f.caches.Sessions.CreateSession("someone else", "auth_test", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	revoked, err := f.caches.Sessions.CreateSession(f.userID, "auth_test", "192.0.2.1")
+	if err == nil {
+		err = f.caches.Sessions.RevokeSession(revoked.SessionID, f.userID)
+	}
+	if err != nil {
+		t.Fatalf("revoking a session: %v", err)
+	}
+
+	apiKey, err := f.stores.APIKeys.CreateAPIKey(f.userID, models.APIKeyParams{Name: "ci", Scopes: []string{models.ScopeActionsRead}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	mfaToken, _, err := f.keys.GenerateMFAToken(f.userID)
+	if err != nil {
+		t.Fatalf("GenerateMFAToken: %v", err)
+	}
+
+	live := jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	expired := jwt.StandardClaims{ExpiresAt: time.Now().Add(-time.Minute).Unix()}
+
+	tests := []struct {
+		name     string
+		setUp    func(r *http.Request)
+		expected int
+		identity *Identity
+	}{
+		{name: "no token", expected: http.StatusUnauthorized},
+		{name: "a bearer token", setUp: bearer(f.token), expected: http.StatusOK,
+			identity: &Identity{UserID: f.userID, SessionID: f.session.SessionID, Role: models.RoleUser}},
+		{name: "a session_token cookie", setUp: func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "session_token", Value: f.token})
+		}, expected: http.StatusOK, identity: &Identity{UserID: f.userID, SessionID: f.session.SessionID, Role: models.RoleUser}},
+		{name: "a bearer token over a bad cookie", setUp: func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "session_token", Value: "garbage"})
+			bearer(f.token)(r)
+		}, expected: http.StatusOK, identity: &Identity{UserID: f.userID, SessionID: f.session.SessionID, Role: models.RoleUser}},
+		{name: "a malformed authorization header", setUp: func(r *http.Request) {
+			r.Header.Set("Authorization", "Basic "+f.token)
+		}, expected: http.StatusUnauthorized},
+		{name: "garbage", setUp: bearer("garbage"), expected: http.StatusUnauthorized},
+		{name: "signed with another secret", setUp: bearer(signed(t, "another s3cret", security.CustomClaims{
+			UID: f.userID, SessionID: f.session.SessionID, StandardClaims: live,
+		})), expected: http.StatusUnauthorized},
+		{name: "signed before keys were named", setUp: bearer(signed(t, "s3cret", security.CustomClaims{
+			UID: f.userID, SessionID: f.session.SessionID, StandardClaims: live,
+		})), expected: http.StatusOK, identity: &Identity{UserID: f.userID, SessionID: f.session.SessionID, Role: models.RoleUser}},
+		{name: "expired", setUp: bearer(signed(t, "s3cret", security.CustomClaims{
+			UID: f.userID, SessionID: f.session.SessionID, StandardClaims: expired,
+		})), expected: http.StatusUnauthorized},
+		{name: "an mfa token", setUp: bearer(mfaToken), expected: http.StatusUnauthorized},
+		{name: "meant for another audience", setUp: bearer(signed(t, "s3cret", security.CustomClaims{
+			UID: f.userID, SessionID: f.session.SessionID, StandardClaims: jwt.StandardClaims{Audience: "oidc", ExpiresAt: live.ExpiresAt},
+		})), expected: http.StatusUnauthorized},
+		{name: "a revoked session", setUp: bearer(signed(t, "s3cret", security.CustomClaims{
+			UID: f.userID, SessionID: revoked.SessionID, StandardClaims: live,
+		})), expected: http.StatusUnauthorized},
+		{name: "another user's session", setUp: bearer(signed(t, "s3cret", security.CustomClaims{
+			UID: f.userID, SessionID: other.SessionID, StandardClaims: live,
+		})), expected: http.StatusUnauthorized},
+		{name: "an API key", setUp: func(r *http.Request) {
+			r.Header.Set(APIKeyHeader, apiKey.Key)
+		}, expected: http.StatusOK, identity: &Identity{UserID: f.userID, APIKey: apiKey}},
+		{name: "an unknown API key", setUp: func(r *http.Request) {
+			r.Header.Set(APIKeyHeader, apiKey.Key+"x")
+		}, expected: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, identity := serve(func(next http.HandlerFunc) http.Handler { return f.checkAuth(next) }, tt.setUp)
+			if code != tt.expected {
+				t.Errorf("CheckAuth: got %v, expected %v", code, tt.expected)
+			}
+
+			switch {
+			case tt.identity == nil && identity != nil:
+				t.Errorf("CheckAuth let %+v through", identity)
+
+			case tt.identity != nil && (identity == nil || identity.UserID != tt.identity.UserID || identity.SessionID != tt.identity.SessionID ||
+				identity.Role != tt.identity.Role || (identity.APIKey == nil) != (tt.identity.APIKey == nil)):
+				t.Errorf("CheckAuth let %+v through, expected %+v", identity, tt.identity)
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	f := newAuthFixture(t, models.RoleUser)
+
+	apiKey, err := f.stores.APIKeys.CreateAPIKey(f.userID, models.APIKeyParams{Name: "ci", Scopes: []string{models.ScopeActionsRead, models.ScopeOutputsRead}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	withAPIKey := func(r *http.Request) {
+		r.Header.Set(APIKeyHeader, apiKey.Key)
+	}
+
+	// required is nil for routes that never call RequireScope, which API keys may not use
+	tests := []struct {
+		name     string
+		setUp    func(r *http.Request)
+		required []string
+		expected int
+	}{
+		{name: "an API key with the scope", setUp: withAPIKey, required: []string{models.ScopeActionsRead}, expected: http.StatusOK},
+		{name: "an API key with every scope", setUp: withAPIKey, required: []string{models.ScopeActionsRead, models.ScopeOutputsRead}, expected: http.StatusOK},
+		{name: "an API key without the scope", setUp: withAPIKey, required: []string{models.ScopeActionsWrite}, expected: http.StatusForbidden},
+		{name: "an API key with only some of the scopes", setUp: withAPIKey, required: []string{models.ScopeActionsRead, models.ScopeActionsWrite}, expected: http.StatusForbidden},
+		{name: "an API key on a route without scopes", setUp: withAPIKey, required: nil, expected: http.StatusForbidden},
+		{name: "a logged in user", setUp: bearer(f.token), required: []string{models.ScopeActionsWrite}, expected: http.StatusOK},
+		{name: "a logged in user on a route without scopes", setUp: bearer(f.token), required: nil, expected: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := serve(func(next http.HandlerFunc) http.Handler {
+				// handlers read who they serve with IdentityFrom, which refuses API keys whose scopes were not checked
+				handler := func(w http.ResponseWriter, r *http.Request) {
+					_, err := IdentityFrom(r)
+					if err != nil {
+						w.WriteHeader(http.StatusForbidden)
+						return
+					}
+					next(w, r)
+				}
+
+				if tt.required != nil {
+					handler = RequireScope(handler, tt.required...)
+				}
+				return f.checkAuth(http.HandlerFunc(handler))
+			}, tt.setUp)
+
+			if code != tt.expected {
+				t.Errorf("RequireScope(%v): got %v, expected %v", tt.required, code, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	admin := newAuthFixture(t, models.RoleAdmin)
+	user := newAuthFixture(t, models.RoleUser)
+	legacy := newAuthFixture(t, "")
+
+	apiKey, err := admin.stores.APIKeys.CreateAPIKey(admin.userID, models.APIKeyParams{Name: "ci", Scopes: models.Scopes})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	// API keys get every scope, so that only their lack of a role stops them, and setUp is nil for requests CheckAuth never saw
+	tests := []struct {
+		name        string
+		f           *authFixture
+		setUp       func(r *http.Request)
+		permissions []string
+		expected    int
+	}{
+		{name: "an admin", f: admin, setUp: bearer(admin.token), permissions: []string{models.PermissionUsersRead}, expected: http.StatusOK},
+		{name: "an admin, for every permission", f: admin, setUp: bearer(admin.token),
+			permissions: []string{models.PermissionUsersRead, models.PermissionUsersWrite, models.PermissionActionsReadAny, models.PermissionActionsPurgeAny}, expected: http.StatusOK},
+		{name: "a user", f: user, setUp: bearer(user.token), permissions: []string{models.PermissionUsersRead}, expected: http.StatusForbidden},
+		{name: "a user, with a token issued before roles", f: legacy, setUp: bearer(legacy.token), permissions: []string{models.PermissionUsersRead}, expected: http.StatusForbidden},
+		{name: "an admin's API key, with every scope", f: admin, setUp: func(r *http.Request) {
+			r.Header.Set(APIKeyHeader, apiKey.Key)
+		}, permissions: []string{models.PermissionUsersRead}, expected: http.StatusForbidden},
+		{name: "no one", f: admin, setUp: nil, permissions: []string{models.PermissionUsersRead}, expected: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := serve(func(next http.HandlerFunc) http.Handler {
+				handler := RequireScope(RequirePermission(next, tt.permissions...), models.Scopes...)
+				if tt.setUp == nil {
+					return handler
+				}
+				return tt.f.checkAuth(handler)
+			}, tt.setUp)
+
+			if code != tt.expected {
+				t.Errorf("RequirePermission(%v): got %v, expected %v", tt.permissions, code, tt.expected)
+			}
+		})
+	}
+}
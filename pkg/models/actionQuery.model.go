@@ -0,0 +1,322 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default and maximum page sizes for listing actions
+const defaultPageLimit = 20
+const maxPageLimit = 100
+
+// sortableActionColumns maps the accepted `sort` values to their columns
+var sortableActionColumns = map[string]string{
+	"createdAt": "createdAt",
+	"updatedAt": "updatedAt",
+	"title":     "title",
+}
+
+// ActionQueryParams defines the raw query string params accepted when listing actions
+type ActionQueryParams struct {
+	Limit         string `json:"limit,omitempty"`
+	Sort          string `json:"sort,omitempty"`
+	Order         string `json:"order,omitempty"`
+	Cursor        string `json:"cursor,omitempty"`
+	CreatedAfter  string `json:"createdAfter,omitempty"`
+	CreatedBefore string `json:"createdBefore,omitempty"`
+	UpdatedAfter  string `json:"updatedAfter,omitempty"`
+	UpdatedBefore string `json:"updatedBefore,omitempty"`
+	UserID        string `json:"userID,omitempty"`
+	Archived      string `json:"archived,omitempty"`
+}
+
+// Error allows for ActionQueryParams to be used a valid err type
+func (p ActionQueryParams) Error() string {
+	return "err in action query params"
+}
+
+// ActionQuery is a parsed, validated request for a page of actions
+type ActionQuery struct {
+	Limit         int
+	Sort          string
+	Descending    bool
+	Cursor        *actionCursor
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	UserID        string
+	Archived      bool
+}
+
+// ActionPage is a single page of actions, with cursors to its neighbours
+type ActionPage struct {
+	Actions []Action
+	Next    string
+	Prev    string
+}
+
+// actionCursor marks a position in a sorted list of actions
+// It is handed to clients as opaque, base64-encoded JSON
+type actionCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d"`
+	Value      string `json:"v"`
+	ActionID   string `json:"id"`
+	Backwards  bool   `json:"b,omitempty"`
+}
+
+// NewActionQueryParams reads the action query params from a url's query string
+func NewActionQueryParams(values url.Values) *ActionQueryParams {
+	return &ActionQueryParams{
+		Limit:         values.Get("limit"),
+		Sort:          values.Get("sort"),
+		Order:         values.Get("order"),
+		Cursor:        values.Get("cursor"),
+		CreatedAfter:  values.Get("createdAfter"),
+		CreatedBefore: values.Get("createdBefore"),
+		UpdatedAfter:  values.Get("updatedAfter"),
+		UpdatedBefore: values.Get("updatedBefore"),
+		UserID:        values.Get("userID"),
+		Archived:      values.Get("archived"),
+	}
+}
+
+// Parse validates the action query params, converting them into an ActionQuery
+func (p *ActionQueryParams) Parse() (*ActionQuery, error) {
+	hasErrors := false
+	validationErrs := &ActionQueryParams{}
+	query := &ActionQuery{
+		Limit:      defaultPageLimit,
+		Sort:       "createdAt",
+		Descending: true,
+	}
+
+	if p.Limit != "" {
+		limit, err := strconv.Atoi(p.Limit)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			validationErrs.Limit = fmt.Sprintf("invalid limit. Use a number between 1 and %v", maxPageLimit)
+			hasErrors = true
+		}
+		query.Limit = limit
+	}
+
+	if p.Sort != "" {
+		if _, ok := sortableActionColumns[p.Sort]; !ok {
+			validationErrs.Sort = "invalid sort. Use one of createdAt, updatedAt or title"
+			hasErrors = true
+		}
+		query.Sort = p.Sort
+	}
+
+	switch p.Order {
+	case "", "desc":
+		query.Descending = true
+	case "asc":
+		query.Descending = false
+	default:
+		validationErrs.Order = "invalid order. Use asc or desc"
+		hasErrors = true
+	}
+
+	dateHelper := func(raw string, message *string) *time.Time {
+		if raw == "" {
+			return nil
+		}
+
+		for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+			if t, err := time.Parse(layout, raw); err == nil {
+				return &t
+			}
+		}
+
+		*message = "invalid date. Use an RFC3339 timestamp or a YYYY-MM-DD date"
+		hasErrors = true
+		return nil
+	}
+	query.CreatedAfter = dateHelper(p.CreatedAfter, &validationErrs.CreatedAfter)
+	query.CreatedBefore = dateHelper(p.CreatedBefore, &validationErrs.CreatedBefore)
+	query.UpdatedAfter = dateHelper(p.UpdatedAfter, &validationErrs.UpdatedAfter)
+	query.UpdatedBefore = dateHelper(p.UpdatedBefore, &validationErrs.UpdatedBefore)
+
+	if p.UserID != "" && !validUUID.MatchString(p.UserID) {
+		validationErrs.UserID = "invalid userID"
+		hasErrors = true
+	}
+	query.UserID = p.UserID
+
+	switch p.Archived {
+	case "", "false":
+		query.Archived = false
+	case "true":
+		query.Archived = true
+	default:
+		validationErrs.Archived = "invalid archived. Use true or false"
+		hasErrors = true
+	}
+
+	if p.Cursor != "" {
+		cursor, err := decodeActionCursor(p.Cursor)
+		if err != nil || cursor.Sort != query.Sort || cursor.Descending != query.Descending {
+			validationErrs.Cursor = "invalid cursor. Cursors only work with the sort and order they were issued for"
+			hasErrors = true
+		}
+		query.Cursor = cursor
+	}
+
+	if hasErrors {
+		return nil, validationErrs
+	}
+
+	return query, nil
+}
+
+// Where builds the sql conditions and args selecting the requested page, minus visibility
+// Queries are expected to alias actions as `a`
+func (q *ActionQuery) Where() (string, []interface{}) {
+	conditions := []string{"a.isArchived = ?"}
+	args := []interface{}{q.Archived}
+
+	timeHelper := func(condition string, t *time.Time) {
+		if t != nil {
+			conditions = append(conditions, condition)
+			args = append(args, t.UTC())
+		}
+	}
+	timeHelper("a.createdAt >= ?", q.CreatedAfter)
+	timeHelper("a.createdAt <= ?", q.CreatedBefore)
+	timeHelper("a.updatedAt >= ?", q.UpdatedAfter)
+	timeHelper("a.updatedAt <= ?", q.UpdatedBefore)
+
+	if q.UserID != "" {
+		conditions = append(conditions, "a.userID = ?")
+		args = append(args, q.UserID)
+	}
+
+	if q.Cursor != nil {
+		// rows strictly after the cursor, in the direction of travel
+		comparison := ">"
+		if q.descendingOnTheWire() {
+			comparison = "<"
+		}
+
+		column := fmt.Sprintf("a.%v", sortableActionColumns[q.Sort])
+		conditions = append(conditions, fmt.Sprintf("(%v %v ? OR (%v = ? AND a.actionID %v ?))", column, comparison, column, comparison))
+
+		// decodeActionCursor has already checked that the value parses
+		value, _ := q.Cursor.value()
+		args = append(args, value, value, q.Cursor.ActionID)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// OrderBy builds the sql ordering for the requested page, in the direction of travel
+func (q *ActionQuery) OrderBy() string {
+	direction := "ASC"
+	if q.descendingOnTheWire() {
+		direction = "DESC"
+	}
+
+	return fmt.Sprintf("a.%v %v, a.actionID %v", sortableActionColumns[q.Sort], direction, direction)
+}
+
+// Page turns up to Limit+1 actions, fetched in the direction of travel, into a page with cursors
+func (q *ActionQuery) Page(actions []Action) *ActionPage {
+	hasMore := len(actions) > q.Limit
+	if hasMore {
+		actions = actions[:q.Limit]
+	}
+
+	// pages fetched backwards come out in reverse
+	backwards := q.Cursor != nil && q.Cursor.Backwards
+	if backwards {
+		for i, j := 0, len(actions)-1; i < j; i, j = i+1, j-1 {
+			actions[i], actions[j] = actions[j], actions[i]
+		}
+	}
+
+	page := &ActionPage{Actions: actions}
+	if len(actions) == 0 {
+		return page
+	}
+
+	// a backwards page always has the page it came from after it
+	if hasMore || backwards {
+		page.Next = q.cursorFor(actions[len(actions)-1], false)
+	}
+
+	// likewise, a forwards page from a cursor always has one before it
+	if (backwards && hasMore) || (!backwards && q.Cursor != nil) {
+		page.Prev = q.cursorFor(actions[0], true)
+	}
+
+	return page
+}
+
+// descendingOnTheWire reports whether rows are fetched in descending order,
+// which is flipped from the requested order when paging backwards
+func (q *ActionQuery) descendingOnTheWire() bool {
+	return q.Descending != (q.Cursor != nil && q.Cursor.Backwards)
+}
+
+// cursorFor builds the cursor pointing at action, for paging forwards or backwards
+func (q *ActionQuery) cursorFor(action Action, backwards bool) string {
+	cursor := actionCursor{
+		Sort:       q.Sort,
+		Descending: q.Descending,
+		ActionID:   action.ActionID,
+		Backwards:  backwards,
+	}
+
+	switch q.Sort {
+	case "createdAt":
+		cursor.Value = action.CreatedAt.Format(time.RFC3339Nano)
+	case "updatedAt":
+		cursor.Value = action.UpdatedAt.Format(time.RFC3339Nano)
+	default:
+		cursor.Value = action.Title
+	}
+
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// value converts the cursor's value into the type of its sort column
+func (c *actionCursor) value() (interface{}, error) {
+	if c.Sort == "title" {
+		return c.Value, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, c.Value)
+	return t.UTC(), err
+}
+
+// decodeActionCursor reverses cursorFor
+func decodeActionCursor(raw string) (*actionCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor actionCursor
+	err = json.Unmarshal(decoded, &cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := sortableActionColumns[cursor.Sort]; !ok || !validUUID.MatchString(cursor.ActionID) {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	if _, err := cursor.value(); err != nil {
+		return nil, err
+	}
+
+	return &cursor, nil
+}
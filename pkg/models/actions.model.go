@@ -2,12 +2,10 @@
 package models
 
 import (
-	"database/sql"
-	"fmt"
 	"regexp"
 	"time"
 
-	"github.com/dmithamo/timelineapi/pkg/dbservice"
+	"github.com/dmithamo/timelineapi/pkg/utils"
 )
 
 // Params defines the structure of a valid action
@@ -25,10 +23,14 @@ func (p ActionParams) Error() string {
 type Action struct {
 	ActionID string `json:"actionID,omitempty"`
 	ActionParams
-	isArchived bool
-	CreatedAt  time.Time `json:"createdAt,omitempty"`
-	UpdatedAt  time.Time `json:"updatedAt,omitempty"`
-	UserID     string    `json:"userID,omitempty"`
+	IsArchived bool       `json:"isArchived,omitempty"`
+	ArchivedAt *time.Time `json:"archivedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt,omitempty"`
+	UpdatedAt  time.Time  `json:"updatedAt,omitempty"`
+	UserID     string     `json:"userID,omitempty"`
+
+	// WorkspaceID is empty for actions in their owner's personal space
+	WorkspaceID string `json:"workspaceID,omitempty"`
 }
 
 //regexes for valid input
@@ -37,11 +39,25 @@ var validDescription = regexp.MustCompile(`^[a-zA-Z].([A-Za-z0-9_ \.\?,']){3,300
 
 // Validate checks the action params for errs
 func (p *ActionParams) Validate() error {
+	return p.validate(false)
+}
+
+// ValidatePartial checks only the action params that were supplied, as in an update
+func (p *ActionParams) ValidatePartial() error {
+	if p.Title == "" && p.Description == "" {
+		return &ActionParams{Title: utils.NO_UPDATE_PARAMS_ERR}
+	}
+
+	return p.validate(true)
+}
+
+// validate checks the action params, skipping absent ones if partial is true
+func (p *ActionParams) validate(partial bool) error {
 
 	hasErrors := false
 	validationErrs := &ActionParams{}
 
-	if !validTitle.MatchString(p.Title) {
+	if !(partial && p.Title == "") && !validTitle.MatchString(p.Title) {
 		if p.Title == "" {
 			validationErrs.Title = "title is required"
 		} else {
@@ -50,7 +66,7 @@ func (p *ActionParams) Validate() error {
 		hasErrors = true
 	}
 
-	if !validDescription.MatchString(p.Description) {
+	if !(partial && p.Description == "") && !validDescription.MatchString(p.Description) {
 		if p.Description == "" {
 			validationErrs.Description = "description is required"
 		} else {
@@ -66,125 +82,3 @@ func (p *ActionParams) Validate() error {
 
 	return validationErrs
 }
-
-// CreateAction adds a new action in the db
-func (a *Action) CreateAction(db *sql.DB, params ActionParams, userID string) error {
-	stmt, err := db.Prepare("INSERT INTO actions (actionID, title, description, userID) VALUES(UUID_TO_BIN(UUID()), ?, ?, UUID_TO_BIN(?))")
-	if err != nil {
-		return err
-	}
-
-	_, err = stmt.Exec(params.Title, params.Description, userID)
-	if err != nil {
-		return dbservice.CheckDatabaseErr(err, "title")
-	}
-
-	return nil
-}
-
-// GetActions retrieves all actions in the db
-func (a *Action) GetActions(db *sql.DB) ([]Action, error) {
-	stmt, err := db.Prepare("SELECT BIN_TO_UUID(actionID)actionID,title,description,isArchived,createdAt,updatedAt,BIN_TO_UUID(userID)userID FROM actions")
-	if err != nil {
-		return nil, dbservice.CheckDatabaseErr(err)
-	}
-
-	rows, err := stmt.Query()
-
-	if err != nil {
-		return nil, err
-	}
-
-	var actions []Action
-	for rows.Next() {
-		var action Action
-
-		err := rows.Scan(
-			&action.ActionID,
-			&action.Title,
-			&action.Description,
-			&action.isArchived,
-			&action.CreatedAt,
-			&action.UpdatedAt,
-			&action.UserID,
-		)
-
-		if err != nil {
-			return nil, err
-		}
-		// ignore archived actions
-		if !action.isArchived {
-			actions = append(actions, action)
-		}
-	}
-
-	err = rows.Err()
-	if err != nil {
-		return nil, err
-	}
-
-	return actions, nil
-}
-
-// GetActionByID retrueves a single action by its actionID
-func (a *Action) GetActionByID(db *sql.DB, actionID string) (*Action, error) {
-	stmt, err := db.Prepare(fmt.Sprintf("SELECT BIN_TO_UUID(actionID)actionID,title,description,isArchived,createdAt,updatedAt,BIN_TO_UUID(userID)userID FROM actions WHERE actionID=UUID_TO_BIN('%v')", actionID))
-
-	if err != nil {
-		return nil, err
-	}
-
-	action := Action{}
-	err = stmt.QueryRow().Scan(
-		&action.ActionID,
-		&action.Title,
-		&action.Description,
-		&action.isArchived,
-		&action.CreatedAt,
-		&action.UpdatedAt,
-		&action.UserID,
-	)
-
-	if err != nil {
-		fmt.Println("ERROR", err)
-
-		return nil, err
-	}
-
-	if action.isArchived {
-		return nil, sql.ErrNoRows
-	}
-
-	return &action, nil
-}
-
-// UpdateAction updates an action's title or description
-func (a *Action) UpdateAction(db *sql.DB, actionID string, params ActionParams) error {
-	updateCommand := ""
-	switch {
-	case params.Title != "" && params.Description != "":
-		updateCommand = fmt.Sprintf("UPDATE actions SET title = %v, description = %v WHERE actionID = ?", params.Title, params.Description)
-
-	case params.Title == "":
-		updateCommand = fmt.Sprintf("UPDATE actions SET description = %v WHERE actionID = ?", params.Description)
-
-	case params.Description == "":
-		updateCommand = fmt.Sprintf("UPDATE actions SET title = %v WHERE actionID = ?", params.Title)
-
-	default:
-		return fmt.Errorf("no valid title or description in update request")
-
-	}
-
-	stmt, err := db.Prepare(updateCommand)
-	if err != nil {
-		return err
-	}
-
-	_, err = stmt.Exec(actionID)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
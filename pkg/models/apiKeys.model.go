@@ -0,0 +1,128 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// API key scopes, each allowing a key to read or write one kind of resource
+const (
+	ScopeActionsRead  = "actions:read"
+	ScopeActionsWrite = "actions:write"
+	ScopeOutputsRead  = "outputs:read"
+	ScopeOutputsWrite = "outputs:write"
+)
+
+// Scopes lists every scope an API key may be granted
+var Scopes = []string{ScopeActionsRead, ScopeActionsWrite, ScopeOutputsRead, ScopeOutputsWrite}
+
+// APIKeyParams defines the structure of a valid request to create an API key
+type APIKeyParams struct {
+	Name      string     `json:"name,omitempty"`
+	Scopes    []string   `json:"scopes,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// APIKeyParamsErrs describes what is wrong with APIKeyParams, param by param
+type APIKeyParamsErrs struct {
+	Name      string `json:"name,omitempty"`
+	Scopes    string `json:"scopes,omitempty"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
+
+// Error allows for APIKeyParamsErrs to be used a valid err type
+func (e APIKeyParamsErrs) Error() string {
+	return "err in api key params"
+}
+
+// APIKey is a credential a user hands to scripts and CI jobs in place of logging in
+// Only its hash is ever stored: Key is set once, when it is created, and never again
+type APIKey struct {
+	KeyID      string     `json:"keyID,omitempty"`
+	UserID     string     `json:"-"`
+	Name       string     `json:"name,omitempty"`
+	Prefix     string     `json:"prefix,omitempty"`
+	Scopes     []string   `json:"scopes,omitempty"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+// HasScope reports whether the key was granted scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, granted := range k.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validAPIKeyName matches the names keys may be given
+var validAPIKeyName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_ .\-]{0,49}$`)
+
+// Validate checks the api key params for errs, tidying up the scopes as it goes
+func (p *APIKeyParams) Validate() error {
+	hasErrors := false
+	validationErrs := &APIKeyParamsErrs{}
+
+	if !validAPIKeyName.MatchString(p.Name) {
+		if p.Name == "" {
+			validationErrs.Name = "name is required"
+		} else {
+			validationErrs.Name = "invalid name. Use letters, numbers, spaces, dots, dashes and underscores only, and keep it under 50 chars long"
+		}
+		hasErrors = true
+	}
+
+	scopes, err := normalizeScopes(p.Scopes)
+	if err != nil {
+		validationErrs.Scopes = err.Error()
+		hasErrors = true
+	}
+	p.Scopes = scopes
+
+	if p.ExpiresAt != nil && !p.ExpiresAt.After(time.Now()) {
+		validationErrs.ExpiresAt = "invalid expiresAt. Use a time in the future, or leave it out for a key that never expires"
+		hasErrors = true
+	}
+
+	if !hasErrors {
+		return nil
+	}
+
+	return validationErrs
+}
+
+// normalizeScopes checks that every scope exists, sorting them and dropping duplicates
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("scopes are required. Use any of %v", strings.Join(Scopes, ", "))
+	}
+
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, scope := range scopes {
+		valid := false
+		for _, known := range Scopes {
+			valid = valid || scope == known
+		}
+
+		if !valid {
+			return nil, fmt.Errorf("invalid scope %q. Use any of %v", scope, strings.Join(Scopes, ", "))
+		}
+
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+
+	sort.Strings(normalized)
+	return normalized, nil
+}
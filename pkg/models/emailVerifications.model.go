@@ -0,0 +1,81 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Verification policies, naming what users who have not verified their email may not do
+const (
+	VerificationNone   = "none"
+	VerificationWrites = "writes"
+	VerificationLogin  = "login"
+)
+
+// VerificationPolicies lists every verification policy the api may enforce
+var VerificationPolicies = []string{VerificationNone, VerificationWrites, VerificationLogin}
+
+// ValidVerificationPolicy reports whether policy is one of VerificationPolicies
+func ValidVerificationPolicy(policy string) bool {
+	for _, p := range VerificationPolicies {
+		if p == policy {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EmailVerification is a request for a user to prove they own their email, with the token emailed to them
+// Only its token's hash is stored: Token is set once, when it is created, and never again
+type EmailVerification struct {
+	UserID    string    `json:"userID,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	Token     string    `json:"-"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// ResendVerificationParams defines the structure of a valid request for another verification email
+type ResendVerificationParams struct {
+	Username string `json:"username,omitempty"`
+}
+
+// Error allows for ResendVerificationParams to be used a valid err type
+func (p ResendVerificationParams) Error() string {
+	return "err in resend verification params"
+}
+
+// Validate checks that the username is an email address, lowercasing it
+func (p *ResendVerificationParams) Validate() error {
+	p.Username = NormalizeUsername(p.Username)
+
+	if !validEmailRegex.MatchString(p.Username) {
+		if p.Username == "" {
+			return &ResendVerificationParams{Username: "username is required"}
+		}
+
+		return &ResendVerificationParams{Username: invalidEmailMessage}
+	}
+
+	return nil
+}
+
+// VerifyEmailParams defines the structure of a valid request to verify an email with an emailed token
+type VerifyEmailParams struct {
+	Token string `json:"token,omitempty"`
+}
+
+// Error allows for VerifyEmailParams to be used a valid err type
+func (p VerifyEmailParams) Error() string {
+	return "err in verify email params"
+}
+
+// Validate checks that the token is given
+func (p *VerifyEmailParams) Validate() error {
+	if strings.TrimSpace(p.Token) == "" {
+		return &VerifyEmailParams{Token: "token is required"}
+	}
+
+	return nil
+}
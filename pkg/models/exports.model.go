@@ -0,0 +1,73 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ActionsExport is every action a user created, archived or not, in their personal space and in workspaces,
+// along with those actions' outputs, and who they shared them with
+type ActionsExport struct {
+	Actions []Action      `json:"actions"`
+	Outputs []Output      `json:"outputs"`
+	Shares  []ActionShare `json:"shares"`
+}
+
+// UserExport is everything the api holds on a user, for them to take away
+type UserExport struct {
+	ExportedAt time.Time `json:"exportedAt"`
+	Profile    *User     `json:"profile"`
+	ActionsExport
+	Workspaces []Workspace   `json:"workspaces"`
+	Audit      *AuditHistory `json:"audit"`
+}
+
+// AuditHistory is what is known of what a user did with their account, and how they got in to it
+// No log of events is kept: its events are read off when things were created, verified, enabled or last used
+type AuditHistory struct {
+	Events     []AuditEvent       `json:"events"`
+	Sessions   []Session          `json:"sessions"`
+	APIKeys    []APIKey           `json:"apiKeys"`
+	Identities []ExternalIdentity `json:"identities"`
+}
+
+// AuditEvent is a single thing that happened to a user's account
+type AuditEvent struct {
+	At    time.Time `json:"at"`
+	Event string    `json:"event"`
+}
+
+// NewAuditHistory lists what happened to a user's account, oldest first, as their sessions, api keys and identities have it
+func NewAuditHistory(user *User, sessions []Session, apiKeys []APIKey, identities []ExternalIdentity) *AuditHistory {
+	history := &AuditHistory{Events: []AuditEvent{}, Sessions: sessions, APIKeys: apiKeys, Identities: identities}
+	record := func(at *time.Time, event string, args ...interface{}) {
+		if at != nil {
+			history.Events = append(history.Events, AuditEvent{At: *at, Event: fmt.Sprintf(event, args...)})
+		}
+	}
+
+	record(&user.CreatedAt, "account created")
+	record(user.EmailVerifiedAt, "email verified")
+	record(user.MFAEnabledAt, "mfa enabled")
+	record(user.DisabledAt, "account disabled")
+
+	for i := range identities {
+		record(&identities[i].CreatedAt, "logged in with %v for the first time", identities[i].Issuer)
+	}
+
+	for i := range apiKeys {
+		record(&apiKeys[i].CreatedAt, "api key %q created", apiKeys[i].Name)
+		record(apiKeys[i].LastUsedAt, "api key %q last used", apiKeys[i].Name)
+	}
+
+	for i := range sessions {
+		record(&sessions[i].CreatedAt, "logged in from %v (%v)", sessions[i].IP, sessions[i].UserAgent)
+	}
+
+	sort.SliceStable(history.Events, func(i, j int) bool {
+		return history.Events[i].At.Before(history.Events[j].At)
+	})
+
+	return history
+}
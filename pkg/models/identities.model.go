@@ -0,0 +1,54 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/utils"
+)
+
+// ExternalIdentity is an account at an OpenID Connect provider, linked to a user who logs in with it
+// It is known by its issuer and subject, which never change, rather than by its email, which may
+type ExternalIdentity struct {
+	Provider      string    `json:"provider,omitempty"`
+	Issuer        string    `json:"issuer"`
+	Subject       string    `json:"subject"`
+	Email         string    `json:"email,omitempty"`
+	EmailVerified bool      `json:"-"`
+	Name          string    `json:"-"`
+	CreatedAt     time.Time `json:"createdAt,omitempty"`
+}
+
+// IdentityLogin is who logging in with an external identity logged in, and how they came to
+type IdentityLogin struct {
+	UserID string
+
+	// Linked is set as the identity is first linked to a user, and Provisioned if that user was created for it
+	Linked      bool
+	Provisioned bool
+
+	// Claimed is set as the identity is linked to an account whose email was never verified,
+	// which may have been signed up by someone else: its pwd and api keys are dropped, and its sessions should be too
+	Claimed bool
+}
+
+// LinkEmail is the email an identity not yet linked to a user is linked by, normalized as usernames are
+// Identities whose provider has not verified their email, or whose email is not one, fail with an IDENTITY_EMAIL_NOT_VERIFIED_ERR
+func (i *ExternalIdentity) LinkEmail() (string, error) {
+	email := NormalizeUsername(strings.TrimSpace(i.Email))
+	if !i.EmailVerified || len(email) > 100 || !validEmailRegex.MatchString(email) {
+		return "", utils.ErrIdentityEmailNotVerified
+	}
+
+	return email, nil
+}
+
+// DisplayName is the name an identity gives, if it will do as a user's displayName
+func (i *ExternalIdentity) DisplayName() string {
+	name := strings.TrimSpace(i.Name)
+	if !validDisplayName(name) {
+		return ""
+	}
+
+	return name
+}
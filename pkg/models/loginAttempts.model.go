@@ -0,0 +1,93 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LoginAttempts counts the logins that failed in a row, to an account or from an ip
+type LoginAttempts struct {
+	Failures     int       `json:"failures"`
+	LastFailedAt time.Time `json:"lastFailedAt,omitempty"`
+}
+
+// AccountAttemptsKey names the failed logins to the account of the user named username
+func AccountAttemptsKey(username string) string {
+	return fmt.Sprintf("account:%v", username)
+}
+
+// IPAttemptsKey names the failed logins from ip, to any account
+func IPAttemptsKey(ip string) string {
+	return fmt.Sprintf("ip:%v", ip)
+}
+
+// ResendAttemptsKey names the requests for email verification links from ip, to any address
+func ResendAttemptsKey(ip string) string {
+	return fmt.Sprintf("resend:ip:%v", ip)
+}
+
+// LoginPolicy bounds how fast pwds may be guessed
+// Failures are forgotten LockoutDuration after the last of them, or once a login succeeds
+type LoginPolicy struct {
+	// FreeAttempts is how many logins to an account may fail before each next one is delayed,
+	// and IPFreeAttempts how many from a single ip may, whatever the account
+	FreeAttempts   int
+	IPFreeAttempts int
+
+	// BaseDelay is the delay after the first failure past the free ones,
+	// doubling with every failure after it, up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// LockoutThreshold locks an account once that many logins to it fail, until it is unlocked
+	// or LockoutDuration passes without another failure. Accounts are never locked if it is 0
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+// Locked reports whether an account with attempts is locked out
+func (p LoginPolicy) Locked(attempts *LoginAttempts) bool {
+	return p.LockoutThreshold > 0 && attempts.Failures >= p.LockoutThreshold
+}
+
+// RetryAfter is how long, from at, the next login after attempts must wait, if free of them are let through undelayed
+func (p LoginPolicy) RetryAfter(attempts *LoginAttempts, free int, at time.Time) time.Duration {
+	if attempts.Failures <= free {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := free + 1; i < attempts.Failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if wait := attempts.LastFailedAt.Add(delay).Sub(at); wait > 0 {
+		return wait
+	}
+
+	return 0
+}
+
+// UnlockParams defines the structure of a valid request to unlock an account with an emailed token
+type UnlockParams struct {
+	Token string `json:"token,omitempty"`
+}
+
+// Error allows for UnlockParams to be used a valid err type
+func (p UnlockParams) Error() string {
+	return "err in unlock params"
+}
+
+// Validate checks that the token is given
+func (p *UnlockParams) Validate() error {
+	if strings.TrimSpace(p.Token) == "" {
+		return &UnlockParams{Token: "token is required"}
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package models
+
+import "strings"
+
+// MFAIssuer names the api in authenticator apps
+const MFAIssuer = "timelineapi"
+
+// MFARecoveryCodes is how many recovery codes a user is given as they enable mfa
+const MFARecoveryCodes = 10
+
+// MFAEnrollment is a totp secret, waiting for the user to add it to their authenticator app and confirm it
+// OTPAuthURI is the secret as apps enroll it, typed in or rendered as a QR code
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauthURI"`
+}
+
+// MFARecovery hands a user the recovery codes they log in with when their authenticator app is lost
+// They are shown once, and never again: only their hashes are kept
+type MFARecovery struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// MFAChallenge is what logging in returns in place of a session, to users who enabled mfa
+// MFAToken is then traded, along with a totp or recovery code, for the session
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfaRequired"`
+	MFAToken    string `json:"mfaToken"`
+	ExpiresIn   int64  `json:"expiresIn"`
+}
+
+// MFACodeParams defines the structure of a valid request carrying a totp or recovery code
+type MFACodeParams struct {
+	Code string `json:"code,omitempty"`
+}
+
+// Error allows for MFACodeParams to be used a valid err type
+func (p MFACodeParams) Error() string {
+	return "err in mfa code params"
+}
+
+// Validate checks that the code is given
+func (p *MFACodeParams) Validate() error {
+	if strings.TrimSpace(p.Code) == "" {
+		return &MFACodeParams{Code: "code is required"}
+	}
+
+	return nil
+}
+
+// MFALoginParams defines the structure of a valid request to finish logging in with a second factor
+type MFALoginParams struct {
+	MFAToken string `json:"mfaToken,omitempty"`
+	Code     string `json:"code,omitempty"`
+}
+
+// Error allows for MFALoginParams to be used a valid err type
+func (p MFALoginParams) Error() string {
+	return "err in mfa login params"
+}
+
+// Validate checks that both the mfa token and the code are given
+func (p *MFALoginParams) Validate() error {
+	hasErrors := false
+	validationErrs := &MFALoginParams{}
+
+	if strings.TrimSpace(p.MFAToken) == "" {
+		validationErrs.MFAToken = "mfaToken is required"
+		hasErrors = true
+	}
+
+	if strings.TrimSpace(p.Code) == "" {
+		validationErrs.Code = "code is required"
+		hasErrors = true
+	}
+
+	if !hasErrors {
+		return nil
+	}
+
+	return validationErrs
+}
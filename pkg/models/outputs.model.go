@@ -0,0 +1,97 @@
+package models
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/utils"
+)
+
+// OutputParams defines the structure of a valid output
+type OutputParams struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	ActionID    string `json:"actionID,omitempty"`
+}
+
+// Error allows for OutputParams to be used a valid err type
+func (p OutputParams) Error() string {
+	return "err in output params"
+}
+
+// Output is the interface for CRUD'ing output data in the db
+// An output always belongs to an action, and is owned by that action's owner, in that action's workspace
+type Output struct {
+	OutputID string `json:"outputID,omitempty"`
+	OutputParams
+	IsArchived bool       `json:"isArchived,omitempty"`
+	ArchivedAt *time.Time `json:"archivedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt,omitempty"`
+	UpdatedAt  time.Time  `json:"updatedAt,omitempty"`
+	UserID     string     `json:"userID,omitempty"`
+
+	// WorkspaceID is empty for outputs in their owner's personal space
+	WorkspaceID string `json:"workspaceID,omitempty"`
+}
+
+// validUUID matches the textual form of the UUIDs used as ids
+var validUUID = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// Validate checks the output params for errs
+func (p *OutputParams) Validate() error {
+	return p.validate(false)
+}
+
+// ValidatePartial checks only the output params that were supplied, as in an update
+// The parent action of an output cannot be changed
+func (p *OutputParams) ValidatePartial() error {
+	if p.ActionID != "" {
+		return &OutputParams{ActionID: "actionID cannot be changed"}
+	}
+
+	if p.Title == "" && p.Description == "" {
+		return &OutputParams{Title: utils.NO_UPDATE_PARAMS_ERR}
+	}
+
+	return p.validate(true)
+}
+
+// validate checks the output params, skipping absent ones if partial is true
+func (p *OutputParams) validate(partial bool) error {
+
+	hasErrors := false
+	validationErrs := &OutputParams{}
+
+	if !(partial && p.Title == "") && !validTitle.MatchString(p.Title) {
+		if p.Title == "" {
+			validationErrs.Title = "title is required"
+		} else {
+			validationErrs.Title = "invalid title. Use letters, numbers and underscores only, and keep it between 4 and 50 chars long"
+		}
+		hasErrors = true
+	}
+
+	if !(partial && p.Description == "") && !validDescription.MatchString(p.Description) {
+		if p.Description == "" {
+			validationErrs.Description = "description is required"
+		} else {
+			validationErrs.Description = "invalid description. Use letters, numbers and underscores only, and keep it between 4 and 200 chars long"
+		}
+		hasErrors = true
+	}
+
+	if !partial && !validUUID.MatchString(p.ActionID) {
+		if p.ActionID == "" {
+			validationErrs.ActionID = "actionID is required"
+		} else {
+			validationErrs.ActionID = "invalid actionID"
+		}
+		hasErrors = true
+	}
+
+	if !hasErrors {
+		return nil
+	}
+
+	return validationErrs
+}
@@ -0,0 +1,74 @@
+package models
+
+import "time"
+
+// PasswordReset is a request to reset a user's pwd, proven by the token emailed to them
+// Only its token's hash is stored: Token is set once, when it is created, and never again
+type PasswordReset struct {
+	UserID    string    `json:"userID,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	Token     string    `json:"-"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// ForgotPasswordParams defines the structure of a valid request for a pwd reset email
+type ForgotPasswordParams struct {
+	Username string `json:"username,omitempty"`
+}
+
+// Error allows for ForgotPasswordParams to be used a valid err type
+func (p ForgotPasswordParams) Error() string {
+	return "err in forgot password params"
+}
+
+// Validate checks that the username is an email address, lowercasing it
+func (p *ForgotPasswordParams) Validate() error {
+	p.Username = NormalizeUsername(p.Username)
+
+	if !validEmailRegex.MatchString(p.Username) {
+		if p.Username == "" {
+			return &ForgotPasswordParams{Username: "username is required"}
+		}
+
+		return &ForgotPasswordParams{Username: invalidEmailMessage}
+	}
+
+	return nil
+}
+
+// ResetPasswordParams defines the structure of a valid request to reset a pwd with an emailed token
+type ResetPasswordParams struct {
+	Token       string `json:"token,omitempty"`
+	NewPassword string `json:"newPassword,omitempty"`
+}
+
+// Error allows for ResetPasswordParams to be used a valid err type
+func (p ResetPasswordParams) Error() string {
+	return "err in reset password params"
+}
+
+// Validate checks that the token is given, and that the new pwd is valid
+func (p *ResetPasswordParams) Validate() error {
+	hasErrors := false
+	validationErrs := &ResetPasswordParams{}
+
+	if p.Token == "" {
+		validationErrs.Token = "token is required"
+		hasErrors = true
+	}
+
+	if p.NewPassword == "" {
+		validationErrs.NewPassword = "newPassword is required"
+		hasErrors = true
+	} else if message := invalidPasswordMessage(p.NewPassword); message != "" {
+		validationErrs.NewPassword = message
+		hasErrors = true
+	}
+
+	if !hasErrors {
+		return nil
+	}
+
+	return validationErrs
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// snippetRadius is the number of chars kept either side of the first match in a snippet
+const snippetRadius = 60
+
+// SearchParams defines the raw query string params accepted when searching
+type SearchParams struct {
+	Query string `json:"q,omitempty"`
+	Limit string `json:"limit,omitempty"`
+}
+
+// Error allows for SearchParams to be used a valid err type
+func (p SearchParams) Error() string {
+	return "err in search params"
+}
+
+// SearchResult is a single ranked match, either an action or an output
+// Title and Snippet are html-escaped, with matched terms wrapped in <mark> tags
+type SearchResult struct {
+	Kind     string  `json:"kind,omitempty"`
+	ID       string  `json:"id,omitempty"`
+	ActionID string  `json:"actionID,omitempty"`
+	Title    string  `json:"title,omitempty"`
+	Snippet  string  `json:"snippet,omitempty"`
+	Score    float64 `json:"score,omitempty"`
+}
+
+// NewSearchParams reads the search params from a url's query string
+func NewSearchParams(values url.Values) *SearchParams {
+	return &SearchParams{
+		Query: strings.TrimSpace(values.Get("q")),
+		Limit: values.Get("limit"),
+	}
+}
+
+// Validate checks the search params for errs
+func (p *SearchParams) Validate() error {
+	hasErrors := false
+	validationErrs := &SearchParams{}
+
+	if length := utf8.RuneCountInString(p.Query); length < 2 || length > 100 {
+		if p.Query == "" {
+			validationErrs.Query = "q is required"
+		} else {
+			validationErrs.Query = "invalid q. Keep it between 2 and 100 chars long"
+		}
+		hasErrors = true
+	}
+
+	if p.Limit != "" {
+		limit, err := strconv.Atoi(p.Limit)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			validationErrs.Limit = fmt.Sprintf("invalid limit. Use a number between 1 and %v", maxPageLimit)
+			hasErrors = true
+		}
+	}
+
+	if !hasErrors {
+		return nil
+	}
+
+	return validationErrs
+}
+
+// MaxResults is the number of results requested, or the default page size
+func (p *SearchParams) MaxResults() int {
+	limit, err := strconv.Atoi(p.Limit)
+	if err != nil {
+		return defaultPageLimit
+	}
+
+	return limit
+}
+
+// Highlight fills in a result's Title and Snippet from the matched text
+func (r *SearchResult) Highlight(title, description, query string) {
+	terms := searchTerms(query)
+	r.Title = highlight(title, terms)
+	r.Snippet = highlight(snippet(description, terms), terms)
+}
+
+// searchTerms builds a case-insensitive regex matching any word in a search query
+func searchTerms(q string) *regexp.Regexp {
+	words := []string{}
+	for _, word := range strings.Fields(q) {
+		words = append(words, regexp.QuoteMeta(word))
+	}
+
+	return regexp.MustCompile(fmt.Sprintf(`(?i)(%v)`, strings.Join(words, "|")))
+}
+
+// snippet trims text down to a window around its first match
+func snippet(text string, terms *regexp.Regexp) string {
+	runes := []rune(text)
+	center := 0
+	if loc := terms.FindStringIndex(text); loc != nil {
+		center = utf8.RuneCountInString(text[:loc[0]])
+	}
+
+	start, end := center-snippetRadius, center+snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	trimmed := string(runes[start:end])
+	if start > 0 {
+		trimmed = "…" + trimmed
+	}
+	if end < len(runes) {
+		trimmed += "…"
+	}
+
+	return trimmed
+}
+
+// highlight html-escapes text, wrapping every match in <mark> tags
+func highlight(text string, terms *regexp.Regexp) string {
+	parts := []string{}
+	last := 0
+	for _, loc := range terms.FindAllStringIndex(text, -1) {
+		parts = append(parts, html.EscapeString(text[last:loc[0]]), "<mark>", html.EscapeString(text[loc[0]:loc[1]]), "</mark>")
+		last = loc[1]
+	}
+	parts = append(parts, html.EscapeString(text[last:]))
+
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Session is a single logged in device, kept server-side so that it can be revoked
+// RefreshToken is only ever known right after the session is created or rotated
+type Session struct {
+	SessionID  string    `json:"sessionID,omitempty"`
+	UserID     string    `json:"userID,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	Current    bool      `json:"current,omitempty"`
+	CreatedAt  time.Time `json:"createdAt,omitempty"`
+	LastSeenAt time.Time `json:"lastSeenAt,omitempty"`
+	ExpiresAt  time.Time `json:"expiresAt,omitempty"`
+
+	RefreshToken string `json:"-"`
+}
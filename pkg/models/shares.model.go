@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// ShareParams defines the structure of a valid request to share an action
+type ShareParams struct {
+	Username string `json:"username,omitempty"`
+}
+
+// Error allows for ShareParams to be used a valid err type
+func (p ShareParams) Error() string {
+	return "err in share params"
+}
+
+// Validate checks the share params for errs, lowercasing the username
+func (p *ShareParams) Validate() error {
+	p.Username = NormalizeUsername(p.Username)
+
+	if !validEmailRegex.MatchString(p.Username) {
+		if p.Username == "" {
+			return &ShareParams{Username: "username is required"}
+		}
+
+		return &ShareParams{Username: invalidEmailMessage}
+	}
+
+	return nil
+}
+
+// ActionShare is a grant of read access on an action to a user other than its owner
+type ActionShare struct {
+	ActionID  string    `json:"actionID,omitempty"`
+	UserID    string    `json:"userID,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+}
@@ -1,12 +1,14 @@
 package models
 
 import (
-	"database/sql"
 	"fmt"
+	"net/url"
 	"regexp"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/dmithamo/timelineapi/pkg/dbservice"
 	"github.com/dmithamo/timelineapi/pkg/security"
 	"github.com/dmithamo/timelineapi/pkg/utils"
 )
@@ -17,56 +19,93 @@ type UserCredentials struct {
 	Password string `json:"password,omitempty"`
 }
 
+// User roles, each granting the permissions listed in rolePermissions
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Roles lists every role a user may be given
+var Roles = []string{RoleUser, RoleAdmin}
+
+// Permissions a role may grant, each allowing its holder past one kind of admin route
+const (
+	PermissionUsersRead       = "users:read"
+	PermissionUsersWrite      = "users:write"
+	PermissionActionsReadAny  = "actions:read:any"
+	PermissionActionsPurgeAny = "actions:purge:any"
+)
+
+// rolePermissions lists the permissions of every role
+// Plain users need none: they may only touch what they own, or what is shared with them
+var rolePermissions = map[string][]string{
+	RoleUser:  {},
+	RoleAdmin: {PermissionUsersRead, PermissionUsersWrite, PermissionActionsReadAny, PermissionActionsPurgeAny},
+}
+
+// RoleHasPermission reports whether role grants permission
+func RoleHasPermission(role string, permission string) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
 // User defines a user fully
 type User struct {
 	UserID string `json:"userID,omitempty"`
 	UserCredentials
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"-"`
-}
+	Role            string     `json:"role,omitempty"`
+	DisabledAt      *time.Time `json:"disabledAt,omitempty"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt,omitempty"`
+	MFAEnabledAt    *time.Time `json:"mfaEnabledAt,omitempty"`
 
-// regexes for valid creds
-var validEmailRegex *regexp.Regexp = regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
-var invalidPasswordRegex *regexp.Regexp = regexp.MustCompile("^(.{0,7}|[^0-9]*|[^A-Z]*|[^a-z]*|[a-zA-Z0-9]*)$")
+	// DeleteAfter is set once the user asks for their account to be deleted, which it is from then on
+	DeleteAfter *time.Time `json:"deleteAfter,omitempty"`
+	ProfileParams
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
 
-// error messages
-var invalidEmailMessage string = "invalid username. Use a valid email address"
-var invalidPasswordMessage string = "invalid password. Use at least 8 characters, combining uppercase letters, lowercase letters, digits, and special characters"
+// ProfileParams defines the structure of a valid request to change a user's profile
+// Empty fields are left as they are
+type ProfileParams struct {
+	DisplayName string `json:"displayName,omitempty"`
+	Timezone    string `json:"timezone,omitempty"`
+	AvatarURL   string `json:"avatarURL,omitempty"`
+}
 
-// Error is defined here inorder to qualify validation errs (modelled on Credentials)
-// as being a valid `error` type
-// It is otherwise quite useless
-func (c UserCredentials) Error() string {
-	return "err in user credentials"
+// Error allows for ProfileParams to be used a valid err type
+func (p ProfileParams) Error() string {
+	return "err in profile params"
 }
 
-// Validate checks that user credentials are valid
-func (c *UserCredentials) Validate() error {
+// Validate checks the profile params for errs, requiring at least one of them
+func (p *ProfileParams) Validate() error {
+	if p.DisplayName == "" && p.Timezone == "" && p.AvatarURL == "" {
+		return &ProfileParams{DisplayName: "no valid displayName, timezone or avatarURL in update request"}
+	}
 
-	validationErrs := &UserCredentials{}
 	hasErrors := false
+	validationErrs := &ProfileParams{}
 
-	func() {
-		if invalidPasswordRegex.MatchString(c.Password) {
-			if c.Password == "" {
-				validationErrs.Password = "password is required"
-			} else {
-				validationErrs.Password = invalidPasswordMessage
-			}
-			hasErrors = true
-		}
-	}()
+	if p.DisplayName != "" && !validDisplayName(p.DisplayName) {
+		validationErrs.DisplayName = "invalid displayName. Keep it between 1 and 50 chars long, without leading or trailing spaces"
+		hasErrors = true
+	}
 
-	func() {
-		if !validEmailRegex.MatchString(c.Username) {
-			if c.Username == "" {
-				validationErrs.Username = "username is required"
-			} else {
-				validationErrs.Username = invalidEmailMessage
-			}
-			hasErrors = true
-		}
-	}()
+	if p.Timezone != "" && !validTimezone(p.Timezone) {
+		validationErrs.Timezone = "invalid timezone. Use an IANA timezone name, such as Africa/Nairobi"
+		hasErrors = true
+	}
+
+	if p.AvatarURL != "" && !validAvatarURL(p.AvatarURL) {
+		validationErrs.AvatarURL = "invalid avatarURL. Use an absolute https url, up to 255 chars long"
+		hasErrors = true
+	}
 
 	if !hasErrors {
 		return nil
@@ -75,86 +114,207 @@ func (c *UserCredentials) Validate() error {
 	return validationErrs
 }
 
-// CreateUser registers a new user in the db
-func (u *User) CreateUser(db *sql.DB, credentials *UserCredentials) error {
-	stmt, err := db.Prepare("INSERT INTO users(userID,username,password) VALUES (UUID_TO_BIN(UUID()),?,?)")
-	if err != nil {
-		return err
+// validDisplayName reports whether name is 1 to 50 printable chars, trimmed of spaces
+func validDisplayName(name string) bool {
+	if utf8.RuneCountInString(name) > 50 || strings.TrimSpace(name) != name {
+		return false
 	}
-	defer stmt.Close()
 
-	pwdHash, err := security.GeneratePasswordHash(&credentials.Password)
-	if err != nil {
-		return fmt.Errorf("error hashing password: %v", err.Error())
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return false
+		}
 	}
 
-	_, err = stmt.Exec(credentials.Username, pwdHash)
-
-	return dbservice.CheckDatabaseErr(err, "username")
+	return true
 }
 
-// GetByCredentials retrieves a user from the db by username, password - for login
-func (u *User) GetByCredentials(db *sql.DB, credentials *UserCredentials) (*User, error) {
-	var pwdHash string
-	var userID string
-	var user User
+// validTimezone reports whether timezone is an IANA timezone name, such as Africa/Nairobi
+// Local is the server's own timezone, and means nothing to clients
+func validTimezone(timezone string) bool {
+	_, err := time.LoadLocation(timezone)
+	return err == nil && timezone != "Local"
+}
 
-	stmt, err := db.Prepare("SELECT BIN_TO_UUID(userID) userID, password FROM users WHERE username=?")
-	if err != nil {
-		return nil, err
+// validAvatarURL reports whether avatarURL is an absolute https url that fits its column
+func validAvatarURL(avatarURL string) bool {
+	if len(avatarURL) > 255 {
+		return false
 	}
-	defer stmt.Close()
 
-	err = stmt.QueryRow(credentials.Username).Scan(&userID, &pwdHash)
-	if err != nil {
-		return nil, err
+	parsed, err := url.Parse(avatarURL)
+	return err == nil && parsed.Scheme == "https" && parsed.Host != "" && parsed.User == nil
+}
+
+// PasswordParams defines the structure of a valid request to change a user's password
+type PasswordParams struct {
+	CurrentPassword string `json:"currentPassword,omitempty"`
+	NewPassword     string `json:"newPassword,omitempty"`
+}
+
+// ReauthParams prove that whoever holds a session is the user it belongs to, before something as grave as deleting their account
+// Either the current password or an mfa code will do
+type ReauthParams struct {
+	CurrentPassword string `json:"currentPassword,omitempty"`
+	Code            string `json:"code,omitempty"`
+}
+
+// Error allows for PasswordParams to be used a valid err type
+func (p PasswordParams) Error() string {
+	return "err in password params"
+}
+
+// Validate checks that the current password is given, and that the new one is valid, and new
+func (p *PasswordParams) Validate() error {
+	hasErrors := false
+	validationErrs := &PasswordParams{}
+
+	if p.CurrentPassword == "" {
+		validationErrs.CurrentPassword = "currentPassword is required"
+		hasErrors = true
 	}
 
-	isCorrectPwd := security.VerifyPassword(&pwdHash, &credentials.Password)
-	if !isCorrectPwd {
-		return nil, fmt.Errorf(utils.WRONG_PASSWORD_ERR)
+	var message string
+	if p.NewPassword != "" {
+		message = invalidPasswordMessage(p.NewPassword)
 	}
 
-	user.UserID = userID
-	return &user, nil
-}
+	switch {
+	case p.NewPassword == "":
+		validationErrs.NewPassword = "newPassword is required"
+		hasErrors = true
+
+	case message != "":
+		validationErrs.NewPassword = message
+		hasErrors = true
 
-// GetByUUID searches the db for a user with a given UUID
-func (u *User) GetByUUID(db *sql.DB, uuid string) (*User, error) {
-	stmt, err := db.Prepare("SELECT BIN_TO_UUID(userID) userID FROM users WHERE userID=UUID_TO_BIN(?)")
-	if err != nil {
-		return nil, err
+	case p.NewPassword == p.CurrentPassword:
+		validationErrs.NewPassword = "invalid newPassword. Use a password other than the current one"
+		hasErrors = true
 	}
 
-	var user *User
-	err = stmt.QueryRow(uuid).Scan(user.UserID)
-	if err != nil {
-		return nil, err
+	if !hasErrors {
+		return nil
 	}
 
-	return u, nil
+	return validationErrs
+}
+
+// RoleParams defines the structure of a valid request to change a user's role
+type RoleParams struct {
+	Role string `json:"role,omitempty"`
 }
 
-// UpdatePassword updates an action's title or description
-func (u *User) UpdatePassword(db *sql.DB, userID string, password string) error {
-	if invalidPasswordRegex.MatchString(password) {
-		return fmt.Errorf(invalidPasswordMessage)
+// Error allows for RoleParams to be used a valid err type
+func (p RoleParams) Error() string {
+	return "err in role params"
+}
+
+// Validate checks that the role exists
+func (p *RoleParams) Validate() error {
+	for _, role := range Roles {
+		if p.Role == role {
+			return nil
+		}
 	}
 
-	stmt, err := db.Prepare("UPDATE users SET password = ? WHERE userID = ?")
-	if err != nil {
-		return err
+	if p.Role == "" {
+		return &RoleParams{Role: "role is required"}
 	}
 
-	pwdHash, err := security.GeneratePasswordHash(&password)
-	if err != nil {
-		return err
+	return &RoleParams{Role: fmt.Sprintf("invalid role %q. Use any of %v", p.Role, strings.Join(Roles, ", "))}
+}
+
+// NormalizeUsername lowercases a username, so that users are known by their email however they type it
+func NormalizeUsername(username string) string {
+	return strings.ToLower(username)
+}
+
+// regexes for valid creds
+var validEmailRegex *regexp.Regexp = regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
+
+// error messages
+var invalidEmailMessage string = "invalid username. Use a valid email address"
+
+// invalidPasswordMessage lists every rule of the password policy a new pwd breaks, or is "" if it breaks none
+// userInputs, such as the user's email, may not be in the pwd
+func invalidPasswordMessage(password string, userInputs ...string) string {
+	messages := security.CheckPassword(password, userInputs...)
+	if len(messages) == 0 {
+		return ""
 	}
 
-	_, err = stmt.Exec(pwdHash, userID)
-	if err != nil {
-		return err
+	return fmt.Sprintf("invalid password: %v", strings.Join(messages, "; "))
+}
+
+// CheckNewPassword checks a user's new pwd against the one rule its params' Validate cannot,
+// not knowing who the user is: it fails with a PASSWORD_CONTAINS_EMAIL_ERR if the pwd gives their email away
+func CheckNewPassword(username string, password string) error {
+	if security.PasswordContainsUserInput(password, username) {
+		return utils.ErrPasswordContainsEmail
 	}
 
 	return nil
 }
+
+// Error is defined here inorder to qualify validation errs (modelled on Credentials)
+// as being a valid `error` type
+// It is otherwise quite useless
+func (c UserCredentials) Error() string {
+	return "err in user credentials"
+}
+
+// Validate checks that user credentials are valid, for a new user
+// The password must follow the password policy
+func (c *UserCredentials) Validate() error {
+	return c.validate(true)
+}
+
+// ValidateLogin checks that user credentials are valid, for logging in
+// The password is only required: it was checked against the password policy as it was set,
+// and the policy may have changed since
+func (c *UserCredentials) ValidateLogin() error {
+	return c.validate(false)
+}
+
+// validate lowercases the username, and checks that user credentials are valid, with the password checked against the policy if checkPolicy is true
+func (c *UserCredentials) validate(checkPolicy bool) error {
+	c.Username = NormalizeUsername(c.Username)
+
+	validationErrs := &UserCredentials{}
+	hasErrors := false
+
+	func() {
+		if c.Password == "" {
+			validationErrs.Password = "password is required"
+			hasErrors = true
+			return
+		}
+
+		if !checkPolicy {
+			return
+		}
+
+		if message := invalidPasswordMessage(c.Password, c.Username); message != "" {
+			validationErrs.Password = message
+			hasErrors = true
+		}
+	}()
+
+	func() {
+		if !validEmailRegex.MatchString(c.Username) {
+			if c.Username == "" {
+				validationErrs.Username = "username is required"
+			} else {
+				validationErrs.Username = invalidEmailMessage
+			}
+			hasErrors = true
+		}
+	}()
+
+	if !hasErrors {
+		return nil
+	}
+
+	return validationErrs
+}
@@ -0,0 +1,255 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Workspace roles, from the most to the least trusted
+// Owners manage the workspace and its members, editors change its actions and outputs, viewers only read them
+const (
+	WorkspaceOwner  = "owner"
+	WorkspaceEditor = "editor"
+	WorkspaceViewer = "viewer"
+)
+
+// WorkspaceRoles lists every role a workspace member may be given
+var WorkspaceRoles = []string{WorkspaceOwner, WorkspaceEditor, WorkspaceViewer}
+
+// Actor is a user acting in one of their spaces: their personal space, or a workspace they are a member of
+type Actor struct {
+	UserID string `json:"userID,omitempty"`
+
+	// WorkspaceID is empty in the personal space
+	WorkspaceID string `json:"workspaceID,omitempty"`
+}
+
+// NewActor reads the workspace userID acts in from a url's query string, defaulting to their personal space
+func NewActor(userID string, values url.Values) *Actor {
+	return &Actor{
+		UserID:      userID,
+		WorkspaceID: values.Get("workspaceID"),
+	}
+}
+
+// Error allows for Actor to be used a valid err type
+func (a Actor) Error() string {
+	return "err in workspace selector"
+}
+
+// Validate checks that the workspace selected is a well-formed id
+// Whether the actor is a member of it is left to the stores
+func (a *Actor) Validate() error {
+	if a.WorkspaceID != "" && !validUUID.MatchString(a.WorkspaceID) {
+		return &Actor{WorkspaceID: "invalid workspaceID. Use the id of a workspace you are a member of"}
+	}
+
+	return nil
+}
+
+// ScopeID is the id of the space the actor acts in: their workspace, or else their own, for their personal space
+// Action titles are unique within it
+func (a *Actor) ScopeID() string {
+	if a.WorkspaceID != "" {
+		return a.WorkspaceID
+	}
+
+	return a.UserID
+}
+
+// Access is what an Actor may do where they act
+// Stores find it, checking that the actor is a member of the workspace they act in, before acting on their behalf
+type Access struct {
+	Actor
+
+	// Role is the actor's role in the workspace, empty in the personal space
+	Role string
+}
+
+// CanWrite reports whether the actor may create actions and outputs where they act
+func (a Access) CanWrite() bool {
+	return a.WorkspaceID == "" || a.Role == WorkspaceOwner || a.Role == WorkspaceEditor
+}
+
+// CanModify reports whether the actor may change an action or output owned by ownerID
+// In the personal space only owners may, in a workspace every editor may
+func (a Access) CanModify(ownerID string) bool {
+	if a.WorkspaceID == "" {
+		return ownerID == a.UserID
+	}
+
+	return a.CanWrite()
+}
+
+// CanSeeArchived reports whether the actor may see, restore and purge an archived action
+// Archived actions are only ever visible to those who may modify them
+func (a Access) CanSeeArchived(action *Action) bool {
+	return action.WorkspaceID == a.WorkspaceID && a.CanModify(action.UserID)
+}
+
+// Visibility restricts an `actions a` query to the actions visible where the actor acts:
+// in the personal space those owned by or shared with them, and in a workspace all of its actions
+func (a Access) Visibility() (string, []interface{}) {
+	if a.WorkspaceID != "" {
+		return "a.workspaceID = ?", []interface{}{a.WorkspaceID}
+	}
+
+	return "a.workspaceID IS NULL AND (a.userID = ? OR EXISTS (SELECT 1 FROM action_shares s WHERE s.actionID = a.actionID AND s.userID = ?))",
+		[]interface{}{a.UserID, a.UserID}
+}
+
+// ArchiveVisibility restricts an `actions a` query to the actions whose archived state the actor may see,
+// as CanSeeArchived does
+func (a Access) ArchiveVisibility() (string, []interface{}) {
+	switch {
+	case a.WorkspaceID == "":
+		return "a.workspaceID IS NULL AND a.userID = ?", []interface{}{a.UserID}
+
+	case a.CanWrite():
+		return a.Visibility()
+
+	default:
+		return "1 = 0", nil
+	}
+}
+
+// validWorkspaceRole reports whether role is one of WorkspaceRoles
+func validWorkspaceRole(role string) bool {
+	for _, valid := range WorkspaceRoles {
+		if role == valid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// workspaceRoleErr describes what is wrong with a workspace role
+func workspaceRoleErr(role string) string {
+	if role == "" {
+		return "role is required"
+	}
+
+	return fmt.Sprintf("invalid role %q. Use any of %v", role, strings.Join(WorkspaceRoles, ", "))
+}
+
+// WorkspaceParams defines the structure of a valid workspace
+type WorkspaceParams struct {
+	Name string `json:"name,omitempty"`
+}
+
+// Error allows for WorkspaceParams to be used a valid err type
+func (p WorkspaceParams) Error() string {
+	return "err in workspace params"
+}
+
+// validWorkspaceName matches names of 3 to 50 letters, numbers, spaces, underscores and dashes
+var validWorkspaceName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_ \-]{2,49}$`)
+
+// Validate checks the workspace params for errs
+func (p *WorkspaceParams) Validate() error {
+	if !validWorkspaceName.MatchString(p.Name) {
+		if p.Name == "" {
+			return &WorkspaceParams{Name: "name is required"}
+		}
+
+		return &WorkspaceParams{Name: "invalid name. Use letters, numbers, spaces, underscores and dashes only, and keep it between 3 and 50 chars long"}
+	}
+
+	return nil
+}
+
+// Workspace is a space whose actions and outputs are shared by all of its members
+type Workspace struct {
+	WorkspaceID string `json:"workspaceID,omitempty"`
+	WorkspaceParams
+
+	// Role is the role of the user the workspace was retrieved for
+	Role      string    `json:"role,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// MemberParams defines the structure of a valid request to change a member's role
+type MemberParams struct {
+	Role string `json:"role,omitempty"`
+}
+
+// Error allows for MemberParams to be used a valid err type
+func (p MemberParams) Error() string {
+	return "err in member params"
+}
+
+// Validate checks that the role exists
+func (p *MemberParams) Validate() error {
+	if !validWorkspaceRole(p.Role) {
+		return &MemberParams{Role: workspaceRoleErr(p.Role)}
+	}
+
+	return nil
+}
+
+// WorkspaceMember is a user's membership of a workspace
+type WorkspaceMember struct {
+	WorkspaceID string    `json:"workspaceID,omitempty"`
+	UserID      string    `json:"userID,omitempty"`
+	Username    string    `json:"username,omitempty"`
+	Role        string    `json:"role,omitempty"`
+	CreatedAt   time.Time `json:"createdAt,omitempty"`
+}
+
+// InvitationParams defines the structure of a valid request to invite a user to a workspace
+type InvitationParams struct {
+	Username string `json:"username,omitempty"`
+	Role     string `json:"role,omitempty"`
+}
+
+// Error allows for InvitationParams to be used a valid err type
+func (p InvitationParams) Error() string {
+	return "err in invitation params"
+}
+
+// Validate checks the invitation params for errs, lowercasing the username
+func (p *InvitationParams) Validate() error {
+	p.Username = NormalizeUsername(p.Username)
+
+	hasErrors := false
+	validationErrs := &InvitationParams{}
+
+	if !validEmailRegex.MatchString(p.Username) {
+		if p.Username == "" {
+			validationErrs.Username = "username is required"
+		} else {
+			validationErrs.Username = invalidEmailMessage
+		}
+		hasErrors = true
+	}
+
+	if !validWorkspaceRole(p.Role) {
+		validationErrs.Role = workspaceRoleErr(p.Role)
+		hasErrors = true
+	}
+
+	if !hasErrors {
+		return nil
+	}
+
+	return validationErrs
+}
+
+// Invitation is a pending invitation for a user to join a workspace, in a role
+// It becomes a membership once the user accepts it
+// Invitations are addressed to usernames, whether or not anyone has signed up with them yet,
+// so that inviting someone tells nothing of who has
+type Invitation struct {
+	InvitationID  string    `json:"invitationID,omitempty"`
+	WorkspaceID   string    `json:"workspaceID,omitempty"`
+	WorkspaceName string    `json:"workspaceName,omitempty"`
+	Username      string    `json:"username,omitempty"`
+	Role          string    `json:"role,omitempty"`
+	InvitedBy     string    `json:"invitedBy,omitempty"`
+	CreatedAt     time.Time `json:"createdAt,omitempty"`
+}
@@ -0,0 +1,128 @@
+package oidc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/dmithamo/timelineapi/pkg/security"
+)
+
+// signingAlgs are the algs id tokens may be signed with: asymmetric ones only, so that none, and HS* with a public key, are refused
+var signingAlgs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", security.SigningMethodEdDSA.Alg()}
+
+// audience is an id token's aud, which is either a single string or an array of them
+type audience []string
+
+// UnmarshalJSON reads an aud in either form
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if json.Unmarshal(b, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	err := json.Unmarshal(b, &many)
+	if err != nil {
+		return fmt.Errorf("aud is neither a string nor an array of them")
+	}
+
+	*a = many
+	return nil
+}
+
+// flag is a boolean claim, which some providers send as the string "true" or "false"
+type flag bool
+
+// UnmarshalJSON reads a flag in either form
+func (f *flag) UnmarshalJSON(b []byte) error {
+	var value interface{}
+	err := json.Unmarshal(b, &value)
+	if err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case bool:
+		*f = flag(v)
+	case string:
+		*f = flag(v == "true")
+	}
+
+	return nil
+}
+
+// idTokenClaims are the claims of an id token the api checks, and reads (OpenID Connect Core 1.0, section 2)
+type idTokenClaims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	ExpiresAt       int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Email           string   `json:"email"`
+	EmailVerified   flag     `json:"email_verified"`
+	Name            string   `json:"name"`
+}
+
+// Valid lets idTokenClaims be parsed by jwt-go, which leaves checking them to verifyIDToken
+func (c *idTokenClaims) Valid() error {
+	return nil
+}
+
+// verifyIDToken checks that an id token was signed by the provider, for the api, in answer to the login that nonce belongs to,
+// and that it has not expired, allowing for leeway, as OpenID Connect Core 1.0 section 3.1.3.7 says
+func (p *Provider) verifyIDToken(d *discovery, idToken string, nonce string) (*Claims, error) {
+	claims := &idTokenClaims{}
+	parser := &jwt.Parser{ValidMethods: signingAlgs, SkipClaimsValidation: true}
+
+	_, err := parser.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.get(d.JWKSURI, kid, token.Method.Alg())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("id token: %v", err)
+	}
+
+	at := time.Now()
+	forClient := false
+	for _, aud := range claims.Audience {
+		forClient = forClient || aud == p.ClientID
+	}
+
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.Issuer:
+		return nil, fmt.Errorf("id token: issued by %q, not %q", claims.Issuer, p.Issuer)
+
+	case !forClient:
+		return nil, fmt.Errorf("id token: not issued for this client")
+
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID:
+		return nil, fmt.Errorf("id token: issued to another party")
+
+	case claims.Subject == "":
+		return nil, fmt.Errorf("id token: sub is required")
+
+	case at.After(time.Unix(claims.ExpiresAt, 0).Add(leeway)):
+		return nil, fmt.Errorf("id token: expired")
+
+	case claims.IssuedAt == 0 || at.Add(leeway).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, fmt.Errorf("id token: issued in the future")
+
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("id token: nonce does not match the login's")
+	}
+
+	return &Claims{
+		Issuer:        p.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
@@ -0,0 +1,190 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// keysRefetchInterval is how often, at most, a provider's keys are fetched again, as tokens signed with unknown keys come in
+// Providers publish new keys before signing with them, so a key unknown for longer is taken to be no key of theirs
+const keysRefetchInterval = 1 * time.Minute
+
+// jwk is a public key, as a provider publishes it (RFC 7517, RFC 8037)
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+// publicKey is a provider's key, and the algs it may sign with
+type publicKey struct {
+	key  interface{}
+	algs []string
+}
+
+// keySet is the keys a provider signs id tokens with, by kid, fetched as they are needed
+type keySet struct {
+	client *http.Client
+
+	// mu guards uri, keys and fetchedAt
+	mu        sync.Mutex
+	uri       string
+	keys      map[string]*publicKey
+	fetchedAt time.Time
+}
+
+// newKeySet creates an empty key set, fetched with client
+func newKeySet(client *http.Client) *keySet {
+	return &keySet{client: client, keys: map[string]*publicKey{}}
+}
+
+// get finds the key named kid, that signs with alg, among the keys published @ uri,
+// fetching them again if it is unknown, unless they were fetched less than keysRefetchInterval ago
+func (ks *keySet) get(uri string, kid string, alg string) (interface{}, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.keys[kid]
+	if (!ok || ks.uri != uri) && time.Since(ks.fetchedAt) >= keysRefetchInterval {
+		err := ks.fetch(uri)
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok = ks.keys[kid]
+	}
+
+	if !ok || ks.uri != uri {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	// never let a token pick its own alg, e.g. to pass a public key off as an HMAC secret
+	for _, keyAlg := range key.algs {
+		if keyAlg == alg {
+			return key.key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected signing method %v for key %q", alg, kid)
+}
+
+// fetch replaces the keys with those published @ uri; callers hold the lock
+// Keys of unknown types, or for encryption, are skipped
+func (ks *keySet) fetch(uri string) error {
+	ks.fetchedAt = time.Now()
+
+	var published struct {
+		Keys []jwk `json:"keys"`
+	}
+	err := getJSON(ks.client, uri, &published)
+	if err != nil {
+		return fmt.Errorf("jwks: %v", err)
+	}
+
+	keys := map[string]*publicKey{}
+	for _, k := range published.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+
+		keys[k.KeyID] = key
+	}
+
+	ks.uri, ks.keys = uri, keys
+	return nil
+}
+
+// publicKey decodes a jwk, along with the algs it may sign with: the one it names, or else any its type allows
+func (k *jwk) publicKey() (*publicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	key := &publicKey{}
+
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decode(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid rsa exponent")
+		}
+
+		key.key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		key.algs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+
+	case "EC":
+		curves := map[string]struct {
+			curve elliptic.Curve
+			alg   string
+		}{"P-256": {elliptic.P256(), "ES256"}, "P-384": {elliptic.P384(), "ES384"}, "P-521": {elliptic.P521(), "ES512"}}
+
+		curve, ok := curves[k.Curve]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", k.Curve)
+		}
+
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		public := &ecdsa.PublicKey{Curve: curve.curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.curve.IsOnCurve(public.X, public.Y) {
+			return nil, fmt.Errorf("point is not on curve %v", k.Curve)
+		}
+
+		key.key = public
+		key.algs = []string{curve.alg}
+
+	case "OKP":
+		x, err := decode(k.X)
+		if err != nil || k.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+
+		key.key = ed25519.PublicKey(x)
+		key.algs = []string{"EdDSA"}
+
+	default:
+		return nil, fmt.Errorf("unknown key type %q", k.KeyType)
+	}
+
+	if k.Algorithm != "" {
+		for _, alg := range key.algs {
+			if alg == k.Algorithm {
+				key.algs = []string{alg}
+				return key, nil
+			}
+		}
+
+		return nil, fmt.Errorf("key type %v cannot sign with %v", k.KeyType, k.Algorithm)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,314 @@
+// package oidc logs users in with OpenID Connect providers, as a relying party,
+// with the authorization code flow and PKCE
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/security"
+)
+
+// discoveryTTL is how long a provider's discovery document is kept, before it is fetched again
+const discoveryTTL = 24 * time.Hour
+
+// leeway is how far the clocks of the api and a provider may drift apart, as id tokens' times are checked
+const leeway = 1 * time.Minute
+
+// validProviderName keeps provider names fit for the paths they are part of
+var validProviderName = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// Config is a provider users may log in with, as the api is registered with it
+type Config struct {
+	// Name is how the provider appears in paths, such as /auth/oidc/<name>/login
+	Name string `json:"name"`
+
+	// Issuer is the provider's issuer identifier, where its discovery document is found
+	Issuer string `json:"issuer"`
+
+	ClientID string `json:"clientID"`
+
+	// ClientSecret is left out for public clients, which rely on PKCE alone
+	// $VARS in it are expanded from the env, so that it can be kept out of the file
+	ClientSecret string `json:"clientSecret,omitempty"`
+
+	// RedirectURL is where the provider sends users back to, once they log in
+	// It defaults to the api's callback, @ <base url>/auth/oidc/<name>/callback
+	RedirectURL string `json:"redirectURL,omitempty"`
+
+	// Scopes default to openid, email and profile. openid is always asked for
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// Validate checks that the config names its provider, and what the api is to it
+func (c *Config) Validate() error {
+	switch {
+	case !validProviderName.MatchString(c.Name):
+		return fmt.Errorf("invalid provider name %q. Use 1 to 32 lowercase letters, digits or dashes", c.Name)
+
+	case c.Issuer == "":
+		return fmt.Errorf("provider %v: issuer is required", c.Name)
+
+	case c.ClientID == "":
+		return fmt.Errorf("provider %v: clientID is required", c.Name)
+	}
+
+	issuer, err := url.Parse(c.Issuer)
+	if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" {
+		return fmt.Errorf("provider %v: issuer must be an http(s) url", c.Name)
+	}
+
+	return nil
+}
+
+// Claims is who a provider says logged in, as its id token has it
+type Claims struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider is an OpenID Connect provider users log in with
+// Its discovery document and keys are fetched as they are first needed, and kept
+type Provider struct {
+	Config
+	client *http.Client
+
+	// mu guards discovery, and keys
+	mu          sync.Mutex
+	discovery   *discovery
+	discoveryAt time.Time
+	keys        *keySet
+}
+
+// discovery is the part of a provider's discovery document the api uses
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewProvider sets up a provider, once its config checks out
+// Nothing is fetched from it until a user logs in with it
+func NewProvider(c Config) (*Provider, error) {
+	err := c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	c.Issuer = strings.TrimSuffix(c.Issuer, "/")
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	hasOpenID := false
+	for _, scope := range c.Scopes {
+		hasOpenID = hasOpenID || scope == "openid"
+	}
+	if !hasOpenID {
+		c.Scopes = append([]string{"openid"}, c.Scopes...)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	return &Provider{Config: c, client: client, keys: newKeySet(client)}, nil
+}
+
+// Providers are the providers users may log in with, in the order they are configured
+type Providers []*Provider
+
+// Get finds the provider named name
+func (ps Providers) Get(name string) (*Provider, bool) {
+	for _, p := range ps {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return nil, false
+}
+
+// LoadProviders reads the JSON array of provider configs at path
+// Providers without a RedirectURL are sent back to the api's callback, under baseURL
+func LoadProviders(path string, baseURL string) (Providers, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []Config
+	err = json.Unmarshal(b, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	providers := Providers{}
+	for _, c := range configs {
+		if _, taken := providers.Get(c.Name); taken {
+			return nil, fmt.Errorf("%v: provider %v is configured twice", path, c.Name)
+		}
+
+		c.ClientSecret = os.ExpandEnv(c.ClientSecret)
+		if c.RedirectURL == "" {
+			c.RedirectURL = fmt.Sprintf("%v/auth/oidc/%v/callback", strings.TrimSuffix(baseURL, "/"), c.Name)
+		}
+
+		p, err := NewProvider(c)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+
+		providers = append(providers, p)
+	}
+
+	return providers, nil
+}
+
+// getDiscovery fetches the provider's discovery document, unless it is already at hand
+// Its issuer must be the one configured, lest another provider's tokens pass for this one's
+func (p *Provider) getDiscovery() (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveryAt) < discoveryTTL {
+		return p.discovery, nil
+	}
+
+	d := &discovery{}
+	err := getJSON(p.client, p.Issuer+"/.well-known/openid-configuration", d)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %v", err)
+	}
+
+	switch {
+	case strings.TrimSuffix(d.Issuer, "/") != p.Issuer:
+		return nil, fmt.Errorf("discovery: issuer %q does not match %q", d.Issuer, p.Issuer)
+
+	case d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "":
+		return nil, fmt.Errorf("discovery: authorization_endpoint, token_endpoint and jwks_uri are required")
+	}
+
+	p.discovery, p.discoveryAt = d, time.Now()
+	return d, nil
+}
+
+// AuthCodeURL is where a user is sent to log in with the provider,
+// asking for a code that only the holder of verifier can redeem, and an id token carrying nonce
+func (p *Provider) AuthCodeURL(state string, nonce string, verifier string) (string, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("discovery: invalid authorization_endpoint: %v", err)
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", CodeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), nil
+}
+
+// tokenResponse is the part of the token endpoint's response the api uses
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange redeems the code a user was sent back with for their id token, proving it holds verifier,
+// and returns who the id token says they are, once it checks out
+func (p *Provider) Exchange(code string, verifier string, nonce string) (*Claims, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.ClientID)
+
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	// confidential clients authenticate as RFC 6749 says, their id and secret form-encoded first
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token endpoint: %v", err)
+	}
+	defer res.Body.Close()
+
+	tokens := &tokenResponse{}
+	err = json.NewDecoder(res.Body).Decode(tokens)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("token endpoint: %v responded with %v", res.Status, err)
+
+	case tokens.Error != "":
+		return nil, fmt.Errorf("token endpoint: %v: %v", tokens.Error, tokens.ErrorDescription)
+
+	case res.StatusCode != http.StatusOK || tokens.IDToken == "":
+		return nil, fmt.Errorf("token endpoint: %v responded without an id_token", res.Status)
+	}
+
+	return p.verifyIDToken(d, tokens.IDToken, nonce)
+}
+
+// GenerateVerifier generates a PKCE code verifier, along with the state and nonce of a login
+func GenerateVerifier() (string, error) {
+	return security.GenerateOneTimeToken()
+}
+
+// CodeChallenge is the S256 PKCE challenge of verifier, which the provider checks it against (RFC 7636)
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// getJSON fetches the JSON document at u into v
+func getJSON(client *http.Client, u string, v interface{}) error {
+	res, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v responded with %v", u, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
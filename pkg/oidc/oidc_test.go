@@ -0,0 +1,174 @@
+package oidc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dmithamo/timelineapi/pkg/oidc/oidctest"
+)
+
+// noRedirects is a client that stops at redirects, so that where they go can be read
+var noRedirects = &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}}
+
+// startProvider serves a mock provider, and sets up a Provider the api logs in with it through
+// The mock is served until the server returned is closed
+func startProvider(t *testing.T, clientSecret string) (*Provider, *oidctest.Provider, *httptest.Server) {
+	t.Helper()
+
+	mock, err := oidctest.NewProvider("", "timelineapi", clientSecret)
+	if err != nil {
+		t.Fatalf("oidctest.NewProvider: %v", err)
+	}
+
+	server := httptest.NewServer(mock)
+	mock.Issuer = server.URL
+
+	p, err := NewProvider(Config{
+		Name:         "mock",
+		Issuer:       server.URL,
+		ClientID:     "timelineapi",
+		ClientSecret: clientSecret,
+		RedirectURL:  "http://api.test/auth/oidc/mock/callback",
+	})
+	if err != nil {
+		server.Close()
+		t.Fatalf("NewProvider: %v", err)
+	}
+
+	return p, mock, server
+}
+
+// authorize sends a user to authURL, as email, and returns where they are sent back to
+// Unless verified, the provider is told their email is not verified
+func authorize(t *testing.T, authURL string, email string, verified bool) url.Values {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse authURL: %v", err)
+	}
+
+	form := u.Query()
+	form.Set("email", email)
+	form.Set("decision", "allow")
+	if verified {
+		form.Set("email_verified", "true")
+	}
+	u.RawQuery = ""
+
+	res, err := noRedirects.PostForm(u.String(), form)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	res.Body.Close()
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if res.StatusCode != http.StatusFound || err != nil || !strings.HasPrefix(callback.String(), "http://api.test/auth/oidc/mock/callback?") {
+		t.Fatalf("authorize: got %v to %q, expected a redirect to the callback", res.Status, res.Header.Get("Location"))
+	}
+
+	return callback.Query()
+}
+
+func TestExchange(t *testing.T) {
+	for _, clientSecret := range []string{"", "s3cret:&"} {
+		p, _, server := startProvider(t, clientSecret)
+		defer server.Close()
+
+		authURL, err := p.AuthCodeURL("state", "nonce", "verifier")
+		if err != nil {
+			t.Fatalf("AuthCodeURL: %v", err)
+		}
+
+		query := mustParseQuery(t, authURL)
+		if query.Get("code_challenge") != CodeChallenge("verifier") || query.Get("code_challenge_method") != "S256" ||
+			query.Get("nonce") != "nonce" || query.Get("state") != "state" || query.Get("scope") != "openid email profile" {
+			t.Fatalf("AuthCodeURL asks for %v", query)
+		}
+
+		callback := authorize(t, authURL, "ada@example.com", true)
+		if callback.Get("state") != "state" {
+			t.Errorf("callback state: got %q, expected %q", callback.Get("state"), "state")
+		}
+
+		claims, err := p.Exchange(callback.Get("code"), "verifier", "nonce")
+		if err != nil || claims.Issuer != p.Issuer || claims.Subject == "" || claims.Email != "ada@example.com" || !claims.EmailVerified {
+			t.Fatalf("Exchange with client secret %q: %+v, %v", clientSecret, claims, err)
+		}
+
+		_, err = p.Exchange(callback.Get("code"), "verifier", "nonce")
+		if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+			t.Errorf("Exchange of a spent code: got %v, expected an invalid_grant", err)
+		}
+	}
+}
+
+func TestExchangeChecks(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier string
+		nonce    string
+		verified bool
+		expected string
+	}{
+		{name: "verifier", verifier: "verifier", nonce: "nonce", verified: true},
+		{name: "unverified email", verifier: "verifier", nonce: "nonce", verified: false},
+		{name: "another verifier", verifier: "another verifier", nonce: "nonce", verified: true, expected: "code_verifier does not match"},
+		{name: "another nonce", verifier: "verifier", nonce: "another nonce", verified: true, expected: "nonce does not match"},
+		{name: "no nonce", verifier: "verifier", nonce: "", verified: true, expected: "nonce does not match"},
+	}
+
+	p, _, server := startProvider(t, "")
+	defer server.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authURL, err := p.AuthCodeURL("state", "nonce", "verifier")
+			if err != nil {
+				t.Fatalf("AuthCodeURL: %v", err)
+			}
+
+			callback := authorize(t, authURL, "ada@example.com", tt.verified)
+			claims, err := p.Exchange(callback.Get("code"), tt.verifier, tt.nonce)
+			if tt.expected != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expected) {
+					t.Errorf("Exchange: got %+v, %v, expected an err saying %q", claims, err, tt.expected)
+				}
+				return
+			}
+
+			if err != nil || claims.EmailVerified != tt.verified {
+				t.Errorf("Exchange: got %+v, %v, expected emailVerified %v", claims, err, tt.verified)
+			}
+		})
+	}
+}
+
+func TestIssuerMismatch(t *testing.T) {
+	p, mock, server := startProvider(t, "")
+	defer server.Close()
+
+	mock.Issuer = "http://impostor.test"
+
+	_, err := p.AuthCodeURL("state", "nonce", "verifier")
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("AuthCodeURL at a provider claiming another issuer: got %v, expected a mismatch", err)
+	}
+}
+
+// mustParseQuery reads the query of url u
+func mustParseQuery(t *testing.T, u string) url.Values {
+	t.Helper()
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatalf("parse %q: %v", u, err)
+	}
+
+	return parsed.Query()
+}
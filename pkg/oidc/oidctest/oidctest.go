@@ -0,0 +1,321 @@
+// package oidctest is a mock OpenID Connect provider, to test logging in with identity providers against,
+// and to log in to a local api with, as cmd/mockoidc
+//
+// Users log in with whatever email and name they type in, without a pwd.
+// Adding `&login_hint=<email>` to the authorize url skips the form, logging in as that email, verified,
+// for scripts and tests. Never use it for anything else: anyone may log in as anyone
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/dmithamo/timelineapi/pkg/security"
+)
+
+// codeTTL is how long an authorization code may be redeemed for
+const codeTTL = 1 * time.Minute
+
+// grant is what an authorization code was issued for, kept until it is redeemed
+type grant struct {
+	clientID      string
+	redirectURI   string
+	challenge     string
+	nonce         string
+	email         string
+	emailVerified bool
+	name          string
+	expiresAt     time.Time
+}
+
+// Provider is a mock provider: its signing key, and the codes it has issued
+// Issuer is the base url it is reached at, which is set once it is known, before it serves anyone
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	key *rsa.PrivateKey
+	kid string
+	mux *http.ServeMux
+
+	// mu guards codes
+	mu    sync.Mutex
+	codes map[string]*grant
+}
+
+// NewProvider generates a provider's signing key, for it to issue id tokens to clientID with
+// Public clients, relying on PKCE alone, are let in if clientSecret is ""
+func NewProvider(issuer string, clientID string, clientSecret string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		kid:          kid[:16],
+		mux:          http.NewServeMux(),
+		codes:        map[string]*grant{},
+	}
+
+	p.mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	p.mux.HandleFunc("/jwks", p.jwks)
+	p.mux.HandleFunc("/authorize", p.authorize)
+	p.mux.HandleFunc("/token", p.token)
+
+	return p, nil
+}
+
+// ServeHTTP serves the provider's discovery document, keys, and authorization and token endpoints
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+// discovery serves the provider's discovery document
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+	})
+}
+
+// jwks serves the key id tokens are signed with
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	encode := base64.RawURLEncoding.EncodeToString
+	sendJSON(w, http.StatusOK, &security.JWKS{Keys: []security.JWK{{
+		KeyType:   "RSA",
+		KeyID:     p.kid,
+		Use:       "sig",
+		Algorithm: "RS256",
+		N:         encode(p.key.N.Bytes()),
+		E:         encode(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+// loginForm asks who to log in as, carrying the authorization request along
+var loginForm = template.Must(template.New("login").Parse(`<!doctype html>
+<title>Mock OIDC provider</title>
+<h1>Log in to the mock OIDC provider</h1>
+<form method="post" action="/authorize">
+	{{range $name, $value := .}}<input type="hidden" name="{{$name}}" value="{{index $value 0}}">
+	{{end}}
+	<p><label>Email <input name="email" type="email" required></label></p>
+	<p><label>Name <input name="name"></label></p>
+	<p><label><input name="email_verified" type="checkbox" value="true" checked> Email is verified</label></p>
+	<p><button name="decision" value="allow">Log in</button> <button name="decision" value="deny" formnovalidate>Deny</button></p>
+</form>
+`))
+
+// authorize checks an authorization request, and logs the user in,
+// as the login_hint says, or else as they say on the login form
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// errs are only sent back to redirect uris once the client and its redirect uri check out
+	redirectURI, err := url.Parse(r.Form.Get("redirect_uri"))
+	if r.Form.Get("client_id") != p.ClientID || err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "unknown client_id, or invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	redirect := func(params url.Values) {
+		params.Set("state", r.Form.Get("state"))
+		redirectURI.RawQuery = params.Encode()
+		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+	}
+
+	switch {
+	case r.Form.Get("response_type") != "code":
+		redirect(url.Values{"error": {"unsupported_response_type"}})
+		return
+
+	case !strings.Contains(" "+r.Form.Get("scope")+" ", " openid "):
+		redirect(url.Values{"error": {"invalid_scope"}, "error_description": {"openid scope is required"}})
+		return
+
+	case r.Form.Get("code_challenge") == "" || r.Form.Get("code_challenge_method") != "S256":
+		redirect(url.Values{"error": {"invalid_request"}, "error_description": {"S256 PKCE is required"}})
+		return
+	}
+
+	g := &grant{
+		clientID:      p.ClientID,
+		redirectURI:   r.Form.Get("redirect_uri"),
+		challenge:     r.Form.Get("code_challenge"),
+		nonce:         r.Form.Get("nonce"),
+		email:         r.Form.Get("login_hint"),
+		emailVerified: true,
+		expiresAt:     time.Now().Add(codeTTL),
+	}
+
+	if r.Method == http.MethodPost {
+		if r.Form.Get("decision") != "allow" {
+			redirect(url.Values{"error": {"access_denied"}})
+			return
+		}
+
+		g.email = r.PostForm.Get("email")
+		g.name = r.PostForm.Get("name")
+		g.emailVerified = r.PostForm.Get("email_verified") == "true"
+	}
+
+	if g.email == "" {
+		request := url.Values{}
+		for _, param := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+			request.Set(param, r.Form.Get(param))
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginForm.Execute(w, request)
+		return
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		redirect(url.Values{"error": {"server_error"}})
+		return
+	}
+
+	p.mu.Lock()
+	p.codes[code] = g
+	p.mu.Unlock()
+
+	redirect(url.Values{"code": {code}})
+}
+
+// token redeems an authorization code for an id token, once, given the PKCE verifier its challenge was made from
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil || r.Method != http.MethodPost || r.PostForm.Get("grant_type") != "authorization_code" {
+		sendJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	if clientID != p.ClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.ClientSecret)) != 1 {
+		sendJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	// codes are spent as they are looked up, so that they are never redeemed twice
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok || time.Now().After(g.expiresAt) || g.clientID != clientID:
+		sendJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "unknown, expired or used code"})
+		return
+
+	case g.redirectURI != r.PostForm.Get("redirect_uri"):
+		sendJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "redirect_uri does not match"})
+		return
+
+	case base64.RawURLEncoding.EncodeToString(challenge[:]) != g.challenge:
+		sendJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code_verifier does not match code_challenge"})
+		return
+	}
+
+	// a user is known by their email, so that logging in as it again is logging in as the same user
+	sub := sha256.Sum256([]byte(strings.ToLower(g.email)))
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.Issuer,
+		"sub":            fmt.Sprintf("%x", sub[:8]),
+		"aud":            clientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"email":          g.email,
+		"email_verified": g.emailVerified,
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	if g.name != "" {
+		claims["name"] = g.name
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = p.kid
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	accessToken, err := randomToken()
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	sendJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+// randomToken generates a random token, for codes and key ids
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", b), nil
+}
+
+// sendJSON sends v back as JSON, with status
+func sendJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
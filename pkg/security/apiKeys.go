@@ -0,0 +1,31 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+// apiKeyPrefix marks API keys, so that they are easy to tell apart from other secrets
+const apiKeyPrefix = "tlk_"
+
+// apiKeyHintLength is how much of an API key is kept in the clear, for its owner to recognise it by
+const apiKeyHintLength = len(apiKeyPrefix) + 8
+
+// GenerateAPIKey generates a random API key, along with the prefix it is recognised by once hidden
+func GenerateAPIKey() (string, string, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+
+	key := fmt.Sprintf("%v%x", apiKeyPrefix, b)
+	return key, key[:apiKeyHintLength], nil
+}
+
+// HashAPIKey is how API keys are kept in the db
+// They are long and random, so unlike pwds a fast hash will do, and lets them be looked up by it
+func HashAPIKey(key string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+}
@@ -0,0 +1,107 @@
+package security
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// breachPrefixLength is how many hex characters of a SHA-1 hash name the range it is in,
+// as in the Pwned Passwords range api and its downloads
+const breachPrefixLength = 5
+
+// BreachList is a set of pwds known to have leaked in data breaches, kept as SHA-1 hashes, offline
+// It is either a single file, loaded whole, of a `HASH` or `HASH:COUNT` line per pwd,
+// or a directory of `<PREFIX>.txt` files of `SUFFIX:COUNT` lines, each read as pwds in its range are checked
+type BreachList struct {
+	dir    string
+	hashes map[[sha1.Size]byte]struct{}
+}
+
+// LoadBreachList loads the breach list at path, which is a file or a directory of range files
+func LoadBreachList(path string) (*BreachList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &BreachList{dir: path}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := &BreachList{hashes: map[[sha1.Size]byte]struct{}{}}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		hash, ok := parseBreachLine(scanner.Text(), "")
+		if !ok {
+			return nil, fmt.Errorf("%v:%v: expected a SHA-1 hash, optionally followed by :COUNT", path, line)
+		}
+
+		if hash != nil {
+			b.hashes[*hash] = struct{}{}
+		}
+	}
+
+	return b, scanner.Err()
+}
+
+// parseBreachLine reads the hash on a line of a breach list, prefixed with the range it is in, if any
+// Blank lines hold no hash, and are skipped
+func parseBreachLine(line string, prefix string) (*[sha1.Size]byte, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, true
+	}
+
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+
+	decoded, err := hex.DecodeString(prefix + line)
+	if err != nil || len(decoded) != sha1.Size {
+		return nil, false
+	}
+
+	var hash [sha1.Size]byte
+	copy(hash[:], decoded)
+	return &hash, true
+}
+
+// Contains reports whether password is in the breach list
+// Ranges that cannot be read are taken to hold no pwds, so that a broken list does not stop users signing up
+func (b *BreachList) Contains(password string) bool {
+	hash := sha1.Sum([]byte(password))
+	if b.dir == "" {
+		_, ok := b.hashes[hash]
+		return ok
+	}
+
+	hexHash := strings.ToUpper(hex.EncodeToString(hash[:]))
+	prefix := hexHash[:breachPrefixLength]
+
+	f, err := os.Open(filepath.Join(b.dir, prefix+".txt"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		found, ok := parseBreachLine(scanner.Text(), prefix)
+		if ok && found != nil && *found == hash {
+			return true
+		}
+	}
+
+	return false
+}
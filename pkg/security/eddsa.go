@@ -0,0 +1,53 @@
+package security
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// signingMethodEdDSA signs tokens with Ed25519 keys, which jwt-go does not support out of the box
+type signingMethodEdDSA struct{}
+
+// SigningMethodEdDSA is registered with jwt-go under the `EdDSA` alg, as RFC 8037 names it
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Verify checks the signature of signingString with an ed25519.PublicKey
+func (m *signingMethodEdDSA) Verify(signingString string, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return fmt.Errorf("ed25519: verification error")
+	}
+
+	return nil
+}
+
+// Sign signs signingString with an ed25519.PrivateKey
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
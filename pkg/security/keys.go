@@ -0,0 +1,332 @@
+package security
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Signing algs a key manager can generate keys for
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// SigningKey is a key tokens are signed and verified with, named by the `kid` header of its tokens
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	// ActiveFrom is when the key may start signing tokens, once verifiers have had time to fetch it. It is zero for the secret
+	ActiveFrom time.Time
+
+	// private signs tokens, and public verifies them. For HS256, both are the secret
+	private interface{}
+	public  interface{}
+}
+
+// KeyManager holds every key tokens may be signed with
+// Only the newest active key signs tokens, but every key verifies them,
+// so that keys can be rotated without logging anyone out
+// Keys are published as soon as they are loaded, but only become active activation after they were added to the dir,
+// so that verifiers caching the published keys know a key before they meet tokens signed with it
+type KeyManager struct {
+	secret     *SigningKey
+	dir        string
+	activation time.Duration
+
+	mu     sync.RWMutex
+	keys   map[string]*SigningKey
+	signer *SigningKey
+}
+
+// NewKeyManager loads the `<kid>.pem` keys in dir, if any, and the secret, if any, as an HS256 key
+// The active key in dir whose kid sorts last signs tokens, or the secret, if dir holds none that is active
+func NewKeyManager(secret string, dir string, activation time.Duration) (*KeyManager, error) {
+	km := &KeyManager{dir: dir, activation: activation}
+
+	if secret != "" {
+		km.secret = &SigningKey{
+			ID:      fmt.Sprintf("hs256-%x", sha256.Sum256([]byte(secret)))[:14],
+			Method:  jwt.SigningMethodHS256,
+			private: []byte(secret),
+			public:  []byte(secret),
+		}
+	}
+
+	err := km.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return km, nil
+}
+
+// Reload rereads the keys dir, picking up keys added to or removed from it since, and keys that became active
+// If it fails, the keys already loaded are kept
+func (km *KeyManager) Reload() error {
+	keys := map[string]*SigningKey{}
+	var signer, oldest *SigningKey
+	at := time.Now()
+
+	if km.secret != nil {
+		keys[km.secret.ID] = km.secret
+		signer = km.secret
+	}
+
+	if km.dir != "" {
+		paths, err := filepath.Glob(filepath.Join(km.dir, "*.pem"))
+		if err != nil {
+			return err
+		}
+
+		// kids are generated from the time, so the last of them is the newest
+		sort.Strings(paths)
+		for _, path := range paths {
+			key, err := readSigningKey(path)
+			if err != nil {
+				return err
+			}
+
+			// a key was added to the dir as its file was last written, which outlives restarts
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+
+			key.ActiveFrom = info.ModTime().Add(km.activation)
+			keys[key.ID] = key
+
+			if oldest == nil {
+				oldest = key
+			}
+
+			if !at.Before(key.ActiveFrom) {
+				signer = key
+			}
+		}
+	}
+
+	// with neither the secret nor an active key, the oldest key signs, as no other has been published for longer
+	if signer == nil {
+		signer = oldest
+	}
+
+	if signer == nil {
+		return fmt.Errorf("no signing keys: set SECRET, or generate a key with cmd/keys")
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	km.keys = keys
+	km.signer = signer
+	return nil
+}
+
+// Keys lists the keys held, in kid order, along with the one that signs
+func (km *KeyManager) Keys() ([]*SigningKey, *SigningKey) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	keys := []*SigningKey{}
+	for _, key := range km.keys {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, km.signer
+}
+
+// sign signs claims with the signing key, naming it in the token's `kid` header
+func (km *KeyManager) sign(claims jwt.Claims) (string, error) {
+	km.mu.RLock()
+	signer := km.signer
+	km.mu.RUnlock()
+
+	token := jwt.NewWithClaims(signer.Method, claims)
+	token.Header["kid"] = signer.ID
+
+	return token.SignedString(signer.private)
+}
+
+// verificationKey finds the key a token claims to be signed with, for jwt.Parse
+// Tokens issued before keys were named carry no `kid`, and were signed with the secret
+func (km *KeyManager) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	km.mu.RLock()
+	key, ok := km.keys[kid]
+	if kid == "" {
+		key, ok = km.secret, km.secret != nil
+	}
+	km.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	// never let a token pick its own alg, e.g. to pass a public key off as an HMAC secret
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Method.Alg())
+	}
+
+	return key.public, nil
+}
+
+// GenerateSigningKey generates a new key for alg, saving it to dir as `<kid>.pem`
+// Being the newest, it signs tokens once key managers reload after it becomes active
+func GenerateSigningKey(dir string, alg string) (*SigningKey, error) {
+	var private interface{}
+	var err error
+
+	switch alg {
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+
+	case EdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+
+	default:
+		return nil, fmt.Errorf("unsupported signing alg %q. Use %v or %v", alg, RS256, EdDSA)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 2)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := fmt.Sprintf("%v-%x", time.Now().UTC().Format("20060102T150405Z"), suffix)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%v.pem", kid))
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return readSigningKey(path)
+}
+
+// readSigningKey reads a PEM encoded RSA or Ed25519 private key, named after its file
+func readSigningKey(path string) (*SigningKey, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("%v: no PEM encoded key found", path)
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	key := &SigningKey{
+		ID:      strings.TrimSuffix(filepath.Base(path), ".pem"),
+		private: private,
+	}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+		key.public = &private.PublicKey
+
+	case ed25519.PrivateKey:
+		key.Method = SigningMethodEdDSA
+		key.public = private.Public()
+
+	default:
+		return nil, fmt.Errorf("%v: unsupported key type %T. Use RSA or Ed25519", path, private)
+	}
+
+	return key, nil
+}
+
+// JWK is a public key, as published in a JSON Web Key Set (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, for other services to verify tokens with
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public keys tokens are verified with
+// The secret, being symmetric, is never published
+func (km *KeyManager) JWKS() *JWKS {
+	keys, _ := km.Keys()
+	encode := base64.RawURLEncoding.EncodeToString
+
+	jwks := &JWKS{Keys: []JWK{}}
+	for _, key := range keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Method.Alg()}
+
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = encode(public.N.Bytes())
+			jwk.E = encode(big.NewInt(int64(public.E)).Bytes())
+
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = encode(public)
+
+		default:
+			continue
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks
+}
@@ -0,0 +1,317 @@
+package security
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// keysDir makes a dir for signing keys, which the caller removes
+func keysDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "keys_test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+
+	return dir
+}
+
+// generateKey generates a key for alg in dir, renamed to kid, so that the order keys sort in is up to the test
+// Its file is dated added, which activation is counted from
+func generateKey(t *testing.T, dir string, alg string, kid string, added time.Time) {
+	t.Helper()
+
+	key, err := GenerateSigningKey(dir, alg)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey(%v): %v", alg, err)
+	}
+
+	path := filepath.Join(dir, kid+".pem")
+	err = os.Rename(filepath.Join(dir, key.ID+".pem"), path)
+	if err == nil {
+		err = os.Chtimes(path, added, added)
+	}
+	if err != nil {
+		t.Fatalf("renaming %v to %v: %v", key.ID, kid, err)
+	}
+}
+
+// signedBy generates a token with km, and returns the kid it was signed with
+func signedBy(t *testing.T, km *KeyManager) (string, string) {
+	t.Helper()
+
+	token, err := km.GenerateToken("ada", "session", "user")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(*token, &CustomClaims{})
+	if err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+
+	kid, _ := parsed.Header["kid"].(string)
+	return *token, kid
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := keysDir(t)
+	defer os.RemoveAll(dir)
+
+	km, err := NewKeyManager("s3cret", dir, 0)
+	if err != nil {
+		t.Fatalf("NewKeyManager: %v", err)
+	}
+
+	_, secret := km.Keys()
+	tokens := map[string]string{}
+
+	// each key added signs once the key manager reloads, and every key before it still verifies what it signed
+	rotations := []struct {
+		alg string
+		kid string
+	}{
+		{alg: RS256, kid: "20200101T000000Z-0001"},
+		{alg: EdDSA, kid: "20200102T000000Z-0001"},
+		{alg: RS256, kid: "20200103T000000Z-0001"},
+	}
+
+	token, kid := signedBy(t, km)
+	if kid != secret.ID {
+		t.Fatalf("signed with %q, expected the secret, %q", kid, secret.ID)
+	}
+	tokens[kid] = token
+
+	for _, rotation := range rotations {
+		generateKey(t, dir, rotation.alg, rotation.kid, time.Now().Add(-time.Minute))
+		err = km.Reload()
+		if err != nil {
+			t.Fatalf("Reload: %v", err)
+		}
+
+		token, kid := signedBy(t, km)
+		if kid != rotation.kid {
+			t.Errorf("signed with %q once %v was added, expected it to sign", kid, rotation.kid)
+		}
+		tokens[kid] = token
+
+		for kid, token := range tokens {
+			_, err := km.ValidateToken(token)
+			if err != nil {
+				t.Errorf("ValidateToken signed with %v, once %v was added: %v", kid, rotation.kid, err)
+			}
+		}
+	}
+
+	// tokens signed with a key that was removed are no longer verified
+	err = os.Remove(filepath.Join(dir, rotations[0].kid+".pem"))
+	if err == nil {
+		err = km.Reload()
+	}
+	if err != nil {
+		t.Fatalf("removing %v: %v", rotations[0].kid, err)
+	}
+
+	_, err = km.ValidateToken(tokens[rotations[0].kid])
+	if err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Errorf("ValidateToken signed with a removed key: got %v, expected an unknown signing key", err)
+	}
+}
+
+func TestKeyActivation(t *testing.T) {
+	kids := []string{"20200101T000000Z-0001", "20200102T000000Z-0001"}
+
+	// added is how long ago each key in kids was added, and expected the key that signs, or the secret, if ""
+	tests := []struct {
+		name     string
+		secret   string
+		added    []time.Duration
+		expected string
+	}{
+		{name: "a new key", secret: "s3cret", added: []time.Duration{0}, expected: ""},
+		{name: "a key added longer ago than activation", secret: "s3cret", added: []time.Duration{2 * time.Hour}, expected: kids[0]},
+		{name: "a new key after an active one", secret: "s3cret", added: []time.Duration{2 * time.Hour, 0}, expected: kids[0]},
+		{name: "new keys without a secret", secret: "", added: []time.Duration{0, 0}, expected: kids[0]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := keysDir(t)
+			defer os.RemoveAll(dir)
+
+			for i, added := range tt.added {
+				generateKey(t, dir, EdDSA, kids[i], time.Now().Add(-added))
+			}
+
+			km, err := NewKeyManager(tt.secret, dir, time.Hour)
+			if err != nil {
+				t.Fatalf("NewKeyManager: %v", err)
+			}
+
+			expected := tt.expected
+			if expected == "" {
+				expected = km.secret.ID
+			}
+
+			_, kid := signedBy(t, km)
+			if kid != expected {
+				t.Errorf("signed with %q, expected %q", kid, expected)
+			}
+
+			// keys are published before they sign, so that verifiers know them before they meet their tokens
+			jwks := km.JWKS()
+			if len(jwks.Keys) != len(tt.added) {
+				t.Errorf("JWKS: %+v, expected all %v keys to be published", jwks.Keys, len(tt.added))
+			}
+		})
+	}
+}
+
+func TestVerificationKeyPinsAlg(t *testing.T) {
+	dir := keysDir(t)
+	defer os.RemoveAll(dir)
+
+	generateKey(t, dir, RS256, "20200101T000000Z-0001", time.Now().Add(-time.Minute))
+	km, err := NewKeyManager("s3cret", dir, 0)
+	if err != nil {
+		t.Fatalf("NewKeyManager: %v", err)
+	}
+
+	_, signer := km.Keys()
+	public, err := x509.MarshalPKIXPublicKey(signer.public)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+
+	claims := CustomClaims{UID: "ada", StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix()}}
+	tests := []struct {
+		name     string
+		method   jwt.SigningMethod
+		kid      interface{}
+		key      interface{}
+		expected string
+	}{
+		{name: "the rsa key's public key passed off as an HMAC secret", method: jwt.SigningMethodHS256, kid: signer.ID, key: public, expected: "unexpected signing method"},
+		{name: "the secret, under the rsa key's kid", method: jwt.SigningMethodHS256, kid: signer.ID, key: []byte("s3cret"), expected: "unexpected signing method"},
+		{name: "an unknown kid", method: jwt.SigningMethodHS256, kid: "20200101T000000Z-0002", key: []byte("s3cret"), expected: "unknown signing key"},
+		{name: "no kid, signed with the secret", method: jwt.SigningMethodHS256, kid: nil, key: []byte("s3cret")},
+		{name: "no kid, signed with another secret", method: jwt.SigningMethodHS256, kid: nil, key: []byte("another s3cret"), expected: "signature is invalid"},
+		{name: "the rsa key", method: jwt.SigningMethodRS256, kid: signer.ID, key: signer.private},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := jwt.NewWithClaims(tt.method, claims)
+			if tt.kid != nil {
+				token.Header["kid"] = tt.kid
+			}
+
+			signed, err := token.SignedString(tt.key)
+			if err != nil {
+				t.Fatalf("SignedString: %v", err)
+			}
+
+			_, err = km.ValidateToken(signed)
+			if tt.expected == "" && err != nil {
+				t.Errorf("ValidateToken: %v", err)
+			}
+			if tt.expected != "" && (err == nil || !strings.Contains(err.Error(), tt.expected)) {
+				t.Errorf("ValidateToken: got %v, expected an err saying %q", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir := keysDir(t)
+	defer os.RemoveAll(dir)
+
+	generateKey(t, dir, RS256, "20200101T000000Z-0001", time.Now())
+	generateKey(t, dir, EdDSA, "20200102T000000Z-0001", time.Now())
+	km, err := NewKeyManager("s3cret", dir, 0)
+	if err != nil {
+		t.Fatalf("NewKeyManager: %v", err)
+	}
+
+	keys, _ := km.Keys()
+	published := map[string]JWK{}
+	for _, jwk := range km.JWKS().Keys {
+		published[jwk.KeyID] = jwk
+	}
+
+	// the secret is never published, and the public keys are, whole
+	if len(published) != 2 || len(keys) != 3 {
+		t.Fatalf("JWKS of %v keys: %+v, expected the 2 public ones", len(keys), published)
+	}
+
+	decode := base64.RawURLEncoding.DecodeString
+	for _, key := range keys {
+		jwk, ok := published[key.ID]
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			n, _ := decode(jwk.N)
+			e, _ := decode(jwk.E)
+			if !ok || jwk.KeyType != "RSA" || jwk.Algorithm != RS256 || jwk.Use != "sig" ||
+				new(big.Int).SetBytes(n).Cmp(public.N) != 0 || int(new(big.Int).SetBytes(e).Int64()) != public.E {
+				t.Errorf("JWK of an rsa key: %+v", jwk)
+			}
+
+		case ed25519.PublicKey:
+			x, _ := decode(jwk.X)
+			if !ok || jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" || jwk.Algorithm != EdDSA || !bytes.Equal(public, x) {
+				t.Errorf("JWK of an ed25519 key: %+v", jwk)
+			}
+
+		default:
+			if ok {
+				t.Errorf("JWKS published the secret: %+v", jwk)
+			}
+		}
+	}
+}
+
+func TestTokenAudiences(t *testing.T) {
+	km, err := NewKeyManager("s3cret", "", 0)
+	if err != nil {
+		t.Fatalf("NewKeyManager: %v", err)
+	}
+
+	access, err := km.GenerateToken("ada", "session", "user")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	mfa, _, err := km.GenerateMFAToken("ada")
+	if err != nil {
+		t.Fatalf("GenerateMFAToken: %v", err)
+	}
+
+	// neither passes for the other
+	_, err = km.ValidateToken(mfa)
+	if err == nil {
+		t.Errorf("ValidateToken of an mfa token: expected an err")
+	}
+
+	_, err = km.ValidateMFAToken(*access)
+	if err == nil {
+		t.Errorf("ValidateMFAToken of an access token: expected an err")
+	}
+
+	userID, err := km.ValidateMFAToken(mfa)
+	if err != nil || userID != "ada" {
+		t.Errorf("ValidateMFAToken: got %q, %v", userID, err)
+	}
+}
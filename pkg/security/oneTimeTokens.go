@@ -0,0 +1,25 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+// GenerateOneTimeToken generates a random token, such as those emailed to reset a pwd,
+// to be used once and then thrown away
+func GenerateOneTimeToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", b), nil
+}
+
+// HashOneTimeToken is how one-time tokens are kept in the db, so that a leaked db leaks none
+// Like API keys, they are long and random, so a fast hash will do, and lets them be looked up by it
+func HashOneTimeToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
@@ -0,0 +1,152 @@
+package security
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// PasswordPolicy sets the rules new pwds must follow
+// Pwds already set are never checked against it, so that changing it locks no one out
+type PasswordPolicy struct {
+	// MinLength and MaxLength bound how many characters a pwd has
+	MinLength int
+	MaxLength int
+
+	// CharacterClasses is how many of lowercase letters, uppercase letters, digits and symbols a pwd must combine
+	CharacterClasses int
+
+	// MinStrength is the least PasswordStrength score a pwd may have, from 0 to 4
+	MinStrength int
+
+	// Breaches, if set, lists the pwds that may not be used, as they have leaked
+	Breaches *BreachList
+}
+
+// DefaultPasswordPolicy is the rules pwds follow, unless SetPasswordPolicy says otherwise
+// It asks for length and strength, rather than kinds of characters, so that long passphrases pass
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:   10,
+	MaxLength:   128,
+	MinStrength: 3,
+}
+
+// Validate checks that the policy's rules can be followed
+func (p PasswordPolicy) Validate() error {
+	switch {
+	case p.MinLength < 1 || p.MaxLength < p.MinLength:
+		return fmt.Errorf("password lengths must be at least 1, with the max no less than the min")
+
+	case p.CharacterClasses < 0 || p.CharacterClasses > 4:
+		return fmt.Errorf("password character classes must be between 0 and 4")
+
+	case p.MinStrength < 0 || p.MinStrength > 4:
+		return fmt.Errorf("password strength must be between 0 and 4")
+	}
+
+	return nil
+}
+
+var (
+	// policyMu guards policy
+	policyMu sync.Mutex
+	policy   = DefaultPasswordPolicy
+)
+
+// SetPasswordPolicy sets the rules new pwds must follow from now on, once they check out
+func SetPasswordPolicy(p PasswordPolicy) error {
+	err := p.Validate()
+	if err != nil {
+		return err
+	}
+
+	policyMu.Lock()
+	defer policyMu.Unlock()
+
+	policy = p
+	return nil
+}
+
+// currentPasswordPolicy reads the rules new pwds must follow
+func currentPasswordPolicy() PasswordPolicy {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+
+	return policy
+}
+
+// CheckPassword checks a new pwd against the rules, returning a message for each rule it breaks
+// userInputs, such as the user's email, may not be in the pwd, and make it weaker if they are
+func CheckPassword(password string, userInputs ...string) []string {
+	p := currentPasswordPolicy()
+	messages := []string{}
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		messages = append(messages, fmt.Sprintf("use at least %v characters", p.MinLength))
+	}
+
+	// longer pwds are refused outright, as scoring and hashing them takes ever longer
+	if length > p.MaxLength {
+		return append(messages, fmt.Sprintf("use at most %v characters", p.MaxLength))
+	}
+
+	if characterClasses(password) < p.CharacterClasses {
+		messages = append(messages, fmt.Sprintf("combine at least %v of lowercase letters, uppercase letters, digits and symbols", p.CharacterClasses))
+	}
+
+	if PasswordContainsUserInput(password, userInputs...) {
+		messages = append(messages, "do not use your email address in it")
+	}
+
+	if PasswordStrength(password, userInputs...) < p.MinStrength {
+		messages = append(messages, "make it harder to guess, with more words or less predictable ones, rather than common passwords, sequences or dates")
+	}
+
+	if p.Breaches != nil && p.Breaches.Contains(password) {
+		messages = append(messages, "it has leaked in a data breach, so it is among the first passwords attackers try. Use another")
+	}
+
+	return messages
+}
+
+// PasswordContainsUserInput reports whether password gives away any of userInputs, such as the user's email,
+// whole or for the part before the @, whatever the case
+func PasswordContainsUserInput(password string, userInputs ...string) bool {
+	lowered := strings.ToLower(password)
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		local := input
+		if at := strings.IndexByte(input, '@'); at >= 0 {
+			local = input[:at]
+		}
+
+		for _, part := range []string{input, local} {
+			if len(part) >= 3 && strings.Contains(lowered, part) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// characterClasses counts which of lowercase letters, uppercase letters, digits and symbols password has
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+
+	return lower + upper + digit + symbol
+}
@@ -0,0 +1,194 @@
+package security
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// usePasswordPolicy makes new pwds follow p, until the func returned puts back the rules they followed before
+func usePasswordPolicy(t *testing.T, p PasswordPolicy) func() {
+	t.Helper()
+
+	previous := currentPasswordPolicy()
+	err := SetPasswordPolicy(p)
+	if err != nil {
+		t.Fatalf("SetPasswordPolicy: %v", err)
+	}
+
+	return func() {
+		err := SetPasswordPolicy(previous)
+		if err != nil {
+			t.Fatalf("SetPasswordPolicy: %v", err)
+		}
+	}
+}
+
+// sha1Hex is the hex SHA-1 hash of password, as breach lists have it
+func sha1Hex(password string) string {
+	hash := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(hash[:]))
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy PasswordPolicy
+		valid  bool
+	}{
+		{name: "default", policy: DefaultPasswordPolicy, valid: true},
+		{name: "every rule at its max", policy: PasswordPolicy{MinLength: 128, MaxLength: 128, CharacterClasses: 4, MinStrength: 4}, valid: true},
+		{name: "no min length", policy: PasswordPolicy{MinLength: 0, MaxLength: 128}},
+		{name: "a max length below the min", policy: PasswordPolicy{MinLength: 10, MaxLength: 9}},
+		{name: "more than 4 character classes", policy: PasswordPolicy{MinLength: 10, MaxLength: 128, CharacterClasses: 5}},
+		{name: "negative character classes", policy: PasswordPolicy{MinLength: 10, MaxLength: 128, CharacterClasses: -1}},
+		{name: "a strength above 4", policy: PasswordPolicy{MinLength: 10, MaxLength: 128, MinStrength: 5}},
+		{name: "a negative strength", policy: PasswordPolicy{MinLength: 10, MaxLength: 128, MinStrength: -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("Validate: got %v, expected valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	var (
+		tooShort  = "use at least 10 characters"
+		tooLong   = "use at most 20 characters"
+		classes   = "combine at least 3 of lowercase letters, uppercase letters, digits and symbols"
+		email     = "do not use your email address in it"
+		weak      = "make it harder to guess, with more words or less predictable ones, rather than common passwords, sequences or dates"
+		breached  = "it has leaked in a data breach, so it is among the first passwords attackers try. Use another"
+		breaches  = &BreachList{hashes: map[[sha1.Size]byte]struct{}{sha1.Sum([]byte("Tr0ub4dor&3")): {}}}
+		policy    = PasswordPolicy{MinLength: 10, MaxLength: 20, MinStrength: 3, Breaches: breaches}
+		withClass = PasswordPolicy{MinLength: 10, MaxLength: 20, CharacterClasses: 3, MinStrength: 3}
+	)
+
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		expected []string
+	}{
+		{name: "a passphrase", policy: policy, password: "correct horse battery", expected: []string{tooLong}},
+		{name: "a short passphrase", policy: policy, password: "vivid otter", expected: []string{}},
+		{name: "a random pwd", policy: policy, password: "xK9#mQ2$vL7!", expected: []string{}},
+		{name: "a common pwd", policy: policy, password: "password", expected: []string{tooShort, weak}},
+		{name: "a common pwd, dressed up", policy: policy, password: "p@ssw0rd2019", expected: []string{weak}},
+		{name: "a keyboard run", policy: policy, password: "qwertyuiop", expected: []string{weak}},
+		{name: "a repeat", policy: policy, password: "aaaaaaaaaaaa", expected: []string{weak}},
+		{name: "the user's email", policy: policy, password: "Ada@Example.com2020", expected: []string{email, weak}},
+		{name: "the user's name, before the @", policy: policy, password: "ada-lovelace-1815", expected: []string{email}},
+		{name: "a breached pwd", policy: policy, password: "Tr0ub4dor&3", expected: []string{breached}},
+		{name: "too long to score", policy: policy, password: strings.Repeat("a", 21), expected: []string{tooLong}},
+		{name: "a passphrase, where character classes are required", policy: withClass, password: "vivid otter", expected: []string{classes}},
+		{name: "a random pwd, where character classes are required", policy: withClass, password: "xK9#mQ2$vL7!", expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer usePasswordPolicy(t, tt.policy)()
+
+			messages := CheckPassword(tt.password, "ada@example.com")
+			if !reflect.DeepEqual(messages, tt.expected) {
+				t.Errorf("CheckPassword(%q): got %q, expected %q", tt.password, messages, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPasswordContainsUserInput(t *testing.T) {
+	tests := []struct {
+		password string
+		inputs   []string
+		expected bool
+	}{
+		{password: "ada@example.com!", inputs: []string{"ada@example.com"}, expected: true},
+		{password: "ADA@EXAMPLE.COM!", inputs: []string{"ada@example.com"}, expected: true},
+		{password: "my name is ada", inputs: []string{"ada@example.com"}, expected: true},
+		{password: "example.com is mine", inputs: []string{"ada@example.com"}, expected: false},
+		{password: "my name is al", inputs: []string{"al@example.com"}, expected: false},
+		{password: "my name is ada", inputs: nil, expected: false},
+	}
+
+	for _, tt := range tests {
+		if contains := PasswordContainsUserInput(tt.password, tt.inputs...); contains != tt.expected {
+			t.Errorf("PasswordContainsUserInput(%q, %q): got %v, expected %v", tt.password, tt.inputs, contains, tt.expected)
+		}
+	}
+}
+
+func TestBreachList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "breaches_test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	leaked, other := sha1Hex("Tr0ub4dor&3"), sha1Hex("correct horse battery staple")
+
+	// a single file, of HASH or HASH:COUNT lines, in either case
+	file := filepath.Join(dir, "breaches.txt")
+	err = ioutil.WriteFile(file, []byte(strings.ToLower(leaked)+":42\n\n"+sha1Hex("password")+"\n"), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// a dir of range files, of SUFFIX:COUNT lines
+	ranges := filepath.Join(dir, "ranges")
+	err = os.Mkdir(ranges, 0700)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(ranges, leaked[:5]+".txt"), []byte(leaked[5:]+":42\r\n"), 0600)
+	}
+	if err == nil {
+		// a range holding the suffix of another range's hash does not hold that hash
+		err = ioutil.WriteFile(filepath.Join(ranges, "00000.txt"), []byte(other[5:]+":1\r\n"), 0600)
+	}
+	if err != nil {
+		t.Fatalf("writing ranges: %v", err)
+	}
+
+	for _, path := range []string{file, ranges} {
+		b, err := LoadBreachList(path)
+		if err != nil {
+			t.Fatalf("LoadBreachList(%v): %v", path, err)
+		}
+
+		tests := []struct {
+			password string
+			expected bool
+		}{
+			{password: "Tr0ub4dor&3", expected: true},
+			{password: "tr0ub4dor&3", expected: false},
+			{password: "correct horse battery staple", expected: false},
+		}
+
+		for _, tt := range tests {
+			if contains := b.Contains(tt.password); contains != tt.expected {
+				t.Errorf("Contains(%q) in %v: got %v, expected %v", tt.password, path, contains, tt.expected)
+			}
+		}
+	}
+
+	// a file holding anything but hashes is refused, rather than taken to hold none
+	broken := filepath.Join(dir, "broken.txt")
+	err = ioutil.WriteFile(broken, []byte(leaked+"\nTr0ub4dor&3\n"), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	_, err = LoadBreachList(broken)
+	if err == nil || !strings.Contains(err.Error(), "broken.txt:2") {
+		t.Errorf("LoadBreachList of a broken file: got %v, expected an err at line 2", err)
+	}
+}
@@ -1,23 +1,253 @@
 // package security handles encryption/decryption of pwds and auth tokens
 package security
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
 
-// pwdHashingStrength sets the level of rigour employed in hashing the pwd
-const pwdHashingStrength = 11
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
 
-// GeneratePasswordHash encrypts a pwd for storage in the db
+// Password hashing algorithms, as named in the hashes they produce
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// argon2SaltLength and argon2KeyLength are the lengths, in bytes, of argon2id salts and hashes
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// PasswordHashing sets the algorithm new pwds are hashed with, and the level of rigour employed
+// Stored hashes made any other way still verify, and are rehashed as their users log in
+type PasswordHashing struct {
+	Algorithm string
+
+	// Argon2Memory is in KiB, and Argon2Time the number of passes over it
+	Argon2Memory  uint32
+	Argon2Time    uint32
+	Argon2Threads uint8
+
+	BcryptCost int
+}
+
+// DefaultPasswordHashing is how pwds are hashed, unless SetPasswordHashing says otherwise
+var DefaultPasswordHashing = PasswordHashing{
+	Algorithm:     Argon2id,
+	Argon2Memory:  64 * 1024,
+	Argon2Time:    3,
+	Argon2Threads: 2,
+	BcryptCost:    11,
+}
+
+// Validate checks that the algorithm is known, and that its parameters are in range
+func (h PasswordHashing) Validate() error {
+	switch h.Algorithm {
+	case Argon2id:
+		if h.Argon2Memory < 8*uint32(h.Argon2Threads) || h.Argon2Time < 1 || h.Argon2Threads < 1 {
+			return fmt.Errorf("argon2id needs a time and threads of at least 1, and at least 8KiB of memory per thread")
+		}
+
+	case Bcrypt:
+		if h.BcryptCost < bcrypt.MinCost || h.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %v and %v", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+
+	default:
+		return fmt.Errorf("unknown password hashing algorithm %q. Use %v or %v", h.Algorithm, Argon2id, Bcrypt)
+	}
+
+	return nil
+}
+
+var (
+	// hashingMu guards hashing, and dummyPwdHash
+	hashingMu sync.Mutex
+	hashing   = DefaultPasswordHashing
+
+	// dummyPwdHash is the hash of a pwd no one knows, hashed as real ones are
+	// Checking pwds of unknown users against it makes them take as long as wrong pwds of real users
+	// It is made whenever hashing changes, or else the first time it is needed
+	dummyPwdHash string
+)
+
+// SetPasswordHashing sets how pwds are hashed from now on, once it checks out
+func SetPasswordHashing(h PasswordHashing) error {
+	err := h.Validate()
+	if err != nil {
+		return err
+	}
+
+	// the dummy is made up front, lest the first unknown user take longer than the rest
+	dummy, err := newDummyPwdHash(h)
+	if err != nil {
+		return err
+	}
+
+	hashingMu.Lock()
+	defer hashingMu.Unlock()
+
+	hashing = h
+	dummyPwdHash = dummy
+	return nil
+}
+
+// currentHashing reads how pwds are hashed
+func currentHashing() PasswordHashing {
+	hashingMu.Lock()
+	defer hashingMu.Unlock()
+
+	return hashing
+}
+
+// GeneratePasswordHash encrypts a pwd for storage in the db, as currently configured
+// Hashes are in the PHC string format, `$argon2id$v=19$m=...,t=...,p=...$<salt>$<hash>`,
+// or bcrypt's own, `$2a$<cost>$...`, so that each names how it was made
 func GeneratePasswordHash(password *string) (string, error) {
-	pwdHash, err := bcrypt.GenerateFromPassword([]byte(*password), pwdHashingStrength)
+	return hashPassword(currentHashing(), password)
+}
+
+// hashPassword hashes a pwd as h says
+func hashPassword(h PasswordHashing, password *string) (string, error) {
+	if h.Algorithm == Bcrypt {
+		pwdHash, err := bcrypt.GenerateFromPassword([]byte(*password), h.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+
+		return string(pwdHash), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(*password), salt, h.Argon2Time, h.Argon2Memory, h.Argon2Threads, argon2KeyLength)
+	return fmt.Sprintf("$%v$v=%v$m=%v,t=%v,p=%v$%v$%v", Argon2id, argon2.Version, h.Argon2Memory, h.Argon2Time, h.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// argon2Hash is a parsed argon2id hash
+type argon2Hash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2Hash reads an argon2id hash in the PHC string format
+func parseArgon2Hash(pwdHash string) (*argon2Hash, bool) {
+	parts := strings.Split(pwdHash, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return nil, false
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, false
+	}
+
+	h := &argon2Hash{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads)
 	if err != nil {
-		return "", nil
+		return nil, false
 	}
 
-	return string(pwdHash), nil
+	h.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, false
+	}
+
+	h.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(h.key) == 0 {
+		return nil, false
+	}
+
+	return h, true
 }
 
-// VerifyPassword compares a pwd with the stored hash
+// VerifyPassword compares a pwd with the stored hash, however it was made
+// Users without a pwd, such as those who log in with an identity provider, have an empty hash, which no pwd matches,
+// and which takes as long to check as any other
 func VerifyPassword(pwdHash, password *string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(*pwdHash), []byte(*password))
-	return err == nil
+	if *pwdHash == "" {
+		SimulateVerifyPassword(password)
+		return false
+	}
+
+	if !strings.HasPrefix(*pwdHash, "$"+Argon2id+"$") {
+		err := bcrypt.CompareHashAndPassword([]byte(*pwdHash), []byte(*password))
+		return err == nil
+	}
+
+	h, ok := parseArgon2Hash(*pwdHash)
+	if !ok {
+		return false
+	}
+
+	key := argon2.IDKey([]byte(*password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// PasswordNeedsRehash reports whether a stored hash was made other than as pwds are now hashed,
+// so that it can be replaced while its pwd is at hand, as its user logs in
+func PasswordNeedsRehash(pwdHash string) bool {
+	h := currentHashing()
+
+	if h.Algorithm == Bcrypt {
+		cost, err := bcrypt.Cost([]byte(pwdHash))
+		return err != nil || cost != h.BcryptCost
+	}
+
+	stored, ok := parseArgon2Hash(pwdHash)
+	return !ok || stored.memory != h.Argon2Memory || stored.time != h.Argon2Time ||
+		stored.threads != h.Argon2Threads || len(stored.salt) != argon2SaltLength || len(stored.key) != argon2KeyLength
+}
+
+// SimulateVerifyPassword takes as long as VerifyPassword, for when there is no user to verify a pwd against
+func SimulateVerifyPassword(password *string) {
+	pwdHash, err := currentDummyPwdHash()
+	if err != nil {
+		return
+	}
+
+	VerifyPassword(&pwdHash, password)
+}
+
+// currentDummyPwdHash reads dummyPwdHash, making it first if need be
+func currentDummyPwdHash() (string, error) {
+	hashingMu.Lock()
+	defer hashingMu.Unlock()
+
+	if dummyPwdHash == "" {
+		dummy, err := newDummyPwdHash(hashing)
+		if err != nil {
+			return "", err
+		}
+
+		dummyPwdHash = dummy
+	}
+
+	return dummyPwdHash, nil
+}
+
+// newDummyPwdHash hashes a random pwd, as h says
+func newDummyPwdHash(h PasswordHashing) (string, error) {
+	unknown, err := GenerateOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+
+	return hashPassword(h, &unknown)
 }
@@ -0,0 +1,204 @@
+package security
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestVerifyPasswordWithoutHash(t *testing.T) {
+	hashingMu.Lock()
+	dummyPwdHash = ""
+	hashingMu.Unlock()
+
+	empty, password := "", ""
+	if VerifyPassword(&empty, &password) {
+		t.Errorf("VerifyPassword with an empty hash: got true, expected no pwd to match it")
+	}
+
+	// the empty hash took as long to check as a real one, against the dummy hash
+	hashingMu.Lock()
+	simulated := dummyPwdHash != ""
+	hashingMu.Unlock()
+
+	if !simulated {
+		t.Errorf("VerifyPassword with an empty hash returned without checking a pwd against the dummy hash")
+	}
+}
+
+// cheapArgon2 and cheapBcrypt hash quickly, for tests
+var (
+	cheapArgon2 = PasswordHashing{Algorithm: Argon2id, Argon2Memory: 64, Argon2Time: 1, Argon2Threads: 1}
+	cheapBcrypt = PasswordHashing{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}
+)
+
+// useHashing hashes pwds as h says, until the func returned puts back how they were hashed before
+func useHashing(t *testing.T, h PasswordHashing) func() {
+	t.Helper()
+
+	previous := currentHashing()
+	err := SetPasswordHashing(h)
+	if err != nil {
+		t.Fatalf("SetPasswordHashing: %v", err)
+	}
+
+	return func() {
+		err := SetPasswordHashing(previous)
+		if err != nil {
+			t.Fatalf("SetPasswordHashing: %v", err)
+		}
+	}
+}
+
+func TestPasswordHashingValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hashing PasswordHashing
+		valid   bool
+	}{
+		{name: "default", hashing: DefaultPasswordHashing, valid: true},
+		{name: "cheap argon2id", hashing: cheapArgon2, valid: true},
+		{name: "cheap bcrypt", hashing: cheapBcrypt, valid: true},
+		{name: "argon2id with less than 8KiB per thread", hashing: PasswordHashing{Algorithm: Argon2id, Argon2Memory: 15, Argon2Time: 1, Argon2Threads: 2}},
+		{name: "argon2id without passes", hashing: PasswordHashing{Algorithm: Argon2id, Argon2Memory: 64, Argon2Threads: 1}},
+		{name: "argon2id without threads", hashing: PasswordHashing{Algorithm: Argon2id, Argon2Memory: 64, Argon2Time: 1}},
+		{name: "bcrypt below its min cost", hashing: PasswordHashing{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost - 1}},
+		{name: "bcrypt above its max cost", hashing: PasswordHashing{Algorithm: Bcrypt, BcryptCost: bcrypt.MaxCost + 1}},
+		{name: "unknown algorithm", hashing: PasswordHashing{Algorithm: "scrypt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.hashing.Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("Validate: got %v, expected valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("Pa55word!"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+
+	// hashes made any way verify, whichever way pwds are hashed now
+	tests := []struct {
+		name   string
+		hash   func(password *string) (string, error)
+		prefix string
+	}{
+		{name: "argon2id", hash: func(password *string) (string, error) { return hashPassword(cheapArgon2, password) }, prefix: "$argon2id$v=19$m=64,t=1,p=1$"},
+		{name: "bcrypt", hash: func(password *string) (string, error) { return hashPassword(cheapBcrypt, password) }, prefix: "$2a$04$"},
+		{name: "bcrypt made before hashes were configurable", hash: func(password *string) (string, error) { return string(legacy), nil }, prefix: "$2a$04$"},
+	}
+
+	for _, hashing := range []PasswordHashing{cheapArgon2, cheapBcrypt} {
+		restore := useHashing(t, hashing)
+
+		for _, tt := range tests {
+			t.Run(hashing.Algorithm+"/"+tt.name, func(t *testing.T) {
+				password, wrong := "Pa55word!", "Pa55word?"
+				pwdHash, err := tt.hash(&password)
+				if err != nil || !strings.HasPrefix(pwdHash, tt.prefix) {
+					t.Fatalf("hashing: got %q, %v, expected a hash starting %q", pwdHash, err, tt.prefix)
+				}
+
+				if !VerifyPassword(&pwdHash, &password) {
+					t.Errorf("VerifyPassword with the right pwd: got false")
+				}
+
+				if VerifyPassword(&pwdHash, &wrong) {
+					t.Errorf("VerifyPassword with a wrong pwd: got true")
+				}
+			})
+		}
+
+		restore()
+	}
+}
+
+func TestParseArgon2Hash(t *testing.T) {
+	password := "Pa55word!"
+	pwdHash, err := hashPassword(cheapArgon2, &password)
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	parts := strings.Split(pwdHash, "$")
+
+	tests := []struct {
+		name    string
+		pwdHash string
+		valid   bool
+	}{
+		{name: "argon2id", pwdHash: pwdHash, valid: true},
+		{name: "argon2i", pwdHash: strings.Replace(pwdHash, "$argon2id$", "$argon2i$", 1)},
+		{name: "another version", pwdHash: strings.Replace(pwdHash, "$v=19$", "$v=16$", 1)},
+		{name: "params out of order", pwdHash: strings.Replace(pwdHash, "m=64,t=1,p=1", "t=1,m=64,p=1", 1)},
+		{name: "a salt that is not base64", pwdHash: strings.Join([]string{"", parts[1], parts[2], parts[3], "!!", parts[5]}, "$")},
+		{name: "no key", pwdHash: strings.Join([]string{"", parts[1], parts[2], parts[3], parts[4], ""}, "$")},
+		{name: "a part short", pwdHash: strings.Join(parts[:5], "$")},
+		{name: "bcrypt", pwdHash: "$2a$04$abcdefghijklmnopqrstuuabcdefghijklmnopqrstuvwxyz01234"},
+		{name: "empty", pwdHash: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, ok := parseArgon2Hash(tt.pwdHash)
+			if ok != tt.valid {
+				t.Fatalf("parseArgon2Hash(%q): got %+v, %v, expected valid %v", tt.pwdHash, h, ok, tt.valid)
+			}
+
+			if ok && (h.memory != 64 || h.time != 1 || h.threads != 1 || len(h.salt) != argon2SaltLength || len(h.key) != argon2KeyLength) {
+				t.Errorf("parseArgon2Hash(%q): got %+v", tt.pwdHash, h)
+			}
+
+			// hashes that do not parse verify no pwd
+			if !tt.valid && tt.name != "bcrypt" && tt.pwdHash != "" && VerifyPassword(&tt.pwdHash, &password) {
+				t.Errorf("VerifyPassword against %q: got true", tt.pwdHash)
+			}
+		})
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	password := "Pa55word!"
+	hash := func(h PasswordHashing) string {
+		pwdHash, err := hashPassword(h, &password)
+		if err != nil {
+			t.Fatalf("hashPassword: %v", err)
+		}
+		return pwdHash
+	}
+
+	moreArgon2 := cheapArgon2
+	moreArgon2.Argon2Time = 2
+	moreBcrypt := cheapBcrypt
+	moreBcrypt.BcryptCost++
+
+	tests := []struct {
+		name     string
+		hashing  PasswordHashing
+		pwdHash  string
+		expected bool
+	}{
+		{name: "argon2id, as configured", hashing: cheapArgon2, pwdHash: hash(cheapArgon2), expected: false},
+		{name: "argon2id, with fewer passes than configured", hashing: moreArgon2, pwdHash: hash(cheapArgon2), expected: true},
+		{name: "bcrypt, while argon2id is configured", hashing: cheapArgon2, pwdHash: hash(cheapBcrypt), expected: true},
+		{name: "bcrypt, as configured", hashing: cheapBcrypt, pwdHash: hash(cheapBcrypt), expected: false},
+		{name: "bcrypt, with a lower cost than configured", hashing: moreBcrypt, pwdHash: hash(cheapBcrypt), expected: true},
+		{name: "argon2id, while bcrypt is configured", hashing: cheapBcrypt, pwdHash: hash(cheapArgon2), expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer useHashing(t, tt.hashing)()
+
+			if needsRehash := PasswordNeedsRehash(tt.pwdHash); needsRehash != tt.expected {
+				t.Errorf("PasswordNeedsRehash(%q): got %v, expected %v", tt.pwdHash, needsRehash, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package security
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// commonPasswords are among the pwds, and parts of pwds, guessed first, most common first
+var commonPasswords = []string{
+	"password", "123456", "qwerty", "letmein", "welcome", "admin", "login", "iloveyou", "monkey", "dragon",
+	"football", "baseball", "sunshine", "princess", "master", "shadow", "superman", "trustno1", "starwars", "hello",
+	"freedom", "whatever", "passw0rd", "secret", "summer", "winter", "spring", "autumn", "love", "money",
+	"michael", "jennifer", "jordan", "charlie", "thomas", "hunter", "ranger", "buster", "soccer", "hockey",
+	"batman", "killer", "george", "andrew", "harley", "robert", "daniel", "maggie", "pepper", "ginger",
+	"cheese", "computer", "internet", "access", "flower", "orange", "banana", "cookie", "chocolate", "purple",
+	"silver", "golden", "diamond", "lovely", "angel", "blessed", "family", "friend", "jesus", "mother",
+	"father", "forever", "changeme", "default", "guest", "root", "test", "user", "pass", "temp",
+	"timeline", "company", "office", "google", "apple", "microsoft", "facebook", "twitter", "linkedin", "qazwsx",
+}
+
+// commonPasswordRanks ranks commonPasswords, from 1
+var commonPasswordRanks = func() map[string]int {
+	ranks := map[string]int{}
+	for i, password := range commonPasswords {
+		ranks[password] = i + 1
+	}
+
+	return ranks
+}()
+
+// keyboardRows are runs of adjacent keys, which are as easy to guess as sequences
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+// leetSubstitutions undoes the substitutions most often made to dress up words
+var leetSubstitutions = map[rune]rune{'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's', '!': 'i'}
+
+// PasswordStrength scores how hard password is to guess, from 0 (too guessable) to 4 (very unguessable),
+// as zxcvbn does: the pwd is split into the parts an attacker would guess,
+// such as common pwds, words from userInputs, sequences, repeats, keyboard runs and years,
+// and scored by the number of guesses it would take, rather than by the kinds of characters in it
+func PasswordStrength(password string, userInputs ...string) int {
+	guesses := log10Guesses([]rune(password), userInputTokens(userInputs))
+
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// userInputTokens splits userInputs, such as email addresses, into the words an attacker would try
+func userInputTokens(userInputs []string) map[string]bool {
+	tokens := map[string]bool{}
+	for _, input := range userInputs {
+		for _, token := range strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len([]rune(token)) >= 3 {
+				tokens[token] = true
+			}
+		}
+	}
+
+	return tokens
+}
+
+// log10Guesses estimates the log10 of the guesses it takes to find password,
+// as the cheapest way of splitting it into parts, each guessed on its own
+func log10Guesses(password []rune, userTokens map[string]bool) float64 {
+	n := len(password)
+	best := make([]float64, n+1)
+	for i := 1; i <= n; i++ {
+		best[i] = math.Inf(1)
+	}
+
+	for i := 0; i < n; i++ {
+		// any character may be brute forced
+		if cost := best[i] + math.Log10(charsetSize(password[i])); cost < best[i+1] {
+			best[i+1] = cost
+		}
+
+		for j := i + 3; j <= n; j++ {
+			if cost := best[i] + matchCost(password[i:j], userTokens); cost < best[j] {
+				best[j] = cost
+			}
+		}
+	}
+
+	return best[n]
+}
+
+// matchCost is the log10 of the guesses it takes to find part, if it is a pattern, or +Inf if it is not
+func matchCost(part []rune, userTokens map[string]bool) float64 {
+	cost := math.Inf(1)
+	length := float64(len(part))
+
+	if rank, ok := dictionaryRank(part, userTokens); ok {
+		cost = math.Min(cost, math.Log10(float64(rank)+1)+variationCost(part))
+	}
+
+	if isRepeat(part) {
+		cost = math.Min(cost, math.Log10(charsetSize(part[0])*length))
+	}
+
+	if isSequence(part) {
+		cost = math.Min(cost, math.Log10(charsetSize(part[0])*length))
+	}
+
+	if len(part) >= 4 && isKeyboardRun(part) {
+		cost = math.Min(cost, math.Log10(float64(2*len(keyboardRows)*10)*length))
+	}
+
+	if isYear(part) {
+		cost = math.Min(cost, math.Log10(200))
+	}
+
+	return cost
+}
+
+// dictionaryRank finds how soon part is guessed, as a common pwd or one of userTokens, leet or not
+func dictionaryRank(part []rune, userTokens map[string]bool) (int, bool) {
+	word := strings.ToLower(string(part))
+	unleet := unleetWord(word)
+
+	for _, candidate := range []string{word, unleet} {
+		if userTokens[candidate] {
+			return 1, true
+		}
+
+		if rank, ok := commonPasswordRanks[candidate]; ok {
+			return rank, true
+		}
+	}
+
+	return 0, false
+}
+
+// unleetWord undoes leetSubstitutions in a word
+func unleetWord(word string) string {
+	return strings.Map(func(r rune) rune {
+		if sub, ok := leetSubstitutions[r]; ok {
+			return sub
+		}
+
+		return r
+	}, word)
+}
+
+// variationCost is the log10 of the guesses added by dressing up a word with capitals or leet
+func variationCost(part []rune) float64 {
+	cost := 0.0
+	word := string(part)
+
+	if strings.ToLower(word) != word {
+		cost += math.Log10(2)
+	}
+
+	if unleetWord(strings.ToLower(word)) != strings.ToLower(word) {
+		cost += math.Log10(2)
+	}
+
+	return cost
+}
+
+// isRepeat reports whether part is a single character, repeated
+func isRepeat(part []rune) bool {
+	for _, r := range part[1:] {
+		if r != part[0] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isSequence reports whether part runs up or down, one character at a time, as abc or 987 do
+func isSequence(part []rune) bool {
+	delta := part[1] - part[0]
+	if delta != 1 && delta != -1 {
+		return false
+	}
+
+	for i := 2; i < len(part); i++ {
+		if part[i]-part[i-1] != delta {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isKeyboardRun reports whether part is a run of adjacent keys on a keyboard row, either way
+func isKeyboardRun(part []rune) bool {
+	word := strings.ToLower(string(part))
+	reversed := []rune(word)
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+
+	for _, row := range keyboardRows {
+		if strings.Contains(row, word) || strings.Contains(row, string(reversed)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isYear reports whether part is a year people are likely to use, from 1900 to 2099
+func isYear(part []rune) bool {
+	if len(part) != 4 {
+		return false
+	}
+
+	for _, r := range part {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return (part[0] == '1' && part[1] == '9') || (part[0] == '2' && part[1] == '0')
+}
+
+// charsetSize is how many characters like r there are to brute force
+func charsetSize(r rune) float64 {
+	switch {
+	case r >= '0' && r <= '9':
+		return 10
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		return 26
+	case r < unicode.MaxASCII:
+		return 33
+	default:
+		return 100
+	}
+}
@@ -1,49 +1,70 @@
 package security
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/dmithamo/timelineapi/pkg/utils"
 )
 
-var signingKey = []byte(os.Getenv("SECRET"))
+// accessTokenTTL is how long an access token lasts, before it must be refreshed
+const accessTokenTTL = 10 * time.Minute
+
+// mfaTokenTTL is how long a user has to give their second factor, once their pwd checks out
+const mfaTokenTTL = 5 * time.Minute
+
+// mfaAudience sets mfa tokens apart from access tokens, so that neither passes for the other
+const mfaAudience = "mfa"
+
+// oidcStateTTL is how long a user has to log in with an identity provider, once they are sent to it
+const oidcStateTTL = 10 * time.Minute
+
+// oidcAudience sets the tokens that hold an identity provider login's state apart from every other token
+const oidcAudience = "oidc"
+
+// oidcStatePath is the only path the oidc state cookie is ever sent to
+const oidcStatePath = "/auth/oidc"
+
+// refreshTokenPath is the only path the refresh token cookie is ever sent to
+const refreshTokenPath = "/auth/refresh"
 
 // CustomClaims standardizes the shape of custom claims
 type CustomClaims struct {
-	UID interface{} `json:"uuid"`
+	UID       interface{} `json:"uuid"`
+	SessionID string      `json:"sid,omitempty"`
+	Role      string      `json:"role,omitempty"`
 	jwt.StandardClaims
 }
 
-// GenerateToken generates a token with <any> claims embedded
-func GenerateToken(claims interface{}) (*string, error) {
-	tokenExpiryDate := time.Now().Add(10 * time.Minute).Unix()
+// GenerateToken generates a token for a session, with <any> claims and the user's role embedded,
+// signed with the signing key
+func (km *KeyManager) GenerateToken(claims interface{}, sessionID string, role string) (*string, error) {
+	tokenExpiryDate := time.Now().Add(accessTokenTTL).Unix()
 
 	structuredClaims := CustomClaims{
 		claims,
+		sessionID,
+		role,
 		jwt.StandardClaims{
 			ExpiresAt: tokenExpiryDate,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, structuredClaims)
-
-	signedToken, err := token.SignedString(signingKey)
+	signedToken, err := km.sign(structuredClaims)
 	if err != nil {
 		return nil, err
 	}
 	return &signedToken, nil
 }
 
-// ValidateToken parses a token to validate it
-func ValidateToken(signedToken string) (interface{}, error) {
+// ValidateToken parses a token to validate it, with whichever held key its `kid` header names
+func (km *KeyManager) ValidateToken(signedToken string) (*CustomClaims, error) {
 
-	token, err := jwt.ParseWithClaims(signedToken, &CustomClaims{}, func(t *jwt.Token) (interface{}, error) {
-		return signingKey, nil
-	})
+	token, err := jwt.ParseWithClaims(signedToken, &CustomClaims{}, km.verificationKey)
 
 	// getClaimsHelper extracts claims from token
 	getClaimsHelper := func() (*CustomClaims, error) {
@@ -62,12 +83,12 @@ func ValidateToken(signedToken string) (interface{}, error) {
 
 		errType := err.(*jwt.ValidationError)
 		if errType.Errors == jwt.ValidationErrorExpired {
-			// token exists, isValid(ish), but need refreshing
+			// token exists, isValid(ish), but needs refreshing at POST /auth/refresh
 			claims, err := getClaimsHelper()
 			if err != nil {
 				return nil, err
 			}
-			return claims.UID, fmt.Errorf(utils.TOKEN_EXPIRED_ERR)
+			return claims, utils.ErrTokenExpired
 		}
 
 		return nil, err
@@ -78,35 +99,188 @@ func ValidateToken(signedToken string) (interface{}, error) {
 		return nil, err
 	}
 
-	return claims.UID, err
+	// mfa and oidc state tokens are meant for others, and never pass for access tokens
+	if claims.Audience != "" {
+		return nil, fmt.Errorf("invalid auth token")
+	}
+
+	return claims, nil
 }
 
-// DecodeToken reads the cookie vlaue from a request and parses it
-func DecodeToken(r *http.Request) (interface{}, error) {
+// GenerateMFAToken generates the short-lived token a user whose pwd checked out trades,
+// along with their second factor, for a session
+func (km *KeyManager) GenerateMFAToken(userID string) (string, int64, error) {
+	signedToken, err := km.sign(jwt.StandardClaims{
+		Subject:   userID,
+		Audience:  mfaAudience,
+		ExpiresAt: time.Now().Add(mfaTokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	return signedToken, int64(mfaTokenTTL.Seconds()), nil
+}
 
-	cookie, err := r.Cookie("session_token")
+// ValidateMFAToken parses an mfa token, returning the id of the user it was issued to
+func (km *KeyManager) ValidateMFAToken(signedToken string) (string, error) {
+	claims := &jwt.StandardClaims{}
+
+	_, err := jwt.ParseWithClaims(signedToken, claims, km.verificationKey)
+	if err != nil || claims.Audience != mfaAudience || claims.Subject == "" {
+		return "", utils.ErrMFATokenInvalid
+	}
+
+	return claims.Subject, nil
+}
+
+// OIDCState is what is kept of a login with an identity provider while the user is away at it,
+// to check that the callback answers it, and to finish it with
+type OIDCState struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.StandardClaims
+}
+
+// SetOIDCStateCookie signs a login's state into a cookie, which the client holds while the user is away at the identity provider
+// It is sent back on the callback, a top-level navigation from the provider, so it is Lax rather than Strict
+func (km *KeyManager) SetOIDCStateCookie(w http.ResponseWriter, state OIDCState) error {
+	state.Audience = oidcAudience
+	state.ExpiresAt = time.Now().Add(oidcStateTTL).Unix()
+
+	signedToken, err := km.sign(state)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	claims, err := ValidateToken(cookie.Value)
+	http.SetCookie(w, &http.Cookie{
+		Name:     "oidc_state",
+		Value:    signedToken,
+		HttpOnly: true,
+		Path:     oidcStatePath,
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// OIDCStateFromRequest reads the state of the login a callback answers from its cookie,
+// failing with an OIDC_STATE_INVALID_ERR unless it is there, was signed for provider and matches the callback's state
+// The cookie is cleared, so that a login's state is only ever used once by the client
+func (km *KeyManager) OIDCStateFromRequest(w http.ResponseWriter, r *http.Request, provider string) (*OIDCState, error) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "oidc_state",
+		Value:    "",
+		HttpOnly: true,
+		Expires:  time.Unix(0, 0),
+		Path:     oidcStatePath,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	cookie, err := r.Cookie("oidc_state")
 	if err != nil {
-		if err.Error() != utils.TOKEN_EXPIRED_ERR {
-			return nil, err
-		}
+		return nil, utils.ErrOIDCStateInvalid
+	}
 
-		// refresh token, just to read its innards
-		rt, err := GenerateToken(claims)
+	state := &OIDCState{}
+	_, err = jwt.ParseWithClaims(cookie.Value, state, km.verificationKey)
+	if err != nil || state.Audience != oidcAudience || state.Provider != provider || state.State == "" ||
+		subtle.ConstantTimeCompare([]byte(state.State), []byte(r.URL.Query().Get("state"))) != 1 {
+		return nil, utils.ErrOIDCStateInvalid
+	}
 
-		if err != nil {
-			return nil, err
-		}
+	return state, nil
+}
 
-		claims, err = ValidateToken(*rt)
-		if err != nil {
-			return nil, err
+// TokenFromRequest reads the access token a request carries
+// An `Authorization: Bearer <token>` header takes precedence over the session_token cookie,
+// and a malformed header is rejected rather than ignored
+func TokenFromRequest(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		parts := strings.SplitN(header, " ", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") || strings.TrimSpace(parts[1]) == "" {
+			return "", utils.ErrMalformedAuthHeader
 		}
+
+		return strings.TrimSpace(parts[1]), nil
 	}
 
-	return claims, nil
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		return "", utils.ErrNoToken
+	}
+
+	return cookie.Value, nil
+}
+
+// DecodeToken reads the access token from a request and parses it
+// Expired tokens are not decoded: they must be refreshed first
+func (km *KeyManager) DecodeToken(r *http.Request) (*CustomClaims, error) {
+	token, err := TokenFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return km.ValidateToken(token)
+}
+
+// Tokens hands a client its tokens in a response body, for clients that do not keep cookies
+type Tokens struct {
+	AccessToken  string `json:"accessToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int64  `json:"expiresIn"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// NewTokens describes a freshly issued access token, and the refresh token that renews it
+func NewTokens(accessToken string, refreshToken string) *Tokens {
+	return &Tokens{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}
+}
+
+// SetSessionCookies hands the client its access token, and the refresh token that renews it
+func SetSessionCookies(w http.ResponseWriter, accessToken string, refreshToken string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    accessToken,
+		HttpOnly: true,
+		Path:     "/",
+		SameSite: 1,
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken,
+		HttpOnly: true,
+		Path:     refreshTokenPath,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// ClearSessionCookies tells the client to forget its tokens
+func ClearSessionCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    "",
+		HttpOnly: true,
+		Expires:  time.Unix(0, 0),
+		Path:     "/",
+		SameSite: 1,
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    "",
+		HttpOnly: true,
+		Expires:  time.Unix(0, 0),
+		Path:     refreshTokenPath,
+		SameSite: http.SameSiteStrictMode,
+	})
 }
@@ -0,0 +1,251 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// totp parameters, as every authenticator app defaults to them (RFC 6238)
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+
+	// totpModulus is 10^totpDigits
+	totpModulus = 1000000
+)
+
+// totpSkew is how many periods either side of now a code is accepted for, so that clocks may drift a little
+const totpSkew = 1
+
+// recoveryCodeGroups and recoveryCodeGroupLength shape recovery codes, such as `a1b2c-3d4e5-f6a7b-8c9d0`
+const (
+	recoveryCodeGroups      = 4
+	recoveryCodeGroupLength = 5
+)
+
+// totpEncoding is how totp secrets are shown to authenticator apps
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// sealedTOTPPrefix marks totp secrets sealed with the totp key, telling them apart from those stored before secrets were sealed
+const sealedTOTPPrefix = "sealed:"
+
+var (
+	// totpKeyMu guards totpKey
+	totpKeyMu sync.RWMutex
+
+	// totpKey seals totp secrets before they are stored, so that a leaked db does not give away every user's second factor
+	totpKey cipher.AEAD
+)
+
+// SetTOTPKey sets the key totp secrets are sealed with from now on, derived from a secret from the env as SECRET is
+// Changing it leaves the secrets sealed with the old one unreadable, so users enrolled with them enroll again
+func SetTOTPKey(secret string) error {
+	if secret == "" {
+		return fmt.Errorf("totp key is empty")
+	}
+
+	sum := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	totpKeyMu.Lock()
+	defer totpKeyMu.Unlock()
+
+	totpKey = aead
+	return nil
+}
+
+// getTOTPKey reads the totp key, failing if none is set
+func getTOTPKey() (cipher.AEAD, error) {
+	totpKeyMu.RLock()
+	defer totpKeyMu.RUnlock()
+
+	if totpKey == nil {
+		return nil, fmt.Errorf("no totp key set")
+	}
+
+	return totpKey, nil
+}
+
+// SealTOTPSecret encrypts a user's totp secret to be stored. It only opens for the same user,
+// so that sealed secrets cannot be swapped between users in the db
+func SealTOTPSecret(secret string, userID string) (string, error) {
+	aead, err := getTOTPKey()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(secret), []byte(strings.ToLower(userID)))
+	return sealedTOTPPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenTOTPSecret decrypts a user's stored totp secret
+// Secrets stored before secrets were sealed are given back as they are, until they are sealed too
+func OpenTOTPSecret(stored string, userID string) (string, error) {
+	if !IsSealedTOTPSecret(stored) {
+		return stored, nil
+	}
+
+	aead, err := getTOTPKey()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, sealedTOTPPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("totp secret is not sealed with the totp key")
+	}
+
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(strings.ToLower(userID)))
+	if err != nil {
+		return "", fmt.Errorf("totp secret is not sealed with the totp key")
+	}
+
+	return string(secret), nil
+}
+
+// IsSealedTOTPSecret reports whether a stored totp secret is sealed, rather than stored as it was before secrets were sealed
+func IsSealedTOTPSecret(stored string) bool {
+	return strings.HasPrefix(stored, sealedTOTPPrefix)
+}
+
+// GenerateTOTPSecret generates a random totp secret, base32 encoded for authenticator apps
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPURI builds the `otpauth://` uri authenticator apps enroll a secret with, typed in or scanned off a QR code
+func TOTPURI(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+
+	label := url.PathEscape(fmt.Sprintf("%v:%v", issuer, account))
+	return fmt.Sprintf("otpauth://totp/%v?%v", label, query.Encode())
+}
+
+// ValidateTOTP checks a totp code against secret at the time given, returning the period it belongs to
+// Codes of periods up to lastStep are refused, so that each code works once
+func ValidateTOTP(secret string, code string, at time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	step := at.Unix() / int64(totpPeriod.Seconds())
+	for i := step - totpSkew; i <= step+totpSkew; i++ {
+		if i <= lastStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, i)), []byte(code)) == 1 {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// GenerateTOTPCode computes the totp code of secret at the time given, as an authenticator app would
+func GenerateTOTPCode(secret string, at time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	return totpCode(key, at.Unix()/int64(totpPeriod.Seconds())), nil
+}
+
+// IsTOTPCode reports whether code is shaped like a totp code, rather than a recovery code
+func IsTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// totpCode computes the code of a period (RFC 4226)
+func totpCode(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulus)
+}
+
+// GenerateRecoveryCodes generates n random recovery codes, each good for a single login without a totp code
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, recoveryCodeGroups*recoveryCodeGroupLength/2)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		hex := fmt.Sprintf("%x", b)
+		groups := make([]string, recoveryCodeGroups)
+		for j := range groups {
+			groups[j] = hex[j*recoveryCodeGroupLength : (j+1)*recoveryCodeGroupLength]
+		}
+
+		codes[i] = strings.Join(groups, "-")
+	}
+
+	return codes, nil
+}
+
+// HashRecoveryCode is how recovery codes are kept in the db, ignoring case and dashes, as users type them in
+// Like API keys, they are long and random, so a fast hash will do
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(normalized)))
+}
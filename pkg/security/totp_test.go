@@ -0,0 +1,182 @@
+package security
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 secret of RFC 6238's test vectors, `12345678901234567890`, base32 encoded
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateTOTPCode(t *testing.T) {
+	// RFC 6238's SHA-1 test vectors, cut to 6 digits
+	tests := []struct {
+		at       int64
+		expected string
+	}{
+		{at: 59, expected: "287082"},
+		{at: 1111111109, expected: "081804"},
+		{at: 1111111111, expected: "050471"},
+		{at: 1234567890, expected: "005924"},
+		{at: 2000000000, expected: "279037"},
+	}
+
+	for _, tt := range tests {
+		for _, secret := range []string{rfc6238Secret, strings.ToLower(rfc6238Secret)} {
+			code, err := GenerateTOTPCode(secret, time.Unix(tt.at, 0))
+			if err != nil || code != tt.expected {
+				t.Errorf("GenerateTOTPCode(%v, %v): got %q, %v, expected %q", secret, tt.at, code, err, tt.expected)
+			}
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	step := at.Unix() / 30
+	codeAt := func(offset time.Duration) string {
+		code, err := GenerateTOTPCode(rfc6238Secret, at.Add(offset))
+		if err != nil {
+			t.Fatalf("GenerateTOTPCode: %v", err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		expected int64
+		valid    bool
+	}{
+		{name: "the current code", code: codeAt(0), expected: step, valid: true},
+		{name: "the previous period's code", code: codeAt(-30 * time.Second), expected: step - 1, valid: true},
+		{name: "the next period's code", code: codeAt(30 * time.Second), expected: step + 1, valid: true},
+		{name: "a code from two periods ago", code: codeAt(-time.Minute)},
+		{name: "a code from two periods ahead", code: codeAt(time.Minute)},
+		{name: "the current code, used already", code: codeAt(0), lastStep: step},
+		{name: "the next period's code, once the current one is used", code: codeAt(30 * time.Second), lastStep: step, expected: step + 1, valid: true},
+		{name: "a code too short", code: codeAt(0)[:5]},
+		{name: "a recovery code", code: "a1b2c-3d4e5-f6a7b-8c9d0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ValidateTOTP(rfc6238Secret, tt.code, at, tt.lastStep)
+			if ok != tt.valid || got != tt.expected {
+				t.Errorf("ValidateTOTP(%q): got %v, %v, expected %v, %v", tt.code, got, ok, tt.expected, tt.valid)
+			}
+		})
+	}
+
+	_, ok := ValidateTOTP("not base32!", codeAt(0), at, 0)
+	if ok {
+		t.Errorf("ValidateTOTP with a secret that is not base32: got true")
+	}
+}
+
+func TestIsTOTPCode(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected bool
+	}{
+		{code: "123456", expected: true},
+		{code: "000000", expected: true},
+		{code: "12345", expected: false},
+		{code: "1234567", expected: false},
+		{code: "12345a", expected: false},
+		{code: "a1b2c-3d4e5-f6a7b-8c9d0", expected: false},
+	}
+
+	for _, tt := range tests {
+		if isCode := IsTOTPCode(tt.code); isCode != tt.expected {
+			t.Errorf("IsTOTPCode(%q): got %v, expected %v", tt.code, isCode, tt.expected)
+		}
+	}
+}
+
+func TestSealTOTPSecret(t *testing.T) {
+	err := SetTOTPKey("totp_test")
+	if err != nil {
+		t.Fatalf("SetTOTPKey: %v", err)
+	}
+
+	sealed, err := SealTOTPSecret(rfc6238Secret, "Ada")
+	if err != nil || !IsSealedTOTPSecret(sealed) || strings.Contains(sealed, rfc6238Secret) {
+		t.Fatalf("SealTOTPSecret: got %q, %v", sealed, err)
+	}
+
+	tests := []struct {
+		name     string
+		stored   string
+		userID   string
+		expected string
+		valid    bool
+	}{
+		{name: "sealed for the user", stored: sealed, userID: "Ada", expected: rfc6238Secret, valid: true},
+		{name: "sealed for the user, whatever the case of their id", stored: sealed, userID: "ada", expected: rfc6238Secret, valid: true},
+		{name: "sealed for another user", stored: sealed, userID: "bob"},
+		{name: "tampered with", stored: sealed[:len(sealed)-2] + "AA", userID: "Ada"},
+		{name: "not base64", stored: sealedTOTPPrefix + "!!", userID: "Ada"},
+		{name: "stored before secrets were sealed", stored: rfc6238Secret, userID: "Ada", expected: rfc6238Secret, valid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, err := OpenTOTPSecret(tt.stored, tt.userID)
+			if (err == nil) != tt.valid || secret != tt.expected {
+				t.Errorf("OpenTOTPSecret: got %q, %v, expected %q", secret, err, tt.expected)
+			}
+		})
+	}
+
+	// secrets sealed with another key no longer open
+	err = SetTOTPKey("another totp_test")
+	if err != nil {
+		t.Fatalf("SetTOTPKey: %v", err)
+	}
+
+	_, err = OpenTOTPSecret(sealed, "Ada")
+	if err == nil {
+		t.Errorf("OpenTOTPSecret sealed with another key: expected an err")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("timelineapi", "ada@example.com", rfc6238Secret))
+	if err != nil {
+		t.Fatalf("parse TOTPURI: %v", err)
+	}
+
+	query := uri.Query()
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/timelineapi:ada@example.com" ||
+		query.Get("secret") != rfc6238Secret || query.Get("issuer") != "timelineapi" ||
+		query.Get("algorithm") != "SHA1" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("TOTPURI: got %v", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil || len(codes) != 10 {
+		t.Fatalf("GenerateRecoveryCodes: got %v, %v", codes, err)
+	}
+
+	shape := regexp.MustCompile(`^[0-9a-f]{5}(-[0-9a-f]{5}){3}$`)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if !shape.MatchString(code) || seen[code] {
+			t.Errorf("GenerateRecoveryCodes: got %q, expected distinct codes shaped like a1b2c-3d4e5-f6a7b-8c9d0", code)
+		}
+		seen[code] = true
+
+		// codes are hashed as users type them in, whatever the case, dashes and spaces
+		typed := " " + strings.ToUpper(strings.ReplaceAll(code, "-", "")) + " "
+		if HashRecoveryCode(typed) != HashRecoveryCode(code) {
+			t.Errorf("HashRecoveryCode(%q): got another hash than for %q", typed, code)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/security"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+)
+
+// NewMemoryStores creates empty stores that live and die with the process
+// They are the sql stores over a throwaway SQLite db, so that they keep to the same rules as every other backend
+func NewMemoryStores() *Stores {
+	stores, err := NewSQLiteStores(":memory:", false)
+	if err != nil {
+		// creating the tables of an empty in-memory db only fails if sqlite itself is broken
+		panic(err)
+	}
+
+	return stores
+}
+
+// NewMemoryCaches creates empty caches that live and die with the process
+func NewMemoryCaches(policy SessionPolicy) *Caches {
+	return &Caches{
+		Sessions: &memorySessionStore{sessions: map[string]*memorySession{}, policy: policy},
+		LoginAttempts: &memoryLoginAttemptStore{
+			attempts:     map[string]*memoryLoginAttempts{},
+			unlockTokens: map[string]*memoryUnlockToken{},
+		},
+	}
+}
+
+// memorySession is a session, alongside the hashes of its current and spent refresh tokens
+type memorySession struct {
+	session     models.Session
+	refreshHash string
+	spentHashes map[string]bool
+}
+
+// memorySessionStore is an in-memory SessionStore
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*memorySession
+	policy   SessionPolicy
+}
+
+// getSession finds a session that has not expired; callers hold the lock
+func (s *memorySessionStore) getSession(sessionID string) (*memorySession, error) {
+	ms, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, utils.ErrSessionNotFound
+	}
+
+	if !now().Before(ms.session.ExpiresAt) {
+		delete(s.sessions, sessionID)
+		return nil, utils.ErrSessionNotFound
+	}
+
+	return ms, nil
+}
+
+// touchHelper records that a session was just used; callers hold the lock
+func (s *memorySessionStore) touchHelper(ms *memorySession) {
+	ms.session.LastSeenAt = now()
+	ms.session.ExpiresAt = s.policy.expiresAt(ms.session.CreatedAt, ms.session.LastSeenAt)
+}
+
+func (s *memorySessionStore) CreateSession(userID string, userAgent string, ip string) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	createdAt := now()
+	ms := &memorySession{
+		session: models.Session{
+			SessionID:  newSessionID(),
+			UserID:     userID,
+			UserAgent:  userAgent,
+			IP:         ip,
+			CreatedAt:  createdAt,
+			LastSeenAt: createdAt,
+			ExpiresAt:  s.policy.expiresAt(createdAt, createdAt),
+		},
+		spentHashes: map[string]bool{},
+	}
+	s.sessions[ms.session.SessionID] = ms
+
+	session := ms.session
+	session.RefreshToken = newRefreshToken(session.SessionID)
+	ms.refreshHash = hashRefreshToken(session.RefreshToken)
+
+	return &session, nil
+}
+
+func (s *memorySessionStore) RotateSession(refreshToken string) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessionID, ok := sessionOfRefreshToken(refreshToken)
+	if !ok {
+		return nil, utils.ErrSessionNotFound
+	}
+
+	ms, err := s.getSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := hashRefreshToken(refreshToken)
+	if hash != ms.refreshHash {
+		// a spent token in use means it leaked: end the session for whoever holds it
+		if ms.spentHashes[hash] {
+			delete(s.sessions, sessionID)
+			return nil, utils.ErrRefreshTokenReused
+		}
+
+		return nil, utils.ErrSessionNotFound
+	}
+
+	ms.spentHashes[hash] = true
+	s.touchHelper(ms)
+
+	session := ms.session
+	session.RefreshToken = newRefreshToken(session.SessionID)
+	ms.refreshHash = hashRefreshToken(session.RefreshToken)
+
+	return &session, nil
+}
+
+func (s *memorySessionStore) TouchSession(sessionID string) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms, err := s.getSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.touchHelper(ms)
+
+	session := ms.session
+	return &session, nil
+}
+
+func (s *memorySessionStore) GetSessions(userID string) ([]models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := []models.Session{}
+	for sessionID, ms := range s.sessions {
+		if ms.session.UserID != userID {
+			continue
+		}
+
+		if _, err := s.getSession(sessionID); err != nil {
+			continue
+		}
+
+		sessions = append(sessions, ms.session)
+	}
+
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+func (s *memorySessionStore) RevokeSession(sessionID string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms, err := s.getSession(sessionID)
+	if err != nil {
+		return err
+	}
+
+	// other users' sessions are as good as missing
+	if ms.session.UserID != userID {
+		return utils.ErrSessionNotFound
+	}
+
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+func (s *memorySessionStore) RevokeSessions(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sessionID, ms := range s.sessions {
+		if ms.session.UserID == userID {
+			delete(s.sessions, sessionID)
+		}
+	}
+
+	return nil
+}
+
+// memoryLoginAttempts is a count of failed logins, alongside when it is forgotten, window after the last of them
+type memoryLoginAttempts struct {
+	attempts  models.LoginAttempts
+	window    time.Duration
+	expiresAt time.Time
+}
+
+// memoryUnlockToken is the username an unlock token unlocks, alongside when the token expires
+type memoryUnlockToken struct {
+	username  string
+	expiresAt time.Time
+}
+
+// memoryLoginAttemptStore is an in-memory LoginAttemptStore, keeping unlock tokens by their hashes
+type memoryLoginAttemptStore struct {
+	mu           sync.Mutex
+	attempts     map[string]*memoryLoginAttempts
+	unlockTokens map[string]*memoryUnlockToken
+}
+
+// getAttempts finds the failed logins under key that have not been forgotten; callers hold the lock
+func (s *memoryLoginAttemptStore) getAttempts(key string) (*memoryLoginAttempts, bool) {
+	mla, ok := s.attempts[key]
+	if !ok {
+		return nil, false
+	}
+
+	if !now().Before(mla.expiresAt) {
+		delete(s.attempts, key)
+		return nil, false
+	}
+
+	return mla, true
+}
+
+func (s *memoryLoginAttemptStore) GetLoginAttempts(key string) (*models.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mla, ok := s.getAttempts(key)
+	if !ok {
+		return &models.LoginAttempts{}, nil
+	}
+
+	attempts := mla.attempts
+	return &attempts, nil
+}
+
+func (s *memoryLoginAttemptStore) ReserveLoginAttempt(key string, window time.Duration) (*models.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mla, ok := s.getAttempts(key)
+	if !ok {
+		mla = &memoryLoginAttempts{}
+		s.attempts[key] = mla
+	}
+
+	attempts := mla.attempts
+
+	mla.attempts.Failures++
+	mla.attempts.LastFailedAt = now()
+	mla.window = window
+	mla.expiresAt = mla.attempts.LastFailedAt.Add(window)
+
+	return &attempts, nil
+}
+
+func (s *memoryLoginAttemptStore) ReleaseLoginAttempt(key string, reserved *models.LoginAttempts) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mla, ok := s.getAttempts(key)
+	if !ok {
+		return nil
+	}
+
+	mla.attempts.Failures--
+	if mla.attempts.Failures <= 0 {
+		delete(s.attempts, key)
+		return nil
+	}
+
+	// the count is back to what it was before the login, so no login reserved since is still counted
+	if mla.attempts.Failures == reserved.Failures {
+		mla.attempts.LastFailedAt = reserved.LastFailedAt
+		mla.expiresAt = reserved.LastFailedAt.Add(mla.window)
+	}
+
+	return nil
+}
+
+func (s *memoryLoginAttemptStore) ClearLoginAttempts(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *memoryLoginAttemptStore) CreateUnlockToken(username string, ttl time.Duration) (string, error) {
+	token, err := security.GenerateOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unlockTokens[security.HashOneTimeToken(token)] = &memoryUnlockToken{username: username, expiresAt: now().Add(ttl)}
+	return token, nil
+}
+
+func (s *memoryLoginAttemptStore) RedeemUnlockToken(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokenHash := security.HashOneTimeToken(token)
+	unlock, ok := s.unlockTokens[tokenHash]
+	if !ok {
+		return "", utils.ErrUnlockTokenInvalid
+	}
+
+	delete(s.unlockTokens, tokenHash)
+	if !now().Before(unlock.expiresAt) {
+		return "", utils.ErrUnlockTokenInvalid
+	}
+
+	delete(s.attempts, models.AccountAttemptsKey(unlock.username))
+	return unlock.username, nil
+}
@@ -0,0 +1,20 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/dmithamo/timelineapi/pkg/store"
+	"github.com/dmithamo/timelineapi/pkg/store/storetest"
+)
+
+func TestMemoryStores(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) *store.Stores {
+		return store.NewMemoryStores()
+	})
+}
+
+func TestMemoryCaches(t *testing.T) {
+	storetest.RunCaches(t, func(t *testing.T, policy store.SessionPolicy) *store.Caches {
+		return store.NewMemoryCaches(policy)
+	})
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/dmithamo/timelineapi/pkg/dbservice"
+)
+
+// NewMySQLStores connects to MySQL, bringing its schema up to date
+func NewMySQLStores(dsn string, reset bool) (*Stores, error) {
+	db, err := dbservice.ConnectDB(&dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if reset {
+		err = dbservice.MigrateTo(db, 0)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	err = dbservice.MigrateUp(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return newSQLStores(&sqlDB{
+		DB:          db,
+		rebind:      dbservice.MySQL.Rebind,
+		checkErr:    dbservice.CheckDatabaseErr,
+		searchMatch: mysqlSearchMatch,
+		lockRows:    " FOR UPDATE",
+	}), nil
+}
+
+// mysqlSearchMatch narrows a search down to rows matching the query in natural language mode,
+// using the FULLTEXT indexes over (title, description)
+func mysqlSearchMatch(alias string, query string) (string, []interface{}) {
+	return fmt.Sprintf("MATCH(%v.title, %v.description) AGAINST (? IN NATURAL LANGUAGE MODE)", alias, alias), []interface{}{query}
+}
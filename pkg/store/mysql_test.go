@@ -0,0 +1,27 @@
+package store_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dmithamo/timelineapi/pkg/store"
+	"github.com/dmithamo/timelineapi/pkg/store/storetest"
+)
+
+// TestMySQLStores runs the suite against the MySQL db at $TIMELINEAPI_TEST_MYSQL_DSN,
+// which is wiped before every test. It is skipped if the dsn is unset
+func TestMySQLStores(t *testing.T) {
+	dsn := os.Getenv("TIMELINEAPI_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("TIMELINEAPI_TEST_MYSQL_DSN is unset")
+	}
+
+	storetest.Run(t, func(t *testing.T) *store.Stores {
+		s, err := store.NewMySQLStores(dsn, true)
+		if err != nil {
+			t.Fatalf("NewMySQLStores: %v", err)
+		}
+
+		return s
+	})
+}
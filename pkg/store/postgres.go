@@ -0,0 +1,59 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/dmithamo/timelineapi/pkg/dbservice"
+)
+
+// NewPostgresStores connects to Postgres, bringing its schema up to date
+func NewPostgresStores(dsn string, reset bool) (*Stores, error) {
+	db, err := dbservice.ConnectDB(&dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if reset {
+		err = dbservice.MigrateTo(db, 0)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	err = dbservice.MigrateUp(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return newSQLStores(&sqlDB{
+		DB:          db,
+		rebind:      dbservice.Postgres.Rebind,
+		checkErr:    dbservice.CheckDatabaseErr,
+		searchMatch: postgresSearchMatch,
+		lockRows:    " FOR UPDATE",
+	}), nil
+}
+
+// postgresSearchMatch narrows a search down to rows with a word starting with any of the query's terms,
+// using the GIN indexes over to_tsvector('simple', title || ' ' || description)
+func postgresSearchMatch(alias string, query string) (string, []interface{}) {
+	// split terms into plain words, so that no tsquery syntax gets through
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return "FALSE", nil
+	}
+
+	prefixes := []string{}
+	for _, word := range words {
+		prefixes = append(prefixes, fmt.Sprintf("%v:*", word))
+	}
+
+	match := fmt.Sprintf("to_tsvector('simple', %v.title || ' ' || %v.description) @@ to_tsquery('simple', ?)", alias, alias)
+	return match, []interface{}{strings.Join(prefixes, " | ")}
+}
@@ -0,0 +1,27 @@
+package store_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dmithamo/timelineapi/pkg/store"
+	"github.com/dmithamo/timelineapi/pkg/store/storetest"
+)
+
+// TestPostgresStores runs the suite against the Postgres db at $TIMELINEAPI_TEST_POSTGRES_DSN,
+// which is wiped before every test. It is skipped if the dsn is unset
+func TestPostgresStores(t *testing.T) {
+	dsn := os.Getenv("TIMELINEAPI_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TIMELINEAPI_TEST_POSTGRES_DSN is unset")
+	}
+
+	storetest.Run(t, func(t *testing.T) *store.Stores {
+		s, err := store.NewPostgresStores(dsn, true)
+		if err != nil {
+			t.Fatalf("NewPostgresStores: %v", err)
+		}
+
+		return s
+	})
+}
@@ -0,0 +1,478 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/dbservice"
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/security"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+// redisKeyPrefix namespaces every key the app writes to redis
+const redisKeyPrefix = "timelineapi:"
+
+// touchSessionScript extends a session, unless it has been revoked or outlived its lifetime in the meantime
+// It returns the session's hash, or nil if it is gone
+// ttls are formatted with %d, as some lua runtimes print large numbers in exponent notation
+var touchSessionScript = redis.NewScript(2, `
+	if redis.call('EXISTS', KEYS[1]) == 0 then
+		return false
+	end
+	local ttl = math.min(tonumber(ARGV[2]), tonumber(redis.call('HGET', KEYS[1], 'endsAt')) - tonumber(ARGV[1]))
+	if ttl <= 0 then
+		redis.call('DEL', KEYS[1], KEYS[2])
+		return false
+	end
+	redis.call('HSET', KEYS[1], 'lastSeenAt', ARGV[1])
+	redis.call('PEXPIRE', KEYS[1], string.format('%d', ttl))
+	redis.call('PEXPIRE', KEYS[2], string.format('%d', ttl))
+	return redis.call('HGETALL', KEYS[1])
+`)
+
+// rotateSessionScript swaps a session's refresh token for a new one, as touchSessionScript does,
+// deleting the session instead if the token presented was spent already
+var rotateSessionScript = redis.NewScript(2, `
+	local current = redis.call('HGET', KEYS[1], 'refreshHash')
+	if not current then
+		return false
+	end
+	if current ~= ARGV[1] then
+		if redis.call('SISMEMBER', KEYS[2], ARGV[1]) == 1 then
+			redis.call('DEL', KEYS[1], KEYS[2])
+			return redis.error_reply('reused')
+		end
+		return false
+	end
+	local ttl = math.min(tonumber(ARGV[4]), tonumber(redis.call('HGET', KEYS[1], 'endsAt')) - tonumber(ARGV[3]))
+	if ttl <= 0 then
+		redis.call('DEL', KEYS[1], KEYS[2])
+		return false
+	end
+	redis.call('SADD', KEYS[2], ARGV[1])
+	redis.call('HMSET', KEYS[1], 'refreshHash', ARGV[2], 'lastSeenAt', ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], string.format('%d', ttl))
+	redis.call('PEXPIRE', KEYS[2], string.format('%d', ttl))
+	return redis.call('HGETALL', KEYS[1])
+`)
+
+// revokeSessionsScript deletes every session of a user at once, returning how many there were
+var revokeSessionsScript = redis.NewScript(1, `
+	local sessionIDs = redis.call('ZRANGE', KEYS[1], 0, -1)
+	for _, sessionID in ipairs(sessionIDs) do
+		redis.call('DEL', ARGV[1] .. sessionID, ARGV[1] .. sessionID .. ':spent')
+	end
+	redis.call('DEL', KEYS[1])
+	return #sessionIDs
+`)
+
+// releaseLoginAttemptScript uncounts a login from a hash of failed logins, deleting the hash once none are left
+// Hashes forgotten in the meantime are left alone, rather than counted below zero
+// Once the count is back to ARGV[1], what it was before the login, the failure time before it, ARGV[2], is put back,
+// and the hash expires its window after it
+var releaseLoginAttemptScript = redis.NewScript(1, `
+	if redis.call('EXISTS', KEYS[1]) == 0 then
+		return 0
+	end
+	local failures = redis.call('HINCRBY', KEYS[1], 'failures', -1)
+	if failures <= 0 then
+		redis.call('DEL', KEYS[1])
+	elseif failures == tonumber(ARGV[1]) then
+		redis.call('HSET', KEYS[1], 'lastFailedAt', ARGV[2])
+		local window = redis.call('HGET', KEYS[1], 'window')
+		if window then
+			redis.call('PEXPIREAT', KEYS[1], string.format('%d', tonumber(ARGV[2]) + tonumber(window)))
+		end
+	end
+	return failures
+`)
+
+// NewRedisCaches connects to redis at cdsn
+func NewRedisCaches(cdsn string, policy SessionPolicy) (*Caches, error) {
+	pool, err := dbservice.ConnectCache(&cdsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Caches{
+		Sessions:      &redisSessionStore{pool, policy},
+		LoginAttempts: &redisLoginAttemptStore{pool},
+		close:         pool.Close,
+	}, nil
+}
+
+// redisSession is a session, as kept in a redis hash
+// Times are in unix millis, so that lua scripts can do sums with them
+type redisSession struct {
+	UserID      string `redis:"userID"`
+	UserAgent   string `redis:"userAgent"`
+	IP          string `redis:"ip"`
+	CreatedAt   int64  `redis:"createdAt"`
+	LastSeenAt  int64  `redis:"lastSeenAt"`
+	EndsAt      int64  `redis:"endsAt"`
+	RefreshHash string `redis:"refreshHash"`
+}
+
+// redisSessionStore is a SessionStore keeping each session in a hash that expires when idle,
+// the hashes of its spent refresh tokens in a set alongside it,
+// and a sorted set of every user's session ids
+type redisSessionStore struct {
+	pool   *redis.Pool
+	policy SessionPolicy
+}
+
+// sessionKey names the hash holding a session
+func (s *redisSessionStore) sessionKey(sessionID string) string {
+	return fmt.Sprintf("%vsession:%v", redisKeyPrefix, sessionID)
+}
+
+// spentKey names the set holding the hashes of a session's spent refresh tokens
+func (s *redisSessionStore) spentKey(sessionID string) string {
+	return fmt.Sprintf("%v:spent", s.sessionKey(sessionID))
+}
+
+// userSessionsKey names the sorted set of a user's session ids, scored by creation time
+func (s *redisSessionStore) userSessionsKey(userID string) string {
+	return fmt.Sprintf("%vuser_sessions:%v", redisKeyPrefix, userID)
+}
+
+// toMillis and fromMillis convert times to and from the unix millis kept in redis
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(millis int64) time.Time {
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC()
+}
+
+// toSession reads a session hash into a Session
+func (s *redisSessionStore) toSession(sessionID string, values []interface{}) (*models.Session, error) {
+	var rs redisSession
+	err := redis.ScanStruct(values, &rs)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.sessionOf(sessionID, &rs), nil
+}
+
+// sessionOf converts a redisSession into a Session
+func (s *redisSessionStore) sessionOf(sessionID string, rs *redisSession) *models.Session {
+	createdAt, lastSeenAt := fromMillis(rs.CreatedAt), fromMillis(rs.LastSeenAt)
+	return &models.Session{
+		SessionID:  sessionID,
+		UserID:     rs.UserID,
+		UserAgent:  rs.UserAgent,
+		IP:         rs.IP,
+		CreatedAt:  createdAt,
+		LastSeenAt: lastSeenAt,
+		ExpiresAt:  s.policy.expiresAt(createdAt, lastSeenAt),
+	}
+}
+
+func (s *redisSessionStore) CreateSession(userID string, userAgent string, ip string) (*models.Session, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	sessionID := newSessionID()
+	refreshToken := newRefreshToken(sessionID)
+	createdAt := fromMillis(toMillis(now()))
+	rs := &redisSession{
+		UserID:      userID,
+		UserAgent:   userAgent,
+		IP:          ip,
+		CreatedAt:   toMillis(createdAt),
+		LastSeenAt:  toMillis(createdAt),
+		EndsAt:      toMillis(s.policy.endsAt(createdAt)),
+		RefreshHash: hashRefreshToken(refreshToken),
+	}
+
+	key, userKey := s.sessionKey(sessionID), s.userSessionsKey(userID)
+	ttl := s.policy.expiresAt(createdAt, createdAt).Sub(createdAt).Milliseconds()
+
+	conn.Send("MULTI")
+	conn.Send("HMSET", redis.Args{}.Add(key).AddFlat(rs)...)
+	conn.Send("PEXPIRE", key, ttl)
+	conn.Send("ZADD", userKey, rs.CreatedAt, sessionID)
+	conn.Send("PEXPIRE", userKey, s.policy.Lifetime.Milliseconds())
+	_, err := conn.Do("EXEC")
+	if err != nil {
+		return nil, err
+	}
+
+	session := s.sessionOf(sessionID, rs)
+	session.RefreshToken = refreshToken
+	return session, nil
+}
+
+func (s *redisSessionStore) RotateSession(refreshToken string) (*models.Session, error) {
+	sessionID, ok := sessionOfRefreshToken(refreshToken)
+	if !ok {
+		return nil, utils.ErrSessionNotFound
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	rotated := newRefreshToken(sessionID)
+	values, err := redis.Values(rotateSessionScript.Do(conn, s.sessionKey(sessionID), s.spentKey(sessionID),
+		hashRefreshToken(refreshToken), hashRefreshToken(rotated), toMillis(now()), s.policy.IdleTimeout.Milliseconds()))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, utils.ErrSessionNotFound
+		}
+
+		if redisErr, ok := err.(redis.Error); ok && redisErr.Error() == "reused" {
+			return nil, utils.ErrRefreshTokenReused
+		}
+
+		return nil, err
+	}
+
+	session, err := s.toSession(sessionID, values)
+	if err != nil {
+		return nil, err
+	}
+
+	session.RefreshToken = rotated
+	return session, nil
+}
+
+func (s *redisSessionStore) TouchSession(sessionID string) (*models.Session, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.Values(touchSessionScript.Do(conn, s.sessionKey(sessionID), s.spentKey(sessionID),
+		toMillis(now()), s.policy.IdleTimeout.Milliseconds()))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, utils.ErrSessionNotFound
+		}
+
+		return nil, err
+	}
+
+	return s.toSession(sessionID, values)
+}
+
+func (s *redisSessionStore) GetSessions(userID string) ([]models.Session, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	userKey := s.userSessionsKey(userID)
+	sessionIDs, err := redis.Strings(conn.Do("ZRANGE", userKey, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sessionID := range sessionIDs {
+		conn.Send("HGETALL", s.sessionKey(sessionID))
+	}
+	conn.Flush()
+
+	sessions := []models.Session{}
+	expired := redis.Args{}.Add(userKey)
+	for _, sessionID := range sessionIDs {
+		values, err := redis.Values(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+
+		// the hashes of idle sessions expire on their own, leaving their ids behind
+		if len(values) == 0 {
+			expired = expired.Add(sessionID)
+			continue
+		}
+
+		session, err := s.toSession(sessionID, values)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, *session)
+	}
+
+	if len(expired) > 1 {
+		_, err = conn.Do("ZREM", expired...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+func (s *redisSessionStore) RevokeSession(sessionID string, userID string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	key := s.sessionKey(sessionID)
+	owner, err := redis.String(conn.Do("HGET", key, "userID"))
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+
+	// other users' sessions are as good as missing
+	if owner != userID {
+		return utils.ErrSessionNotFound
+	}
+
+	conn.Send("MULTI")
+	conn.Send("DEL", key, s.spentKey(sessionID))
+	conn.Send("ZREM", s.userSessionsKey(userID), sessionID)
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (s *redisSessionStore) RevokeSessions(userID string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := revokeSessionsScript.Do(conn, s.userSessionsKey(userID), s.sessionKey(""))
+	return err
+}
+
+// redisLoginAttempts is a count of failed logins, as kept in a redis hash
+type redisLoginAttempts struct {
+	Failures     int   `redis:"failures"`
+	LastFailedAt int64 `redis:"lastFailedAt"`
+}
+
+// redisLoginAttemptStore is a LoginAttemptStore keeping each key's count in a hash that expires with its window,
+// and the username of each unlock token in a string that expires with the token
+type redisLoginAttemptStore struct {
+	pool *redis.Pool
+}
+
+// attemptsKey names the hash counting the failed logins under key
+func (s *redisLoginAttemptStore) attemptsKey(key string) string {
+	return fmt.Sprintf("%vlogin_attempts:%v", redisKeyPrefix, key)
+}
+
+// unlockKey names the string holding the username an unlock token unlocks, by the token's hash
+func (s *redisLoginAttemptStore) unlockKey(tokenHash string) string {
+	return fmt.Sprintf("%vunlock:%v", redisKeyPrefix, tokenHash)
+}
+
+// toLoginAttempts reads a hash of failed logins, which is empty once forgotten, into LoginAttempts
+func (s *redisLoginAttemptStore) toLoginAttempts(values []interface{}) (*models.LoginAttempts, error) {
+	attempts := &models.LoginAttempts{}
+	if len(values) == 0 {
+		return attempts, nil
+	}
+
+	var rla redisLoginAttempts
+	err := redis.ScanStruct(values, &rla)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts.Failures = rla.Failures
+	attempts.LastFailedAt = fromMillis(rla.LastFailedAt)
+	return attempts, nil
+}
+
+func (s *redisLoginAttemptStore) GetLoginAttempts(key string) (*models.LoginAttempts, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.Values(conn.Do("HGETALL", s.attemptsKey(key)))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toLoginAttempts(values)
+}
+
+func (s *redisLoginAttemptStore) ReserveLoginAttempt(key string, window time.Duration) (*models.LoginAttempts, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	attemptsKey := s.attemptsKey(key)
+
+	// the count is read and incremented in one transaction, so that racing logins each read a different one
+	conn.Send("MULTI")
+	conn.Send("HGETALL", attemptsKey)
+	conn.Send("HINCRBY", attemptsKey, "failures", 1)
+	conn.Send("HMSET", attemptsKey, "lastFailedAt", toMillis(now()), "window", window.Milliseconds())
+	conn.Send("PEXPIRE", attemptsKey, window.Milliseconds())
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := redis.Values(replies[0], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toLoginAttempts(values)
+}
+
+func (s *redisLoginAttemptStore) ReleaseLoginAttempt(key string, reserved *models.LoginAttempts) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := releaseLoginAttemptScript.Do(conn, s.attemptsKey(key), reserved.Failures, toMillis(reserved.LastFailedAt))
+	return err
+}
+
+func (s *redisLoginAttemptStore) ClearLoginAttempts(key string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", s.attemptsKey(key))
+	return err
+}
+
+func (s *redisLoginAttemptStore) CreateUnlockToken(username string, ttl time.Duration) (string, error) {
+	token, err := security.GenerateOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("SET", s.unlockKey(security.HashOneTimeToken(token)), username, "PX", ttl.Milliseconds())
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (s *redisLoginAttemptStore) RedeemUnlockToken(token string) (string, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	// the token is spent by whoever deletes it, so that racing requests cannot both use it
+	unlockKey := s.unlockKey(security.HashOneTimeToken(token))
+
+	conn.Send("MULTI")
+	conn.Send("GET", unlockKey)
+	conn.Send("DEL", unlockKey)
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return "", err
+	}
+
+	username, err := redis.String(replies[0], nil)
+	if err != nil {
+		if err == redis.ErrNil {
+			return "", utils.ErrUnlockTokenInvalid
+		}
+
+		return "", err
+	}
+
+	_, err = conn.Do("DEL", s.attemptsKey(models.AccountAttemptsKey(username)))
+	if err != nil {
+		return "", err
+	}
+
+	return username, nil
+}
@@ -0,0 +1,41 @@
+package store_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dmithamo/timelineapi/pkg/dbservice"
+	"github.com/dmithamo/timelineapi/pkg/store"
+	"github.com/dmithamo/timelineapi/pkg/store/storetest"
+)
+
+// TestRedisCaches runs the cache half of the suite against the redis at $TIMELINEAPI_TEST_REDIS_DSN,
+// whose db is flushed before every test. It is skipped if the dsn is unset
+func TestRedisCaches(t *testing.T) {
+	cdsn := os.Getenv("TIMELINEAPI_TEST_REDIS_DSN")
+	if cdsn == "" {
+		t.Skip("TIMELINEAPI_TEST_REDIS_DSN is unset")
+	}
+
+	storetest.RunCaches(t, func(t *testing.T, policy store.SessionPolicy) *store.Caches {
+		pool, err := dbservice.ConnectCache(&cdsn)
+		if err != nil {
+			t.Fatalf("ConnectCache: %v", err)
+		}
+
+		conn := pool.Get()
+		_, err = conn.Do("FLUSHDB")
+		conn.Close()
+		pool.Close()
+		if err != nil {
+			t.Fatalf("FLUSHDB: %v", err)
+		}
+
+		c, err := store.NewRedisCaches(cdsn, policy)
+		if err != nil {
+			t.Fatalf("NewRedisCaches: %v", err)
+		}
+
+		return c
+	})
+}
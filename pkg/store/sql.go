@@ -0,0 +1,2227 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/dbservice"
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/security"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+)
+
+// sqlDB is a database/sql db, along with what the sql stores need to know of its dialect
+// The stores write plain `?` placeholders and text uuids, and set every timestamp from Go, in UTC
+type sqlDB struct {
+	*sql.DB
+
+	// rebind rewrites `?` placeholders into the ones the driver expects
+	rebind func(query string) string
+
+	// checkErr turns driver errs into the friendly ones dbservice.CheckDatabaseErr returns
+	checkErr func(err error, uniqueColumn ...string) error
+
+	// searchMatch builds a where clause narrowing the rows of a table aliased as alias
+	// down to those that may match a search query, before they are ranked in Go
+	searchMatch func(alias string, query string) (string, []interface{})
+
+	// lockRows ends selects whose rows stay locked until their tx ends: FOR UPDATE,
+	// or nothing for SQLite, whose txs already run one at a time
+	lockRows string
+}
+
+func (db *sqlDB) exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.Exec(db.rebind(query), args...)
+}
+
+func (db *sqlDB) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.Query(db.rebind(query), args...)
+}
+
+func (db *sqlDB) queryRow(query string, args ...interface{}) *sql.Row {
+	return db.QueryRow(db.rebind(query), args...)
+}
+
+// sqlStatement is a query, along with its args
+type sqlStatement struct {
+	query string
+	args  []interface{}
+}
+
+// execTx runs statements in a single transaction, so that they all take effect or none does
+func (db *sqlDB) execTx(statements ...sqlStatement) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range statements {
+		_, err = tx.Exec(db.rebind(statement.query), statement.args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// resolveAccess finds what an actor may do, failing if they act in a workspace they are not a member of
+func (db *sqlDB) resolveAccess(actor models.Actor) (models.Access, error) {
+	access := models.Access{Actor: actor}
+	if actor.WorkspaceID == "" {
+		return access, nil
+	}
+
+	err := db.queryRow("SELECT role FROM workspace_members WHERE workspaceID = ? AND userID = ?", actor.WorkspaceID, actor.UserID).Scan(&access.Role)
+	if err == sql.ErrNoRows {
+		return access, utils.ErrWorkspaceNotFound
+	}
+
+	return access, err
+}
+
+// resolveOwner finds what an actor may do, failing unless they own the workspace they act in
+func (db *sqlDB) resolveOwner(actor models.Actor) (models.Access, error) {
+	access, err := db.resolveAccess(actor)
+	if err != nil {
+		return access, err
+	}
+
+	if access.Role != models.WorkspaceOwner {
+		return access, utils.ErrForbidden
+	}
+
+	return access, nil
+}
+
+// newSQLStores bundles the sql stores of a single db
+func newSQLStores(db *sqlDB) *Stores {
+	return &Stores{
+		Users:      &sqlUserStore{db},
+		Actions:    &sqlActionStore{db},
+		Outputs:    &sqlOutputStore{db},
+		Search:     &sqlSearchStore{db},
+		APIKeys:    &sqlAPIKeyStore{db},
+		Workspaces: &sqlWorkspaceStore{db},
+
+		PasswordResets:     &sqlPasswordResetStore{db},
+		EmailVerifications: &sqlEmailVerificationStore{db},
+		MFA:                &sqlMFAStore{db},
+		Identities:         &sqlIdentityStore{db},
+		close:              db.Close,
+	}
+}
+
+// sqlActionColumns lists the columns read into an Action, in scan order
+const sqlActionColumns = "a.actionID,a.title,a.description,a.isArchived,a.archivedAt,a.createdAt,a.updatedAt,a.userID,a.workspaceID"
+
+// sqlOutputColumns lists the columns read into an Output, in scan order
+const sqlOutputColumns = "o.outputID,o.title,o.description,o.actionID,o.isArchived,o.archivedAt,o.createdAt,o.updatedAt,a.userID,a.workspaceID"
+
+// sqlOutputsJoin joins outputs to their parent actions, which hold the owner and workspace
+const sqlOutputsJoin = "outputs o JOIN actions a ON a.actionID = o.actionID"
+
+// sqlUserColumns lists the columns read into a User, in scan order
+const sqlUserColumns = "userID,username,role,disabledAt,emailVerifiedAt,mfaEnabledAt,deleteAfter,displayName,timezone,avatarURL,createdAt,updatedAt"
+
+// sqlWorkspaceColumns lists the columns read into a Workspace, in scan order, from workspaces w joined to workspace_members m
+const sqlWorkspaceColumns = "w.workspaceID,w.name,m.role,w.createdAt,w.updatedAt"
+
+// sqlMemberColumns lists the columns read into a WorkspaceMember, in scan order, from workspace_members m joined to users u
+const sqlMemberColumns = "m.workspaceID,m.userID,u.username,m.role,m.createdAt"
+
+// sqlInvitationColumns lists the columns read into an Invitation, in scan order, from sqlInvitationsJoin
+const sqlInvitationColumns = "i.invitationID,i.workspaceID,w.name,i.username,i.role,i.invitedBy,i.createdAt"
+
+// sqlInvitationsJoin joins invitations to the workspaces they are to
+const sqlInvitationsJoin = "workspace_invitations i JOIN workspaces w ON w.workspaceID = i.workspaceID"
+
+// sqlInviteeUsername is the username of the user with the id given, which their invitations are addressed to
+const sqlInviteeUsername = "(SELECT username FROM users WHERE userID = ?)"
+
+// sqlAPIKeyColumns lists the columns read into an APIKey, in scan order
+const sqlAPIKeyColumns = "keyID,userID,name,prefix,scopes,createdAt,lastUsedAt,expiresAt"
+
+// sqlAPIKeyUserEnabled restricts an api_keys query to the keys of users who are neither disabled nor being deleted
+const sqlAPIKeyUserEnabled = "NOT EXISTS (SELECT 1 FROM users u WHERE u.userID = api_keys.userID AND (u.disabledAt IS NOT NULL OR u.deleteAfter IS NOT NULL))"
+
+// scanSQLUser reads a single row into a User
+func scanSQLUser(row interface{ Scan(...interface{}) error }) (*models.User, error) {
+	var user models.User
+	var disabledAt, emailVerifiedAt, mfaEnabledAt, deleteAfter sql.NullTime
+
+	err := row.Scan(
+		&user.UserID,
+		&user.Username,
+		&user.Role,
+		&disabledAt,
+		&emailVerifiedAt,
+		&mfaEnabledAt,
+		&deleteAfter,
+		&user.DisplayName,
+		&user.Timezone,
+		&user.AvatarURL,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
+
+	if emailVerifiedAt.Valid {
+		user.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+
+	if mfaEnabledAt.Valid {
+		user.MFAEnabledAt = &mfaEnabledAt.Time
+	}
+
+	if deleteAfter.Valid {
+		user.DeleteAfter = &deleteAfter.Time
+	}
+
+	return &user, nil
+}
+
+// scanSQLAction reads a single row into an Action
+func scanSQLAction(row interface{ Scan(...interface{}) error }) (*models.Action, error) {
+	var action models.Action
+	var archivedAt sql.NullTime
+	var workspaceID sql.NullString
+
+	err := row.Scan(
+		&action.ActionID,
+		&action.Title,
+		&action.Description,
+		&action.IsArchived,
+		&archivedAt,
+		&action.CreatedAt,
+		&action.UpdatedAt,
+		&action.UserID,
+		&workspaceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if archivedAt.Valid {
+		action.ArchivedAt = &archivedAt.Time
+	}
+	action.WorkspaceID = workspaceID.String
+
+	return &action, nil
+}
+
+// scanSQLOutput reads a single row into an Output
+func scanSQLOutput(row interface{ Scan(...interface{}) error }) (*models.Output, error) {
+	var output models.Output
+	var archivedAt sql.NullTime
+	var workspaceID sql.NullString
+
+	err := row.Scan(
+		&output.OutputID,
+		&output.Title,
+		&output.Description,
+		&output.ActionID,
+		&output.IsArchived,
+		&archivedAt,
+		&output.CreatedAt,
+		&output.UpdatedAt,
+		&output.UserID,
+		&workspaceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if archivedAt.Valid {
+		output.ArchivedAt = &archivedAt.Time
+	}
+	output.WorkspaceID = workspaceID.String
+
+	return &output, nil
+}
+
+// scanSQLAPIKey reads a single row into an APIKey
+func scanSQLAPIKey(row interface{ Scan(...interface{}) error }) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var lastUsedAt, expiresAt sql.NullTime
+
+	err := row.Scan(
+		&key.KeyID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&scopes,
+		&key.CreatedAt,
+		&lastUsedAt,
+		&expiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = strings.Fields(scopes)
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+
+	return &key, nil
+}
+
+// sqlUserStore is a UserStore for SQLite, MySQL and Postgres
+type sqlUserStore struct {
+	db *sqlDB
+}
+
+func (s *sqlUserStore) CreateUser(credentials *models.UserCredentials) error {
+	pwdHash, err := security.GeneratePasswordHash(&credentials.Password)
+	if err != nil {
+		return fmt.Errorf("error hashing password: %v", err.Error())
+	}
+
+	createdAt := now()
+	_, err = s.db.exec("INSERT INTO users (userID, username, password, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?)",
+		newUUID(), credentials.Username, pwdHash, createdAt, createdAt)
+
+	return s.db.checkErr(err, "username")
+}
+
+func (s *sqlUserStore) GetByCredentials(credentials *models.UserCredentials) (*models.User, error) {
+	var userID, pwdHash string
+
+	err := s.db.queryRow("SELECT userID, password FROM users WHERE username = ?", credentials.Username).Scan(&userID, &pwdHash)
+	if err != nil {
+		// unknown users take as long as wrong pwds
+		if err == sql.ErrNoRows {
+			security.SimulateVerifyPassword(&credentials.Password)
+		}
+
+		return nil, err
+	}
+
+	// users who log in with an identity provider may have no pwd, which no pwd is right for, but takes as long to check
+	if pwdHash == "" {
+		security.SimulateVerifyPassword(&credentials.Password)
+		return nil, utils.ErrWrongPassword
+	}
+
+	if !security.VerifyPassword(&pwdHash, &credentials.Password) {
+		return nil, utils.ErrWrongPassword
+	}
+
+	// hashes made with outdated parameters are replaced, while the pwd is at hand
+	// unless the pwd changed in the meantime
+	if security.PasswordNeedsRehash(pwdHash) {
+		newHash, err := security.GeneratePasswordHash(&credentials.Password)
+		if err != nil {
+			return nil, fmt.Errorf("error hashing password: %v", err.Error())
+		}
+
+		_, err = s.db.exec("UPDATE users SET password = ? WHERE userID = ? AND password = ?", newHash, userID, pwdHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.GetByUUID(userID)
+}
+
+func (s *sqlUserStore) GetByUUID(userID string) (*models.User, error) {
+	row := s.db.queryRow(fmt.Sprintf("SELECT %v FROM users WHERE userID = ?", sqlUserColumns), userID)
+	return scanSQLUser(row)
+}
+
+func (s *sqlUserStore) GetUsers() ([]models.User, error) {
+	rows, err := s.db.query(fmt.Sprintf("SELECT %v FROM users ORDER BY createdAt, username", sqlUserColumns))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanSQLUser(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, *user)
+	}
+
+	return users, rows.Err()
+}
+
+func (s *sqlUserStore) SetRole(userID string, role string) (*models.User, error) {
+	_, err := s.GetByUUID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.exec("UPDATE users SET role = ?, updatedAt = ? WHERE userID = ?", role, now(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetByUUID(userID)
+}
+
+func (s *sqlUserStore) BootstrapAdmin(userID string) (*models.User, error) {
+	_, err := s.GetByUUID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the admins are read through a derived table, as MySQL cannot read the table it updates in a subquery
+	_, err = s.db.exec("UPDATE users SET role = ?, updatedAt = ? WHERE userID = ? AND NOT EXISTS (SELECT 1 FROM (SELECT userID FROM users WHERE role = ? LIMIT 1) admins)", models.RoleAdmin, now(), userID, models.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetByUUID(userID)
+}
+
+func (s *sqlUserStore) SetDisabled(userID string, disabled bool) (*models.User, error) {
+	user, err := s.GetByUUID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// disabling a disabled user keeps the time they were first disabled
+	updatedAt := now()
+	disabledAt := user.DisabledAt
+	if !disabled {
+		disabledAt = nil
+	} else if disabledAt == nil {
+		disabledAt = &updatedAt
+	}
+
+	_, err = s.db.exec("UPDATE users SET disabledAt = ?, updatedAt = ? WHERE userID = ?", disabledAt, updatedAt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetByUUID(userID)
+}
+
+func (s *sqlUserStore) UpdateProfile(userID string, params models.ProfileParams) (*models.User, error) {
+	if params == (models.ProfileParams{}) {
+		return nil, utils.ErrNoUpdateParams
+	}
+
+	_, err := s.GetByUUID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.exec(`UPDATE users SET
+		displayName = COALESCE(NULLIF(?, ''), displayName),
+		timezone = COALESCE(NULLIF(?, ''), timezone),
+		avatarURL = COALESCE(NULLIF(?, ''), avatarURL),
+		updatedAt = ?
+		WHERE userID = ?`, params.DisplayName, params.Timezone, params.AvatarURL, now(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetByUUID(userID)
+}
+
+func (s *sqlUserStore) ChangePassword(userID string, params models.PasswordParams) error {
+	var username, pwdHash string
+
+	err := s.db.queryRow("SELECT username, password FROM users WHERE userID = ?", userID).Scan(&username, &pwdHash)
+	if err != nil {
+		return err
+	}
+
+	if !security.VerifyPassword(&pwdHash, &params.CurrentPassword) {
+		return utils.ErrWrongPassword
+	}
+
+	err = models.CheckNewPassword(username, params.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	newHash, err := security.GeneratePasswordHash(&params.NewPassword)
+	if err != nil {
+		return fmt.Errorf("error hashing password: %v", err.Error())
+	}
+
+	_, err = s.db.exec("UPDATE users SET password = ?, updatedAt = ? WHERE userID = ?", newHash, now(), userID)
+	return err
+}
+
+func (s *sqlUserStore) ScheduleDeletion(userID string, grace time.Duration) (*models.User, error) {
+	_, err := s.GetByUUID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// scheduling a deletion twice keeps the date it was first scheduled for
+	updatedAt := now()
+	_, err = s.db.exec("UPDATE users SET deleteAfter = COALESCE(deleteAfter, ?), updatedAt = ? WHERE userID = ?", updatedAt.Add(grace), updatedAt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetByUUID(userID)
+}
+
+func (s *sqlUserStore) CancelDeletion(userID string) (*models.User, error) {
+	_, err := s.GetByUUID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.exec("UPDATE users SET deleteAfter = NULL, updatedAt = ? WHERE userID = ? AND deleteAfter IS NOT NULL", now(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetByUUID(userID)
+}
+
+func (s *sqlUserStore) PurgeDeletedUsers() (int64, error) {
+	purgedAt := now()
+	rows, err := s.db.query("SELECT userID FROM users WHERE deleteAfter <= ?", purgedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	userIDs := []string{}
+	for rows.Next() {
+		var userID string
+		err := rows.Scan(&userID)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// users are deleted one at a time, so that one failing leaves the others deleted
+	var purged int64
+	for _, userID := range userIDs {
+		deleted, err := s.purgeUser(userID, purgedAt)
+		if err != nil {
+			return purged, err
+		}
+
+		if deleted {
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// purgeUser deletes a user whose deletion was due at purgedAt, unless it was called off in the meantime
+// Every workspace they are the last owner of is handed to its longest-standing member, or deleted if they were its only one
+// Their actions in workspaces go to the longest-standing owner left, so the workspace keeps them
+func (s *sqlUserStore) purgeUser(userID string, purgedAt time.Time) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(s.db.rebind(`SELECT m.workspaceID FROM workspace_members m
+		WHERE m.userID = ? AND m.role = ?
+		AND NOT EXISTS (SELECT 1 FROM workspace_members o WHERE o.workspaceID = m.workspaceID AND o.role = ? AND o.userID <> m.userID)`),
+		userID, models.WorkspaceOwner, models.WorkspaceOwner)
+	if err != nil {
+		return false, err
+	}
+
+	workspaceIDs := []string{}
+	for rows.Next() {
+		var workspaceID string
+		err := rows.Scan(&workspaceID)
+		if err != nil {
+			rows.Close()
+			return false, err
+		}
+
+		workspaceIDs = append(workspaceIDs, workspaceID)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	for _, workspaceID := range workspaceIDs {
+		var heirID string
+		err := tx.QueryRow(s.db.rebind("SELECT userID FROM workspace_members WHERE workspaceID = ? AND userID <> ? ORDER BY createdAt, userID LIMIT 1"), workspaceID, userID).Scan(&heirID)
+		switch {
+		case err == sql.ErrNoRows:
+			_, err = tx.Exec(s.db.rebind("DELETE FROM workspaces WHERE workspaceID = ?"), workspaceID)
+
+		case err == nil:
+			_, err = tx.Exec(s.db.rebind("UPDATE workspace_members SET role = ? WHERE workspaceID = ? AND userID = ?"), models.WorkspaceOwner, workspaceID, heirID)
+		}
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// the user's actions in workspaces belong to the workspace, so they go to its longest-standing owner rather than with the user
+	// every workspace left has an owner other than the user by now
+	_, err = tx.Exec(s.db.rebind(`UPDATE actions SET userID = (SELECT m.userID FROM workspace_members m
+		WHERE m.workspaceID = actions.workspaceID AND m.role = ? AND m.userID <> ? ORDER BY m.createdAt, m.userID LIMIT 1)
+		WHERE userID = ? AND workspaceID IS NOT NULL`), models.WorkspaceOwner, userID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	// invitations are addressed to usernames rather than users, so the foreign keys leave the user's behind
+	_, err = tx.Exec(s.db.rebind("DELETE FROM workspace_invitations WHERE username = "+sqlInviteeUsername), userID)
+	if err != nil {
+		return false, err
+	}
+
+	// everything else the user owns goes with them, as the foreign keys cascade
+	// a deletion called off since it was found due leaves the user, and their workspaces, as they were
+	res, err := tx.Exec(s.db.rebind("DELETE FROM users WHERE userID = ? AND deleteAfter <= ?"), userID, purgedAt)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil || deleted == 0 {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// sqlActionStore is an ActionStore for SQLite, MySQL and Postgres
+type sqlActionStore struct {
+	db *sqlDB
+}
+
+func (s *sqlActionStore) CreateAction(params models.ActionParams, actor models.Actor) error {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return err
+	}
+
+	if !access.CanWrite() {
+		return utils.ErrForbidden
+	}
+
+	var workspaceID interface{}
+	if actor.WorkspaceID != "" {
+		workspaceID = actor.WorkspaceID
+	}
+
+	createdAt := now()
+	_, err = s.db.exec("INSERT INTO actions (actionID, title, description, createdAt, updatedAt, userID, workspaceID, scopeID) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		newUUID(), params.Title, params.Description, createdAt, createdAt, actor.UserID, workspaceID, actor.ScopeID())
+
+	return s.db.checkErr(err, "title")
+}
+
+func (s *sqlActionStore) GetActions(actor models.Actor, query *models.ActionQuery) (*models.ActionPage, error) {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := query.Where()
+	visibility, visibilityArgs := access.Visibility()
+	if query.Archived {
+		visibility, visibilityArgs = access.ArchiveVisibility()
+	}
+
+	where = fmt.Sprintf("%v AND %v", where, visibility)
+	args = append(args, visibilityArgs...)
+
+	// fetch one extra row to find out whether there is a further page
+	args = append(args, query.Limit+1)
+	rows, err := s.db.query(fmt.Sprintf("SELECT %v FROM actions a WHERE %v ORDER BY %v LIMIT ?", sqlActionColumns, where, query.OrderBy()), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []models.Action
+	for rows.Next() {
+		action, err := scanSQLAction(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		actions = append(actions, *action)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return query.Page(actions), nil
+}
+
+func (s *sqlActionStore) GetActionByID(actionID string, actor models.Actor) (*models.Action, error) {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getAction(actionID, access)
+}
+
+// getAction retrieves a single live action, as long as it is visible to access
+func (s *sqlActionStore) getAction(actionID string, access models.Access) (*models.Action, error) {
+	visibility, args := access.Visibility()
+	row := s.db.queryRow(fmt.Sprintf("SELECT %v FROM actions a WHERE a.actionID = ? AND a.isArchived = FALSE AND %v", sqlActionColumns, visibility), append([]interface{}{actionID}, args...)...)
+	return scanSQLAction(row)
+}
+
+func (s *sqlActionStore) GetAnyAction(actionID string) (*models.Action, error) {
+	return s.getActionByID(actionID)
+}
+
+// getActionByID retrieves a single action by its actionID, archived or not, regardless of owner
+func (s *sqlActionStore) getActionByID(actionID string) (*models.Action, error) {
+	row := s.db.queryRow(fmt.Sprintf("SELECT %v FROM actions a WHERE a.actionID = ?", sqlActionColumns), actionID)
+	return scanSQLAction(row)
+}
+
+// getModifiableAction retrieves a live action visible to access, failing if access may not modify it
+func (s *sqlActionStore) getModifiableAction(actionID string, access models.Access) (*models.Action, error) {
+	action, err := s.getAction(actionID, access)
+	if err != nil {
+		return nil, err
+	}
+
+	if !access.CanModify(action.UserID) {
+		return nil, utils.ErrForbidden
+	}
+
+	return action, nil
+}
+
+// getOwnedAction retrieves a live action in actor's personal space, failing if they do not own it
+// Workspace actions are visible to every member of their workspace, so they are never shared
+func (s *sqlActionStore) getOwnedAction(actionID string, actor models.Actor) (*models.Action, error) {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	if access.WorkspaceID != "" {
+		return nil, utils.ErrShareWorkspaceAction
+	}
+
+	return s.getModifiableAction(actionID, access)
+}
+
+func (s *sqlActionStore) UpdateAction(actionID string, actor models.Actor, params models.ActionParams) (*models.Action, error) {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.getModifiableAction(actionID, access)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := []string{"updatedAt = ?"}
+	args := []interface{}{now()}
+
+	if params.Title != "" {
+		columns = append(columns, "title = ?")
+		args = append(args, params.Title)
+	}
+
+	if params.Description != "" {
+		columns = append(columns, "description = ?")
+		args = append(args, params.Description)
+	}
+
+	if len(columns) == 1 {
+		return nil, utils.ErrNoUpdateParams
+	}
+
+	args = append(args, actionID)
+	_, err = s.db.exec(fmt.Sprintf("UPDATE actions SET %v WHERE actionID = ?", strings.Join(columns, ", ")), args...)
+	if err != nil {
+		return nil, s.db.checkErr(err, "title")
+	}
+
+	return s.getAction(actionID, access)
+}
+
+func (s *sqlActionStore) ArchiveAction(actionID string, actor models.Actor) error {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.getModifiableAction(actionID, access)
+	if err != nil {
+		return err
+	}
+
+	archivedAt := now()
+	_, err = s.db.exec("UPDATE actions SET isArchived = TRUE, archivedAt = ?, updatedAt = ? WHERE actionID = ?", archivedAt, archivedAt, actionID)
+	return err
+}
+
+func (s *sqlActionStore) RestoreAction(actionID string, actor models.Actor) (*models.Action, error) {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	action, err := s.getActionByID(actionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// archived actions are invisible to everyone but those who may modify them
+	if !action.IsArchived || !access.CanSeeArchived(action) {
+		return nil, sql.ErrNoRows
+	}
+
+	_, err = s.db.exec("UPDATE actions SET isArchived = FALSE, archivedAt = NULL, updatedAt = ? WHERE actionID = ?", now(), actionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getAction(actionID, access)
+}
+
+func (s *sqlActionStore) PurgeAction(actionID string, actor models.Actor) error {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return err
+	}
+
+	action, err := s.getActionByID(actionID)
+	if err != nil {
+		return err
+	}
+
+	// only archived actions may be purged, so a purge is never a surprise
+	if !action.IsArchived || !access.CanSeeArchived(action) {
+		return sql.ErrNoRows
+	}
+
+	_, err = s.db.exec("DELETE FROM actions WHERE actionID = ?", actionID)
+	return err
+}
+
+func (s *sqlActionStore) PurgeAnyAction(actionID string) error {
+	action, err := s.getActionByID(actionID)
+	if err != nil {
+		return err
+	}
+
+	// admins, too, may only purge archived actions
+	if !action.IsArchived {
+		return sql.ErrNoRows
+	}
+
+	_, err = s.db.exec("DELETE FROM actions WHERE actionID = ?", actionID)
+	return err
+}
+
+func (s *sqlActionStore) PurgeArchivedActions(retention time.Duration) (int64, error) {
+	res, err := s.db.exec("DELETE FROM actions WHERE isArchived = TRUE AND archivedAt < ?", now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (s *sqlActionStore) ShareAction(actionID string, actor models.Actor, params models.ShareParams) error {
+	_, err := s.getOwnedAction(actionID, actor)
+	if err != nil {
+		return err
+	}
+
+	var shareeID string
+	err = s.db.queryRow("SELECT userID FROM users WHERE username = ?", params.Username).Scan(&shareeID)
+	if err != nil {
+		// sharing with a username no one has is a no-op, so that sharing tells nothing of who has an account
+		if err == sql.ErrNoRows {
+			return nil
+		}
+
+		return err
+	}
+
+	if shareeID == actor.UserID {
+		return utils.ErrShareWithOwner
+	}
+
+	// sharing an action twice with the same user is a no-op
+	_, err = s.db.exec("INSERT INTO action_shares (actionID, userID, createdAt) VALUES (?, ?, ?)", actionID, shareeID, now())
+	var duplicateErr *dbservice.DuplicateEntryErr
+	if errors.As(s.db.checkErr(err), &duplicateErr) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *sqlActionStore) UnshareAction(actionID string, actor models.Actor, shareeID string) error {
+	_, err := s.getOwnedAction(actionID, actor)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.exec("DELETE FROM action_shares WHERE actionID = ? AND userID = ?", actionID, shareeID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *sqlActionStore) GetActionShares(actionID string, actor models.Actor) ([]models.ActionShare, error) {
+	_, err := s.getOwnedAction(actionID, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.query("SELECT s.actionID, s.userID, u.username, s.createdAt FROM action_shares s JOIN users u ON u.userID = s.userID WHERE s.actionID = ? ORDER BY u.username", actionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []models.ActionShare{}
+	for rows.Next() {
+		var share models.ActionShare
+
+		err := rows.Scan(&share.ActionID, &share.UserID, &share.Username, &share.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		shares = append(shares, share)
+	}
+
+	return shares, rows.Err()
+}
+
+func (s *sqlActionStore) ExportActions(userID string) (*models.ActionsExport, error) {
+	export := &models.ActionsExport{Actions: []models.Action{}, Outputs: []models.Output{}, Shares: []models.ActionShare{}}
+
+	rows, err := s.db.query(fmt.Sprintf("SELECT %v FROM actions a WHERE a.userID = ? ORDER BY a.createdAt, a.actionID", sqlActionColumns), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		action, err := scanSQLAction(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		export.Actions = append(export.Actions, *action)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := (&sqlOutputStore{s.db}).queryOutputs("a.userID = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	export.Outputs = append(export.Outputs, outputs...)
+
+	shares, err := s.db.query(`SELECT s.actionID, s.userID, u.username, s.createdAt
+		FROM action_shares s JOIN actions a ON a.actionID = s.actionID JOIN users u ON u.userID = s.userID
+		WHERE a.userID = ? ORDER BY s.createdAt, u.username`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer shares.Close()
+
+	for shares.Next() {
+		var share models.ActionShare
+
+		err := shares.Scan(&share.ActionID, &share.UserID, &share.Username, &share.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		export.Shares = append(export.Shares, share)
+	}
+
+	return export, shares.Err()
+}
+
+// sqlOutputStore is an OutputStore for SQLite, MySQL and Postgres
+type sqlOutputStore struct {
+	db *sqlDB
+}
+
+// queryOutputs retrieves the outputs matching a where clause, oldest first
+func (s *sqlOutputStore) queryOutputs(where string, args ...interface{}) ([]models.Output, error) {
+	rows, err := s.db.query(fmt.Sprintf("SELECT %v FROM %v WHERE %v ORDER BY o.createdAt, o.outputID", sqlOutputColumns, sqlOutputsJoin, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var outputs []models.Output
+	for rows.Next() {
+		output, err := scanSQLOutput(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		outputs = append(outputs, *output)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return outputs, nil
+}
+
+func (s *sqlOutputStore) CreateOutput(params models.OutputParams, actor models.Actor) error {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return err
+	}
+
+	actions := &sqlActionStore{s.db}
+	_, err = actions.getModifiableAction(params.ActionID, access)
+	if err != nil {
+		return err
+	}
+
+	createdAt := now()
+	_, err = s.db.exec("INSERT INTO outputs (outputID, title, description, createdAt, updatedAt, actionID) VALUES (?, ?, ?, ?, ?, ?)",
+		newUUID(), params.Title, params.Description, createdAt, createdAt, params.ActionID)
+
+	return s.db.checkErr(err, "title")
+}
+
+func (s *sqlOutputStore) GetOutputs(actor models.Actor, archived bool) ([]models.Output, error) {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	// archived outputs are only ever visible to those who may modify them
+	if archived {
+		visibility, args := access.ArchiveVisibility()
+		return s.queryOutputs(fmt.Sprintf("o.isArchived = TRUE AND a.isArchived = FALSE AND %v", visibility), args...)
+	}
+
+	visibility, args := access.Visibility()
+	return s.queryOutputs(fmt.Sprintf("o.isArchived = FALSE AND a.isArchived = FALSE AND %v", visibility), args...)
+}
+
+func (s *sqlOutputStore) GetOutputsByAction(actionID string, actor models.Actor) ([]models.Output, error) {
+	actions := &sqlActionStore{s.db}
+	_, err := actions.GetActionByID(actionID, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.queryOutputs("o.isArchived = FALSE AND o.actionID = ?", actionID)
+}
+
+func (s *sqlOutputStore) GetOutputByID(outputID string, actor models.Actor) (*models.Output, error) {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getOutput(outputID, access)
+}
+
+// getOutput retrieves a single live output, as long as its action is visible to access
+func (s *sqlOutputStore) getOutput(outputID string, access models.Access) (*models.Output, error) {
+	visibility, args := access.Visibility()
+	row := s.db.queryRow(fmt.Sprintf("SELECT %v FROM %v WHERE o.outputID = ? AND o.isArchived = FALSE AND a.isArchived = FALSE AND %v", sqlOutputColumns, sqlOutputsJoin, visibility), append([]interface{}{outputID}, args...)...)
+	return scanSQLOutput(row)
+}
+
+// getModifiableOutput retrieves a live output visible to access, failing if access may not modify it
+func (s *sqlOutputStore) getModifiableOutput(outputID string, access models.Access) (*models.Output, error) {
+	output, err := s.getOutput(outputID, access)
+	if err != nil {
+		return nil, err
+	}
+
+	if !access.CanModify(output.UserID) {
+		return nil, utils.ErrForbidden
+	}
+
+	return output, nil
+}
+
+func (s *sqlOutputStore) UpdateOutput(outputID string, actor models.Actor, params models.OutputParams) (*models.Output, error) {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.getModifiableOutput(outputID, access)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := []string{"updatedAt = ?"}
+	args := []interface{}{now()}
+
+	if params.Title != "" {
+		columns = append(columns, "title = ?")
+		args = append(args, params.Title)
+	}
+
+	if params.Description != "" {
+		columns = append(columns, "description = ?")
+		args = append(args, params.Description)
+	}
+
+	if len(columns) == 1 {
+		return nil, utils.ErrNoUpdateParams
+	}
+
+	args = append(args, outputID)
+	_, err = s.db.exec(fmt.Sprintf("UPDATE outputs SET %v WHERE outputID = ?", strings.Join(columns, ", ")), args...)
+	if err != nil {
+		return nil, s.db.checkErr(err, "title")
+	}
+
+	return s.getOutput(outputID, access)
+}
+
+func (s *sqlOutputStore) ArchiveOutput(outputID string, actor models.Actor) error {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.getModifiableOutput(outputID, access)
+	if err != nil {
+		return err
+	}
+
+	archivedAt := now()
+	_, err = s.db.exec("UPDATE outputs SET isArchived = TRUE, archivedAt = ?, updatedAt = ? WHERE outputID = ?", archivedAt, archivedAt, outputID)
+	return err
+}
+
+// sqlSearchStore is a SearchStore for SQLite, MySQL and Postgres, ranking by term frequency
+type sqlSearchStore struct {
+	db *sqlDB
+}
+
+func (s *sqlSearchStore) Search(actor models.Actor, params models.SearchParams) ([]models.SearchResult, error) {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	// narrow down to rows that may match, then rank them in Go
+	visibility, visibilityArgs := access.Visibility()
+	actionsMatch, actionsArgs := s.db.searchMatch("a", params.Query)
+	outputsMatch, outputsArgs := s.db.searchMatch("o", params.Query)
+
+	query := fmt.Sprintf(`
+		SELECT 'action', a.actionID, a.actionID, a.title, a.description FROM actions a
+		WHERE a.isArchived = FALSE AND %v AND (%v)
+		UNION ALL
+		SELECT 'output', o.outputID, o.actionID, o.title, o.description FROM %v
+		WHERE o.isArchived = FALSE AND a.isArchived = FALSE AND %v AND (%v)`,
+		visibility, actionsMatch, sqlOutputsJoin, visibility, outputsMatch)
+
+	args := append([]interface{}{}, visibilityArgs...)
+	args = append(args, actionsArgs...)
+	args = append(args, visibilityArgs...)
+	args = append(args, outputsArgs...)
+
+	rows, err := s.db.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []searchCandidate{}
+	for rows.Next() {
+		var c searchCandidate
+
+		err := rows.Scan(&c.kind, &c.id, &c.actionID, &c.title, &c.description)
+		if err != nil {
+			return nil, err
+		}
+
+		candidates = append(candidates, c)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return rankSearchCandidates(candidates, params), nil
+}
+
+// searchCandidate is an action or output that might match a search
+type searchCandidate struct {
+	kind        string
+	id          string
+	actionID    string
+	title       string
+	description string
+}
+
+// rankSearchCandidates scores candidates by how often the query's terms occur in them,
+// so that every backend ranks alike, whichever way it narrows them down. Title matches count double
+func rankSearchCandidates(candidates []searchCandidate, params models.SearchParams) []models.SearchResult {
+	terms := strings.Fields(strings.ToLower(params.Query))
+
+	results := []models.SearchResult{}
+	for _, c := range candidates {
+		title, description := strings.ToLower(c.title), strings.ToLower(c.description)
+
+		score := 0
+		for _, term := range terms {
+			score += 2*strings.Count(title, term) + strings.Count(description, term)
+		}
+
+		if score == 0 {
+			continue
+		}
+
+		result := models.SearchResult{Kind: c.kind, ID: c.id, ActionID: c.actionID, Score: float64(score)}
+		result.Highlight(c.title, c.description, params.Query)
+		results = append(results, result)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score == results[j].Score {
+			return results[i].ID < results[j].ID
+		}
+
+		return results[i].Score > results[j].Score
+	})
+
+	if limit := params.MaxResults(); len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+// sqlAPIKeyStore is an APIKeyStore for SQLite, MySQL and Postgres
+type sqlAPIKeyStore struct {
+	db *sqlDB
+}
+
+func (s *sqlAPIKeyStore) CreateAPIKey(userID string, params models.APIKeyParams) (*models.APIKey, error) {
+	plaintext, prefix, err := security.GenerateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	key := &models.APIKey{
+		KeyID:     newUUID(),
+		UserID:    userID,
+		Name:      params.Name,
+		Prefix:    prefix,
+		Scopes:    params.Scopes,
+		Key:       plaintext,
+		CreatedAt: now(),
+	}
+
+	if params.ExpiresAt != nil {
+		expiresAt := params.ExpiresAt.UTC()
+		key.ExpiresAt = &expiresAt
+	}
+
+	_, err = s.db.exec("INSERT INTO api_keys (keyID, userID, name, prefix, keyHash, scopes, createdAt, expiresAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		key.KeyID, userID, key.Name, prefix, security.HashAPIKey(plaintext), strings.Join(key.Scopes, " "), key.CreatedAt, key.ExpiresAt)
+	if err != nil {
+		return nil, s.db.checkErr(err)
+	}
+
+	return key, nil
+}
+
+func (s *sqlAPIKeyStore) GetAPIKeys(userID string) ([]models.APIKey, error) {
+	rows, err := s.db.query(fmt.Sprintf("SELECT %v FROM api_keys WHERE userID = ? ORDER BY createdAt DESC, keyID", sqlAPIKeyColumns), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanSQLAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *sqlAPIKeyStore) RevokeAPIKey(keyID string, userID string) error {
+	res, err := s.db.exec("DELETE FROM api_keys WHERE keyID = ? AND userID = ?", keyID, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return utils.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (s *sqlAPIKeyStore) AuthenticateAPIKey(plaintext string) (*models.APIKey, error) {
+	usedAt := now()
+	row := s.db.queryRow(fmt.Sprintf("SELECT %v FROM api_keys WHERE keyHash = ? AND (expiresAt IS NULL OR expiresAt > ?) AND %v", sqlAPIKeyColumns, sqlAPIKeyUserEnabled), security.HashAPIKey(plaintext), usedAt)
+
+	key, err := scanSQLAPIKey(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, utils.ErrAPIKeyNotFound
+		}
+
+		return nil, err
+	}
+
+	_, err = s.db.exec("UPDATE api_keys SET lastUsedAt = ? WHERE keyID = ?", usedAt, key.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	key.LastUsedAt = &usedAt
+	return key, nil
+}
+
+// sqlPasswordResetStore is a PasswordResetStore for SQLite, MySQL and Postgres
+type sqlPasswordResetStore struct {
+	db *sqlDB
+}
+
+func (s *sqlPasswordResetStore) CreatePasswordReset(username string, ttl time.Duration) (*models.PasswordReset, error) {
+	reset := &models.PasswordReset{Username: username}
+
+	err := s.db.queryRow("SELECT userID FROM users WHERE username = ?", username).Scan(&reset.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	reset.Token, err = security.GenerateOneTimeToken()
+	if err != nil {
+		return nil, err
+	}
+
+	reset.CreatedAt = now()
+	reset.ExpiresAt = reset.CreatedAt.Add(ttl)
+
+	err = s.db.execTx(
+		sqlStatement{"DELETE FROM password_resets WHERE userID = ?", []interface{}{reset.UserID}},
+		sqlStatement{"INSERT INTO password_resets (tokenHash, userID, createdAt, expiresAt) VALUES (?, ?, ?, ?)",
+			[]interface{}{security.HashOneTimeToken(reset.Token), reset.UserID, reset.CreatedAt, reset.ExpiresAt}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return reset, nil
+}
+
+func (s *sqlPasswordResetStore) ResetPassword(params models.ResetPasswordParams) (string, error) {
+	var userID, username string
+	tokenHash := security.HashOneTimeToken(params.Token)
+
+	err := s.db.queryRow(`SELECT r.userID, u.username FROM password_resets r
+		INNER JOIN users u ON u.userID = r.userID
+		WHERE r.tokenHash = ? AND r.expiresAt > ?`, tokenHash, now()).Scan(&userID, &username)
+	if err == sql.ErrNoRows {
+		return "", utils.ErrResetTokenInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = models.CheckNewPassword(username, params.NewPassword)
+	if err != nil {
+		return "", err
+	}
+
+	pwdHash, err := security.GeneratePasswordHash(&params.NewPassword)
+	if err != nil {
+		return "", fmt.Errorf("error hashing password: %v", err.Error())
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// the token is spent by whoever deletes it, so that racing requests cannot both use it
+	res, err := tx.Exec(s.db.rebind("DELETE FROM password_resets WHERE tokenHash = ?"), tokenHash)
+	if err != nil {
+		return "", err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+
+	if affected == 0 {
+		return "", utils.ErrResetTokenInvalid
+	}
+
+	_, err = tx.Exec(s.db.rebind("UPDATE users SET password = ?, updatedAt = ? WHERE userID = ?"), pwdHash, now(), userID)
+	if err != nil {
+		return "", err
+	}
+
+	return userID, tx.Commit()
+}
+
+// sqlEmailVerificationStore is an EmailVerificationStore for SQLite, MySQL and Postgres
+type sqlEmailVerificationStore struct {
+	db *sqlDB
+}
+
+func (s *sqlEmailVerificationStore) CreateEmailVerification(username string, ttl time.Duration, interval time.Duration) (*models.EmailVerification, error) {
+	verification := &models.EmailVerification{Username: username}
+	var emailVerifiedAt sql.NullTime
+
+	err := s.db.queryRow("SELECT userID, emailVerifiedAt FROM users WHERE username = ?", username).Scan(&verification.UserID, &emailVerifiedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if emailVerifiedAt.Valid {
+		return nil, utils.ErrEmailAlreadyVerified
+	}
+
+	verification.Token, err = security.GenerateOneTimeToken()
+	if err != nil {
+		return nil, err
+	}
+
+	verification.CreatedAt = now()
+	verification.ExpiresAt = verification.CreatedAt.Add(ttl)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var recent int
+	err = tx.QueryRow(s.db.rebind("SELECT COUNT(*) FROM email_verifications WHERE userID = ? AND createdAt > ?"), verification.UserID, verification.CreatedAt.Add(-interval)).Scan(&recent)
+	if err != nil {
+		return nil, err
+	}
+
+	if recent > 0 {
+		return nil, utils.ErrVerificationThrottled
+	}
+
+	_, err = tx.Exec(s.db.rebind("DELETE FROM email_verifications WHERE userID = ?"), verification.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(s.db.rebind("INSERT INTO email_verifications (tokenHash, userID, createdAt, expiresAt) VALUES (?, ?, ?, ?)"),
+		security.HashOneTimeToken(verification.Token), verification.UserID, verification.CreatedAt, verification.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return verification, tx.Commit()
+}
+
+func (s *sqlEmailVerificationStore) VerifyEmail(token string) (string, error) {
+	var userID string
+	tokenHash := security.HashOneTimeToken(token)
+	verifiedAt := now()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(s.db.rebind("SELECT userID FROM email_verifications WHERE tokenHash = ? AND expiresAt > ?"), tokenHash, verifiedAt).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", utils.ErrVerificationTokenInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// the token is spent by whoever deletes it, so that racing requests cannot both use it
+	res, err := tx.Exec(s.db.rebind("DELETE FROM email_verifications WHERE tokenHash = ?"), tokenHash)
+	if err != nil {
+		return "", err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+
+	if affected == 0 {
+		return "", utils.ErrVerificationTokenInvalid
+	}
+
+	_, err = tx.Exec(s.db.rebind("UPDATE users SET emailVerifiedAt = COALESCE(emailVerifiedAt, ?), updatedAt = ? WHERE userID = ?"), verifiedAt, verifiedAt, userID)
+	if err != nil {
+		return "", err
+	}
+
+	return userID, tx.Commit()
+}
+
+// sqlMFAStore is an MFAStore for SQLite, MySQL and Postgres
+type sqlMFAStore struct {
+	db *sqlDB
+}
+
+func (s *sqlMFAStore) EnrollMFA(userID string) (*models.MFAEnrollment, error) {
+	var username string
+	var mfaEnabledAt sql.NullTime
+
+	err := s.db.queryRow("SELECT username, mfaEnabledAt FROM users WHERE userID = ?", userID).Scan(&username, &mfaEnabledAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfaEnabledAt.Valid {
+		return nil, utils.ErrMFAAlreadyEnabled
+	}
+
+	secret, err := security.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := security.SealTOTPSecret(secret, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.exec("UPDATE users SET mfaSecret = ?, updatedAt = ? WHERE userID = ? AND mfaEnabledAt IS NULL", sealed, now(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.MFAEnrollment{Secret: secret, OTPAuthURI: security.TOTPURI(models.MFAIssuer, username, secret)}, nil
+}
+
+func (s *sqlMFAStore) ConfirmMFA(userID string, code string) ([]string, error) {
+	var sealed string
+	var mfaEnabledAt sql.NullTime
+
+	err := s.db.queryRow("SELECT mfaSecret, mfaEnabledAt FROM users WHERE userID = ?", userID).Scan(&sealed, &mfaEnabledAt)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := security.OpenTOTPSecret(sealed, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfaEnabledAt.Valid {
+		return nil, utils.ErrMFAAlreadyEnabled
+	}
+
+	if secret == "" {
+		return nil, utils.ErrMFANotEnrolled
+	}
+
+	enabledAt := now()
+	step, ok := security.ValidateTOTP(secret, code, enabledAt, 0)
+	if !ok {
+		return nil, utils.ErrMFACodeInvalid
+	}
+
+	codes, err := security.GenerateRecoveryCodes(models.MFARecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(s.db.rebind("UPDATE users SET mfaEnabledAt = ?, mfaLastStep = ?, updatedAt = ? WHERE userID = ? AND mfaSecret = ? AND mfaEnabledAt IS NULL"), enabledAt, step, enabledAt, userID, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	// the secret was replaced, or confirmed, in the meantime
+	if affected == 0 {
+		return nil, utils.ErrMFACodeInvalid
+	}
+
+	_, err = tx.Exec(s.db.rebind("DELETE FROM mfa_recovery_codes WHERE userID = ?"), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range codes {
+		_, err = tx.Exec(s.db.rebind("INSERT INTO mfa_recovery_codes (codeHash, userID, createdAt) VALUES (?, ?, ?)"), security.HashRecoveryCode(c), userID, enabledAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, tx.Commit()
+}
+
+func (s *sqlMFAStore) VerifyMFA(userID string, code string) error {
+	var sealed string
+	var mfaEnabledAt sql.NullTime
+	var lastStep int64
+
+	err := s.db.queryRow("SELECT mfaSecret, mfaEnabledAt, mfaLastStep FROM users WHERE userID = ?", userID).Scan(&sealed, &mfaEnabledAt, &lastStep)
+	if err != nil {
+		return err
+	}
+
+	if !mfaEnabledAt.Valid {
+		return utils.ErrMFANotEnabled
+	}
+
+	var res sql.Result
+	if security.IsTOTPCode(code) {
+		secret, err := security.OpenTOTPSecret(sealed, userID)
+		if err != nil {
+			return err
+		}
+
+		step, ok := security.ValidateTOTP(secret, code, now(), lastStep)
+		if !ok {
+			return utils.ErrMFACodeInvalid
+		}
+
+		// a code is spent by whoever moves mfaLastStep up to it, so that racing requests cannot both use it
+		res, err = s.db.exec("UPDATE users SET mfaLastStep = ? WHERE userID = ? AND mfaLastStep < ?", step, userID, step)
+	} else {
+		res, err = s.db.exec("DELETE FROM mfa_recovery_codes WHERE codeHash = ? AND userID = ?", security.HashRecoveryCode(code), userID)
+	}
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return utils.ErrMFACodeInvalid
+	}
+
+	return nil
+}
+
+func (s *sqlMFAStore) DisableMFA(userID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(s.db.rebind("UPDATE users SET mfaSecret = '', mfaEnabledAt = NULL, mfaLastStep = 0, updatedAt = ? WHERE userID = ? AND mfaEnabledAt IS NOT NULL"), now(), userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return utils.ErrMFANotEnabled
+	}
+
+	_, err = tx.Exec(s.db.rebind("DELETE FROM mfa_recovery_codes WHERE userID = ?"), userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlMFAStore) SealMFASecrets() (int64, error) {
+	rows, err := s.db.query("SELECT userID, mfaSecret FROM users WHERE mfaSecret <> ''")
+	if err != nil {
+		return 0, err
+	}
+
+	stored := map[string]string{}
+	for rows.Next() {
+		var userID, secret string
+		err := rows.Scan(&userID, &secret)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		if !security.IsSealedTOTPSecret(secret) {
+			stored[userID] = secret
+		}
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var sealedCount int64
+	for userID, secret := range stored {
+		sealed, err := security.SealTOTPSecret(secret, userID)
+		if err != nil {
+			return sealedCount, err
+		}
+
+		// a secret replaced in the meantime was sealed as it was stored
+		res, err := s.db.exec("UPDATE users SET mfaSecret = ? WHERE userID = ? AND mfaSecret = ?", sealed, userID, secret)
+		if err != nil {
+			return sealedCount, err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return sealedCount, err
+		}
+		sealedCount += affected
+	}
+
+	return sealedCount, nil
+}
+
+// sqlIdentityStore is an IdentityStore for SQLite, MySQL and Postgres
+type sqlIdentityStore struct {
+	db *sqlDB
+}
+
+func (s *sqlIdentityStore) LoginWithIdentity(identity *models.ExternalIdentity) (*models.IdentityLogin, error) {
+	login := &models.IdentityLogin{}
+
+	err := s.db.queryRow("SELECT userID FROM user_identities WHERE issuer = ? AND subject = ?", identity.Issuer, identity.Subject).Scan(&login.UserID)
+	if err == nil {
+		// the email on file is kept up to date, for users to tell their identities apart
+		_, err = s.db.exec("UPDATE user_identities SET email = ? WHERE issuer = ? AND subject = ?", identity.Email, identity.Issuer, identity.Subject)
+		return login, err
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	email, err := identity.LinkEmail()
+	if err != nil {
+		return nil, err
+	}
+
+	linkedAt := now()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var emailVerifiedAt sql.NullTime
+	err = tx.QueryRow(s.db.rebind("SELECT userID, emailVerifiedAt FROM users WHERE username = ?"), email).Scan(&login.UserID, &emailVerifiedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		login.UserID = newUUID()
+		_, err = tx.Exec(s.db.rebind("INSERT INTO users (userID, username, password, emailVerifiedAt, displayName, createdAt, updatedAt) VALUES (?, ?, '', ?, ?, ?, ?)"),
+			login.UserID, email, linkedAt, identity.DisplayName(), linkedAt, linkedAt)
+		if err != nil {
+			return nil, s.db.checkErr(err, "username")
+		}
+		login.Provisioned = true
+
+	case err != nil:
+		return nil, err
+
+	case !emailVerifiedAt.Valid:
+		// whoever signed up with the email may have set a pwd, api keys, mfa or a pwd reset up: none of them are kept
+		_, err = tx.Exec(s.db.rebind("UPDATE users SET password = '', emailVerifiedAt = ?, mfaSecret = '', mfaEnabledAt = NULL, mfaLastStep = 0, updatedAt = ? WHERE userID = ?"),
+			linkedAt, linkedAt, login.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, table := range []string{"api_keys", "mfa_recovery_codes", "password_resets"} {
+			_, err = tx.Exec(s.db.rebind(fmt.Sprintf("DELETE FROM %v WHERE userID = ?", table)), login.UserID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		login.Claimed = true
+	}
+
+	_, err = tx.Exec(s.db.rebind("INSERT INTO user_identities (issuer, subject, userID, email, createdAt) VALUES (?, ?, ?, ?, ?)"),
+		identity.Issuer, identity.Subject, login.UserID, identity.Email, linkedAt)
+	if err != nil {
+		return nil, s.db.checkErr(err, "issuer", "subject")
+	}
+	login.Linked = true
+
+	return login, tx.Commit()
+}
+
+func (s *sqlIdentityStore) GetIdentities(userID string) ([]models.ExternalIdentity, error) {
+	rows, err := s.db.query("SELECT issuer, subject, email, createdAt FROM user_identities WHERE userID = ? ORDER BY createdAt, issuer, subject", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []models.ExternalIdentity{}
+	for rows.Next() {
+		var identity models.ExternalIdentity
+		err = rows.Scan(&identity.Issuer, &identity.Subject, &identity.Email, &identity.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+// sqlWorkspaceStore is a WorkspaceStore for SQLite, MySQL and Postgres
+type sqlWorkspaceStore struct {
+	db *sqlDB
+}
+
+func (s *sqlWorkspaceStore) CreateWorkspace(userID string, params models.WorkspaceParams) (*models.Workspace, error) {
+	workspaceID := newUUID()
+	createdAt := now()
+
+	err := s.db.execTx(
+		sqlStatement{"INSERT INTO workspaces (workspaceID, name, createdAt, updatedAt) VALUES (?, ?, ?, ?)", []interface{}{workspaceID, params.Name, createdAt, createdAt}},
+		sqlStatement{"INSERT INTO workspace_members (workspaceID, userID, role, createdAt) VALUES (?, ?, ?, ?)", []interface{}{workspaceID, userID, models.WorkspaceOwner, createdAt}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetWorkspace(models.Actor{UserID: userID, WorkspaceID: workspaceID})
+}
+
+func (s *sqlWorkspaceStore) GetWorkspaces(userID string) ([]models.Workspace, error) {
+	rows, err := s.db.query(fmt.Sprintf("SELECT %v FROM workspaces w JOIN workspace_members m ON m.workspaceID = w.workspaceID WHERE m.userID = ? ORDER BY w.name, w.workspaceID", sqlWorkspaceColumns), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := []models.Workspace{}
+	for rows.Next() {
+		var workspace models.Workspace
+
+		err := rows.Scan(&workspace.WorkspaceID, &workspace.Name, &workspace.Role, &workspace.CreatedAt, &workspace.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		workspaces = append(workspaces, workspace)
+	}
+
+	return workspaces, rows.Err()
+}
+
+func (s *sqlWorkspaceStore) GetWorkspace(actor models.Actor) (*models.Workspace, error) {
+	var workspace models.Workspace
+
+	err := s.db.queryRow(fmt.Sprintf("SELECT %v FROM workspaces w JOIN workspace_members m ON m.workspaceID = w.workspaceID WHERE w.workspaceID = ? AND m.userID = ?", sqlWorkspaceColumns), actor.WorkspaceID, actor.UserID).
+		Scan(&workspace.WorkspaceID, &workspace.Name, &workspace.Role, &workspace.CreatedAt, &workspace.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, utils.ErrWorkspaceNotFound
+		}
+
+		return nil, err
+	}
+
+	return &workspace, nil
+}
+
+// queryMembers retrieves the members matching a where clause, oldest first
+func (s *sqlWorkspaceStore) queryMembers(where string, args ...interface{}) ([]models.WorkspaceMember, error) {
+	rows, err := s.db.query(fmt.Sprintf("SELECT %v FROM workspace_members m JOIN users u ON u.userID = m.userID WHERE %v ORDER BY m.createdAt, u.username", sqlMemberColumns, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.WorkspaceMember{}
+	for rows.Next() {
+		var member models.WorkspaceMember
+
+		err := rows.Scan(&member.WorkspaceID, &member.UserID, &member.Username, &member.Role, &member.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+// getMember retrieves a single member of a workspace
+func (s *sqlWorkspaceStore) getMember(workspaceID string, memberID string) (*models.WorkspaceMember, error) {
+	members, err := s.queryMembers("m.workspaceID = ? AND m.userID = ?", workspaceID, memberID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(members) == 0 {
+		return nil, utils.ErrUserNotFound
+	}
+
+	return &members[0], nil
+}
+
+// lockOwners lists the owners of a workspace, locking their rows until tx ends,
+// so that owners demoting or removing each other at once take turns, rather than leave it without any
+func (s *sqlWorkspaceStore) lockOwners(tx *sql.Tx, workspaceID string) (map[string]bool, error) {
+	rows, err := tx.Query(s.db.rebind("SELECT userID FROM workspace_members WHERE workspaceID = ? AND role = ?"+s.db.lockRows), workspaceID, models.WorkspaceOwner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	owners := map[string]bool{}
+	for rows.Next() {
+		var ownerID string
+		err := rows.Scan(&ownerID)
+		if err != nil {
+			return nil, err
+		}
+
+		owners[ownerID] = true
+	}
+
+	return owners, rows.Err()
+}
+
+func (s *sqlWorkspaceStore) GetMembers(actor models.Actor) ([]models.WorkspaceMember, error) {
+	_, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.queryMembers("m.workspaceID = ?", actor.WorkspaceID)
+}
+
+func (s *sqlWorkspaceStore) UpdateMember(actor models.Actor, memberID string, params models.MemberParams) (*models.WorkspaceMember, error) {
+	_, err := s.db.resolveOwner(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.getMember(actor.WorkspaceID, memberID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	owners, err := s.lockOwners(tx, actor.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the actor may have been demoted while waiting for the lock
+	if !owners[actor.UserID] {
+		return nil, utils.ErrForbidden
+	}
+
+	if owners[memberID] && len(owners) == 1 && params.Role != models.WorkspaceOwner {
+		return nil, utils.ErrLastOwner
+	}
+
+	_, err = tx.Exec(s.db.rebind("UPDATE workspace_members SET role = ? WHERE workspaceID = ? AND userID = ?"), params.Role, actor.WorkspaceID, memberID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getMember(actor.WorkspaceID, memberID)
+}
+
+func (s *sqlWorkspaceStore) RemoveMember(actor models.Actor, memberID string) error {
+	access, err := s.db.resolveAccess(actor)
+	if err != nil {
+		return err
+	}
+
+	// members may always leave, but only owners may remove others
+	if memberID != actor.UserID && access.Role != models.WorkspaceOwner {
+		return utils.ErrForbidden
+	}
+
+	_, err = s.getMember(actor.WorkspaceID, memberID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	owners, err := s.lockOwners(tx, actor.WorkspaceID)
+	if err != nil {
+		return err
+	}
+
+	// the actor may have been demoted while waiting for the lock
+	if memberID != actor.UserID && !owners[actor.UserID] {
+		return utils.ErrForbidden
+	}
+
+	if owners[memberID] && len(owners) == 1 {
+		return utils.ErrLastOwner
+	}
+
+	_, err = tx.Exec(s.db.rebind("DELETE FROM workspace_members WHERE workspaceID = ? AND userID = ?"), actor.WorkspaceID, memberID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlWorkspaceStore) InviteMember(actor models.Actor, params models.InvitationParams) (*models.Invitation, error) {
+	_, err := s.db.resolveOwner(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	// invitations are addressed to usernames, whether or not anyone signed up with them, so that inviting tells nothing of who has
+	members, err := s.queryMembers("m.workspaceID = ? AND u.username = ?", actor.WorkspaceID, params.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(members) > 0 {
+		return nil, utils.ErrAlreadyMember
+	}
+
+	invitationID := newUUID()
+	_, err = s.db.exec("INSERT INTO workspace_invitations (invitationID, workspaceID, username, role, invitedBy, createdAt) VALUES (?, ?, ?, ?, ?, ?)",
+		invitationID, actor.WorkspaceID, params.Username, params.Role, actor.UserID, now())
+	if err != nil {
+		return nil, s.db.checkErr(err, "username")
+	}
+
+	invitations, err := s.queryInvitations("i.invitationID = ?", invitationID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invitations[0], nil
+}
+
+// queryInvitations retrieves the invitations matching a where clause, oldest first
+func (s *sqlWorkspaceStore) queryInvitations(where string, args ...interface{}) ([]models.Invitation, error) {
+	rows, err := s.db.query(fmt.Sprintf("SELECT %v FROM %v WHERE %v ORDER BY i.createdAt, i.invitationID", sqlInvitationColumns, sqlInvitationsJoin, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []models.Invitation{}
+	for rows.Next() {
+		var invitation models.Invitation
+
+		err := rows.Scan(
+			&invitation.InvitationID,
+			&invitation.WorkspaceID,
+			&invitation.WorkspaceName,
+			&invitation.Username,
+			&invitation.Role,
+			&invitation.InvitedBy,
+			&invitation.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, invitation)
+	}
+
+	return invitations, rows.Err()
+}
+
+func (s *sqlWorkspaceStore) GetWorkspaceInvitations(actor models.Actor) ([]models.Invitation, error) {
+	_, err := s.db.resolveOwner(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.queryInvitations("i.workspaceID = ?", actor.WorkspaceID)
+}
+
+func (s *sqlWorkspaceStore) RevokeInvitation(actor models.Actor, invitationID string) error {
+	_, err := s.db.resolveOwner(actor)
+	if err != nil {
+		return err
+	}
+
+	return s.deleteInvitation("invitationID = ? AND workspaceID = ?", invitationID, actor.WorkspaceID)
+}
+
+// checkInviteeVerified fails unless the user verified their email, whatever the verification policy,
+// as invitations are addressed to it: whoever signs up with someone else's email must not see or answer their invitations
+func (s *sqlWorkspaceStore) checkInviteeVerified(userID string) error {
+	var emailVerifiedAt sql.NullTime
+
+	err := s.db.queryRow("SELECT emailVerifiedAt FROM users WHERE userID = ?", userID).Scan(&emailVerifiedAt)
+	if err == sql.ErrNoRows {
+		return utils.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if !emailVerifiedAt.Valid {
+		return utils.ErrEmailNotVerified
+	}
+
+	return nil
+}
+
+func (s *sqlWorkspaceStore) GetInvitations(userID string) ([]models.Invitation, error) {
+	err := s.checkInviteeVerified(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.queryInvitations("i.username = "+sqlInviteeUsername, userID)
+}
+
+func (s *sqlWorkspaceStore) AcceptInvitation(invitationID string, userID string) (*models.Workspace, error) {
+	err := s.checkInviteeVerified(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	invitations, err := s.queryInvitations("i.invitationID = ? AND i.username = "+sqlInviteeUsername, invitationID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(invitations) == 0 {
+		return nil, utils.ErrInvitationNotFound
+	}
+
+	invitation := invitations[0]
+	err = s.db.execTx(
+		sqlStatement{"INSERT INTO workspace_members (workspaceID, userID, role, createdAt) VALUES (?, ?, ?, ?)", []interface{}{invitation.WorkspaceID, userID, invitation.Role, now()}},
+		sqlStatement{"DELETE FROM workspace_invitations WHERE invitationID = ?", []interface{}{invitationID}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetWorkspace(models.Actor{UserID: userID, WorkspaceID: invitation.WorkspaceID})
+}
+
+func (s *sqlWorkspaceStore) DeclineInvitation(invitationID string, userID string) error {
+	err := s.checkInviteeVerified(userID)
+	if err != nil {
+		return err
+	}
+
+	return s.deleteInvitation("invitationID = ? AND username = "+sqlInviteeUsername, invitationID, userID)
+}
+
+// deleteInvitation deletes the invitation matching a where clause, failing if there is none
+func (s *sqlWorkspaceStore) deleteInvitation(where string, args ...interface{}) error {
+	res, err := s.db.exec(fmt.Sprintf("DELETE FROM workspace_invitations WHERE %v", where), args...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return utils.ErrInvitationNotFound
+	}
+
+	return nil
+}
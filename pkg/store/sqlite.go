@@ -0,0 +1,331 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/dmithamo/timelineapi/pkg/dbservice"
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchemas mirrors the MySQL schema, in the types SQLite has
+// Timestamps are always written from Go, in UTC, so that they compare as text
+var sqliteSchemas = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		userID TEXT PRIMARY KEY,
+		username TEXT UNIQUE NOT NULL,
+		password TEXT NOT NULL,
+		role TEXT NOT NULL DEFAULT 'user',
+		disabledAt TIMESTAMP NULL DEFAULT NULL,
+		emailVerifiedAt TIMESTAMP NULL DEFAULT NULL,
+		mfaSecret TEXT NOT NULL DEFAULT '',
+		mfaEnabledAt TIMESTAMP NULL DEFAULT NULL,
+		mfaLastStep INTEGER NOT NULL DEFAULT 0,
+		deleteAfter TIMESTAMP NULL DEFAULT NULL,
+		displayName TEXT NOT NULL DEFAULT '',
+		timezone TEXT NOT NULL DEFAULT 'UTC',
+		avatarURL TEXT NOT NULL DEFAULT '',
+		createdAt TIMESTAMP NOT NULL,
+		updatedAt TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS workspaces (
+		workspaceID TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		createdAt TIMESTAMP NOT NULL,
+		updatedAt TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS workspace_members (
+		workspaceID TEXT NOT NULL REFERENCES workspaces(workspaceID) ON DELETE CASCADE,
+		userID TEXT NOT NULL REFERENCES users(userID) ON DELETE CASCADE,
+		role TEXT NOT NULL,
+		createdAt TIMESTAMP NOT NULL,
+		PRIMARY KEY (workspaceID, userID)
+	)`,
+	sqliteInvitationsSchema,
+	`CREATE TABLE IF NOT EXISTS actions (
+		actionID TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		description TEXT NOT NULL,
+		isArchived BOOLEAN NOT NULL DEFAULT FALSE,
+		archivedAt TIMESTAMP NULL DEFAULT NULL,
+		createdAt TIMESTAMP NOT NULL,
+		updatedAt TIMESTAMP NOT NULL,
+		userID TEXT NOT NULL REFERENCES users(userID) ON DELETE CASCADE,
+		workspaceID TEXT NULL DEFAULT NULL REFERENCES workspaces(workspaceID) ON DELETE CASCADE,
+		scopeID TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS outputs (
+		outputID TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		description TEXT NOT NULL,
+		isArchived BOOLEAN NOT NULL DEFAULT FALSE,
+		archivedAt TIMESTAMP NULL DEFAULT NULL,
+		createdAt TIMESTAMP NOT NULL,
+		updatedAt TIMESTAMP NOT NULL,
+		actionID TEXT NOT NULL REFERENCES actions(actionID) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS action_shares (
+		actionID TEXT NOT NULL REFERENCES actions(actionID) ON DELETE CASCADE,
+		userID TEXT NOT NULL REFERENCES users(userID) ON DELETE CASCADE,
+		createdAt TIMESTAMP NOT NULL,
+		PRIMARY KEY (actionID, userID)
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		keyID TEXT PRIMARY KEY,
+		userID TEXT NOT NULL REFERENCES users(userID) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		keyHash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL,
+		createdAt TIMESTAMP NOT NULL,
+		lastUsedAt TIMESTAMP NULL DEFAULT NULL,
+		expiresAt TIMESTAMP NULL DEFAULT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS password_resets (
+		tokenHash TEXT PRIMARY KEY,
+		userID TEXT NOT NULL REFERENCES users(userID) ON DELETE CASCADE,
+		createdAt TIMESTAMP NOT NULL,
+		expiresAt TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS email_verifications (
+		tokenHash TEXT PRIMARY KEY,
+		userID TEXT NOT NULL REFERENCES users(userID) ON DELETE CASCADE,
+		createdAt TIMESTAMP NOT NULL,
+		expiresAt TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+		codeHash TEXT PRIMARY KEY,
+		userID TEXT NOT NULL REFERENCES users(userID) ON DELETE CASCADE,
+		createdAt TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		userID TEXT NOT NULL REFERENCES users(userID) ON DELETE CASCADE,
+		email TEXT NOT NULL DEFAULT '',
+		createdAt TIMESTAMP NOT NULL,
+		PRIMARY KEY (issuer, subject)
+	)`,
+}
+
+// sqliteInvitationsSchema is the workspace_invitations table, addressed to usernames
+// Dbs created while invitations were addressed to users have theirs rebuilt
+const sqliteInvitationsSchema = `CREATE TABLE IF NOT EXISTS workspace_invitations (
+		invitationID TEXT PRIMARY KEY,
+		workspaceID TEXT NOT NULL REFERENCES workspaces(workspaceID) ON DELETE CASCADE,
+		username TEXT NOT NULL,
+		role TEXT NOT NULL,
+		invitedBy TEXT NOT NULL REFERENCES users(userID) ON DELETE CASCADE,
+		createdAt TIMESTAMP NOT NULL,
+		UNIQUE (workspaceID, username)
+	)`
+
+// sqliteTables lists the tables in sqliteSchemas, in the order they can be dropped
+var sqliteTables = []string{"user_identities", "mfa_recovery_codes", "email_verifications", "password_resets", "api_keys", "action_shares", "outputs", "actions", "workspace_invitations", "workspace_members", "workspaces", "users"}
+
+// sqliteAddedColumns lists the columns added to sqliteSchemas since their tables were first created,
+// which are added to dbs created before them
+var sqliteAddedColumns = []struct{ table, column, definition string }{
+	{"users", "role", "TEXT NOT NULL DEFAULT 'user'"},
+	{"users", "disabledAt", "TIMESTAMP NULL DEFAULT NULL"},
+	{"actions", "workspaceID", "TEXT NULL DEFAULT NULL REFERENCES workspaces(workspaceID) ON DELETE CASCADE"},
+	{"users", "displayName", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "timezone", "TEXT NOT NULL DEFAULT 'UTC'"},
+	{"users", "avatarURL", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "emailVerifiedAt", "TIMESTAMP NULL DEFAULT NULL"},
+	{"users", "mfaSecret", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "mfaEnabledAt", "TIMESTAMP NULL DEFAULT NULL"},
+	{"users", "mfaLastStep", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "deleteAfter", "TIMESTAMP NULL DEFAULT NULL"},
+	{"actions", "scopeID", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// sqliteScopedTitles makes titles unique within their space, rather than everywhere:
+// actions' within their workspace, or else their owner's personal space, and outputs' within their action
+var sqliteScopedTitles = []string{
+	"UPDATE actions SET scopeID = COALESCE(workspaceID, userID) WHERE scopeID = ''",
+	"CREATE UNIQUE INDEX IF NOT EXISTS idx_actions_scopeID_title ON actions (scopeID, title)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS idx_outputs_actionID_title ON outputs (actionID, title)",
+}
+
+// NewSQLiteStores opens (creating if need be) the SQLite db at path
+// Use `:memory:` for a throwaway db
+func NewSQLiteStores(path string, reset bool) (*Stores, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%v?_foreign_keys=1&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, err
+	}
+
+	// a single conn serializes writes, and keeps `:memory:` dbs from splitting in two
+	db.SetMaxOpenConns(1)
+
+	statements := sqliteSchemas
+	if reset {
+		for i := len(sqliteTables) - 1; i >= 0; i-- {
+			statements = append([]string{fmt.Sprintf("DROP TABLE IF EXISTS %v", sqliteTables[i])}, statements...)
+		}
+	}
+
+	for _, statement := range statements {
+		_, err = db.Exec(statement)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	err = addSQLiteColumns(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = scopeSQLiteTitles(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = addressSQLiteInvitations(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return newSQLStores(&sqlDB{
+		DB:          db,
+		rebind:      func(query string) string { return query },
+		checkErr:    checkSQLiteErr,
+		searchMatch: sqliteSearchMatch,
+	}), nil
+}
+
+// addSQLiteColumns adds whichever of sqliteAddedColumns a db is missing
+func addSQLiteColumns(db *sql.DB) error {
+	for _, added := range sqliteAddedColumns {
+		var found int
+		err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", added.table, added.column).Scan(&found)
+		if err != nil {
+			return err
+		}
+
+		if found > 0 {
+			continue
+		}
+
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v", added.table, added.column, added.definition))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scopeSQLiteTitles applies sqliteScopedTitles, first rebuilding the tables of dbs created while titles were unique everywhere,
+// as SQLite cannot drop the UNIQUE of a column
+func scopeSQLiteTitles(db *sql.DB) error {
+	for _, table := range []string{"actions", "outputs"} {
+		var schema string
+		err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&schema)
+		if err != nil {
+			return err
+		}
+
+		if !strings.Contains(schema, "title TEXT UNIQUE NOT NULL") {
+			continue
+		}
+
+		err = rebuildSQLiteTable(db, table, strings.Replace(schema, "title TEXT UNIQUE NOT NULL", "title TEXT NOT NULL", 1), "SELECT * FROM "+table)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, statement := range sqliteScopedTitles {
+		_, err := db.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addressSQLiteInvitations rebuilds the invitations of dbs created while they were addressed to users, addressing them to usernames
+func addressSQLiteInvitations(db *sql.DB) error {
+	var schema string
+	err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'workspace_invitations'").Scan(&schema)
+	if err != nil || !strings.Contains(schema, "userID TEXT NOT NULL") {
+		return err
+	}
+
+	return rebuildSQLiteTable(db, "workspace_invitations", sqliteInvitationsSchema,
+		"SELECT i.invitationID, i.workspaceID, u.username, i.role, i.invitedBy, i.createdAt FROM workspace_invitations i JOIN users u ON u.userID = i.userID")
+}
+
+// rebuildSQLiteTable recreates a table as schema has it, filling it with the rows the rows query reads from the old one
+// Foreign keys are off meanwhile, so that dropping the old table cascades to nothing
+func rebuildSQLiteTable(db *sql.DB, table string, schema string, rows string) error {
+	rebuilt := table + "_rebuilt"
+
+	_, err := db.Exec("PRAGMA foreign_keys = OFF")
+	if err != nil {
+		return err
+	}
+	defer db.Exec("PRAGMA foreign_keys = ON")
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		strings.Replace(schema, table, rebuilt, 1),
+		fmt.Sprintf("INSERT INTO %v %v", rebuilt, rows),
+		fmt.Sprintf("DROP TABLE %v", table),
+		fmt.Sprintf("ALTER TABLE %v RENAME TO %v", rebuilt, table),
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// checkSQLiteErr is CheckDatabaseErr for SQLite, producing the same friendly errs
+func checkSQLiteErr(err error, uniqueColumn ...string) error {
+	sqliteErr, ok := err.(sqlite3.Error)
+	if !ok {
+		return err
+	}
+
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return &dbservice.DuplicateEntryErr{Columns: uniqueColumn}
+
+	case sqlite3.ErrConstraintForeignKey:
+		return &dbservice.MissingReferenceErr{}
+	}
+
+	return err
+}
+
+// sqliteSearchMatch narrows a search down to rows containing any of the query's terms
+// SQLite's LIKE ignores case, as MySQL's FULLTEXT search does
+func sqliteSearchMatch(alias string, query string) (string, []interface{}) {
+	likes := []string{}
+	args := []interface{}{}
+	for _, term := range strings.Fields(query) {
+		likes = append(likes, fmt.Sprintf("%v.title LIKE ? OR %v.description LIKE ?", alias, alias))
+		pattern := fmt.Sprintf("%%%v%%", term)
+		args = append(args, pattern, pattern)
+	}
+
+	return strings.Join(likes, " OR "), args
+}
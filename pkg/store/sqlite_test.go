@@ -0,0 +1,117 @@
+package store_test
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/security"
+	"github.com/dmithamo/timelineapi/pkg/store"
+	"github.com/dmithamo/timelineapi/pkg/store/storetest"
+)
+
+func TestSQLiteStores(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) *store.Stores {
+		s, err := store.NewSQLiteStores(":memory:", false)
+		if err != nil {
+			t.Fatalf("NewSQLiteStores: %v", err)
+		}
+
+		return s
+	})
+}
+
+func TestSQLiteSealsTOTPSecrets(t *testing.T) {
+	err := security.SetTOTPKey("sqlite_test")
+	if err != nil {
+		t.Fatalf("SetTOTPKey: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "timelineapi")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "timelineapi.db")
+	s, err := store.NewSQLiteStores(path, false)
+	if err != nil {
+		t.Fatalf("NewSQLiteStores: %v", err)
+	}
+	defer s.Close()
+
+	// the file is read as it is, as whoever got hold of it would
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("open db file: %v", err)
+	}
+	defer db.Close()
+
+	credentials := &models.UserCredentials{Username: "ada@example.com", Password: "Pa55word!"}
+	err = s.Users.CreateUser(credentials)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	user, err := s.Users.GetByCredentials(credentials)
+	if err != nil {
+		t.Fatalf("GetByCredentials: %v", err)
+	}
+
+	enrollment, err := s.MFA.EnrollMFA(user.UserID)
+	if err != nil {
+		t.Fatalf("EnrollMFA: %v", err)
+	}
+
+	var stored string
+	err = db.QueryRow("SELECT mfaSecret FROM users WHERE userID = ?", user.UserID).Scan(&stored)
+	if err != nil || stored == enrollment.Secret || !security.IsSealedTOTPSecret(stored) {
+		t.Errorf("mfaSecret at rest: %q, %v", stored, err)
+	}
+
+	// secrets stored before secrets were sealed keep working, and are sealed once asked
+	_, err = db.Exec("UPDATE users SET mfaSecret = ? WHERE userID = ?", enrollment.Secret, user.UserID)
+	if err != nil {
+		t.Fatalf("store secret as it was: %v", err)
+	}
+
+	code, err := security.GenerateTOTPCode(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("GenerateTOTPCode: %v", err)
+	}
+
+	_, err = s.MFA.ConfirmMFA(user.UserID, code)
+	if err != nil {
+		t.Fatalf("ConfirmMFA with a secret stored as it was: %v", err)
+	}
+
+	sealed, err := s.MFA.SealMFASecrets()
+	if err != nil || sealed != 1 {
+		t.Fatalf("SealMFASecrets: %v, %v", sealed, err)
+	}
+
+	err = db.QueryRow("SELECT mfaSecret FROM users WHERE userID = ?", user.UserID).Scan(&stored)
+	if err != nil || !security.IsSealedTOTPSecret(stored) {
+		t.Errorf("mfaSecret after SealMFASecrets: %q, %v", stored, err)
+	}
+
+	code, err = security.GenerateTOTPCode(enrollment.Secret, time.Now().Add(30*time.Second))
+	if err != nil {
+		t.Fatalf("GenerateTOTPCode: %v", err)
+	}
+
+	err = s.MFA.VerifyMFA(user.UserID, code)
+	if err != nil {
+		t.Errorf("VerifyMFA with a sealed secret: %v", err)
+	}
+
+	// sealed secrets only open for the user they were sealed for
+	_, err = security.OpenTOTPSecret(stored, "00000000-0000-4000-8000-000000000000")
+	if err == nil {
+		t.Errorf("OpenTOTPSecret for another user opened")
+	}
+}
@@ -0,0 +1,325 @@
+// package store defines the storage the api depends on, with interchangeable backends
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/dbservice"
+	"github.com/dmithamo/timelineapi/pkg/models"
+)
+
+// UserStore persists users, along with their roles and profiles
+// Disabled users are kept, but their API keys stop authenticating
+// ChangePassword fails with a WRONG_PASSWORD_ERR unless given the user's current password,
+// and with a PASSWORD_CONTAINS_EMAIL_ERR if the new one gives the user's email away
+// BootstrapAdmin makes a user an admin only while no one is one, so that the first admin can be named in config without undoing later demotions
+// Users scheduled for deletion keep their account until PurgeDeletedUsers finds it due, though their API keys stop authenticating.
+// Workspaces they are the last owner of are handed to their longest-standing member, or deleted along with them
+type UserStore interface {
+	CreateUser(credentials *models.UserCredentials) error
+	GetByCredentials(credentials *models.UserCredentials) (*models.User, error)
+	GetByUUID(userID string) (*models.User, error)
+	GetUsers() ([]models.User, error)
+	SetRole(userID string, role string) (*models.User, error)
+	BootstrapAdmin(userID string) (*models.User, error)
+	SetDisabled(userID string, disabled bool) (*models.User, error)
+	UpdateProfile(userID string, params models.ProfileParams) (*models.User, error)
+	ChangePassword(userID string, params models.PasswordParams) error
+	ScheduleDeletion(userID string, grace time.Duration) (*models.User, error)
+	CancelDeletion(userID string) (*models.User, error)
+	PurgeDeletedUsers() (int64, error)
+}
+
+// ActionStore persists actions, and who they are shared with
+// Every method acting on behalf of a user takes an Actor, and checks what they may do where they act:
+// non-members of a workspace get a WORKSPACE_NOT_FOUND_ERR, and members who may not write a FORBIDDEN_ERR
+// ExportActions is the exception, retrieving everything a user created wherever they created it, for them to take away
+type ActionStore interface {
+	CreateAction(params models.ActionParams, actor models.Actor) error
+	GetActions(actor models.Actor, query *models.ActionQuery) (*models.ActionPage, error)
+	GetActionByID(actionID string, actor models.Actor) (*models.Action, error)
+	GetAnyAction(actionID string) (*models.Action, error)
+	UpdateAction(actionID string, actor models.Actor, params models.ActionParams) (*models.Action, error)
+	ArchiveAction(actionID string, actor models.Actor) error
+	RestoreAction(actionID string, actor models.Actor) (*models.Action, error)
+	PurgeAction(actionID string, actor models.Actor) error
+	PurgeAnyAction(actionID string) error
+	PurgeArchivedActions(retention time.Duration) (int64, error)
+	ShareAction(actionID string, actor models.Actor, params models.ShareParams) error
+	UnshareAction(actionID string, actor models.Actor, shareeID string) error
+	GetActionShares(actionID string, actor models.Actor) ([]models.ActionShare, error)
+	ExportActions(userID string) (*models.ActionsExport, error)
+}
+
+// OutputStore persists outputs, checking what actors may do as ActionStore does
+type OutputStore interface {
+	CreateOutput(params models.OutputParams, actor models.Actor) error
+	GetOutputs(actor models.Actor, archived bool) ([]models.Output, error)
+	GetOutputsByAction(actionID string, actor models.Actor) ([]models.Output, error)
+	GetOutputByID(outputID string, actor models.Actor) (*models.Output, error)
+	UpdateOutput(outputID string, actor models.Actor, params models.OutputParams) (*models.Output, error)
+	ArchiveOutput(outputID string, actor models.Actor) error
+}
+
+// SearchStore searches across the actions and outputs visible where an actor acts
+type SearchStore interface {
+	Search(actor models.Actor, params models.SearchParams) ([]models.SearchResult, error)
+}
+
+// WorkspaceStore persists workspaces, their members, and invitations to them
+// Only owners may manage a workspace's members and invitations, and every workspace keeps at least one owner
+type WorkspaceStore interface {
+	CreateWorkspace(userID string, params models.WorkspaceParams) (*models.Workspace, error)
+	GetWorkspaces(userID string) ([]models.Workspace, error)
+	GetWorkspace(actor models.Actor) (*models.Workspace, error)
+	GetMembers(actor models.Actor) ([]models.WorkspaceMember, error)
+	UpdateMember(actor models.Actor, memberID string, params models.MemberParams) (*models.WorkspaceMember, error)
+	RemoveMember(actor models.Actor, memberID string) error
+	InviteMember(actor models.Actor, params models.InvitationParams) (*models.Invitation, error)
+	GetWorkspaceInvitations(actor models.Actor) ([]models.Invitation, error)
+	RevokeInvitation(actor models.Actor, invitationID string) error
+	GetInvitations(userID string) ([]models.Invitation, error)
+	AcceptInvitation(invitationID string, userID string) (*models.Workspace, error)
+	DeclineInvitation(invitationID string, userID string) error
+}
+
+// APIKeyStore persists users' API keys, keeping only their hashes
+// Revoked keys are deleted, and expired ones kept, though they no longer authenticate
+type APIKeyStore interface {
+	CreateAPIKey(userID string, params models.APIKeyParams) (*models.APIKey, error)
+	GetAPIKeys(userID string) ([]models.APIKey, error)
+	RevokeAPIKey(keyID string, userID string) error
+	AuthenticateAPIKey(key string) (*models.APIKey, error)
+}
+
+// PasswordResetStore persists the pwd resets users start, keeping only their tokens' hashes
+// A user has at most one live reset: starting another calls off the last, and using one spends it
+// ResetPassword fails with a RESET_TOKEN_INVALID_ERR unless given a live, unspent token,
+// and with a PASSWORD_CONTAINS_EMAIL_ERR, leaving the token unspent, if the new pwd gives the user's email away
+type PasswordResetStore interface {
+	CreatePasswordReset(username string, ttl time.Duration) (*models.PasswordReset, error)
+	ResetPassword(params models.ResetPasswordParams) (string, error)
+}
+
+// EmailVerificationStore persists the verifications of users' emails, keeping only their tokens' hashes
+// A user has at most one live verification: starting another calls off the last, and using one spends it
+// CreateEmailVerification fails with a VERIFICATION_THROTTLED_ERR if the last was started less than interval ago,
+// and VerifyEmail with a VERIFICATION_TOKEN_INVALID_ERR unless given a live, unspent token
+type EmailVerificationStore interface {
+	CreateEmailVerification(username string, ttl time.Duration, interval time.Duration) (*models.EmailVerification, error)
+	VerifyEmail(token string) (string, error)
+}
+
+// MFAStore persists users' totp secrets, sealed with the totp key, and recovery codes, keeping only the codes' hashes
+// Users enroll first, and mfa is only enabled once they confirm it with a code of their new secret
+// VerifyMFA fails with an MFA_CODE_INVALID_ERR for wrong codes, and for codes already used
+// SealMFASecrets seals the secrets stored before secrets were sealed, returning how many it sealed
+type MFAStore interface {
+	EnrollMFA(userID string) (*models.MFAEnrollment, error)
+	ConfirmMFA(userID string, code string) ([]string, error)
+	VerifyMFA(userID string, code string) error
+	DisableMFA(userID string) error
+	SealMFASecrets() (int64, error)
+}
+
+// IdentityStore persists the links between users and the accounts they log in with at OpenID Connect providers
+// LoginWithIdentity links an identity as it first logs in, by its verified email, to a user with that username,
+// or else to a user created for it, and fails with an IDENTITY_EMAIL_NOT_VERIFIED_ERR if it cannot be linked
+type IdentityStore interface {
+	LoginWithIdentity(identity *models.ExternalIdentity) (*models.IdentityLogin, error)
+	GetIdentities(userID string) ([]models.ExternalIdentity, error)
+}
+
+// SessionStore persists the sessions of logged in users, so that they can be listed and revoked
+// Each session holds a single-use refresh token, handed out when it is created or rotated
+// Sessions expire as the store's SessionPolicy says
+type SessionStore interface {
+	CreateSession(userID string, userAgent string, ip string) (*models.Session, error)
+	RotateSession(refreshToken string) (*models.Session, error)
+	TouchSession(sessionID string) (*models.Session, error)
+	GetSessions(userID string) ([]models.Session, error)
+	RevokeSession(sessionID string, userID string) error
+	RevokeSessions(userID string) error
+}
+
+// LoginAttemptStore counts failed logins under a key, such as models.AccountAttemptsKey's,
+// so that guessing pwds can be slowed down, and accounts locked out
+// It counts other requests worth slowing down as well, under keys such as models.ResendAttemptsKey's
+// A key's count is forgotten once window passes without another failure, or once it is cleared
+// Logins are counted as failed before they are checked: ReserveLoginAttempt counts one atomically, returning the count before it,
+// so that racing logins each see those reserved ahead of them, and ReleaseLoginAttempt uncounts it once it turns out not to have failed,
+// given the count ReserveLoginAttempt returned. Its failure time is taken back too, unless logins reserved since are still counted
+// Unlock tokens, of which only the hashes are kept, clear an account's count once, when redeemed,
+// and RedeemUnlockToken fails with an UNLOCK_TOKEN_INVALID_ERR unless given a live, unspent one
+type LoginAttemptStore interface {
+	GetLoginAttempts(key string) (*models.LoginAttempts, error)
+	ReserveLoginAttempt(key string, window time.Duration) (*models.LoginAttempts, error)
+	ReleaseLoginAttempt(key string, reserved *models.LoginAttempts) error
+	ClearLoginAttempts(key string) error
+	CreateUnlockToken(username string, ttl time.Duration) (string, error)
+	RedeemUnlockToken(token string) (string, error)
+}
+
+// Stores bundles every store of a single backend
+type Stores struct {
+	Users      UserStore
+	Actions    ActionStore
+	Outputs    OutputStore
+	Search     SearchStore
+	APIKeys    APIKeyStore
+	Workspaces WorkspaceStore
+
+	PasswordResets     PasswordResetStore
+	EmailVerifications EmailVerificationStore
+	MFA                MFAStore
+	Identities         IdentityStore
+
+	// close releases whatever the backend holds on to
+	close func() error
+}
+
+// Close releases the backend's resources
+func (s *Stores) Close() error {
+	if s.close == nil {
+		return nil
+	}
+
+	return s.close()
+}
+
+// Open connects to the backend named by the dsn's scheme: `postgres://` for Postgres,
+// `sqlite://<path>` or `memory://` for local dev and tests, and a plain MySQL dsn otherwise
+// If reset is true, the backend is emptied first
+func Open(dsn string, reset bool) (*Stores, error) {
+	switch {
+	case strings.HasPrefix(dsn, "memory://"):
+		return NewMemoryStores(), nil
+
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return NewSQLiteStores(strings.TrimPrefix(dsn, "sqlite://"), reset)
+
+	case dbservice.DialectOf(dsn) == dbservice.Postgres:
+		return NewPostgresStores(dsn, reset)
+
+	default:
+		return NewMySQLStores(dsn, reset)
+	}
+}
+
+// Caches bundles every store kept in the cache, rather than the db
+type Caches struct {
+	Sessions      SessionStore
+	LoginAttempts LoginAttemptStore
+
+	// close releases whatever the cache holds on to
+	close func() error
+}
+
+// Close releases the cache's resources
+func (c *Caches) Close() error {
+	if c.close == nil {
+		return nil
+	}
+
+	return c.close()
+}
+
+// SessionPolicy bounds how long sessions last
+type SessionPolicy struct {
+	// IdleTimeout ends a session once it goes unused for that long
+	IdleTimeout time.Duration
+
+	// Lifetime ends every session that long after login, however busy it is
+	Lifetime time.Duration
+}
+
+// endsAt is when a session started at createdAt ends, whatever its use
+func (p SessionPolicy) endsAt(createdAt time.Time) time.Time {
+	return createdAt.Add(p.Lifetime)
+}
+
+// expiresAt is when a session started at createdAt, and last used at lastSeenAt, ends
+func (p SessionPolicy) expiresAt(createdAt time.Time, lastSeenAt time.Time) time.Time {
+	expiresAt := lastSeenAt.Add(p.IdleTimeout)
+	if endsAt := p.endsAt(createdAt); endsAt.Before(expiresAt) {
+		return endsAt
+	}
+
+	return expiresAt
+}
+
+// OpenCache connects to the cache named by the cdsn:
+// `memory://` for local dev and tests, and redis otherwise
+func OpenCache(cdsn string, policy SessionPolicy) (*Caches, error) {
+	if strings.HasPrefix(cdsn, "memory://") {
+		return NewMemoryCaches(policy), nil
+	}
+
+	return NewRedisCaches(cdsn, policy)
+}
+
+// sortSessions orders sessions from the most recently used
+func sortSessions(sessions []models.Session) {
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].LastSeenAt.Equal(sessions[j].LastSeenAt) {
+			return sessions[i].SessionID < sessions[j].SessionID
+		}
+
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+}
+
+// newSessionID generates an unguessable session id
+func newSessionID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand only fails if the os is badly broken
+		panic(err)
+	}
+
+	return fmt.Sprintf("%x", b)
+}
+
+// newRefreshToken generates a refresh token for a session, naming the session it belongs to
+func newRefreshToken(sessionID string) string {
+	return fmt.Sprintf("%v.%v", sessionID, newSessionID())
+}
+
+// sessionOfRefreshToken reads the id of the session a refresh token claims to belong to
+func sessionOfRefreshToken(refreshToken string) (string, bool) {
+	parts := strings.SplitN(refreshToken, ".", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", false
+	}
+
+	return parts[0], true
+}
+
+// hashRefreshToken is how refresh tokens are kept, so that a leaked cache leaks none
+func hashRefreshToken(refreshToken string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(refreshToken)))
+}
+
+// now is the current time, as the stores record it
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+// newUUID generates a random (version 4) uuid, for backends that cannot
+func newUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand only fails if the os is badly broken
+		panic(err)
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
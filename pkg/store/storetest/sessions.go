@@ -0,0 +1,428 @@
+package storetest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/store"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+)
+
+// RunCaches runs the cache half of the suite, opening fresh, empty caches for every test
+// Sessions must expire as policy says
+func RunCaches(t *testing.T, open func(t *testing.T, policy store.SessionPolicy) *store.Caches) {
+	tests := map[string]func(t *testing.T, open func(t *testing.T, policy store.SessionPolicy) *store.Caches){
+		"sessions":           testSessions,
+		"session rotation":   testSessionRotation,
+		"session revocation": testSessionRevocation,
+		"session expiry":     testSessionExpiry,
+		"session lifetime":   testSessionLifetime,
+		"login attempts":     testLoginAttempts,
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			test(t, open)
+		})
+	}
+}
+
+// createSession logs userID in on a device, returning the new session
+func createSession(t *testing.T, c *store.Caches, userID string, userAgent string) *models.Session {
+	t.Helper()
+
+	session, err := c.Sessions.CreateSession(userID, userAgent, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("CreateSession(%v, %v): %v", userID, userAgent, err)
+	}
+
+	return session
+}
+
+func testSessions(t *testing.T, open func(t *testing.T, policy store.SessionPolicy) *store.Caches) {
+	c := open(t, store.SessionPolicy{IdleTimeout: time.Hour, Lifetime: 24 * time.Hour})
+	defer c.Close()
+
+	laptop := createSession(t, c, "ada", "laptop")
+	phone := createSession(t, c, "ada", "phone")
+
+	if laptop.SessionID == "" || laptop.SessionID == phone.SessionID {
+		t.Fatalf("CreateSession returned ids %q and %q, expected distinct ones", laptop.SessionID, phone.SessionID)
+	}
+
+	if laptop.RefreshToken == "" || laptop.RefreshToken == phone.RefreshToken {
+		t.Fatalf("CreateSession returned refresh tokens %q and %q, expected distinct ones", laptop.RefreshToken, phone.RefreshToken)
+	}
+
+	createSession(t, c, "bob", "desktop")
+
+	time.Sleep(10 * time.Millisecond)
+	touched, err := c.Sessions.TouchSession(laptop.SessionID)
+	if err != nil || touched.UserID != "ada" || touched.UserAgent != "laptop" || touched.IP != "10.0.0.1" {
+		t.Fatalf("TouchSession: got %+v, %v", touched, err)
+	}
+
+	if !touched.LastSeenAt.After(laptop.LastSeenAt) || !touched.ExpiresAt.After(laptop.ExpiresAt) {
+		t.Errorf("TouchSession did not extend the session: before %+v, after %+v", laptop, touched)
+	}
+
+	_, err = c.Sessions.TouchSession("no-such-session")
+	expectErr(t, "TouchSession of an unknown session", err, utils.ErrSessionNotFound)
+
+	sessions, err := c.Sessions.GetSessions("ada")
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("GetSessions: got %+v, %v, expected 2 sessions", sessions, err)
+	}
+
+	if sessions[0].SessionID != laptop.SessionID {
+		t.Errorf("GetSessions: expected the most recently used session first, got %+v", sessions)
+	}
+}
+
+func testSessionRotation(t *testing.T, open func(t *testing.T, policy store.SessionPolicy) *store.Caches) {
+	c := open(t, store.SessionPolicy{IdleTimeout: time.Hour, Lifetime: 24 * time.Hour})
+	defer c.Close()
+
+	laptop := createSession(t, c, "ada", "laptop")
+	phone := createSession(t, c, "ada", "phone")
+
+	rotated, err := c.Sessions.RotateSession(laptop.RefreshToken)
+	if err != nil || rotated.SessionID != laptop.SessionID || rotated.UserID != "ada" {
+		t.Fatalf("RotateSession: got %+v, %v", rotated, err)
+	}
+
+	if rotated.RefreshToken == "" || rotated.RefreshToken == laptop.RefreshToken {
+		t.Fatalf("RotateSession returned refresh token %q, expected a new one", rotated.RefreshToken)
+	}
+
+	again, err := c.Sessions.RotateSession(rotated.RefreshToken)
+	if err != nil || again.SessionID != laptop.SessionID {
+		t.Fatalf("RotateSession of a rotated token: got %+v, %v", again, err)
+	}
+
+	_, err = c.Sessions.RotateSession("no-such-session.token")
+	expectErr(t, "RotateSession of an unknown session", err, utils.ErrSessionNotFound)
+
+	_, err = c.Sessions.RotateSession("malformed")
+	expectErr(t, "RotateSession of a malformed token", err, utils.ErrSessionNotFound)
+
+	_, err = c.Sessions.RotateSession(laptop.SessionID + ".forged")
+	expectErr(t, "RotateSession of a forged token", err, utils.ErrSessionNotFound)
+
+	_, err = c.Sessions.TouchSession(laptop.SessionID)
+	if err != nil {
+		t.Fatalf("a forged refresh token revoked the session: %v", err)
+	}
+
+	// a spent token in use means it leaked, so the whole session goes
+	_, err = c.Sessions.RotateSession(laptop.RefreshToken)
+	expectErr(t, "RotateSession of a spent token", err, utils.ErrRefreshTokenReused)
+
+	_, err = c.Sessions.TouchSession(laptop.SessionID)
+	expectErr(t, "TouchSession after a spent token was reused", err, utils.ErrSessionNotFound)
+
+	_, err = c.Sessions.RotateSession(again.RefreshToken)
+	expectErr(t, "RotateSession after a spent token was reused", err, utils.ErrSessionNotFound)
+
+	_, err = c.Sessions.RotateSession(phone.RefreshToken)
+	if err != nil {
+		t.Errorf("reusing a spent token revoked another session: %v", err)
+	}
+}
+
+func testSessionRevocation(t *testing.T, open func(t *testing.T, policy store.SessionPolicy) *store.Caches) {
+	c := open(t, store.SessionPolicy{IdleTimeout: time.Hour, Lifetime: 24 * time.Hour})
+	defer c.Close()
+
+	laptop := createSession(t, c, "ada", "laptop")
+	phone := createSession(t, c, "ada", "phone")
+	tablet := createSession(t, c, "ada", "tablet")
+	desktop := createSession(t, c, "bob", "desktop")
+
+	err := c.Sessions.RevokeSession(laptop.SessionID, "bob")
+	expectErr(t, "RevokeSession of another user's session", err, utils.ErrSessionNotFound)
+
+	err = c.Sessions.RevokeSession(laptop.SessionID, "ada")
+	if err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+
+	_, err = c.Sessions.TouchSession(laptop.SessionID)
+	expectErr(t, "TouchSession of a revoked session", err, utils.ErrSessionNotFound)
+
+	err = c.Sessions.RevokeSession(laptop.SessionID, "ada")
+	expectErr(t, "RevokeSession of a revoked session", err, utils.ErrSessionNotFound)
+
+	err = c.Sessions.RevokeSessions("ada")
+	if err != nil {
+		t.Fatalf("RevokeSessions: %v", err)
+	}
+
+	for _, revoked := range []string{phone.SessionID, tablet.SessionID} {
+		_, err = c.Sessions.TouchSession(revoked)
+		expectErr(t, "TouchSession after RevokeSessions", err, utils.ErrSessionNotFound)
+	}
+
+	sessions, err := c.Sessions.GetSessions("ada")
+	if err != nil || len(sessions) != 0 {
+		t.Errorf("GetSessions after RevokeSessions: got %+v, %v, expected none", sessions, err)
+	}
+
+	_, err = c.Sessions.TouchSession(desktop.SessionID)
+	if err != nil {
+		t.Errorf("RevokeSessions revoked another user's session: %v", err)
+	}
+}
+
+func testSessionExpiry(t *testing.T, open func(t *testing.T, policy store.SessionPolicy) *store.Caches) {
+	c := open(t, store.SessionPolicy{IdleTimeout: 200 * time.Millisecond, Lifetime: time.Hour})
+	defer c.Close()
+
+	idle := createSession(t, c, "ada", "laptop")
+	busy := createSession(t, c, "ada", "phone")
+
+	// keep one session in use for longer than the ttl
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+
+		_, err := c.Sessions.TouchSession(busy.SessionID)
+		if err != nil {
+			t.Fatalf("TouchSession of a session in use: %v", err)
+		}
+	}
+
+	_, err := c.Sessions.TouchSession(idle.SessionID)
+	expectErr(t, "TouchSession of an idle session", err, utils.ErrSessionNotFound)
+
+	sessions, err := c.Sessions.GetSessions("ada")
+	if err != nil || len(sessions) != 1 || sessions[0].SessionID != busy.SessionID {
+		t.Errorf("GetSessions: got %+v, %v, expected only the session in use", sessions, err)
+	}
+}
+
+func testSessionLifetime(t *testing.T, open func(t *testing.T, policy store.SessionPolicy) *store.Caches) {
+	c := open(t, store.SessionPolicy{IdleTimeout: time.Hour, Lifetime: 300 * time.Millisecond})
+	defer c.Close()
+
+	session := createSession(t, c, "ada", "laptop")
+	if !session.ExpiresAt.Equal(session.CreatedAt.Add(300 * time.Millisecond)) {
+		t.Errorf("CreateSession: expected the session to expire at the end of its lifetime, got %+v", session)
+	}
+
+	// however busy a session is, it ends with its lifetime
+	refreshToken := session.RefreshToken
+	for i := 0; i < 2; i++ {
+		time.Sleep(100 * time.Millisecond)
+
+		rotated, err := c.Sessions.RotateSession(refreshToken)
+		if err != nil {
+			t.Fatalf("RotateSession within the session's lifetime: %v", err)
+		}
+
+		refreshToken = rotated.RefreshToken
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	_, err := c.Sessions.RotateSession(refreshToken)
+	expectErr(t, "RotateSession after the session's lifetime", err, utils.ErrSessionNotFound)
+
+	_, err = c.Sessions.TouchSession(session.SessionID)
+	expectErr(t, "TouchSession after the session's lifetime", err, utils.ErrSessionNotFound)
+}
+
+func testLoginAttempts(t *testing.T, open func(t *testing.T, policy store.SessionPolicy) *store.Caches) {
+	c := open(t, store.SessionPolicy{IdleTimeout: time.Hour, Lifetime: 24 * time.Hour})
+	defer c.Close()
+
+	adaKey, bobKey := models.AccountAttemptsKey("ada@example.com"), models.AccountAttemptsKey("bob@example.com")
+
+	attempts, err := c.LoginAttempts.GetLoginAttempts(adaKey)
+	if err != nil || attempts.Failures != 0 {
+		t.Fatalf("GetLoginAttempts before any failed: got %+v, %v, expected none", attempts, err)
+	}
+
+	// reserving a login returns those counted before it
+	for i := 0; i < 3; i++ {
+		attempts, err = c.LoginAttempts.ReserveLoginAttempt(adaKey, 300*time.Millisecond)
+		if err != nil || attempts.Failures != i || attempts.LastFailedAt.IsZero() != (i == 0) {
+			t.Fatalf("ReserveLoginAttempt #%v: got %+v, %v", i, attempts, err)
+		}
+	}
+
+	_, err = c.LoginAttempts.ReserveLoginAttempt(bobKey, time.Hour)
+	if err != nil {
+		t.Fatalf("ReserveLoginAttempt: %v", err)
+	}
+
+	attempts, err = c.LoginAttempts.GetLoginAttempts(adaKey)
+	if err != nil || attempts.Failures != 3 {
+		t.Errorf("GetLoginAttempts: got %+v, %v, expected 3 failures", attempts, err)
+	}
+
+	// failures are forgotten once their window passes without another
+	time.Sleep(350 * time.Millisecond)
+
+	attempts, err = c.LoginAttempts.GetLoginAttempts(adaKey)
+	if err != nil || attempts.Failures != 0 {
+		t.Errorf("GetLoginAttempts after the window: got %+v, %v, expected none", attempts, err)
+	}
+
+	err = c.LoginAttempts.ClearLoginAttempts(bobKey)
+	if err != nil {
+		t.Fatalf("ClearLoginAttempts: %v", err)
+	}
+
+	attempts, err = c.LoginAttempts.GetLoginAttempts(bobKey)
+	if err != nil || attempts.Failures != 0 {
+		t.Errorf("GetLoginAttempts after ClearLoginAttempts: got %+v, %v, expected none", attempts, err)
+	}
+
+	// released logins are uncounted, down to none, and no further
+	reservations := []*models.LoginAttempts{}
+	for i := 0; i < 2; i++ {
+		attempts, err = c.LoginAttempts.ReserveLoginAttempt(bobKey, time.Hour)
+		if err != nil {
+			t.Fatalf("ReserveLoginAttempt: %v", err)
+		}
+
+		reservations = append(reservations, attempts)
+	}
+
+	for i := 0; i < 3; i++ {
+		expected := 1 - i
+		if expected < 0 {
+			expected = 0
+		}
+
+		err = c.LoginAttempts.ReleaseLoginAttempt(bobKey, reservations[expected])
+		if err != nil {
+			t.Fatalf("ReleaseLoginAttempt #%v: %v", i, err)
+		}
+
+		attempts, err = c.LoginAttempts.GetLoginAttempts(bobKey)
+		if err != nil || attempts.Failures != expected {
+			t.Errorf("GetLoginAttempts after ReleaseLoginAttempt #%v: got %+v, %v, expected %v failures", i, attempts, err, expected)
+		}
+	}
+
+	// released logins take back their failure time, unless logins reserved after them are still counted
+	_, err = c.LoginAttempts.ReserveLoginAttempt(bobKey, time.Hour)
+	if err != nil {
+		t.Fatalf("ReserveLoginAttempt: %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	released, err := c.LoginAttempts.ReserveLoginAttempt(bobKey, time.Hour)
+	if err != nil {
+		t.Fatalf("ReserveLoginAttempt: %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	err = c.LoginAttempts.ReleaseLoginAttempt(bobKey, released)
+	if err != nil {
+		t.Fatalf("ReleaseLoginAttempt: %v", err)
+	}
+
+	attempts, err = c.LoginAttempts.GetLoginAttempts(bobKey)
+	if err != nil || attempts.Failures != 1 || !attempts.LastFailedAt.Equal(released.LastFailedAt) {
+		t.Errorf("GetLoginAttempts after ReleaseLoginAttempt: got %+v, %v, expected 1 failure at %v", attempts, err, released.LastFailedAt)
+	}
+
+	released, err = c.LoginAttempts.ReserveLoginAttempt(bobKey, time.Hour)
+	if err == nil {
+		time.Sleep(10 * time.Millisecond)
+		_, err = c.LoginAttempts.ReserveLoginAttempt(bobKey, time.Hour)
+	}
+	if err == nil {
+		err = c.LoginAttempts.ReleaseLoginAttempt(bobKey, released)
+	}
+	if err != nil {
+		t.Fatalf("reserving two logins, and releasing the first: %v", err)
+	}
+
+	attempts, err = c.LoginAttempts.GetLoginAttempts(bobKey)
+	if err != nil || attempts.Failures != 2 || !attempts.LastFailedAt.After(released.LastFailedAt) {
+		t.Errorf("GetLoginAttempts after releasing a login reserved before another: got %+v, %v, expected 2 failures, the last of them after %v", attempts, err, released.LastFailedAt)
+	}
+
+	err = c.LoginAttempts.ClearLoginAttempts(bobKey)
+	if err != nil {
+		t.Fatalf("ClearLoginAttempts: %v", err)
+	}
+
+	// racing logins each see those reserved ahead of them, so that no two are judged on the same count
+	const racing = 50
+	reserved := make(chan int, racing)
+	var wg sync.WaitGroup
+	for i := 0; i < racing; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempts, err := c.LoginAttempts.ReserveLoginAttempt(bobKey, time.Hour)
+			if err != nil {
+				t.Errorf("ReserveLoginAttempt: %v", err)
+				return
+			}
+
+			reserved <- attempts.Failures
+		}()
+	}
+	wg.Wait()
+	close(reserved)
+
+	seen := map[int]bool{}
+	for failures := range reserved {
+		if seen[failures] || failures < 0 || failures >= racing {
+			t.Errorf("ReserveLoginAttempt by racing logins: got %v failures twice, or out of range", failures)
+		}
+		seen[failures] = true
+	}
+
+	attempts, err = c.LoginAttempts.GetLoginAttempts(bobKey)
+	if err != nil || attempts.Failures != racing {
+		t.Errorf("GetLoginAttempts after racing logins: got %+v, %v, expected %v failures", attempts, err, racing)
+	}
+
+	err = c.LoginAttempts.ClearLoginAttempts(bobKey)
+	if err != nil {
+		t.Fatalf("ClearLoginAttempts: %v", err)
+	}
+
+	// unlock tokens clear the account they name, once
+	_, err = c.LoginAttempts.ReserveLoginAttempt(bobKey, time.Hour)
+	if err != nil {
+		t.Fatalf("ReserveLoginAttempt: %v", err)
+	}
+
+	token, err := c.LoginAttempts.CreateUnlockToken("bob@example.com", time.Hour)
+	if err != nil || token == "" {
+		t.Fatalf("CreateUnlockToken: got %q, %v", token, err)
+	}
+
+	username, err := c.LoginAttempts.RedeemUnlockToken(token)
+	if err != nil || username != "bob@example.com" {
+		t.Fatalf("RedeemUnlockToken: got %q, %v, expected bob", username, err)
+	}
+
+	attempts, err = c.LoginAttempts.GetLoginAttempts(bobKey)
+	if err != nil || attempts.Failures != 0 {
+		t.Errorf("GetLoginAttempts after RedeemUnlockToken: got %+v, %v, expected none", attempts, err)
+	}
+
+	_, err = c.LoginAttempts.RedeemUnlockToken(token)
+	expectErr(t, "RedeemUnlockToken of a spent token", err, utils.ErrUnlockTokenInvalid)
+
+	expired, err := c.LoginAttempts.CreateUnlockToken("bob@example.com", 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CreateUnlockToken: %v", err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	_, err = c.LoginAttempts.RedeemUnlockToken(expired)
+	expectErr(t, "RedeemUnlockToken of an expired token", err, utils.ErrUnlockTokenInvalid)
+}
@@ -0,0 +1,1604 @@
+// package storetest is a conformance suite that every store backend must pass
+//
+// A backend's tests run it against freshly opened, empty stores:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) *store.Stores {
+//			return store.NewMemoryStores()
+//		})
+//	}
+package storetest
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dmithamo/timelineapi/pkg/dbservice"
+	"github.com/dmithamo/timelineapi/pkg/models"
+	"github.com/dmithamo/timelineapi/pkg/security"
+	"github.com/dmithamo/timelineapi/pkg/store"
+	"github.com/dmithamo/timelineapi/pkg/utils"
+)
+
+// password satisfies the password rules, for every user created by the suite
+const password = "Pa55word!"
+
+// missingID is a well-formed uuid that no row ever has
+const missingID = "00000000-0000-4000-8000-000000000000"
+
+// Run runs the whole suite, opening fresh, empty stores for every test
+func Run(t *testing.T, open func(t *testing.T) *store.Stores) {
+	tests := map[string]func(t *testing.T, s *store.Stores){
+		"users":      testUsers,
+		"actions":    testActions,
+		"lifecycle":  testLifecycle,
+		"pagination": testPagination,
+		"sharing":    testSharing,
+		"outputs":    testOutputs,
+		"search":     testSearch,
+		"api keys":   testAPIKeys,
+		"roles":      testRoles,
+		"workspaces": testWorkspaces,
+		"last owner": testLastOwner,
+		"profiles":   testProfiles,
+
+		"password resets":     testPasswordResets,
+		"email verifications": testEmailVerifications,
+		"mfa":                 testMFA,
+		"identities":          testIdentities,
+		"account deletion":    testAccountDeletion,
+	}
+
+	// totp secrets are sealed before they are stored, as the api does once it has its key
+	err := security.SetTOTPKey("storetest")
+	if err != nil {
+		t.Fatalf("SetTOTPKey: %v", err)
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			defer s.Close()
+
+			test(t, s)
+		})
+	}
+}
+
+// createUser registers a user and logs them in, returning their id
+func createUser(t *testing.T, s *store.Stores, username string) string {
+	t.Helper()
+	credentials := &models.UserCredentials{Username: username, Password: password}
+
+	err := s.Users.CreateUser(credentials)
+	if err != nil {
+		t.Fatalf("CreateUser(%v): %v", username, err)
+	}
+
+	user, err := s.Users.GetByCredentials(credentials)
+	if err != nil {
+		t.Fatalf("GetByCredentials(%v): %v", username, err)
+	}
+
+	return user.UserID
+}
+
+// verifyEmail verifies the email a user signed up with
+func verifyEmail(t *testing.T, s *store.Stores, username string) {
+	t.Helper()
+
+	verification, err := s.EmailVerifications.CreateEmailVerification(username, time.Hour, 0)
+	if err == nil {
+		_, err = s.EmailVerifications.VerifyEmail(verification.Token)
+	}
+	if err != nil {
+		t.Fatalf("verifying %v's email: %v", username, err)
+	}
+}
+
+// createAction creates an action where actor acts, returning it
+func createAction(t *testing.T, s *store.Stores, actor models.Actor, title string) *models.Action {
+	t.Helper()
+
+	err := s.Actions.CreateAction(models.ActionParams{Title: title, Description: fmt.Sprintf("all about %v", title)}, actor)
+	if err != nil {
+		t.Fatalf("CreateAction(%v): %v", title, err)
+	}
+
+	page, err := s.Actions.GetActions(actor, parseQuery(t, url.Values{"limit": {"100"}}))
+	if err != nil {
+		t.Fatalf("GetActions: %v", err)
+	}
+
+	for _, action := range page.Actions {
+		if action.Title == title {
+			return &action
+		}
+	}
+
+	t.Fatalf("created action %v is missing from GetActions", title)
+	return nil
+}
+
+// createOutput creates an output of actionID, returning it
+func createOutput(t *testing.T, s *store.Stores, actor models.Actor, actionID string, title string) *models.Output {
+	t.Helper()
+
+	err := s.Outputs.CreateOutput(models.OutputParams{Title: title, Description: fmt.Sprintf("all about %v", title), ActionID: actionID}, actor)
+	if err != nil {
+		t.Fatalf("CreateOutput(%v): %v", title, err)
+	}
+
+	outputs, err := s.Outputs.GetOutputsByAction(actionID, actor)
+	if err != nil {
+		t.Fatalf("GetOutputsByAction: %v", err)
+	}
+
+	for _, output := range outputs {
+		if output.Title == title {
+			return &output
+		}
+	}
+
+	t.Fatalf("created output %v is missing from GetOutputsByAction", title)
+	return nil
+}
+
+// personal is userID acting in their personal space
+func personal(userID string) models.Actor {
+	return models.Actor{UserID: userID}
+}
+
+// parseQuery parses action query params, failing the test if they are invalid
+func parseQuery(t *testing.T, values url.Values) *models.ActionQuery {
+	t.Helper()
+
+	query, err := models.NewActionQueryParams(values).Parse()
+	if err != nil {
+		t.Fatalf("Parse(%v): %v", values, err)
+	}
+
+	return query
+}
+
+// expectErr fails the test unless err is the expected kind of err:
+// sql.ErrNoRows, a *dbservice.DuplicateEntryErr, or one of the utils sentinel errs
+func expectErr(t *testing.T, what string, err error, expected error) {
+	t.Helper()
+	var duplicateErr *dbservice.DuplicateEntryErr
+
+	if _, ok := expected.(*dbservice.DuplicateEntryErr); ok {
+		if !errors.As(err, &duplicateErr) {
+			t.Errorf("%v: expected a duplicate entry err, got %v", what, err)
+		}
+		return
+	}
+
+	if !errors.Is(err, expected) {
+		t.Errorf("%v: expected err %v, got %v", what, expected, err)
+	}
+}
+
+func testUsers(t *testing.T, s *store.Stores) {
+	userID := createUser(t, s, "ada@example.com")
+	if userID == "" {
+		t.Fatal("GetByCredentials returned no userID")
+	}
+
+	err := s.Users.CreateUser(&models.UserCredentials{Username: "ada@example.com", Password: password})
+	expectErr(t, "CreateUser with a taken username", err, &dbservice.DuplicateEntryErr{})
+
+	_, err = s.Users.GetByCredentials(&models.UserCredentials{Username: "ada@example.com", Password: "Wr0ng!pass"})
+	expectErr(t, "GetByCredentials with a wrong password", err, utils.ErrWrongPassword)
+
+	_, err = s.Users.GetByCredentials(&models.UserCredentials{Username: "nobody@example.com", Password: password})
+	expectErr(t, "GetByCredentials with an unknown username", err, sql.ErrNoRows)
+}
+
+func testActions(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+	action := createAction(t, s, personal(ada), "Write the engine notes")
+
+	if action.UserID != ada || action.IsArchived || action.CreatedAt.IsZero() {
+		t.Errorf("unexpected action %+v", action)
+	}
+
+	err := s.Actions.CreateAction(models.ActionParams{Title: "Write the engine notes", Description: "again"}, personal(ada))
+	expectErr(t, "CreateAction with a taken title", err, &dbservice.DuplicateEntryErr{})
+
+	found, err := s.Actions.GetActionByID(action.ActionID, personal(ada))
+	if err != nil || found.Title != action.Title {
+		t.Errorf("GetActionByID by its owner: %+v, %v", found, err)
+	}
+
+	_, err = s.Actions.GetActionByID(action.ActionID, personal(bob))
+	expectErr(t, "GetActionByID by a stranger", err, sql.ErrNoRows)
+
+	page, err := s.Actions.GetActions(personal(bob), parseQuery(t, url.Values{}))
+	if err != nil || len(page.Actions) != 0 {
+		t.Errorf("GetActions by a stranger: %+v, %v", page, err)
+	}
+
+	updated, err := s.Actions.UpdateAction(action.ActionID, personal(ada), models.ActionParams{Description: "Notes on the analytical engine"})
+	if err != nil || updated.Title != action.Title || updated.Description != "Notes on the analytical engine" {
+		t.Errorf("partial UpdateAction: %+v, %v", updated, err)
+	}
+
+	_, err = s.Actions.UpdateAction(action.ActionID, personal(bob), models.ActionParams{Title: "Hijacked"})
+	expectErr(t, "UpdateAction by a stranger", err, sql.ErrNoRows)
+
+	createAction(t, s, personal(ada), "Another action")
+	_, err = s.Actions.UpdateAction(action.ActionID, personal(ada), models.ActionParams{Title: "Another action"})
+	expectErr(t, "UpdateAction to a taken title", err, &dbservice.DuplicateEntryErr{})
+
+	_, err = s.Actions.UpdateAction(action.ActionID, personal(ada), models.ActionParams{})
+	expectErr(t, "UpdateAction without params", err, utils.ErrNoUpdateParams)
+
+	// titles are only unique within their owner's personal space, so that others' are neither clashed with nor revealed
+	createAction(t, s, personal(bob), "Write the engine notes")
+}
+
+func testLifecycle(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+	action := createAction(t, s, personal(ada), "Short lived action")
+
+	err := s.Actions.PurgeAction(action.ActionID, personal(ada))
+	expectErr(t, "PurgeAction of a live action", err, sql.ErrNoRows)
+
+	err = s.Actions.ArchiveAction(action.ActionID, personal(bob))
+	expectErr(t, "ArchiveAction by a stranger", err, sql.ErrNoRows)
+
+	err = s.Actions.ArchiveAction(action.ActionID, personal(ada))
+	if err != nil {
+		t.Fatalf("ArchiveAction: %v", err)
+	}
+
+	_, err = s.Actions.GetActionByID(action.ActionID, personal(ada))
+	expectErr(t, "GetActionByID of an archived action", err, sql.ErrNoRows)
+
+	page, err := s.Actions.GetActions(personal(ada), parseQuery(t, url.Values{"archived": {"true"}}))
+	if err != nil || len(page.Actions) != 1 || page.Actions[0].ArchivedAt == nil {
+		t.Errorf("GetActions of archived actions: %+v, %v", page, err)
+	}
+
+	_, err = s.Actions.RestoreAction(action.ActionID, personal(bob))
+	expectErr(t, "RestoreAction by a stranger", err, sql.ErrNoRows)
+
+	restored, err := s.Actions.RestoreAction(action.ActionID, personal(ada))
+	if err != nil || restored.IsArchived || restored.ArchivedAt != nil {
+		t.Errorf("RestoreAction: %+v, %v", restored, err)
+	}
+
+	err = s.Actions.ArchiveAction(action.ActionID, personal(ada))
+	if err != nil {
+		t.Fatalf("ArchiveAction: %v", err)
+	}
+
+	purged, err := s.Actions.PurgeArchivedActions(24 * 60 * 60 * 1e9)
+	if err != nil || purged != 0 {
+		t.Errorf("PurgeArchivedActions within the retention window: %v, %v", purged, err)
+	}
+
+	err = s.Actions.PurgeAction(action.ActionID, personal(bob))
+	expectErr(t, "PurgeAction by a stranger", err, sql.ErrNoRows)
+
+	err = s.Actions.PurgeAction(action.ActionID, personal(ada))
+	if err != nil {
+		t.Fatalf("PurgeAction: %v", err)
+	}
+
+	_, err = s.Actions.RestoreAction(action.ActionID, personal(ada))
+	expectErr(t, "RestoreAction of a purged action", err, sql.ErrNoRows)
+
+	// admins purge archived actions whoever owns them, and only archived ones
+	other := createAction(t, s, personal(bob), "Bob's action")
+	err = s.Actions.PurgeAnyAction(other.ActionID)
+	expectErr(t, "PurgeAnyAction of a live action", err, sql.ErrNoRows)
+
+	err = s.Actions.ArchiveAction(other.ActionID, personal(bob))
+	if err != nil {
+		t.Fatalf("ArchiveAction: %v", err)
+	}
+
+	err = s.Actions.PurgeAnyAction(other.ActionID)
+	if err != nil {
+		t.Fatalf("PurgeAnyAction: %v", err)
+	}
+
+	_, err = s.Actions.GetAnyAction(other.ActionID)
+	expectErr(t, "GetAnyAction of a purged action", err, sql.ErrNoRows)
+
+	err = s.Actions.PurgeAnyAction(missingID)
+	expectErr(t, "PurgeAnyAction of an unknown action", err, sql.ErrNoRows)
+}
+
+func testPagination(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	for _, title := range []string{"Action one", "Action two", "Action three", "Action four", "Action five"} {
+		createAction(t, s, personal(ada), title)
+	}
+
+	for _, sort := range []string{"createdAt", "updatedAt", "title"} {
+		for _, order := range []string{"asc", "desc"} {
+			values := url.Values{"sort": {sort}, "order": {order}}
+
+			all, err := s.Actions.GetActions(personal(ada), parseQuery(t, values))
+			if err != nil || len(all.Actions) != 5 || all.Next != "" || all.Prev != "" {
+				t.Fatalf("GetActions sorted by %v %v: %+v, %v", sort, order, all, err)
+			}
+
+			// walk forwards two at a time...
+			values.Set("limit", "2")
+			pages := []*models.ActionPage{}
+			for cursor := ""; ; {
+				values.Set("cursor", cursor)
+				if cursor == "" {
+					values.Del("cursor")
+				}
+
+				page, err := s.Actions.GetActions(personal(ada), parseQuery(t, values))
+				if err != nil {
+					t.Fatalf("GetActions page: %v", err)
+				}
+				pages = append(pages, page)
+
+				if page.Next == "" {
+					break
+				}
+				cursor = page.Next
+			}
+
+			walked := []models.Action{}
+			for _, page := range pages {
+				walked = append(walked, page.Actions...)
+			}
+
+			if len(pages) != 3 || len(walked) != 5 || pages[0].Prev != "" {
+				t.Fatalf("paging by %v %v: got %v pages of %v actions", sort, order, len(pages), len(walked))
+			}
+
+			for i := range walked {
+				if walked[i].ActionID != all.Actions[i].ActionID {
+					t.Errorf("paging by %v %v: action %v is %v, expected %v", sort, order, i, walked[i].Title, all.Actions[i].Title)
+				}
+			}
+
+			// ...then back from the last page
+			values.Set("cursor", pages[2].Prev)
+			back, err := s.Actions.GetActions(personal(ada), parseQuery(t, values))
+			if err != nil || len(back.Actions) != 2 || back.Actions[0].ActionID != pages[1].Actions[0].ActionID || back.Prev == "" || back.Next == "" {
+				t.Errorf("paging back by %v %v: %+v, %v", sort, order, back, err)
+			}
+		}
+	}
+
+	filtered, err := s.Actions.GetActions(personal(ada), parseQuery(t, url.Values{"createdAfter": {"2999-01-01"}}))
+	if err != nil || len(filtered.Actions) != 0 {
+		t.Errorf("GetActions created in the future: %+v, %v", filtered, err)
+	}
+}
+
+func testSharing(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+	action := createAction(t, s, personal(ada), "Shared action")
+
+	// sharing with an unknown user succeeds, as though they had an account, and grants nothing
+	err := s.Actions.ShareAction(action.ActionID, personal(ada), models.ShareParams{Username: "nobody@example.com"})
+	if err != nil {
+		t.Errorf("ShareAction with an unknown user: %v", err)
+	}
+
+	err = s.Actions.ShareAction(action.ActionID, personal(ada), models.ShareParams{Username: "ada@example.com"})
+	expectErr(t, "ShareAction with its owner", err, utils.ErrShareWithOwner)
+
+	err = s.Actions.ShareAction(action.ActionID, personal(bob), models.ShareParams{Username: "bob@example.com"})
+	expectErr(t, "ShareAction by a stranger", err, sql.ErrNoRows)
+
+	for i := 0; i < 2; i++ {
+		err = s.Actions.ShareAction(action.ActionID, personal(ada), models.ShareParams{Username: "bob@example.com"})
+		if err != nil {
+			t.Fatalf("ShareAction: %v", err)
+		}
+	}
+
+	shares, err := s.Actions.GetActionShares(action.ActionID, personal(ada))
+	if err != nil || len(shares) != 1 || shares[0].UserID != bob || shares[0].Username != "bob@example.com" {
+		t.Errorf("GetActionShares: %+v, %v", shares, err)
+	}
+
+	found, err := s.Actions.GetActionByID(action.ActionID, personal(bob))
+	if err != nil || found.UserID != ada {
+		t.Errorf("GetActionByID by a sharee: %+v, %v", found, err)
+	}
+
+	page, err := s.Actions.GetActions(personal(bob), parseQuery(t, url.Values{}))
+	if err != nil || len(page.Actions) != 1 {
+		t.Errorf("GetActions by a sharee: %+v, %v", page, err)
+	}
+
+	_, err = s.Actions.UpdateAction(action.ActionID, personal(bob), models.ActionParams{Title: "Hijacked"})
+	expectErr(t, "UpdateAction by a sharee", err, utils.ErrForbidden)
+
+	_, err = s.Actions.GetActionShares(action.ActionID, personal(bob))
+	expectErr(t, "GetActionShares by a sharee", err, utils.ErrForbidden)
+
+	err = s.Actions.UnshareAction(action.ActionID, personal(ada), bob)
+	if err != nil {
+		t.Fatalf("UnshareAction: %v", err)
+	}
+
+	err = s.Actions.UnshareAction(action.ActionID, personal(ada), bob)
+	if err != nil {
+		t.Errorf("UnshareAction twice: %v", err)
+	}
+
+	_, err = s.Actions.GetActionByID(action.ActionID, personal(bob))
+	expectErr(t, "GetActionByID by a former sharee", err, sql.ErrNoRows)
+}
+
+func testOutputs(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+	action := createAction(t, s, personal(ada), "Action with outputs")
+	output := createOutput(t, s, personal(ada), action.ActionID, "First output")
+
+	if output.ActionID != action.ActionID || output.UserID != ada {
+		t.Errorf("unexpected output %+v", output)
+	}
+
+	err := s.Outputs.CreateOutput(models.OutputParams{Title: "First output", Description: "again", ActionID: action.ActionID}, personal(ada))
+	expectErr(t, "CreateOutput with a taken title", err, &dbservice.DuplicateEntryErr{})
+
+	// output titles are only unique within their action
+	cy := createUser(t, s, "cy@example.com")
+	other := createAction(t, s, personal(cy), "Action with outputs")
+	createOutput(t, s, personal(cy), other.ActionID, "First output")
+
+	err = s.Outputs.CreateOutput(models.OutputParams{Title: "Stray output", Description: "lost", ActionID: missingID}, personal(ada))
+	expectErr(t, "CreateOutput of a missing action", err, sql.ErrNoRows)
+
+	err = s.Outputs.CreateOutput(models.OutputParams{Title: "Stray output", Description: "lost", ActionID: action.ActionID}, personal(bob))
+	expectErr(t, "CreateOutput by a stranger", err, sql.ErrNoRows)
+
+	err = s.Actions.ShareAction(action.ActionID, personal(ada), models.ShareParams{Username: "bob@example.com"})
+	if err != nil {
+		t.Fatalf("ShareAction: %v", err)
+	}
+
+	err = s.Outputs.CreateOutput(models.OutputParams{Title: "Stray output", Description: "lost", ActionID: action.ActionID}, personal(bob))
+	expectErr(t, "CreateOutput by a sharee", err, utils.ErrForbidden)
+
+	outputs, err := s.Outputs.GetOutputs(personal(bob), false)
+	if err != nil || len(outputs) != 1 {
+		t.Errorf("GetOutputs by a sharee: %+v, %v", outputs, err)
+	}
+
+	_, err = s.Outputs.UpdateOutput(output.OutputID, personal(bob), models.OutputParams{Title: "Hijacked"})
+	expectErr(t, "UpdateOutput by a sharee", err, utils.ErrForbidden)
+
+	updated, err := s.Outputs.UpdateOutput(output.OutputID, personal(ada), models.OutputParams{Title: "Renamed output"})
+	if err != nil || updated.Title != "Renamed output" || updated.Description != output.Description {
+		t.Errorf("partial UpdateOutput: %+v, %v", updated, err)
+	}
+
+	err = s.Outputs.ArchiveOutput(output.OutputID, personal(ada))
+	if err != nil {
+		t.Fatalf("ArchiveOutput: %v", err)
+	}
+
+	_, err = s.Outputs.GetOutputByID(output.OutputID, personal(ada))
+	expectErr(t, "GetOutputByID of an archived output", err, sql.ErrNoRows)
+
+	archived, err := s.Outputs.GetOutputs(personal(ada), true)
+	if err != nil || len(archived) != 1 {
+		t.Errorf("GetOutputs of archived outputs: %+v, %v", archived, err)
+	}
+
+	archived, err = s.Outputs.GetOutputs(personal(bob), true)
+	if err != nil || len(archived) != 0 {
+		t.Errorf("GetOutputs of archived outputs by a sharee: %+v, %v", archived, err)
+	}
+
+	// outputs go with their action
+	createOutput(t, s, personal(ada), action.ActionID, "Second output")
+	err = s.Actions.ArchiveAction(action.ActionID, personal(ada))
+	if err != nil {
+		t.Fatalf("ArchiveAction: %v", err)
+	}
+
+	outputs, err = s.Outputs.GetOutputs(personal(ada), false)
+	if err != nil || len(outputs) != 0 {
+		t.Errorf("GetOutputs of an archived action: %+v, %v", outputs, err)
+	}
+
+	_, err = s.Outputs.GetOutputsByAction(action.ActionID, personal(ada))
+	expectErr(t, "GetOutputsByAction of an archived action", err, sql.ErrNoRows)
+}
+
+func testSearch(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+	action := createAction(t, s, personal(ada), "Calibrate the telescope")
+	createOutput(t, s, personal(ada), action.ActionID, "Telescope calibration report")
+	createAction(t, s, personal(bob), "Polish the telescope")
+
+	results, err := s.Search.Search(personal(ada), models.SearchParams{Query: "telescope"})
+	if err != nil || len(results) != 2 {
+		t.Fatalf("Search: %+v, %v", results, err)
+	}
+
+	kinds := map[string]bool{}
+	for _, result := range results {
+		kinds[result.Kind] = true
+		if result.Score <= 0 || result.ActionID != action.ActionID {
+			t.Errorf("unexpected search result %+v", result)
+		}
+	}
+
+	if !kinds["action"] || !kinds["output"] {
+		t.Errorf("Search should find both the action and its output, got %+v", results)
+	}
+
+	results, err = s.Search.Search(personal(ada), models.SearchParams{Query: "telescope", Limit: "1"})
+	if err != nil || len(results) != 1 {
+		t.Errorf("Search with a limit: %+v, %v", results, err)
+	}
+}
+
+func testAPIKeys(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+
+	params := models.APIKeyParams{Name: "ci", Scopes: []string{models.ScopeActionsRead, models.ScopeActionsWrite}}
+	created, err := s.APIKeys.CreateAPIKey(ada, params)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	if created.KeyID == "" || created.Key == "" || !strings.HasPrefix(created.Key, created.Prefix) || created.ExpiresAt != nil {
+		t.Errorf("CreateAPIKey returned %+v", created)
+	}
+
+	key, err := s.APIKeys.AuthenticateAPIKey(created.Key)
+	if err != nil || key.KeyID != created.KeyID || key.UserID != ada || key.LastUsedAt == nil {
+		t.Fatalf("AuthenticateAPIKey: %+v, %v", key, err)
+	}
+
+	if !key.HasScope(models.ScopeActionsWrite) || key.HasScope(models.ScopeOutputsRead) {
+		t.Errorf("AuthenticateAPIKey returned scopes %v, expected %v", key.Scopes, params.Scopes)
+	}
+
+	keys, err := s.APIKeys.GetAPIKeys(ada)
+	if err != nil || len(keys) != 1 || keys[0].Name != "ci" || keys[0].LastUsedAt == nil {
+		t.Fatalf("GetAPIKeys: %+v, %v", keys, err)
+	}
+
+	if keys[0].Key != "" || key.Key != "" {
+		t.Error("an API key was handed out again after it was created")
+	}
+
+	keys, err = s.APIKeys.GetAPIKeys(bob)
+	if err != nil || len(keys) != 0 {
+		t.Errorf("GetAPIKeys of another user: %+v, %v", keys, err)
+	}
+
+	_, err = s.APIKeys.AuthenticateAPIKey(created.Prefix)
+	expectErr(t, "AuthenticateAPIKey with a key's prefix", err, utils.ErrAPIKeyNotFound)
+
+	// expiry is only ever to the second on some dbs
+	expiresAt := time.Now().Add(2 * time.Second)
+	expiring, err := s.APIKeys.CreateAPIKey(ada, models.APIKeyParams{Name: "short lived", Scopes: []string{models.ScopeOutputsRead}, ExpiresAt: &expiresAt})
+	if err != nil || expiring.ExpiresAt == nil {
+		t.Fatalf("CreateAPIKey with an expiry: %+v, %v", expiring, err)
+	}
+
+	_, err = s.APIKeys.AuthenticateAPIKey(expiring.Key)
+	if err != nil {
+		t.Errorf("AuthenticateAPIKey before it expires: %v", err)
+	}
+
+	time.Sleep(2500 * time.Millisecond)
+
+	_, err = s.APIKeys.AuthenticateAPIKey(expiring.Key)
+	expectErr(t, "AuthenticateAPIKey after it expires", err, utils.ErrAPIKeyNotFound)
+
+	keys, err = s.APIKeys.GetAPIKeys(ada)
+	if err != nil || len(keys) != 2 {
+		t.Errorf("GetAPIKeys should still list expired keys, got %+v, %v", keys, err)
+	}
+
+	err = s.APIKeys.RevokeAPIKey(created.KeyID, bob)
+	expectErr(t, "RevokeAPIKey by a stranger", err, utils.ErrAPIKeyNotFound)
+
+	err = s.APIKeys.RevokeAPIKey(created.KeyID, ada)
+	if err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+
+	_, err = s.APIKeys.AuthenticateAPIKey(created.Key)
+	expectErr(t, "AuthenticateAPIKey after it was revoked", err, utils.ErrAPIKeyNotFound)
+
+	err = s.APIKeys.RevokeAPIKey(created.KeyID, ada)
+	expectErr(t, "RevokeAPIKey twice", err, utils.ErrAPIKeyNotFound)
+}
+
+func testRoles(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+
+	user, err := s.Users.GetByUUID(ada)
+	if err != nil || user.UserID != ada || user.Username != "ada@example.com" || user.Role != models.RoleUser || user.DisabledAt != nil {
+		t.Fatalf("GetByUUID: %+v, %v", user, err)
+	}
+
+	if user.Password != "" {
+		t.Error("GetByUUID handed out a password")
+	}
+
+	_, err = s.Users.GetByUUID(missingID)
+	expectErr(t, "GetByUUID of an unknown user", err, sql.ErrNoRows)
+
+	// the first admin is bootstrapped, and no one after them
+	user, err = s.Users.BootstrapAdmin(ada)
+	if err != nil || user.Role != models.RoleAdmin {
+		t.Fatalf("BootstrapAdmin: %+v, %v", user, err)
+	}
+
+	user, err = s.Users.BootstrapAdmin(bob)
+	if err != nil || user.Role != models.RoleUser {
+		t.Errorf("BootstrapAdmin once there is an admin: %+v, %v", user, err)
+	}
+
+	_, err = s.Users.BootstrapAdmin(missingID)
+	expectErr(t, "BootstrapAdmin of an unknown user", err, sql.ErrNoRows)
+
+	user, err = s.Users.SetRole(ada, models.RoleUser)
+	if err != nil || user.Role != models.RoleUser {
+		t.Fatalf("SetRole: %+v, %v", user, err)
+	}
+
+	user, err = s.Users.SetRole(ada, models.RoleAdmin)
+	if err != nil || user.Role != models.RoleAdmin {
+		t.Fatalf("SetRole: %+v, %v", user, err)
+	}
+
+	user, err = s.Users.GetByCredentials(&models.UserCredentials{Username: "ada@example.com", Password: password})
+	if err != nil || user.Role != models.RoleAdmin {
+		t.Errorf("GetByCredentials after SetRole: %+v, %v", user, err)
+	}
+
+	_, err = s.Users.SetRole(missingID, models.RoleAdmin)
+	expectErr(t, "SetRole of an unknown user", err, sql.ErrNoRows)
+
+	users, err := s.Users.GetUsers()
+	if err != nil || len(users) != 2 {
+		t.Fatalf("GetUsers: %+v, %v", users, err)
+	}
+
+	roles := map[string]string{}
+	for _, user := range users {
+		roles[user.UserID] = user.Role
+	}
+
+	if roles[ada] != models.RoleAdmin || roles[bob] != models.RoleUser {
+		t.Errorf("GetUsers returned roles %v", roles)
+	}
+
+	key, err := s.APIKeys.CreateAPIKey(bob, models.APIKeyParams{Name: "ci", Scopes: []string{models.ScopeActionsRead}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	user, err = s.Users.SetDisabled(bob, true)
+	if err != nil || user.DisabledAt == nil {
+		t.Fatalf("SetDisabled: %+v, %v", user, err)
+	}
+
+	disabledAt := *user.DisabledAt
+	user, err = s.Users.SetDisabled(bob, true)
+	if err != nil || user.DisabledAt == nil || !user.DisabledAt.Equal(disabledAt) {
+		t.Errorf("SetDisabled twice should keep when the user was first disabled: %+v, %v", user, err)
+	}
+
+	// disabled users are refused by the api, not the store, so that it can tell them why
+	user, err = s.Users.GetByCredentials(&models.UserCredentials{Username: "bob@example.com", Password: password})
+	if err != nil || user.DisabledAt == nil {
+		t.Errorf("GetByCredentials of a disabled user: %+v, %v", user, err)
+	}
+
+	_, err = s.APIKeys.AuthenticateAPIKey(key.Key)
+	expectErr(t, "AuthenticateAPIKey of a disabled user", err, utils.ErrAPIKeyNotFound)
+
+	user, err = s.Users.SetDisabled(bob, false)
+	if err != nil || user.DisabledAt != nil {
+		t.Fatalf("SetDisabled(false): %+v, %v", user, err)
+	}
+
+	_, err = s.APIKeys.AuthenticateAPIKey(key.Key)
+	if err != nil {
+		t.Errorf("AuthenticateAPIKey once the user is enabled again: %v", err)
+	}
+
+	_, err = s.Users.SetDisabled(missingID, true)
+	expectErr(t, "SetDisabled of an unknown user", err, sql.ErrNoRows)
+
+	// admins read any action, archived ones included
+	action := createAction(t, s, personal(bob), "Polish the brass")
+	err = s.Actions.ArchiveAction(action.ActionID, personal(bob))
+	if err != nil {
+		t.Fatalf("ArchiveAction: %v", err)
+	}
+
+	found, err := s.Actions.GetAnyAction(action.ActionID)
+	if err != nil || found.ActionID != action.ActionID || found.UserID != bob || !found.IsArchived {
+		t.Errorf("GetAnyAction: %+v, %v", found, err)
+	}
+
+	_, err = s.Actions.GetAnyAction(missingID)
+	expectErr(t, "GetAnyAction of an unknown action", err, sql.ErrNoRows)
+}
+
+func testWorkspaces(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+	cy := createUser(t, s, "cy@example.com")
+	verifyEmail(t, s, "bob@example.com")
+	verifyEmail(t, s, "cy@example.com")
+
+	workspace, err := s.Workspaces.CreateWorkspace(ada, models.WorkspaceParams{Name: "Engine room"})
+	if err != nil || workspace.WorkspaceID == "" || workspace.Name != "Engine room" || workspace.Role != models.WorkspaceOwner {
+		t.Fatalf("CreateWorkspace: %+v, %v", workspace, err)
+	}
+
+	asAda := models.Actor{UserID: ada, WorkspaceID: workspace.WorkspaceID}
+	asBob := models.Actor{UserID: bob, WorkspaceID: workspace.WorkspaceID}
+	asCy := models.Actor{UserID: cy, WorkspaceID: workspace.WorkspaceID}
+
+	_, err = s.Workspaces.GetWorkspace(asBob)
+	expectErr(t, "GetWorkspace by a non-member", err, utils.ErrWorkspaceNotFound)
+
+	_, err = s.Actions.GetActions(asBob, parseQuery(t, url.Values{}))
+	expectErr(t, "GetActions by a non-member", err, utils.ErrWorkspaceNotFound)
+
+	// invitations are addressed to usernames, whether or not anyone signed up with them, so that inviting tells nothing of who has
+	early, err := s.Workspaces.InviteMember(asAda, models.InvitationParams{Username: "dan@example.com", Role: models.WorkspaceEditor})
+	if err != nil || early.Username != "dan@example.com" || early.WorkspaceName != "Engine room" || early.InvitedBy != ada {
+		t.Fatalf("InviteMember of an unknown user: %+v, %v", early, err)
+	}
+
+	_, err = s.Workspaces.InviteMember(asAda, models.InvitationParams{Username: "dan@example.com", Role: models.WorkspaceViewer})
+	expectErr(t, "InviteMember of an unknown user twice", err, &dbservice.DuplicateEntryErr{})
+
+	// whoever signs up with an address gets to its invitations only once they verify it, whatever the verification policy
+	dan := createUser(t, s, "dan@example.com")
+	_, err = s.Workspaces.GetInvitations(dan)
+	expectErr(t, "GetInvitations by an unverified user", err, utils.ErrEmailNotVerified)
+
+	_, err = s.Workspaces.AcceptInvitation(early.InvitationID, dan)
+	expectErr(t, "AcceptInvitation by an unverified user", err, utils.ErrEmailNotVerified)
+
+	err = s.Workspaces.DeclineInvitation(early.InvitationID, dan)
+	expectErr(t, "DeclineInvitation by an unverified user", err, utils.ErrEmailNotVerified)
+
+	verifyEmail(t, s, "dan@example.com")
+	invitations, err := s.Workspaces.GetInvitations(dan)
+	if err != nil || len(invitations) != 1 || invitations[0].InvitationID != early.InvitationID {
+		t.Errorf("GetInvitations of a user invited before they signed up: %+v, %v", invitations, err)
+	}
+
+	err = s.Workspaces.RevokeInvitation(asAda, early.InvitationID)
+	if err != nil {
+		t.Fatalf("RevokeInvitation: %v", err)
+	}
+
+	_, err = s.Workspaces.InviteMember(asAda, models.InvitationParams{Username: "ada@example.com", Role: models.WorkspaceEditor})
+	expectErr(t, "InviteMember of a member", err, utils.ErrAlreadyMember)
+
+	invitation, err := s.Workspaces.InviteMember(asAda, models.InvitationParams{Username: "bob@example.com", Role: models.WorkspaceEditor})
+	if err != nil || invitation.Username != "bob@example.com" || invitation.WorkspaceName != "Engine room" || invitation.InvitedBy != ada {
+		t.Fatalf("InviteMember: %+v, %v", invitation, err)
+	}
+
+	_, err = s.Workspaces.InviteMember(asAda, models.InvitationParams{Username: "bob@example.com", Role: models.WorkspaceViewer})
+	expectErr(t, "InviteMember twice", err, &dbservice.DuplicateEntryErr{})
+
+	invitations, err = s.Workspaces.GetInvitations(bob)
+	if err != nil || len(invitations) != 1 || invitations[0].InvitationID != invitation.InvitationID {
+		t.Fatalf("GetInvitations: %+v, %v", invitations, err)
+	}
+
+	err = s.Workspaces.DeclineInvitation(invitation.InvitationID, cy)
+	expectErr(t, "DeclineInvitation by someone else", err, utils.ErrInvitationNotFound)
+
+	joined, err := s.Workspaces.AcceptInvitation(invitation.InvitationID, bob)
+	if err != nil || joined.WorkspaceID != workspace.WorkspaceID || joined.Role != models.WorkspaceEditor {
+		t.Fatalf("AcceptInvitation: %+v, %v", joined, err)
+	}
+
+	_, err = s.Workspaces.AcceptInvitation(invitation.InvitationID, bob)
+	expectErr(t, "AcceptInvitation twice", err, utils.ErrInvitationNotFound)
+
+	_, err = s.Workspaces.InviteMember(asBob, models.InvitationParams{Username: "cy@example.com", Role: models.WorkspaceViewer})
+	expectErr(t, "InviteMember by an editor", err, utils.ErrForbidden)
+
+	declined, err := s.Workspaces.InviteMember(asAda, models.InvitationParams{Username: "cy@example.com", Role: models.WorkspaceEditor})
+	if err != nil {
+		t.Fatalf("InviteMember: %v", err)
+	}
+
+	err = s.Workspaces.DeclineInvitation(declined.InvitationID, cy)
+	if err != nil {
+		t.Fatalf("DeclineInvitation: %v", err)
+	}
+
+	revoked, err := s.Workspaces.InviteMember(asAda, models.InvitationParams{Username: "cy@example.com", Role: models.WorkspaceEditor})
+	if err != nil {
+		t.Fatalf("InviteMember after a declined invitation: %v", err)
+	}
+
+	invitations, err = s.Workspaces.GetWorkspaceInvitations(asAda)
+	if err != nil || len(invitations) != 1 || invitations[0].InvitationID != revoked.InvitationID {
+		t.Errorf("GetWorkspaceInvitations: %+v, %v", invitations, err)
+	}
+
+	err = s.Workspaces.RevokeInvitation(asAda, revoked.InvitationID)
+	if err != nil {
+		t.Fatalf("RevokeInvitation: %v", err)
+	}
+
+	_, err = s.Workspaces.AcceptInvitation(revoked.InvitationID, cy)
+	expectErr(t, "AcceptInvitation of a revoked invitation", err, utils.ErrInvitationNotFound)
+
+	viewer, err := s.Workspaces.InviteMember(asAda, models.InvitationParams{Username: "cy@example.com", Role: models.WorkspaceViewer})
+	if err != nil {
+		t.Fatalf("InviteMember: %v", err)
+	}
+
+	_, err = s.Workspaces.AcceptInvitation(viewer.InvitationID, cy)
+	if err != nil {
+		t.Fatalf("AcceptInvitation: %v", err)
+	}
+
+	members, err := s.Workspaces.GetMembers(asCy)
+	if err != nil || len(members) != 3 || members[0].UserID != ada || members[0].Role != models.WorkspaceOwner {
+		t.Errorf("GetMembers: %+v, %v", members, err)
+	}
+
+	workspaces, err := s.Workspaces.GetWorkspaces(cy)
+	if err != nil || len(workspaces) != 1 || workspaces[0].Role != models.WorkspaceViewer {
+		t.Errorf("GetWorkspaces: %+v, %v", workspaces, err)
+	}
+
+	// workspace actions belong to every member, as their roles allow
+	action := createAction(t, s, asAda, "Stoke the boiler")
+	if action.WorkspaceID != workspace.WorkspaceID || action.UserID != ada {
+		t.Errorf("unexpected workspace action %+v", action)
+	}
+
+	err = s.Actions.CreateAction(models.ActionParams{Title: "Idle the boiler", Description: "quietly"}, asCy)
+	expectErr(t, "CreateAction by a viewer", err, utils.ErrForbidden)
+
+	found, err := s.Actions.GetActionByID(action.ActionID, asCy)
+	if err != nil || found.ActionID != action.ActionID {
+		t.Errorf("GetActionByID by a viewer: %+v, %v", found, err)
+	}
+
+	_, err = s.Actions.UpdateAction(action.ActionID, asCy, models.ActionParams{Description: "Let it go out"})
+	expectErr(t, "UpdateAction by a viewer", err, utils.ErrForbidden)
+
+	updated, err := s.Actions.UpdateAction(action.ActionID, asBob, models.ActionParams{Description: "Keep it at pressure"})
+	if err != nil || updated.Description != "Keep it at pressure" || updated.UserID != ada {
+		t.Errorf("UpdateAction by an editor: %+v, %v", updated, err)
+	}
+
+	// titles are unique within the workspace, whichever member took them
+	err = s.Actions.CreateAction(models.ActionParams{Title: "Stoke the boiler", Description: "again"}, asBob)
+	expectErr(t, "CreateAction with a title taken in the workspace", err, &dbservice.DuplicateEntryErr{})
+
+	output := createOutput(t, s, asBob, action.ActionID, "Pressure log")
+	outputs, err := s.Outputs.GetOutputs(asCy, false)
+	if err != nil || len(outputs) != 1 || outputs[0].OutputID != output.OutputID || outputs[0].WorkspaceID != workspace.WorkspaceID {
+		t.Errorf("GetOutputs by a viewer: %+v, %v", outputs, err)
+	}
+
+	results, err := s.Search.Search(asCy, models.SearchParams{Query: "boiler"})
+	if err != nil || len(results) != 1 {
+		t.Errorf("Search by a viewer: %+v, %v", results, err)
+	}
+
+	// workspace actions stay out of the personal space, and cannot be shared out of it
+	page, err := s.Actions.GetActions(personal(ada), parseQuery(t, url.Values{}))
+	if err != nil || len(page.Actions) != 0 {
+		t.Errorf("GetActions in the personal space: %+v, %v", page, err)
+	}
+
+	_, err = s.Actions.GetActionByID(action.ActionID, personal(ada))
+	expectErr(t, "GetActionByID of a workspace action in the personal space", err, sql.ErrNoRows)
+
+	createAction(t, s, personal(ada), "Stoke the boiler")
+
+	err = s.Actions.ShareAction(action.ActionID, asAda, models.ShareParams{Username: "bob@example.com"})
+	expectErr(t, "ShareAction of a workspace action", err, utils.ErrShareWorkspaceAction)
+
+	err = s.Actions.ArchiveAction(action.ActionID, asBob)
+	if err != nil {
+		t.Fatalf("ArchiveAction by an editor: %v", err)
+	}
+
+	page, err = s.Actions.GetActions(asCy, parseQuery(t, url.Values{"archived": {"true"}}))
+	if err != nil || len(page.Actions) != 0 {
+		t.Errorf("GetActions of archived actions by a viewer: %+v, %v", page, err)
+	}
+
+	_, err = s.Actions.RestoreAction(action.ActionID, asCy)
+	expectErr(t, "RestoreAction by a viewer", err, sql.ErrNoRows)
+
+	restored, err := s.Actions.RestoreAction(action.ActionID, asAda)
+	if err != nil || restored.IsArchived {
+		t.Errorf("RestoreAction by an owner: %+v, %v", restored, err)
+	}
+
+	// members
+	_, err = s.Workspaces.UpdateMember(asBob, cy, models.MemberParams{Role: models.WorkspaceEditor})
+	expectErr(t, "UpdateMember by an editor", err, utils.ErrForbidden)
+
+	_, err = s.Workspaces.UpdateMember(asAda, ada, models.MemberParams{Role: models.WorkspaceEditor})
+	expectErr(t, "UpdateMember demoting the last owner", err, utils.ErrLastOwner)
+
+	err = s.Workspaces.RemoveMember(asAda, ada)
+	expectErr(t, "RemoveMember of the last owner", err, utils.ErrLastOwner)
+
+	_, err = s.Workspaces.UpdateMember(asAda, missingID, models.MemberParams{Role: models.WorkspaceEditor})
+	expectErr(t, "UpdateMember of a non-member", err, utils.ErrUserNotFound)
+
+	member, err := s.Workspaces.UpdateMember(asAda, cy, models.MemberParams{Role: models.WorkspaceEditor})
+	if err != nil || member.Role != models.WorkspaceEditor || member.Username != "cy@example.com" {
+		t.Errorf("UpdateMember: %+v, %v", member, err)
+	}
+
+	err = s.Workspaces.RemoveMember(asBob, cy)
+	expectErr(t, "RemoveMember by an editor", err, utils.ErrForbidden)
+
+	err = s.Workspaces.RemoveMember(asBob, bob)
+	if err != nil {
+		t.Fatalf("RemoveMember of themselves: %v", err)
+	}
+
+	_, err = s.Actions.GetActionByID(action.ActionID, asBob)
+	expectErr(t, "GetActionByID by a former member", err, utils.ErrWorkspaceNotFound)
+
+	err = s.Workspaces.RemoveMember(asAda, cy)
+	if err != nil {
+		t.Fatalf("RemoveMember: %v", err)
+	}
+
+	members, err = s.Workspaces.GetMembers(asAda)
+	if err != nil || len(members) != 1 {
+		t.Errorf("GetMembers after RemoveMember: %+v, %v", members, err)
+	}
+}
+
+func testLastOwner(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+	verifyEmail(t, s, "ada@example.com")
+	verifyEmail(t, s, "bob@example.com")
+
+	workspace, err := s.Workspaces.CreateWorkspace(ada, models.WorkspaceParams{Name: "Engine room"})
+	if err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+
+	asAda := models.Actor{UserID: ada, WorkspaceID: workspace.WorkspaceID}
+	asBob := models.Actor{UserID: bob, WorkspaceID: workspace.WorkspaceID}
+
+	invitation, err := s.Workspaces.InviteMember(asAda, models.InvitationParams{Username: "bob@example.com", Role: models.WorkspaceOwner})
+	if err == nil {
+		_, err = s.Workspaces.AcceptInvitation(invitation.InvitationID, bob)
+	}
+	if err != nil {
+		t.Fatalf("inviting bob as an owner: %v", err)
+	}
+
+	// two owners demoting, or removing, each other at once take turns, and one of them stays an owner
+	for round := 0; round < 10; round++ {
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i, pair := range [][2]models.Actor{{asAda, asBob}, {asBob, asAda}} {
+			wg.Add(1)
+			go func(i int, actor models.Actor, memberID string) {
+				defer wg.Done()
+				if round%2 == 0 {
+					_, errs[i] = s.Workspaces.UpdateMember(actor, memberID, models.MemberParams{Role: models.WorkspaceEditor})
+				} else {
+					errs[i] = s.Workspaces.RemoveMember(actor, memberID)
+				}
+			}(i, pair[0], pair[1].UserID)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			// the loser of the race is no longer an owner, or no longer a member
+			if err != nil && !errors.Is(err, utils.ErrLastOwner) && !errors.Is(err, utils.ErrForbidden) && !errors.Is(err, utils.ErrWorkspaceNotFound) {
+				t.Errorf("round %v: unexpected err %v", round, err)
+			}
+		}
+
+		members, err := s.Workspaces.GetMembers(asAda)
+		if err != nil {
+			members, err = s.Workspaces.GetMembers(asBob)
+		}
+		if err != nil {
+			t.Fatalf("round %v: GetMembers: %v", round, err)
+		}
+
+		owners := []models.WorkspaceMember{}
+		for _, member := range members {
+			if member.Role == models.WorkspaceOwner {
+				owners = append(owners, member)
+			}
+		}
+
+		if len(owners) != 1 {
+			t.Fatalf("round %v: expected a single owner to be left, got %+v", round, members)
+		}
+
+		// the owner left brings the other back, as an owner, for the next round
+		owner := models.Actor{UserID: owners[0].UserID, WorkspaceID: workspace.WorkspaceID}
+		other, otherName := ada, "ada@example.com"
+		if owner.UserID == ada {
+			other, otherName = bob, "bob@example.com"
+		}
+
+		if len(members) == 2 {
+			_, err = s.Workspaces.UpdateMember(owner, other, models.MemberParams{Role: models.WorkspaceOwner})
+		} else {
+			invitation, err = s.Workspaces.InviteMember(owner, models.InvitationParams{Username: otherName, Role: models.WorkspaceOwner})
+			if err == nil {
+				_, err = s.Workspaces.AcceptInvitation(invitation.InvitationID, other)
+			}
+		}
+		if err != nil {
+			t.Fatalf("round %v: bringing the other owner back: %v", round, err)
+		}
+	}
+}
+
+func testProfiles(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+
+	user, err := s.Users.GetByUUID(ada)
+	if err != nil || user.Timezone != "UTC" || user.DisplayName != "" || user.AvatarURL != "" {
+		t.Fatalf("GetByUUID of a new user: %+v, %v", user, err)
+	}
+
+	user, err = s.Users.UpdateProfile(ada, models.ProfileParams{DisplayName: "Ada Lovelace", Timezone: "Europe/London"})
+	if err != nil || user.DisplayName != "Ada Lovelace" || user.Timezone != "Europe/London" || user.AvatarURL != "" || user.Username != "ada@example.com" {
+		t.Fatalf("UpdateProfile: %+v, %v", user, err)
+	}
+
+	user, err = s.Users.UpdateProfile(ada, models.ProfileParams{AvatarURL: "https://example.com/ada.png"})
+	if err != nil || user.DisplayName != "Ada Lovelace" || user.Timezone != "Europe/London" || user.AvatarURL != "https://example.com/ada.png" {
+		t.Errorf("partial UpdateProfile: %+v, %v", user, err)
+	}
+
+	_, err = s.Users.UpdateProfile(ada, models.ProfileParams{})
+	expectErr(t, "UpdateProfile without params", err, utils.ErrNoUpdateParams)
+
+	_, err = s.Users.UpdateProfile(missingID, models.ProfileParams{DisplayName: "Nobody"})
+	expectErr(t, "UpdateProfile of an unknown user", err, sql.ErrNoRows)
+
+	user, err = s.Users.GetByUUID(bob)
+	if err != nil || user.DisplayName != "" {
+		t.Errorf("UpdateProfile changed another user's profile: %+v, %v", user, err)
+	}
+
+	err = s.Users.ChangePassword(ada, models.PasswordParams{CurrentPassword: "Wr0ng!pass", NewPassword: "N3w!password"})
+	expectErr(t, "ChangePassword with a wrong current password", err, utils.ErrWrongPassword)
+
+	err = s.Users.ChangePassword(missingID, models.PasswordParams{CurrentPassword: password, NewPassword: "N3w!password"})
+	expectErr(t, "ChangePassword of an unknown user", err, sql.ErrNoRows)
+
+	err = s.Users.ChangePassword(ada, models.PasswordParams{CurrentPassword: password, NewPassword: "N3w!password"})
+	if err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+
+	_, err = s.Users.GetByCredentials(&models.UserCredentials{Username: "ada@example.com", Password: password})
+	expectErr(t, "GetByCredentials with the old password", err, utils.ErrWrongPassword)
+
+	user, err = s.Users.GetByCredentials(&models.UserCredentials{Username: "ada@example.com", Password: "N3w!password"})
+	if err != nil || user.UserID != ada {
+		t.Errorf("GetByCredentials with the new password: %+v, %v", user, err)
+	}
+
+	_, err = s.Users.GetByCredentials(&models.UserCredentials{Username: "bob@example.com", Password: password})
+	if err != nil {
+		t.Errorf("ChangePassword changed another user's password: %v", err)
+	}
+}
+
+func testPasswordResets(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+
+	_, err := s.PasswordResets.CreatePasswordReset("nobody@example.com", time.Hour)
+	expectErr(t, "CreatePasswordReset of an unknown user", err, sql.ErrNoRows)
+
+	first, err := s.PasswordResets.CreatePasswordReset("ada@example.com", time.Hour)
+	if err != nil || first.UserID != ada || first.Token == "" || !first.ExpiresAt.After(first.CreatedAt) {
+		t.Fatalf("CreatePasswordReset: %+v, %v", first, err)
+	}
+
+	second, err := s.PasswordResets.CreatePasswordReset("ada@example.com", time.Hour)
+	if err != nil || second.Token == first.Token {
+		t.Fatalf("second CreatePasswordReset: %+v, %v", second, err)
+	}
+
+	_, err = s.PasswordResets.ResetPassword(models.ResetPasswordParams{Token: first.Token, NewPassword: "N3w!password"})
+	expectErr(t, "ResetPassword with a called off token", err, utils.ErrResetTokenInvalid)
+
+	_, err = s.PasswordResets.ResetPassword(models.ResetPasswordParams{Token: "not-a-token", NewPassword: "N3w!password"})
+	expectErr(t, "ResetPassword with an unknown token", err, utils.ErrResetTokenInvalid)
+
+	userID, err := s.PasswordResets.ResetPassword(models.ResetPasswordParams{Token: second.Token, NewPassword: "N3w!password"})
+	if err != nil || userID != ada {
+		t.Fatalf("ResetPassword: %v, %v", userID, err)
+	}
+
+	_, err = s.PasswordResets.ResetPassword(models.ResetPasswordParams{Token: second.Token, NewPassword: "An0ther!password"})
+	expectErr(t, "ResetPassword with a spent token", err, utils.ErrResetTokenInvalid)
+
+	_, err = s.Users.GetByCredentials(&models.UserCredentials{Username: "ada@example.com", Password: password})
+	expectErr(t, "GetByCredentials with the old password", err, utils.ErrWrongPassword)
+
+	user, err := s.Users.GetByCredentials(&models.UserCredentials{Username: "ada@example.com", Password: "N3w!password"})
+	if err != nil || user.UserID != ada {
+		t.Errorf("GetByCredentials with the new password: %+v, %v", user, err)
+	}
+
+	// the db's clock counts in seconds, so expiry is given a little over one
+	expiring, err := s.PasswordResets.CreatePasswordReset("bob@example.com", time.Second)
+	if err != nil {
+		t.Fatalf("CreatePasswordReset of an expiring reset: %v", err)
+	}
+
+	time.Sleep(2500 * time.Millisecond)
+
+	_, err = s.PasswordResets.ResetPassword(models.ResetPasswordParams{Token: expiring.Token, NewPassword: "N3w!password"})
+	expectErr(t, "ResetPassword with an expired token", err, utils.ErrResetTokenInvalid)
+
+	user, err = s.Users.GetByCredentials(&models.UserCredentials{Username: "bob@example.com", Password: password})
+	if err != nil || user.UserID != bob {
+		t.Errorf("an expired token changed the password: %+v, %v", user, err)
+	}
+}
+
+func testEmailVerifications(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+
+	user, err := s.Users.GetByUUID(ada)
+	if err != nil || user.EmailVerifiedAt != nil {
+		t.Fatalf("GetByUUID of a new user: %+v, %v", user, err)
+	}
+
+	_, err = s.EmailVerifications.CreateEmailVerification("nobody@example.com", time.Hour, 0)
+	expectErr(t, "CreateEmailVerification of an unknown user", err, sql.ErrNoRows)
+
+	first, err := s.EmailVerifications.CreateEmailVerification("ada@example.com", time.Hour, 0)
+	if err != nil || first.UserID != ada || first.Token == "" || !first.ExpiresAt.After(first.CreatedAt) {
+		t.Fatalf("CreateEmailVerification: %+v, %v", first, err)
+	}
+
+	_, err = s.EmailVerifications.CreateEmailVerification("ada@example.com", time.Hour, time.Hour)
+	expectErr(t, "CreateEmailVerification too soon after the last", err, utils.ErrVerificationThrottled)
+
+	second, err := s.EmailVerifications.CreateEmailVerification("ada@example.com", time.Hour, 0)
+	if err != nil || second.Token == first.Token {
+		t.Fatalf("second CreateEmailVerification: %+v, %v", second, err)
+	}
+
+	_, err = s.EmailVerifications.VerifyEmail(first.Token)
+	expectErr(t, "VerifyEmail with a called off token", err, utils.ErrVerificationTokenInvalid)
+
+	userID, err := s.EmailVerifications.VerifyEmail(second.Token)
+	if err != nil || userID != ada {
+		t.Fatalf("VerifyEmail: %v, %v", userID, err)
+	}
+
+	_, err = s.EmailVerifications.VerifyEmail(second.Token)
+	expectErr(t, "VerifyEmail with a spent token", err, utils.ErrVerificationTokenInvalid)
+
+	user, err = s.Users.GetByUUID(ada)
+	if err != nil || user.EmailVerifiedAt == nil {
+		t.Errorf("GetByUUID of a verified user: %+v, %v", user, err)
+	}
+
+	_, err = s.EmailVerifications.CreateEmailVerification("ada@example.com", time.Hour, 0)
+	expectErr(t, "CreateEmailVerification of a verified user", err, utils.ErrEmailAlreadyVerified)
+
+	user, err = s.Users.GetByUUID(bob)
+	if err != nil || user.EmailVerifiedAt != nil {
+		t.Errorf("VerifyEmail verified another user: %+v, %v", user, err)
+	}
+
+	// the db's clock counts in seconds, so expiry is given a little over one
+	expiring, err := s.EmailVerifications.CreateEmailVerification("bob@example.com", time.Second, 0)
+	if err != nil {
+		t.Fatalf("CreateEmailVerification of an expiring verification: %v", err)
+	}
+
+	time.Sleep(2500 * time.Millisecond)
+
+	_, err = s.EmailVerifications.VerifyEmail(expiring.Token)
+	expectErr(t, "VerifyEmail with an expired token", err, utils.ErrVerificationTokenInvalid)
+}
+
+// totpCode computes the totp code of secret at the time given, failing the test if it cannot
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+
+	code, err := security.GenerateTOTPCode(secret, at)
+	if err != nil {
+		t.Fatalf("GenerateTOTPCode: %v", err)
+	}
+
+	return code
+}
+
+func testMFA(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+
+	err := s.MFA.VerifyMFA(ada, "123456")
+	expectErr(t, "VerifyMFA before mfa is enabled", err, utils.ErrMFANotEnabled)
+
+	_, err = s.MFA.ConfirmMFA(ada, "123456")
+	expectErr(t, "ConfirmMFA before enrolling", err, utils.ErrMFANotEnrolled)
+
+	_, err = s.MFA.EnrollMFA(missingID)
+	expectErr(t, "EnrollMFA of an unknown user", err, sql.ErrNoRows)
+
+	enrollment, err := s.MFA.EnrollMFA(ada)
+	if err != nil || enrollment.Secret == "" || !strings.Contains(enrollment.OTPAuthURI, enrollment.Secret) || !strings.Contains(enrollment.OTPAuthURI, "ada@example.com") {
+		t.Fatalf("EnrollMFA: %+v, %v", enrollment, err)
+	}
+
+	user, err := s.Users.GetByUUID(ada)
+	if err != nil || user.MFAEnabledAt != nil {
+		t.Errorf("EnrollMFA enabled mfa before it was confirmed: %+v, %v", user, err)
+	}
+
+	_, err = s.MFA.ConfirmMFA(ada, totpCode(t, enrollment.Secret, time.Now().Add(-time.Hour)))
+	expectErr(t, "ConfirmMFA with a stale code", err, utils.ErrMFACodeInvalid)
+
+	confirmed := totpCode(t, enrollment.Secret, time.Now())
+	codes, err := s.MFA.ConfirmMFA(ada, confirmed)
+	if err != nil || len(codes) != models.MFARecoveryCodes {
+		t.Fatalf("ConfirmMFA: %v, %v", codes, err)
+	}
+
+	user, err = s.Users.GetByUUID(ada)
+	if err != nil || user.MFAEnabledAt == nil {
+		t.Errorf("GetByUUID after ConfirmMFA: %+v, %v", user, err)
+	}
+
+	// secrets are sealed as they are stored, leaving none to seal later
+	sealed, err := s.MFA.SealMFASecrets()
+	if err != nil || sealed != 0 {
+		t.Errorf("SealMFASecrets of secrets stored sealed: %v, %v", sealed, err)
+	}
+
+	_, err = s.MFA.EnrollMFA(ada)
+	expectErr(t, "EnrollMFA with mfa enabled", err, utils.ErrMFAAlreadyEnabled)
+
+	err = s.MFA.VerifyMFA(ada, confirmed)
+	expectErr(t, "VerifyMFA with the code mfa was confirmed with", err, utils.ErrMFACodeInvalid)
+
+	// codes of the next period are accepted, as clocks drift
+	next := totpCode(t, enrollment.Secret, time.Now().Add(30*time.Second))
+	err = s.MFA.VerifyMFA(ada, next)
+	if err != nil {
+		t.Errorf("VerifyMFA with a totp code: %v", err)
+	}
+
+	err = s.MFA.VerifyMFA(ada, next)
+	expectErr(t, "VerifyMFA with a used totp code", err, utils.ErrMFACodeInvalid)
+
+	err = s.MFA.VerifyMFA(ada, strings.ToUpper(codes[0]))
+	if err != nil {
+		t.Errorf("VerifyMFA with a recovery code: %v", err)
+	}
+
+	err = s.MFA.VerifyMFA(ada, codes[0])
+	expectErr(t, "VerifyMFA with a used recovery code", err, utils.ErrMFACodeInvalid)
+
+	err = s.MFA.VerifyMFA(ada, "not-a-recovery-code")
+	expectErr(t, "VerifyMFA with an unknown recovery code", err, utils.ErrMFACodeInvalid)
+
+	err = s.MFA.VerifyMFA(bob, codes[1])
+	expectErr(t, "VerifyMFA with another user's recovery code", err, utils.ErrMFANotEnabled)
+
+	err = s.MFA.DisableMFA(ada)
+	if err != nil {
+		t.Fatalf("DisableMFA: %v", err)
+	}
+
+	user, err = s.Users.GetByUUID(ada)
+	if err != nil || user.MFAEnabledAt != nil {
+		t.Errorf("GetByUUID after DisableMFA: %+v, %v", user, err)
+	}
+
+	err = s.MFA.VerifyMFA(ada, codes[1])
+	expectErr(t, "VerifyMFA once mfa is disabled", err, utils.ErrMFANotEnabled)
+
+	err = s.MFA.DisableMFA(ada)
+	expectErr(t, "DisableMFA twice", err, utils.ErrMFANotEnabled)
+
+	// enabling mfa again hands out a fresh set of recovery codes, and calls off the old
+	enrollment, err = s.MFA.EnrollMFA(ada)
+	if err != nil {
+		t.Fatalf("EnrollMFA again: %v", err)
+	}
+
+	fresh, err := s.MFA.ConfirmMFA(ada, totpCode(t, enrollment.Secret, time.Now()))
+	if err != nil || len(fresh) != models.MFARecoveryCodes {
+		t.Fatalf("ConfirmMFA again: %v, %v", fresh, err)
+	}
+
+	err = s.MFA.VerifyMFA(ada, codes[2])
+	expectErr(t, "VerifyMFA with a recovery code of the last enrollment", err, utils.ErrMFACodeInvalid)
+}
+
+func testIdentities(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+	verifyEmail(t, s, "ada@example.com")
+
+	_, err := s.APIKeys.CreateAPIKey(bob, models.APIKeyParams{Name: "ci", Scopes: []string{models.ScopeActionsRead}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	// bob's email is unverified, so whoever signed up with it may have set mfa and a pwd reset up too
+	enrollment, err := s.MFA.EnrollMFA(bob)
+	if err != nil {
+		t.Fatalf("EnrollMFA: %v", err)
+	}
+
+	recoveryCodes, err := s.MFA.ConfirmMFA(bob, totpCode(t, enrollment.Secret, time.Now()))
+	if err != nil {
+		t.Fatalf("ConfirmMFA: %v", err)
+	}
+
+	reset, err := s.PasswordResets.CreatePasswordReset("bob@example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("CreatePasswordReset: %v", err)
+	}
+
+	issuer := "https://idp.example.com"
+	_, err = s.Identities.LoginWithIdentity(&models.ExternalIdentity{Issuer: issuer, Subject: "ada", Email: "ada@example.com"})
+	expectErr(t, "LoginWithIdentity with an unverified email", err, utils.ErrIdentityEmailNotVerified)
+
+	// identities are linked by their email, whatever its case
+	login, err := s.Identities.LoginWithIdentity(&models.ExternalIdentity{Issuer: issuer, Subject: "ada", Email: "Ada@Example.com", EmailVerified: true})
+	if err != nil || login.UserID != ada || !login.Linked || login.Provisioned || login.Claimed {
+		t.Fatalf("LoginWithIdentity linking a verified user: %+v, %v", login, err)
+	}
+
+	_, err = s.Users.GetByCredentials(&models.UserCredentials{Username: "ada@example.com", Password: password})
+	if err != nil {
+		t.Errorf("GetByCredentials of a verified user once linked: %v", err)
+	}
+
+	// once linked, identities log in as their user, even if their email changes
+	login, err = s.Identities.LoginWithIdentity(&models.ExternalIdentity{Issuer: issuer, Subject: "ada", Email: "lovelace@example.com"})
+	if err != nil || login.UserID != ada || login.Linked {
+		t.Errorf("LoginWithIdentity of a linked identity: %+v, %v", login, err)
+	}
+
+	// accounts whose email was never verified may have been signed up by someone else, who loses them
+	login, err = s.Identities.LoginWithIdentity(&models.ExternalIdentity{Issuer: issuer, Subject: "bob", Email: "bob@example.com", EmailVerified: true})
+	if err != nil || login.UserID != bob || !login.Linked || !login.Claimed {
+		t.Fatalf("LoginWithIdentity claiming an unverified user: %+v, %v", login, err)
+	}
+
+	_, err = s.Users.GetByCredentials(&models.UserCredentials{Username: "bob@example.com", Password: password})
+	expectErr(t, "GetByCredentials of a claimed user", err, utils.ErrWrongPassword)
+
+	keys, err := s.APIKeys.GetAPIKeys(bob)
+	if err != nil || len(keys) != 0 {
+		t.Errorf("GetAPIKeys of a claimed user: %+v, %v", keys, err)
+	}
+
+	user, err := s.Users.GetByUUID(bob)
+	if err != nil || user.EmailVerifiedAt == nil || user.MFAEnabledAt != nil {
+		t.Errorf("GetByUUID of a claimed user: %+v, %v", user, err)
+	}
+
+	err = s.MFA.VerifyMFA(bob, recoveryCodes[0])
+	expectErr(t, "VerifyMFA of a claimed user, with a recovery code", err, utils.ErrMFANotEnabled)
+
+	_, err = s.MFA.EnrollMFA(bob)
+	if err != nil {
+		t.Errorf("EnrollMFA of a claimed user: %v", err)
+	}
+
+	_, err = s.PasswordResets.ResetPassword(models.ResetPasswordParams{Token: reset.Token, NewPassword: "N3w!password"})
+	expectErr(t, "ResetPassword of a claimed user, with a reset started before", err, utils.ErrResetTokenInvalid)
+
+	// identities with no user to link to get one of their own
+	login, err = s.Identities.LoginWithIdentity(&models.ExternalIdentity{Issuer: issuer, Subject: "carol", Email: "carol@example.com", EmailVerified: true, Name: "Carol"})
+	if err != nil || login.UserID == "" || !login.Linked || !login.Provisioned {
+		t.Fatalf("LoginWithIdentity provisioning a user: %+v, %v", login, err)
+	}
+
+	user, err = s.Users.GetByUUID(login.UserID)
+	if err != nil || user.Username != "carol@example.com" || user.DisplayName != "Carol" || user.Role != models.RoleUser || user.EmailVerifiedAt == nil {
+		t.Errorf("GetByUUID of a provisioned user: %+v, %v", user, err)
+	}
+
+	_, err = s.Users.GetByCredentials(&models.UserCredentials{Username: "carol@example.com", Password: ""})
+	expectErr(t, "GetByCredentials of a provisioned user, without a pwd", err, utils.ErrWrongPassword)
+
+	_, err = s.Identities.LoginWithIdentity(&models.ExternalIdentity{Issuer: "https://other.example.com", Subject: "ada", Email: "ada@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("LoginWithIdentity with another issuer: %v", err)
+	}
+
+	identities, err := s.Identities.GetIdentities(ada)
+	if err != nil || len(identities) != 2 || identities[0].Issuer != issuer || identities[0].Email != "lovelace@example.com" || identities[1].Subject != "ada" {
+		t.Errorf("GetIdentities: %+v, %v", identities, err)
+	}
+
+	identities, err = s.Identities.GetIdentities(missingID)
+	if err != nil || len(identities) != 0 {
+		t.Errorf("GetIdentities of an unknown user: %+v, %v", identities, err)
+	}
+}
+
+func testAccountDeletion(t *testing.T, s *store.Stores) {
+	ada := createUser(t, s, "ada@example.com")
+	bob := createUser(t, s, "bob@example.com")
+
+	shared := createAction(t, s, personal(ada), "Shared action")
+	createOutput(t, s, personal(ada), shared.ActionID, "Shared output")
+	createAction(t, s, personal(bob), "Bobs action")
+
+	err := s.Actions.ShareAction(shared.ActionID, personal(ada), models.ShareParams{Username: "bob@example.com"})
+	if err != nil {
+		t.Fatalf("ShareAction: %v", err)
+	}
+
+	key, err := s.APIKeys.CreateAPIKey(ada, models.APIKeyParams{Name: "ci", Scopes: []string{models.ScopeActionsRead}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	// ada owns one workspace with bob in it, and another on her own
+	engineRoom, err := s.Workspaces.CreateWorkspace(ada, models.WorkspaceParams{Name: "Engine room"})
+	if err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+
+	_, err = s.Workspaces.CreateWorkspace(ada, models.WorkspaceParams{Name: "Solo room"})
+	if err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+
+	asAda := models.Actor{UserID: ada, WorkspaceID: engineRoom.WorkspaceID}
+	verifyEmail(t, s, "bob@example.com")
+	invitation, err := s.Workspaces.InviteMember(asAda, models.InvitationParams{Username: "bob@example.com", Role: models.WorkspaceViewer})
+	if err == nil {
+		_, err = s.Workspaces.AcceptInvitation(invitation.InvitationID, bob)
+	}
+	if err != nil {
+		t.Fatalf("inviting bob: %v", err)
+	}
+	kept := createAction(t, s, asAda, "Workspace action")
+
+	// exports hold everything ada created, wherever she created it, and nothing of bob's
+	export, err := s.Actions.ExportActions(ada)
+	if err != nil || len(export.Actions) != 2 || len(export.Outputs) != 1 || len(export.Shares) != 1 {
+		t.Fatalf("ExportActions: %+v, %v", export, err)
+	}
+
+	if export.Actions[0].ActionID != shared.ActionID || export.Actions[1].WorkspaceID != engineRoom.WorkspaceID || export.Outputs[0].UserID != ada || export.Shares[0].UserID != bob {
+		t.Errorf("ExportActions: %+v", export)
+	}
+
+	export, err = s.Actions.ExportActions(missingID)
+	if err != nil || len(export.Actions) != 0 || len(export.Outputs) != 0 || len(export.Shares) != 0 {
+		t.Errorf("ExportActions of an unknown user: %+v, %v", export, err)
+	}
+
+	// deletions are scheduled once, and keep the api keys of users being deleted from authenticating
+	user, err := s.Users.ScheduleDeletion(ada, time.Hour)
+	if err != nil || user.DeleteAfter == nil || user.DeleteAfter.Before(time.Now().Add(59*time.Minute)) {
+		t.Fatalf("ScheduleDeletion: %+v, %v", user, err)
+	}
+	deleteAfter := *user.DeleteAfter
+
+	user, err = s.Users.ScheduleDeletion(ada, 2*time.Hour)
+	if err != nil || user.DeleteAfter == nil || !user.DeleteAfter.Equal(deleteAfter) {
+		t.Errorf("ScheduleDeletion twice: %+v, %v", user, err)
+	}
+
+	_, err = s.APIKeys.AuthenticateAPIKey(key.Key)
+	expectErr(t, "AuthenticateAPIKey of a user being deleted", err, utils.ErrAPIKeyNotFound)
+
+	_, err = s.Users.ScheduleDeletion(missingID, time.Hour)
+	expectErr(t, "ScheduleDeletion of an unknown user", err, sql.ErrNoRows)
+
+	purged, err := s.Users.PurgeDeletedUsers()
+	if err != nil || purged != 0 {
+		t.Errorf("PurgeDeletedUsers before any deletion is due: %v, %v", purged, err)
+	}
+
+	user, err = s.Users.CancelDeletion(ada)
+	if err != nil || user.DeleteAfter != nil {
+		t.Fatalf("CancelDeletion: %+v, %v", user, err)
+	}
+
+	_, err = s.APIKeys.AuthenticateAPIKey(key.Key)
+	if err != nil {
+		t.Errorf("AuthenticateAPIKey once the deletion is called off: %v", err)
+	}
+
+	// deletions already due are purged, along with everything the user owned
+	_, err = s.Users.ScheduleDeletion(ada, -time.Minute)
+	if err != nil {
+		t.Fatalf("ScheduleDeletion: %v", err)
+	}
+
+	purged, err = s.Users.PurgeDeletedUsers()
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeletedUsers: %v, %v", purged, err)
+	}
+
+	_, err = s.Users.GetByUUID(ada)
+	expectErr(t, "GetByUUID of a purged user", err, sql.ErrNoRows)
+
+	_, err = s.APIKeys.AuthenticateAPIKey(key.Key)
+	expectErr(t, "AuthenticateAPIKey of a purged user", err, utils.ErrAPIKeyNotFound)
+
+	_, err = s.Actions.GetActionByID(shared.ActionID, personal(bob))
+	expectErr(t, "GetActionByID of an action shared by a purged user", err, sql.ErrNoRows)
+
+	export, err = s.Actions.ExportActions(ada)
+	if err != nil || len(export.Actions) != 0 || len(export.Outputs) != 0 || len(export.Shares) != 0 {
+		t.Errorf("ExportActions of a purged user: %+v, %v", export, err)
+	}
+
+	// the workspaces ada was the last owner of go to the members left, and her own go with her
+	workspaces, err := s.Workspaces.GetWorkspaces(bob)
+	if err != nil || len(workspaces) != 1 || workspaces[0].WorkspaceID != engineRoom.WorkspaceID || workspaces[0].Role != models.WorkspaceOwner {
+		t.Errorf("GetWorkspaces of the member left: %+v, %v", workspaces, err)
+	}
+
+	// and the actions ada created in them stay with the workspace, going to its owner
+	page, err := s.Actions.GetActions(models.Actor{UserID: bob, WorkspaceID: engineRoom.WorkspaceID}, parseQuery(t, url.Values{}))
+	if err != nil || len(page.Actions) != 1 || page.Actions[0].ActionID != kept.ActionID || page.Actions[0].UserID != bob {
+		t.Errorf("GetActions in a workspace of a purged user: %+v, %v", page, err)
+	}
+
+	page, err = s.Actions.GetActions(personal(bob), parseQuery(t, url.Values{}))
+	if err != nil || len(page.Actions) != 1 {
+		t.Errorf("GetActions of another user: %+v, %v", page, err)
+	}
+
+	// usernames of purged users are free to be taken again
+	createUser(t, s, "ada@example.com")
+}
@@ -3,3 +3,105 @@ package utils
 // WRONG_PASSWORD_ERR is a reusable constant that identifies a wrong password err
 const WRONG_PASSWORD_ERR = "wrong password"
 const TOKEN_EXPIRED_ERR = "token expired"
+
+// NO_TOKEN_ERR identifies a request carrying neither an Authorization header nor a session_token cookie
+const NO_TOKEN_ERR = "no authorization token"
+
+// MALFORMED_AUTH_HEADER_ERR identifies an Authorization header that is not of the form `Bearer <token>`
+const MALFORMED_AUTH_HEADER_ERR = "authorization header should be of the form `Bearer <token>`"
+
+// FORBIDDEN_ERR identifies an attempt to modify a resource owned by another user
+const FORBIDDEN_ERR = "forbidden"
+
+// NO_UPDATE_PARAMS_ERR identifies an update request that changes nothing
+const NO_UPDATE_PARAMS_ERR = "no valid title or description in update request"
+
+// USER_NOT_FOUND_ERR identifies a reference to a user that does not exist
+const USER_NOT_FOUND_ERR = "user not found"
+
+// SHARE_WITH_OWNER_ERR identifies an attempt to share an action with its own owner
+const SHARE_WITH_OWNER_ERR = "an action cannot be shared with its owner"
+
+// SESSION_NOT_FOUND_ERR identifies a session that has expired, been revoked, or never existed
+const SESSION_NOT_FOUND_ERR = "session not found"
+
+// REFRESH_TOKEN_REUSED_ERR identifies a refresh token presented after it was rotated, which revokes its session
+const REFRESH_TOKEN_REUSED_ERR = "refresh token reused"
+
+// API_KEY_NOT_FOUND_ERR identifies an API key that has expired, been revoked, or never existed
+const API_KEY_NOT_FOUND_ERR = "API key not found"
+
+// API_KEY_NOT_ALLOWED_ERR identifies an API key used on a route that only accepts logged in users
+const API_KEY_NOT_ALLOWED_ERR = "API keys cannot be used here. Log in instead"
+
+// USER_DISABLED_ERR identifies a user disabled by an admin, who may no longer log in
+const USER_DISABLED_ERR = "this account has been disabled"
+
+// WORKSPACE_NOT_FOUND_ERR identifies a workspace that does not exist, or that the user is not a member of
+const WORKSPACE_NOT_FOUND_ERR = "workspace not found"
+
+// INVITATION_NOT_FOUND_ERR identifies a workspace invitation that was accepted, declined, revoked, or never existed
+const INVITATION_NOT_FOUND_ERR = "invitation not found"
+
+// ALREADY_MEMBER_ERR identifies an attempt to invite a user who already is a member of the workspace
+const ALREADY_MEMBER_ERR = "user is already a member of the workspace"
+
+// LAST_OWNER_ERR identifies an attempt to leave a workspace without an owner
+const LAST_OWNER_ERR = "a workspace must keep at least one owner"
+
+// SHARE_WORKSPACE_ACTION_ERR identifies an attempt to share an action that belongs to a workspace
+const SHARE_WORKSPACE_ACTION_ERR = "workspace actions cannot be shared. Invite the user to the workspace instead"
+
+// RESET_TOKEN_INVALID_ERR identifies a pwd reset token that has expired, been used, or never existed
+const RESET_TOKEN_INVALID_ERR = "reset token is invalid or has expired. Ask for another one"
+
+// VERIFICATION_TOKEN_INVALID_ERR identifies an email verification token that has expired, been used, or never existed
+const VERIFICATION_TOKEN_INVALID_ERR = "verification token is invalid or has expired. Ask for another one"
+
+// EMAIL_ALREADY_VERIFIED_ERR identifies a request to verify the email of a user who already has
+const EMAIL_ALREADY_VERIFIED_ERR = "email is already verified"
+
+// VERIFICATION_THROTTLED_ERR identifies a request for a verification email too soon after the last one
+const VERIFICATION_THROTTLED_ERR = "a verification email was sent moments ago. Wait a while before asking for another"
+
+// EMAIL_NOT_VERIFIED_ERR identifies a user who must verify their email before going on
+const EMAIL_NOT_VERIFIED_ERR = "verify your email address first. Ask for another verification email if need be"
+
+// MFA_TOKEN_INVALID_ERR identifies an mfa token that has expired, or was never issued
+const MFA_TOKEN_INVALID_ERR = "mfa token is invalid or has expired. Log in again"
+
+// MFA_CODE_INVALID_ERR identifies a totp code or recovery code that is wrong, or was already used
+const MFA_CODE_INVALID_ERR = "invalid or already used mfa code"
+
+// MFA_ALREADY_ENABLED_ERR identifies an attempt to enroll a user in mfa twice
+const MFA_ALREADY_ENABLED_ERR = "mfa is already enabled. Disable it first"
+
+// MFA_NOT_ENROLLED_ERR identifies an attempt to confirm mfa before enrolling in it
+const MFA_NOT_ENROLLED_ERR = "mfa enrollment not started. Enroll first"
+
+// MFA_NOT_ENABLED_ERR identifies an attempt to use or disable mfa, for a user who has not enabled it
+const MFA_NOT_ENABLED_ERR = "mfa is not enabled"
+
+// LOGIN_THROTTLED_ERR identifies a login attempted too soon after others failed
+const LOGIN_THROTTLED_ERR = "too many failed logins. Wait a while before trying again"
+
+// ACCOUNT_LOCKED_ERR identifies a login to an account locked after too many failed logins
+const ACCOUNT_LOCKED_ERR = "account is locked after too many failed logins. Unlock it with the link emailed to it, reset its password, or try again later"
+
+// UNLOCK_TOKEN_INVALID_ERR identifies an account unlock token that has expired, been used, or never existed
+const UNLOCK_TOKEN_INVALID_ERR = "unlock token is invalid or has expired"
+
+// PASSWORD_CONTAINS_EMAIL_ERR identifies a new pwd that gives away the email of the user setting it
+const PASSWORD_CONTAINS_EMAIL_ERR = "invalid password: do not use your email address in it"
+
+// IDENTITY_EMAIL_NOT_VERIFIED_ERR identifies an external identity that cannot be linked to a user, as its provider vouches for no email
+const IDENTITY_EMAIL_NOT_VERIFIED_ERR = "your identity provider has not verified an email address for you. Verify one with it, then log in again"
+
+// OIDC_PROVIDER_NOT_FOUND_ERR identifies a login with an identity provider the api is not configured with
+const OIDC_PROVIDER_NOT_FOUND_ERR = "no such identity provider"
+
+// OIDC_STATE_INVALID_ERR identifies an identity provider callback that does not answer a login this client started, or came too late
+const OIDC_STATE_INVALID_ERR = "login with identity provider is invalid or has expired. Start again"
+
+// REAUTH_REQUIRED_ERR identifies a grave request made without the current password, an mfa code, or a fresh login
+const REAUTH_REQUIRED_ERR = "confirm it is you: give your currentPassword or an mfa code, or log in again first"
@@ -0,0 +1,43 @@
+package utils
+
+import "errors"
+
+// Sentinel errs carrying the err messages of constants.go, for callers to tell errs apart with errors.Is rather than by their messages
+var (
+	ErrWrongPassword            = errors.New(WRONG_PASSWORD_ERR)
+	ErrTokenExpired             = errors.New(TOKEN_EXPIRED_ERR)
+	ErrNoToken                  = errors.New(NO_TOKEN_ERR)
+	ErrMalformedAuthHeader      = errors.New(MALFORMED_AUTH_HEADER_ERR)
+	ErrForbidden                = errors.New(FORBIDDEN_ERR)
+	ErrNoUpdateParams           = errors.New(NO_UPDATE_PARAMS_ERR)
+	ErrUserNotFound             = errors.New(USER_NOT_FOUND_ERR)
+	ErrShareWithOwner           = errors.New(SHARE_WITH_OWNER_ERR)
+	ErrSessionNotFound          = errors.New(SESSION_NOT_FOUND_ERR)
+	ErrRefreshTokenReused       = errors.New(REFRESH_TOKEN_REUSED_ERR)
+	ErrAPIKeyNotFound           = errors.New(API_KEY_NOT_FOUND_ERR)
+	ErrAPIKeyNotAllowed         = errors.New(API_KEY_NOT_ALLOWED_ERR)
+	ErrUserDisabled             = errors.New(USER_DISABLED_ERR)
+	ErrWorkspaceNotFound        = errors.New(WORKSPACE_NOT_FOUND_ERR)
+	ErrInvitationNotFound       = errors.New(INVITATION_NOT_FOUND_ERR)
+	ErrAlreadyMember            = errors.New(ALREADY_MEMBER_ERR)
+	ErrLastOwner                = errors.New(LAST_OWNER_ERR)
+	ErrShareWorkspaceAction     = errors.New(SHARE_WORKSPACE_ACTION_ERR)
+	ErrResetTokenInvalid        = errors.New(RESET_TOKEN_INVALID_ERR)
+	ErrVerificationTokenInvalid = errors.New(VERIFICATION_TOKEN_INVALID_ERR)
+	ErrEmailAlreadyVerified     = errors.New(EMAIL_ALREADY_VERIFIED_ERR)
+	ErrVerificationThrottled    = errors.New(VERIFICATION_THROTTLED_ERR)
+	ErrEmailNotVerified         = errors.New(EMAIL_NOT_VERIFIED_ERR)
+	ErrMFATokenInvalid          = errors.New(MFA_TOKEN_INVALID_ERR)
+	ErrMFACodeInvalid           = errors.New(MFA_CODE_INVALID_ERR)
+	ErrMFAAlreadyEnabled        = errors.New(MFA_ALREADY_ENABLED_ERR)
+	ErrMFANotEnrolled           = errors.New(MFA_NOT_ENROLLED_ERR)
+	ErrMFANotEnabled            = errors.New(MFA_NOT_ENABLED_ERR)
+	ErrLoginThrottled           = errors.New(LOGIN_THROTTLED_ERR)
+	ErrAccountLocked            = errors.New(ACCOUNT_LOCKED_ERR)
+	ErrUnlockTokenInvalid       = errors.New(UNLOCK_TOKEN_INVALID_ERR)
+	ErrPasswordContainsEmail    = errors.New(PASSWORD_CONTAINS_EMAIL_ERR)
+	ErrIdentityEmailNotVerified = errors.New(IDENTITY_EMAIL_NOT_VERIFIED_ERR)
+	ErrOIDCProviderNotFound     = errors.New(OIDC_PROVIDER_NOT_FOUND_ERR)
+	ErrOIDCStateInvalid         = errors.New(OIDC_STATE_INVALID_ERR)
+	ErrReauthRequired           = errors.New(REAUTH_REQUIRED_ERR)
+)
@@ -10,6 +10,8 @@ import (
 type GenericJSONRes struct {
 	Message string      `json:"message,omitempty"`
 	Data    interface{} `json:"data,omitempty"`
+	Next    string      `json:"next,omitempty"`
+	Prev    string      `json:"prev,omitempty"`
 }
 
 // SendJSONResponse structures any response to JSON for sending over the wire